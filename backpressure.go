@@ -0,0 +1,148 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nautilus/graphql"
+)
+
+// BackpressureError is returned by the executor when it gives up on an operation because the
+// services are too busy to take on more work. The GraphQLHandler responds to operations that
+// run into one with a 503 and a Retry-After header.
+type BackpressureError struct {
+	Message string
+	// RetryAfter is how long the client should wait before trying again (zero if unknown)
+	RetryAfter time.Duration
+}
+
+func (e *BackpressureError) Error() string {
+	return e.Message
+}
+
+// MarshalJSON serializes the error like any other GraphQL error
+func (e *BackpressureError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(graphql.NewError("UNAVAILABLE", e.Message))
+}
+
+// WithExecutionConcurrencyTimeout returns an Option that limits how long a query waits for one of the
+// slots set aside by WithExecutionConcurrency or WithGlobalExecutionConcurrency. Operations with a query
+// that waits any longer fail with a BackpressureError. A value of 0 (the default) waits as long as the
+// request does.
+func WithExecutionConcurrencyTimeout(timeout time.Duration) Option {
+	return func(g *Gateway) {
+		g.concurrencyTimeout = timeout
+	}
+}
+
+// findBackpressureError returns the first BackpressureError in the error, which could be a list of errors
+func findBackpressureError(err error) *BackpressureError {
+	var errList graphql.ErrorList
+	if errors.As(err, &errList) {
+		for _, listErr := range errList {
+			if backpressure := findBackpressureError(listErr); backpressure != nil {
+				return backpressure
+			}
+		}
+		return nil
+	}
+
+	var backpressure *BackpressureError
+	if errors.As(err, &backpressure) {
+		return backpressure
+	}
+	return nil
+}
+
+// setRetryAfter tells the client how long to wait before trying again, rounded up to the second
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+}
+
+// RequestQueuePolicy decides what happens to a request that comes in while the gateway is serving as
+// many requests as WithMaxConcurrentRequests allows
+type RequestQueuePolicy int
+
+const (
+	// RequestQueueWait holds onto the request until another one is done, or the timeout set with
+	// WithRequestQueueTimeout runs out. This is the default.
+	RequestQueueWait RequestQueuePolicy = iota
+	// RequestQueueReject responds to the request with a 503 right away
+	RequestQueueReject
+)
+
+// WithMaxConcurrentRequests returns an Option that limits the number of requests the GraphQLHandler serves
+// at once. This is separate from the limits on the queries sent to the services and protects the gateway
+// itself. What happens to the requests past the limit depends on the RequestQueuePolicy. A value of 0
+// (the default) does not limit the number of requests.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(g *Gateway) {
+		g.requestSlots = nil
+		if n > 0 {
+			g.requestSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithRequestQueuePolicy returns an Option that sets what happens to the requests that come in while the
+// gateway is already serving as many as WithMaxConcurrentRequests allows
+func WithRequestQueuePolicy(policy RequestQueuePolicy) Option {
+	return func(g *Gateway) {
+		g.requestQueuePolicy = policy
+	}
+}
+
+// WithRequestQueueTimeout returns an Option that limits how long a request waits for its turn when the
+// gateway queues requests past WithMaxConcurrentRequests. Requests that wait any longer get a 503. A value
+// of 0 (the default) waits as long as the request does.
+func WithRequestQueueTimeout(timeout time.Duration) Option {
+	return func(g *Gateway) {
+		g.requestQueueTimeout = timeout
+	}
+}
+
+// acquireRequestSlot waits for the gateway to have room for another request, according to its policy
+func (g *Gateway) acquireRequestSlot(ctx context.Context) *BackpressureError {
+	tooBusy := &BackpressureError{
+		Message:    "the gateway is serving too many requests",
+		RetryAfter: g.requestQueueTimeout,
+	}
+
+	if g.requestQueuePolicy == RequestQueueReject {
+		select {
+		case g.requestSlots <- struct{}{}:
+			return nil
+		default:
+			return tooBusy
+		}
+	}
+
+	// a nil channel never fires so we wait as long as the request does
+	var timeout <-chan time.Time
+	if g.requestQueueTimeout > 0 {
+		timer := time.NewTimer(g.requestQueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case g.requestSlots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return tooBusy
+	case <-ctx.Done():
+		return &BackpressureError{Message: "the request was cancelled while waiting for its turn"}
+	}
+}
+
+// releaseRequestSlot lets the next request in
+func (g *Gateway) releaseRequestSlot() {
+	<-g.requestSlots
+}
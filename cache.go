@@ -1,13 +1,18 @@
 package gateway
 
 import (
+	"container/list"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"crypto/sha256"
 	"encoding/hex"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
 )
 
 // In general, "query persistence" is a term for a family of optimizations that involve
@@ -43,6 +48,25 @@ import (
 // a caches query plan
 const MessageMissingCachedQuery = "PersistedQueryNotFound"
 
+// MessageMismatchedQueryHash is the string that the server sends when the hash sent along with a query
+// is not the one the server computes for it
+const MessageMismatchedQueryHash = "provided persisted query hash does not match the query"
+
+// persistedQueryHash is the default way to identify a query: the hex encoded sha256 of the query string
+func persistedQueryHash(query string) string {
+	hash := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(hash[:])
+}
+
+// WithPersistedQueryHasher returns an Option that changes how the automatic query plan cache identifies
+// queries, for clients that don't use the sha256 of the query string. When it is set, the hash a client
+// sends along with a query has to match the one computed by the hasher.
+func WithPersistedQueryHasher(hasher func(query string) string) Option {
+	return func(g *Gateway) {
+		g.persistedQueryHasher = hasher
+	}
+}
+
 // QueryPlanCache decides when to compute a plan
 type QueryPlanCache interface {
 	Retrieve(ctx *PlanningContext, hash *string, planner QueryPlanner) (QueryPlanList, error)
@@ -73,6 +97,16 @@ func WithAutomaticQueryPlanCache() Option {
 	return WithQueryPlanCache(NewAutomaticQueryPlanCache())
 }
 
+// QueryPlanCacheStats holds the counters a query plan cache has collected since it was created
+type QueryPlanCacheStats struct {
+	// Hits is the number of retrievals that were satisfied by a cached plan
+	Hits uint64
+	// Misses is the number of retrievals that had to compute a plan (or ask the client for the query)
+	Misses uint64
+	// Evictions is the number of plans that have been cleaned up from the cache
+	Evictions uint64
+}
+
 type queryPlanCacheItem struct {
 	LastUsed atomic.Value // time.Time
 	Value    QueryPlanList
@@ -83,6 +117,8 @@ type queryPlanCacheItem struct {
 type AutomaticQueryPlanCache struct {
 	cache *sync.Map // map[string]*queryPlanCacheItem
 	ttl   time.Duration
+	// the counters are shared between every copy of the cache
+	stats *QueryPlanCacheStats
 	// the automatic query plan cache needs to clear itself of query plans that have been used
 	// recently. This coordination requires a channel over which events can be trigger whenever
 	// a query is fired, triggering a check to clean up other queries.
@@ -99,6 +135,7 @@ func (c *AutomaticQueryPlanCache) WithCacheTTL(duration time.Duration) *Automati
 	return &AutomaticQueryPlanCache{
 		cache:         c.cache,
 		ttl:           duration,
+		stats:         c.stats,
 		retrievedPlan: c.retrievedPlan,
 		resetTimer:    c.resetTimer,
 	}
@@ -110,6 +147,7 @@ func NewAutomaticQueryPlanCache() *AutomaticQueryPlanCache {
 		cache: new(sync.Map),
 		// default cache lifetime of 3 days
 		ttl:           10 * 24 * time.Hour,
+		stats:         &QueryPlanCacheStats{},
 		retrievedPlan: make(chan bool),
 		resetTimer:    false,
 	}
@@ -119,6 +157,16 @@ func NewAutomaticQueryPlanCache() *AutomaticQueryPlanCache {
 // If the hash is not know but the query is provided, it will compute the plan, return it, and save it for later use.
 // If the hash is not known and the query is not provided, it will return with an error prompting the client to provide the hash and query
 func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, planner QueryPlanner) (QueryPlanList, error) {
+	// the gateway might have been told to identify queries with something other than their sha256
+	hasher := persistedQueryHash
+	if ctx.Gateway != nil && ctx.Gateway.persistedQueryHasher != nil {
+		hasher = ctx.Gateway.persistedQueryHasher
+
+		// if the client sent both, the hash they sent has to be the one we would have computed
+		if *hash != "" && ctx.Query != "" && hasher(ctx.Query) != *hash {
+			return nil, errors.New(MessageMismatchedQueryHash)
+		}
+	}
 
 	// when we're done with retrieving the value we have to clear the cache
 	defer func() {
@@ -167,6 +215,7 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 						if lastUsed.Before(time.Now().Add(-c.ttl)) {
 							// delete it from the cache
 							c.cache.Delete(key)
+							atomic.AddUint64(&c.stats.Evictions, 1)
 						}
 						return true
 					})
@@ -184,11 +233,13 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 		cached := value.(*queryPlanCacheItem)
 		// update the last used
 		cached.LastUsed.Store(time.Now())
+		atomic.AddUint64(&c.stats.Hits, 1)
 		// return it
 		return cached.Value, nil
 	}
 
 	// we dont have a cached value
+	atomic.AddUint64(&c.stats.Misses, 1)
 
 	// if we were not given a query string
 	if ctx.Query == "" {
@@ -204,9 +255,8 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 
 	// if there is no hash
 	if *hash == "" {
-		hashString := sha256.Sum256([]byte(ctx.Query))
 		// generate a hash that will identify the query for later use
-		*hash = hex.EncodeToString(hashString[:])
+		*hash = hasher(ctx.Query)
 	}
 
 	// save it for later
@@ -221,3 +271,115 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 	// we're done
 	return plan, nil
 }
+
+// CacheStats returns a snapshot of the hits, misses, and evictions the cache has seen
+func (c *AutomaticQueryPlanCache) CacheStats() QueryPlanCacheStats {
+	return QueryPlanCacheStats{
+		Hits:      atomic.LoadUint64(&c.stats.Hits),
+		Misses:    atomic.LoadUint64(&c.stats.Misses),
+		Evictions: atomic.LoadUint64(&c.stats.Evictions),
+	}
+}
+
+// WarmPlanCache plans each of the queries and stores the result in the gateway's query plan cache so
+// the first request that sends one of them doesn't have to wait for it to be planned. Every query is
+// planned even if an earlier one fails, and the returned error lists each query that could not be planned,
+// which makes it a cheap way to check that a set of known queries still works with the current schema.
+func (g *Gateway) WarmPlanCache(queries []string) error {
+	errs := graphql.ErrorList{}
+	for ix, query := range queries {
+		planningContext := &PlanningContext{
+			Query:     query,
+			Schema:    g.schema,
+			Gateway:   g,
+			Locations: g.currentFieldURLs(),
+		}
+
+		cacheKey := ""
+		if _, err := g.queryPlanCache.Retrieve(planningContext, &cacheKey, g.planner); err != nil {
+			errs = append(errs, fmt.Errorf("query %d could not be planned: %w", ix, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// parsedQueryCache holds onto the most recently used query documents that have been parsed and validated
+// so that queries sent by value don't have to be parsed again. Documents are only valid for the schema
+// they were validated against so the schema is part of the key.
+type parsedQueryCache struct {
+	size    int
+	lock    sync.Mutex
+	entries map[parsedQueryKey]*list.Element
+	// the most recently used entry is at the front
+	order *list.List
+}
+
+type parsedQueryKey struct {
+	schema *ast.Schema
+	query  string
+}
+
+type parsedQueryEntry struct {
+	key      parsedQueryKey
+	document *ast.QueryDocument
+	// the warnings about the parts of the query that were left out when it was parsed
+	ignored []string
+}
+
+// newParsedQueryCache returns a cache that holds onto at most size documents
+func newParsedQueryCache(size int) *parsedQueryCache {
+	return &parsedQueryCache{
+		size:    size,
+		entries: map[parsedQueryKey]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// get returns the document for the query if it was parsed against the schema, along with the warnings from parsing it
+func (c *parsedQueryCache) get(schema *ast.Schema, query string) (*ast.QueryDocument, []string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[parsedQueryKey{schema: schema, query: query}]
+	if !ok {
+		return nil, nil, false
+	}
+	c.order.MoveToFront(element)
+
+	entry := element.Value.(*parsedQueryEntry)
+	return entry.document, entry.ignored, true
+}
+
+// set saves the document for the query, making room for it if the cache is full
+func (c *parsedQueryCache) set(schema *ast.Schema, query string, document *ast.QueryDocument, ignored []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := parsedQueryKey{schema: schema, query: query}
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*parsedQueryEntry)
+		entry.document, entry.ignored = document, ignored
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&parsedQueryEntry{key: key, document: document, ignored: ignored})
+
+	// drop the documents that haven't been used in the longest time
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*parsedQueryEntry).key)
+	}
+}
+
+// len returns the number of documents in the cache
+func (c *parsedQueryCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
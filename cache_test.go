@@ -7,6 +7,7 @@ import (
 
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
 )
 
 // MockPlanner always returns the provided list of plans. Useful in testing.
@@ -115,6 +116,9 @@ func TestAutomaticQueryPlanCache(t *testing.T) {
 
 	// we should have only computed the plan once
 	assert.Equal(t, 1, planner.Count)
+
+	// the cache should have seen both of the misses and the hit
+	assert.Equal(t, QueryPlanCacheStats{Hits: 1, Misses: 2}, cache.CacheStats())
 }
 
 func TestAutomaticQueryPlanCache_passPlannerErrors(t *testing.T) {
@@ -157,6 +161,85 @@ func TestAutomaticQueryPlanCache_setCacheKey(t *testing.T) {
 	}
 }
 
+func TestAutomaticQueryPlanCache_persistedQueryHasher(t *testing.T) {
+	t.Parallel()
+	planner := &testPlannerCounter{
+		Plans: QueryPlanList{},
+	}
+	cache := NewAutomaticQueryPlanCache()
+
+	// a gateway that namespaces its hashes
+	gateway := &Gateway{}
+	WithPersistedQueryHasher(func(query string) string {
+		return "app:" + query
+	})(gateway)
+
+	// the key we compute for the query is the one that gets used
+	cacheKey := ""
+	_, err := cache.Retrieve(&PlanningContext{Query: "hello", Gateway: gateway}, &cacheKey, planner)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "app:hello", cacheKey)
+
+	// clients can refer to the plan with the hash alone
+	_, err = cache.Retrieve(&PlanningContext{Gateway: gateway}, &cacheKey, planner)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, planner.Count)
+
+	// but they can't send a hash that doesn't match the query
+	mismatchedKey := persistedQueryHash("hello")
+	_, err = cache.Retrieve(&PlanningContext{Query: "hello", Gateway: gateway}, &mismatchedKey, planner)
+	assert.EqualError(t, err, MessageMismatchedQueryHash)
+	assert.Equal(t, 1, planner.Count)
+}
+
+func TestGatewayWarmPlanCache(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cache := NewAutomaticQueryPlanCache()
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithQueryPlanCache(cache))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// every query is planned, even the ones after one that fails
+	err = gw.WarmPlanCache([]string{
+		"{ value }",
+		"{ missing }",
+		"query A { value } query B { value }",
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "query 1 could not be planned")
+		assert.NotContains(t, err.Error(), "query 0")
+		assert.NotContains(t, err.Error(), "query 2")
+	}
+	assert.Equal(t, uint64(3), cache.CacheStats().Misses)
+
+	// clients can refer to the warmed plans by their hash alone
+	plans, err := gw.GetPlans(&RequestContext{CacheKey: persistedQueryHash("{ value }")})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, plans, 1)
+	assert.Equal(t, uint64(1), cache.CacheStats().Hits)
+
+	// a gateway that doesn't cache plans can still check the queries
+	gw, err = New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, gw.WarmPlanCache([]string{"{ value }"}))
+}
+
 func TestAutomaticQueryPlanCache_garbageCollection(t *testing.T) {
 	t.Parallel()
 	cacheKey := "asdf"
@@ -197,4 +280,37 @@ func TestAutomaticQueryPlanCache_garbageCollection(t *testing.T) {
 
 	// we should have only generated the plan twice now (once more than before)
 	assert.Equal(t, 2, planner.Count)
+
+	// the expired plan should have been counted as an eviction
+	assert.Equal(t, QueryPlanCacheStats{Hits: 2, Misses: 2, Evictions: 1}, cache.CacheStats())
+}
+
+func TestParsedQueryCache_evictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	schema := &ast.Schema{}
+	cache := newParsedQueryCache(2)
+
+	first, second, third := &ast.QueryDocument{}, &ast.QueryDocument{}, &ast.QueryDocument{}
+	cache.set(schema, "first", first, []string{"ignoring unknown directive @first"})
+	cache.set(schema, "second", second, nil)
+
+	// using the first document makes the second one the oldest
+	document, ignored, ok := cache.get(schema, "first")
+	assert.True(t, ok)
+	assert.Same(t, first, document)
+	assert.Equal(t, []string{"ignoring unknown directive @first"}, ignored)
+
+	cache.set(schema, "third", third, nil)
+	assert.Equal(t, 2, cache.len())
+
+	_, _, ok = cache.get(schema, "second")
+	assert.False(t, ok)
+	_, _, ok = cache.get(schema, "first")
+	assert.True(t, ok)
+	_, _, ok = cache.get(schema, "third")
+	assert.True(t, ok)
+
+	// documents validated against another schema don't count
+	_, _, ok = cache.get(&ast.Schema{}, "first")
+	assert.False(t, ok)
 }
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nautilus/graphql"
+)
+
+// WithErrorOrdering returns an Option that sorts the errors of every response by their path so the
+// same failures are always reported in the same order, no matter which step finished first. Errors
+// without a path come first and errors with the same path are sorted by their message.
+func WithErrorOrdering(enabled bool) Option {
+	return func(g *Gateway) {
+		g.orderErrors = enabled
+	}
+}
+
+// WithErrorDedup returns an Option that only reports one of the errors of a response that have the
+// same message and path.
+func WithErrorDedup(enabled bool) Option {
+	return func(g *Gateway) {
+		g.dedupErrors = enabled
+	}
+}
+
+// arrangeErrors applies the ordering and deduplication the gateway was configured with to the errors of a response
+func (g *Gateway) arrangeErrors(err error) error {
+	if !g.orderErrors && !g.dedupErrors {
+		return err
+	}
+
+	var errList graphql.ErrorList
+	if !errors.As(err, &errList) {
+		return err
+	}
+
+	arranged := graphql.ErrorList{}
+	seen := Set{}
+	for _, listErr := range errList {
+		if g.dedupErrors {
+			message, path := errorMessageAndPath(listErr)
+			key := fmt.Sprintf("%q %v", message, path)
+			if seen.Has(key) {
+				continue
+			}
+			seen.Add(key)
+		}
+		arranged = append(arranged, listErr)
+	}
+
+	if g.orderErrors {
+		sort.SliceStable(arranged, func(i, j int) bool {
+			messageI, pathI := errorMessageAndPath(arranged[i])
+			messageJ, pathJ := errorMessageAndPath(arranged[j])
+			if comparison := comparePaths(pathI, pathJ); comparison != 0 {
+				return comparison < 0
+			}
+			return messageI < messageJ
+		})
+	}
+
+	return arranged
+}
+
+// errorMessageAndPath returns the message of the error and the path it points to, if it has one
+func errorMessageAndPath(err error) (string, []interface{}) {
+	var graphqlErr *graphql.Error
+	if errors.As(err, &graphqlErr) {
+		return graphqlErr.Message, graphqlErr.Path
+	}
+	return err.Error(), nil
+}
+
+// comparePaths returns a negative number if path1 comes before path2, a positive one if it comes after,
+// and 0 if they are the same. Indices come before field names and are compared by value.
+func comparePaths(path1, path2 []interface{}) int {
+	for i := 0; i < len(path1) && i < len(path2); i++ {
+		index1, isIndex1 := pathIndex(path1[i])
+		index2, isIndex2 := pathIndex(path2[i])
+		switch {
+		case isIndex1 && isIndex2:
+			if index1 != index2 {
+				if index1 < index2 {
+					return -1
+				}
+				return 1
+			}
+		case isIndex1:
+			return -1
+		case isIndex2:
+			return 1
+		default:
+			name1, name2 := fmt.Sprint(path1[i]), fmt.Sprint(path2[i])
+			if name1 != name2 {
+				if name1 < name2 {
+					return -1
+				}
+				return 1
+			}
+		}
+	}
+
+	return len(path1) - len(path2)
+}
+
+// pathIndex returns the value of an entry in a path if it is a list index
+func pathIndex(entry interface{}) (float64, bool) {
+	switch entry := entry.(type) {
+	case int:
+		return float64(entry), true
+	case int64:
+		return float64(entry), true
+	case float64:
+		return entry, true
+	default:
+		return 0, false
+	}
+}
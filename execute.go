@@ -2,14 +2,18 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/nautilus/graphql"
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 // Common type names for manipulating schemas
@@ -19,6 +23,12 @@ const (
 	typeNameSubscription = "Subscription"
 )
 
+// typenameField is the meta field that every object can be asked for
+const typenameField = "__typename"
+
+// nodeIDVariable is the variable that holds the id of the object a step looks up with the node query
+const nodeIDVariable = "id"
+
 // Executor is responsible for executing a query plan against the remote
 // schemas and returning the result
 type Executor interface {
@@ -33,6 +43,8 @@ type queryExecutionResult struct {
 	InsertionPoint []string
 	Result         map[string]interface{}
 	Err            error
+	// Dependents are the insertion points of the steps that were started because of this one
+	Dependents [][]string
 }
 
 // execution is broken up into two phases:
@@ -48,17 +60,110 @@ type ExecutionContext struct {
 	Variables          map[string]interface{}
 	RequestContext     context.Context
 	RequestMiddlewares []graphql.NetworkMiddleware
+	// LocationMiddlewares are the request middlewares that only apply to the steps sent to each location.
+	// They run after the RequestMiddlewares.
+	LocationMiddlewares map[string][]graphql.NetworkMiddleware
+	// Request and ResponseWriter are only set when the operation was sent over HTTP
+	Request        *http.Request
+	ResponseWriter http.ResponseWriter
+	// BubbleNulls enforces the non-null fields of the operation by replacing the nearest
+	// nullable parent of a null value with null
+	BubbleNulls bool
+	// Concurrency limits the number of queries that can be in flight at once. Each query
+	// holds a slot in the channel while it waits for a response. A nil channel has no limit.
+	Concurrency chan struct{}
+	// ConcurrencyTimeout is how long a query waits for a slot in the Concurrency channel before
+	// the executor gives up with a BackpressureError. Zero waits as long as the request does.
+	ConcurrencyTimeout time.Duration
+	// StripErrorLocations removes the locations from the errors returned by the services since they
+	// point into the query sent to the service and not the one sent by the client
+	StripErrorLocations bool
+	// BeforeStep and AfterStep are called around the query of each step. They could be called
+	// by more than one goroutine at once.
+	BeforeStep BeforeStepHook
+	AfterStep  AfterStepHook
+	// NullDataPolicy decides what happens to the fields of a step whose service only returned errors
+	NullDataPolicy NullDataPolicy
+	// StepTimeout limits how long the query of each step can take. Zero waits as long as the request does.
+	StepTimeout time.Duration
+	// SkippedStep is called when a step is never sent because the step before it didn't return any objects
+	// for it to resolve fields on. It could be called by more than one goroutine at once.
+	SkippedStep SkippedStepHook
 }
 
+// NullDataPolicy decides what happens to the fields of a step when its service responds with errors and no data
+type NullDataPolicy int
+
+const (
+	// NullDataPropagateErrors passes the errors along and leaves the fields of the step out of the
+	// result. This is the default.
+	NullDataPropagateErrors NullDataPolicy = iota
+	// NullDataFailField passes the errors along and sets each field of the step to null, which is
+	// bubbled up to a nullable parent if null bubbling is turned on
+	NullDataFailField
+)
+
+// BeforeStepHook is called right before the query of a step is sent to its service. The step is a copy so
+// it can be held onto without seeing the changes the gateway makes to the plan.
+type BeforeStepHook func(ctx context.Context, step *QueryPlanStep)
+
+// SkippedStepHook is called with a copy of a step that has nothing to do for one of the results of its parent
+// because the objects it would be inserted into are null or in an empty list. The steps that come after it
+// aren't reported since they never had a chance to run.
+type SkippedStepHook func(ctx context.Context, step *QueryPlanStep)
+
+// AfterStepHook is called with the response of the service once the query of a step is done. The step is
+// the same copy that was given to the BeforeStepHook. The result must not be modified.
+type AfterStepHook func(ctx context.Context, step *QueryPlanStep, result map[string]interface{}, err error)
+
 // Execute returns the result of the query plan
 func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]interface{}, error) {
+	// if there are no steps after the root step, there is a problem
+	if len(ctx.Plan.RootStep.Then) == 0 {
+		return nil, errors.New("was given empty plan")
+	}
+
+	// most queries only need to talk to one service so there's nothing to stitch together
+	if step := ctx.Plan.singleStep(); step != nil {
+		return executeSingleStep(ctx, step)
+	}
+
+	return executor.execute(ctx, nil)
+}
+
+// ExecuteStream returns the result of the query plan without the entries of the lists marked with @stream past
+// their initialCount, as soon as the steps for the rest of the result are done. Each entry is sent on the channel
+// once the steps under it are done.
+func (executor *ParallelExecutor) ExecuteStream(ctx *ExecutionContext) (map[string]interface{}, <-chan *StreamedItem, error) {
+	// if there are no steps after the root step, there is a problem
+	if len(ctx.Plan.RootStep.Then) == 0 {
+		return nil, nil, errors.New("was given empty plan")
+	}
+
+	lists, err := planStreamedLists(ctx.Plan, ctx.Variables)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(lists) == 0 {
+		result, err := executor.Execute(ctx)
+		return result, nil, err
+	}
+
+	tracker := newStreamTracker(ctx, lists)
+	// the tracker only makes a channel if some list didn't fit in its initialCount
+	result, err := executor.execute(ctx, tracker)
+	return result, tracker.items, err
+}
+
+// execute walks the plan and stitches the results of its steps together. If there is a tracker, it returns
+// once the initial result is ready and leaves the steps for the streamed entries running.
+func (executor *ParallelExecutor) execute(ctx *ExecutionContext, tracker *streamTracker) (map[string]interface{}, error) {
 	// a place to store the result
 	result := map[string]interface{}{}
 
 	// a channel to receive query results
 	const maxResultBuffer = 10
 	resultCh := make(chan *queryExecutionResult, maxResultBuffer)
-	defer close(resultCh)
 
 	// a wait group so we know when we're done with all of the steps
 	stepWg := &sync.WaitGroup{}
@@ -66,20 +171,13 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 	// and a channel for errors
 	errMutex := &sync.Mutex{}
 	errCh := make(chan error, maxResultBuffer)
-	defer close(errCh)
 
 	// a channel to close the goroutine
 	closeCh := make(chan bool)
-	defer close(closeCh)
 
 	// a lock for reading and writing to the result
 	resultLock := &sync.Mutex{}
 
-	// if there are no steps after the root step, there is a problem
-	if len(ctx.Plan.RootStep.Then) == 0 {
-		return nil, errors.New("was given empty plan")
-	}
-
 	// the root step could have multiple steps that have to happen
 	for _, step := range ctx.Plan.RootStep.Then {
 		stepWg.Add(1)
@@ -105,6 +203,17 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 				// acumulator.
 				insertErr := executorInsertObject(ctx, result, resultLock, payload.InsertionPoint, payload.Result)
 
+				// when we're streaming, the tracker decides where the errors go and what can be sent
+				if tracker != nil {
+					err := payload.Err
+					if err == nil {
+						err = insertErr
+					}
+					tracker.stepDone(payload, err, result, resultLock, &errs, errMutex)
+					stepWg.Done()
+					continue
+				}
+
 				switch {
 				case payload.Err != nil: // response errors are the highest priority to return
 					errCh <- payload.Err
@@ -118,13 +227,8 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 			case err := <-errCh:
 				if err != nil {
 					errMutex.Lock()
-					// if the error was a list
-					var errList graphql.ErrorList
-					if errors.As(err, &errList) {
-						errs = append(errs, errList...)
-					} else {
-						errs = append(errs, err)
-					}
+					// if the error was a list, its errors are reported on their own
+					errs = append(errs, executorErrorList(err)...)
 					errMutex.Unlock()
 					stepWg.Done()
 				}
@@ -135,15 +239,78 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 		}
 	}()
 
+	// the steps for the streamed entries finish after we return
+	if tracker != nil {
+		go func() {
+			stepWg.Wait()
+			tracker.finish(result, resultLock)
+			close(closeCh)
+			close(errCh)
+			close(resultCh)
+		}()
+
+		initial := <-tracker.initial
+		return initial.result, initial.err
+	}
+	defer close(resultCh)
+	defer close(errCh)
+	defer close(closeCh)
+
 	// when the wait group is finished
 	stepWg.Wait()
 
 	// if we encountered any errors
 	errMutex.Lock()
-	nErrs := len(errs)
 	defer errMutex.Unlock()
 
-	if nErrs > 0 {
+	return executorCompleteResult(ctx, result, errs)
+}
+
+// executeSingleStep executes a plan made of one query without any of the bookkeeping needed to stitch
+// results together. The response of the service is used as the result directly.
+func executeSingleStep(ctx *ExecutionContext, step *QueryPlanStep) (map[string]interface{}, error) {
+	result, _, err := executeOneStep(ctx, ctx.Plan, step, []string{}, &sync.Mutex{}, ctx.Variables)
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+
+	errs := graphql.ErrorList{}
+	if err != nil {
+		errs = append(errs, executorErrorList(err)...)
+	}
+
+	return executorCompleteResult(ctx, result, errs)
+}
+
+// executorErrorList returns the errors held by the error if it is a list, or a list with just the error
+func executorErrorList(err error) graphql.ErrorList {
+	var errList graphql.ErrorList
+	if errors.As(err, &errList) {
+		return errList
+	}
+
+	// services that are queried in-process could return the lists of errors built by the parser
+	var gqlErrList gqlerror.List
+	if errors.As(err, &gqlErrList) {
+		for _, gqlErr := range gqlErrList {
+			errList = append(errList, gqlErr)
+		}
+		return errList
+	}
+
+	return graphql.ErrorList{err}
+}
+
+// executorCompleteResult applies the final touches to the result of a plan and the errors encountered along the way
+func executorCompleteResult(ctx *ExecutionContext, result map[string]interface{}, errs graphql.ErrorList) (map[string]interface{}, error) {
+	// if we have to enforce the non-null fields of the operation
+	if ctx.BubbleNulls && ctx.Plan.Operation != nil {
+		var nullErrs graphql.ErrorList
+		result, nullErrs = executorBubbleNulls(ctx.Plan, result, errs)
+		errs = append(errs, nullErrs...)
+	}
+
+	if len(errs) > 0 {
 		return result, errs
 	}
 
@@ -151,6 +318,113 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 	return result, nil
 }
 
+// executorBubbleNulls walks the result alongside the operation's selection set and replaces the nearest
+// nullable parent of any null value for a non-null field with null. An error is added for each null that
+// wasn't already reported by the services. If one of the root fields can't be null, the whole result is nil.
+func executorBubbleNulls(plan *QueryPlan, result map[string]interface{}, errs graphql.ErrorList) (map[string]interface{}, graphql.ErrorList) {
+	// the paths that already have an error pointing at them
+	reported := map[string]bool{}
+	for _, err := range errs {
+		var gqlErr *graphql.Error
+		if errors.As(err, &gqlErr) && len(gqlErr.Path) > 0 {
+			reported[fmt.Sprint(gqlErr.Path)] = true
+		}
+	}
+
+	walker := &nullBubbler{
+		fragments: plan.FragmentDefinitions,
+		reported:  reported,
+	}
+	if !walker.completeObject(plan.Operation.SelectionSet, result, []interface{}{}) {
+		return nil, walker.errs
+	}
+
+	return result, walker.errs
+}
+
+// nullBubbler holds the state of a walk through a result looking for non-null violations
+type nullBubbler struct {
+	fragments ast.FragmentDefinitionList
+	reported  map[string]bool
+	errs      graphql.ErrorList
+}
+
+// completeObject enforces the non-null fields in the selection set and returns false if the object
+// itself has to be null
+func (b *nullBubbler) completeObject(selectionSet ast.SelectionSet, object map[string]interface{}, path []interface{}) bool {
+	selection, err := graphql.ApplyFragments(selectionSet, b.fragments)
+	if err != nil {
+		return true
+	}
+
+	for _, field := range graphql.SelectedFields(selection) {
+		alias := field.Alias
+		if alias == "" {
+			alias = field.Name
+		}
+
+		// fields of other types in an abstract selection won't be in the object
+		value, ok := object[alias]
+		if !ok || field.Definition == nil {
+			continue
+		}
+
+		fieldPath := append(append([]interface{}{}, path...), alias)
+		completed := b.completeValue(field, field.Definition.Type, value, fieldPath)
+		object[alias] = completed
+
+		// if the field can't be null then neither can the object
+		if completed == nil && field.Definition.Type.NonNull {
+			return false
+		}
+	}
+
+	return true
+}
+
+// completeValue returns the value with the non-null fields enforced. If the value has to be null because
+// of a violation then nil is returned.
+func (b *nullBubbler) completeValue(field *ast.Field, fieldType *ast.Type, value interface{}, path []interface{}) interface{} {
+	if value == nil {
+		// if the value can't be null and no one has told the user about it
+		if fieldType.NonNull && !b.reported[fmt.Sprint(path)] {
+			b.reported[fmt.Sprint(path)] = true
+			b.errs = append(b.errs, &graphql.Error{
+				Message: fmt.Sprintf("Cannot return null for non-nullable field %s", field.Name),
+				Path:    path,
+			})
+		}
+		return nil
+	}
+
+	// lists need to look at each of their entries
+	if fieldType.Elem != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		for i, entry := range list {
+			entryPath := append(append([]interface{}{}, path...), i)
+			list[i] = b.completeValue(field, fieldType.Elem, entry, entryPath)
+
+			// if the entry can't be null then neither can the list
+			if list[i] == nil && fieldType.Elem.NonNull {
+				return nil
+			}
+		}
+		return list
+	}
+
+	// objects need to look at their fields
+	if object, ok := value.(map[string]interface{}); ok && len(field.SelectionSet) > 0 {
+		if !b.completeObject(field.SelectionSet, object, path) {
+			return nil
+		}
+	}
+
+	return value
+}
+
 // TODO: ugh... so... many... variables...
 func executeStep(
 	ctx *ExecutionContext,
@@ -167,24 +441,34 @@ func executeStep(
 	stepWg.Add(len(dependentSteps))
 	ctx.logger.Debug("Pushing Result. Insertion point: ", insertionPoint, ". Value: ", queryResult)
 	// send the result to be stitched in with our accumulator
-	resultCh <- &queryExecutionResult{
+	result := &queryExecutionResult{
 		InsertionPoint: insertionPoint,
 		Result:         queryResult,
 		Err:            queryErr,
 	}
+	for _, sr := range dependentSteps {
+		result.Dependents = append(result.Dependents, sr.insertionPoint)
+	}
+	resultCh <- result
 	// We need to collect all the dependent steps and execute them after emitting the parent result in this function.
 	// This avoids a race condition, where the result of a dependent request is published to the
 	// result channel even before the result created in this iteration.
 	// Execute dependent steps after the main step has been published.
 	for _, sr := range dependentSteps {
 		ctx.logger.Info("Spawn ", sr.insertionPoint)
-		go executeStep(ctx, plan, sr.step, sr.insertionPoint, resultLock, queryVariables, resultCh, stepWg)
+		variables := queryVariables
+		if sr.variables != nil {
+			variables = sr.variables
+		}
+		go executeStep(ctx, plan, sr.step, sr.insertionPoint, resultLock, variables, resultCh, stepWg)
 	}
 }
 
 type dependentStepArgs struct {
 	step           *QueryPlanStep
 	insertionPoint []string
+	// variables replaces the variables of the operation for the step if it is set
+	variables map[string]interface{}
 }
 
 func executeOneStep(
@@ -213,6 +497,11 @@ func executeOneStep(
 		}
 	}
 
+	// along with the fields of the parent object that the step needs
+	for _, requirement := range step.Requires {
+		variables[requirement.Variable] = queryVariables[requirement.Variable]
+	}
+
 	// the id of the object we are query is defined by the last step in the realized insertion point
 	if len(insertionPoint) > 0 {
 		head := insertionPoint[max(len(insertionPoint)-1, 0)]
@@ -229,7 +518,7 @@ func executeOneStep(
 		}
 
 		// save the id as a variable to the query
-		variables["id"] = pointData.ID
+		variables[nodeIDVariable] = pointData.ID
 	}
 
 	// if there is no queryer
@@ -239,14 +528,20 @@ func executeOneStep(
 
 	// the query we will use
 	queryer := step.Queryer
-	// a place to save the result
-	queryResult := map[string]interface{}{}
+	// a place to save the result. the service could send back anything so we check that it's an object ourselves
+	var rawResult interface{}
+
+	// some of the middlewares only apply to the service the step is sent to
+	middlewares := ctx.RequestMiddlewares
+	if scoped := ctx.LocationMiddlewares[step.Location]; len(scoped) > 0 {
+		middlewares = append(append([]graphql.NetworkMiddleware{}, middlewares...), scoped...)
+	}
 
 	// if we have middlewares
-	if len(ctx.RequestMiddlewares) > 0 {
+	if len(middlewares) > 0 {
 		// if the queryer is a network queryer
 		if nQueryer, ok := queryer.(graphql.QueryerWithMiddlewares); ok {
-			queryer = nQueryer.WithMiddlewares(ctx.RequestMiddlewares)
+			queryer = nQueryer.WithMiddlewares(middlewares)
 		}
 	}
 
@@ -255,13 +550,98 @@ func executeOneStep(
 		operationName = plan.Operation.Name
 	}
 
+	// the planner might have given the query its own name
+	if step.QueryDocument != nil && len(step.QueryDocument.Operations) > 0 && step.QueryDocument.Operations[0].Name != "" {
+		operationName = step.QueryDocument.Operations[0].Name
+	}
+
+	// the planner leaves out the variables we don't send but the step could have been built by someone else
+	queryDocument, queryString := step.QueryDocument, step.QueryString
+	if trimmed := trimVariableDefinitions(step, queryDocument); trimmed != queryDocument {
+		printed, err := graphql.PrintQuery(trimmed)
+		if err != nil {
+			return nil, nil, err
+		}
+		queryDocument, queryString = trimmed, printed
+	}
+
+	// if we are limiting the number of queries in flight, wait for our turn
+	if ctx.Concurrency != nil {
+		// a nil channel never fires so we wait as long as the request does
+		var timeout <-chan time.Time
+		if ctx.ConcurrencyTimeout > 0 {
+			timer := time.NewTimer(ctx.ConcurrencyTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case ctx.Concurrency <- struct{}{}:
+			// the slot has to come back however the step ends
+			defer func() { <-ctx.Concurrency }()
+		case <-timeout:
+			return nil, nil, &BackpressureError{
+				Message:    "the services are too busy to handle the request",
+				RetryAfter: ctx.ConcurrencyTimeout,
+			}
+		case <-ctx.RequestContext.Done():
+			return nil, nil, ctx.RequestContext.Err()
+		}
+	}
+
+	// let anyone watching know that we're about to fire the query
+	var snapshot *QueryPlanStep
+	if ctx.BeforeStep != nil || ctx.AfterStep != nil {
+		snapshot = executorSnapshotStep(step)
+	}
+	if ctx.BeforeStep != nil {
+		ctx.BeforeStep(ctx.RequestContext, snapshot)
+	}
+
+	// the query might only get part of the time the request has left
+	queryContext := ctx.RequestContext
+	if ctx.StepTimeout > 0 {
+		var cancel context.CancelFunc
+		queryContext, cancel = context.WithTimeout(queryContext, ctx.StepTimeout)
+		defer cancel()
+	}
+
 	// fire the query
-	queryErr := queryer.Query(ctx.RequestContext, &graphql.QueryInput{
-		Query:         step.QueryString,
-		QueryDocument: step.QueryDocument,
+	queryErr := queryer.Query(queryContext, &graphql.QueryInput{
+		Query:         queryString,
+		QueryDocument: queryDocument,
 		Variables:     variables,
 		OperationName: operationName,
-	}, &queryResult)
+	}, &rawResult)
+
+	// a service that doesn't respond with an object is broken but that shouldn't take down the request
+	queryResult, resultErr := executorResultObject(step.Location, rawResult)
+	if resultErr != nil {
+		queryErr = resultErr
+	}
+
+	// if the step ran out of time before the request did, say so instead of passing along whatever the queryer saw
+	if queryErr != nil && ctx.StepTimeout > 0 && errors.Is(queryContext.Err(), context.DeadlineExceeded) && ctx.RequestContext.Err() == nil {
+		queryErr = graphql.NewError("TIMEOUT", fmt.Sprintf("the query to %s exceeded its timeout of %s", step.Location, ctx.StepTimeout))
+	}
+
+	if ctx.AfterStep != nil {
+		ctx.AfterStep(ctx.RequestContext, snapshot, queryResult, queryErr)
+	}
+
+	// if the service couldn't resolve anything, the fields of the step might have to be null
+	if queryErr != nil && len(queryResult) == 0 && ctx.NullDataPolicy == NullDataFailField {
+		nullResult, err := executorNullFields(step)
+		if err != nil {
+			return nil, nil, err
+		}
+		queryResult = nullResult
+	}
+
+	// the locations of the service's errors don't mean anything to the client
+	if queryErr != nil && ctx.StripErrorLocations {
+		queryErr = executorStripErrorLocations(queryErr)
+	}
 
 	// NOTE: this insertion point could point to a list of values. If it did, we have to have
 	//       passed it to the this invocation of this function. It is safe to trust this
@@ -295,23 +675,152 @@ func executeOneStep(
 		for _, dependent := range step.Then {
 			copiedInsertionPoint := make([]string, len(insertionPoint))
 			copy(copiedInsertionPoint, insertionPoint)
-			insertPoints, err := executorFindInsertionPoints(ctx, resultLock, dependent.InsertionPoint, step.SelectionSet, queryResult, [][]string{copiedInsertionPoint}, step.FragmentDefinitions)
+			insertPoints, err := executorFindInsertionPoints(ctx, resultLock, dependent.InsertionPoint, step.SelectionSet, queryResult, [][]string{copiedInsertionPoint}, step.FragmentDefinitions, executorOtherTypes(dependent.PossibleTypes))
 			if err != nil {
 				return nil, nil, err
 			}
 
+			// if the dependent only applies to some types, leave out the objects it doesn't apply to
+			if dependent.PossibleTypes != nil {
+				insertPoints, err = executorFilterPossibleTypes(ctx, resultLock, queryResult, len(insertionPoint), insertPoints, dependent.PossibleTypes)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			// null objects and empty lists don't have anything for the dependent (or the steps after it) to do
+			if len(insertPoints) == 0 && ctx.SkippedStep != nil {
+				ctx.SkippedStep(ctx.RequestContext, executorSnapshotStep(dependent))
+			}
+
 			// this dependent needs to fire for every object that the insertion point references
-			for _, insertionPoint := range insertPoints {
-				dependentSteps = append(dependentSteps, dependentStepArgs{
+			for _, point := range insertPoints {
+				args := dependentStepArgs{
 					step:           dependent,
-					insertionPoint: insertionPoint,
-				})
+					insertionPoint: point,
+				}
+
+				// if the dependent needs fields of the object, they have to be passed along as variables
+				if len(dependent.Requires) > 0 {
+					args.variables, err = executorRequiredVariables(ctx, resultLock, queryResult, len(insertionPoint), point, dependent, queryVariables)
+					if err != nil {
+						return nil, nil, err
+					}
+				}
+
+				dependentSteps = append(dependentSteps, args)
 			}
 		}
 	}
 	return queryResult, dependentSteps, queryErr
 }
 
+// executorNullFields returns a response for the step where each of its fields is null
+func executorNullFields(step *QueryPlanStep) (map[string]interface{}, error) {
+	selection, err := graphql.ApplyFragments(step.SelectionSet, step.FragmentDefinitions)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	for _, field := range graphql.SelectedFields(selection) {
+		alias := field.Alias
+		if alias == "" {
+			alias = field.Name
+		}
+
+		// the fields that identify the object are still needed to put the rest of the result together
+		if alias == "id" || alias == typenameField {
+			continue
+		}
+		fields[alias] = nil
+	}
+
+	// steps that aren't on a root type are sent as a node query
+	if step.ParentType != typeNameQuery && step.ParentType != typeNameSubscription && step.ParentType != typeNameMutation {
+		return map[string]interface{}{"node": fields}, nil
+	}
+	return fields, nil
+}
+
+// executorSnapshotStep returns a copy of the step that the hooks can hold onto
+func executorSnapshotStep(step *QueryPlanStep) *QueryPlanStep {
+	snapshot := *step
+	snapshot.InsertionPoint = append([]string{}, step.InsertionPoint...)
+	snapshot.Then = append([]*QueryPlanStep{}, step.Then...)
+	return &snapshot
+}
+
+// executorStripErrorLocations returns the error without the locations of any GraphQL errors it holds
+func executorStripErrorLocations(err error) error {
+	switch err := err.(type) {
+	case graphql.ErrorList:
+		stripped := graphql.ErrorList{}
+		for _, listErr := range err {
+			stripped = append(stripped, executorStripErrorLocations(listErr))
+		}
+		return stripped
+	case gqlerror.List:
+		stripped := gqlerror.List{}
+		for _, listErr := range err {
+			stripped = append(stripped, executorStripErrorLocations(listErr).(*gqlerror.Error))
+		}
+		return stripped
+	case *gqlerror.Error:
+		if err == nil {
+			return err
+		}
+		withoutLocations := *err
+		withoutLocations.Locations = nil
+		return &withoutLocations
+	default:
+		return err
+	}
+}
+
+// executorOtherTypes returns a function that reports the entries of an interface or union that are not one of
+// the types a step applies to. Those don't have an id since the step is never sent for them, and they are
+// filtered out once the insertion points are found. A step that applies to every type needs every id.
+func executorOtherTypes(possibleTypes Set) func(entry map[string]interface{}) bool {
+	if possibleTypes == nil {
+		return nil
+	}
+	return func(entry map[string]interface{}) bool {
+		typename, _ := entry[typenameField].(string)
+		return typename != "" && !possibleTypes.Has(typename)
+	}
+}
+
+// executorFilterPossibleTypes returns the insertion points whose object has one of the given types. The points
+// are relative to the result of the step that was executed at a point of the given depth.
+func executorFilterPossibleTypes(ctx *ExecutionContext, resultLock *sync.Mutex, result map[string]interface{}, depth int, points [][]string, possibleTypes Set) ([][]string, error) {
+	filtered := [][]string{}
+	for _, point := range points {
+		value, err := executorExtractValue(ctx, result, resultLock, point[depth:])
+		if err == errNullInsertionPoint {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		resultLock.Lock()
+		typename, _ := object[typenameField].(string)
+		resultLock.Unlock()
+
+		if possibleTypes.Has(typename) {
+			filtered = append(filtered, point)
+		}
+	}
+
+	return filtered, nil
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -335,12 +844,56 @@ func findSelection(matchString string, selectionSet ast.SelectionSet, fragmentDe
 	return nil, nil
 }
 
+// executorResultObject returns the data a service responded with as an object, or an error that
+// says which service sent something else
+func executorResultObject(location string, result interface{}) (map[string]interface{}, error) {
+	switch result := result.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return result, nil
+	}
+
+	var kind string
+	switch result.(type) {
+	case []interface{}:
+		kind = "a list"
+	case string:
+		kind = "a string"
+	case bool:
+		kind = "a boolean"
+	case float64, float32, int, int64, int32, json.Number:
+		kind = "a number"
+	default:
+		kind = fmt.Sprintf("a %T", result)
+	}
+
+	return map[string]interface{}{}, &graphql.Error{
+		Message: fmt.Sprintf("the service at %s responded with %s instead of an object", location, kind),
+		Extensions: map[string]interface{}{
+			"code":       "BAD_UPSTREAM_RESPONSE",
+			"serviceUrl": location,
+		},
+	}
+}
+
 // executorFindInsertionPoints returns the list of insertion points where this step should be executed.
-func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex, targetPoints []string, selectionSet ast.SelectionSet, result map[string]interface{}, startingPoints [][]string, fragmentDefs ast.FragmentDefinitionList) ([][]string, error) {
+// Every entry of the target list needs an id unless missingID is given and returns true for it.
+func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex, targetPoints []string, selectionSet ast.SelectionSet, result map[string]interface{}, startingPoints [][]string, fragmentDefs ast.FragmentDefinitionList, missingID func(entry map[string]interface{}) bool) ([][]string, error) {
 	ctx.logger.Debug("Looking for insertion points. target: ", targetPoints, " Starting from ", startingPoints)
+
+	// every entry of a list walks down the same selections so we only need to look each one up once
+	selections := make([]*ast.Field, len(targetPoints))
+
+	return executorFindInsertionPointsFrom(ctx, resultLock, targetPoints, selectionSet, result, startingPoints, fragmentDefs, missingID, selections)
+}
+
+// executorFindInsertionPointsFrom does the work for executorFindInsertionPoints. It is called for every entry
+// of every list along the way so it avoids logging and allocates as little as it can.
+func executorFindInsertionPointsFrom(ctx *ExecutionContext, resultLock *sync.Mutex, targetPoints []string, selectionSet ast.SelectionSet, result map[string]interface{}, startingPoints [][]string, fragmentDefs ast.FragmentDefinitionList, missingID func(entry map[string]interface{}) bool, selections []*ast.Field) ([][]string, error) {
 	oldBranch := startingPoints
 
-	// track the root of the selection set while  we walk
+	// track the root of the selection set while  we walk
 	selectionSetRoot := selectionSet
 
 	// a place to refer to parts of the results
@@ -356,9 +909,6 @@ func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex,
 		}
 	}
 
-	ctx.logger.Debug("First meaningful path point: ", targetPoints[startingIndex])
-	ctx.logger.Debug("result ", resultChunk)
-
 	// if our starting point is []string{"users:0"} then we know everything so far
 	// is along the path of the steps insertion point
 	for pointI := startingIndex; pointI < len(targetPoints); pointI++ {
@@ -366,21 +916,23 @@ func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex,
 		point := targetPoints[pointI]
 
 		// find the selection node in the AST corresponding to the point
-		var foundSelection *ast.Field
-		foundSelection, err := findSelection(point, selectionSetRoot, fragmentDefs)
-		if err != nil {
-			ctx.logger.Debug("Error looking for selection")
-			return [][]string{}, err
-		}
-
-		// if we didn't find a selection
+		foundSelection := selections[pointI]
 		if foundSelection == nil {
-			ctx.logger.Debug("No selection")
-			return [][]string{}, nil
+			var err error
+			foundSelection, err = findSelection(point, selectionSetRoot, fragmentDefs)
+			if err != nil {
+				ctx.logger.Debug("Error looking for selection")
+				return [][]string{}, err
+			}
+
+			// if we didn't find a selection
+			if foundSelection == nil {
+				ctx.logger.Debug("No selection")
+				return [][]string{}, nil
+			}
+			selections[pointI] = foundSelection
 		}
 
-		ctx.logger.Debug("Found Selection for: ", point)
-		ctx.logger.Debug("Result Chunk: ", resultChunk)
 		// make sure we are looking at the top of the selection set next time
 		selectionSetRoot = foundSelection.SelectionSet
 
@@ -406,32 +958,41 @@ func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex,
 
 		// if the type is a list
 		if selectionType.Elem != nil {
-			ctx.logger.Debug("Selection should be a list")
 			// make sure the root value is a list
 			rootList, ok := rootValue.([]interface{})
 			if !ok {
 				return nil, fmt.Errorf("Root value of result chunk was not a list: %v", rootValue)
 			}
-			// build up a new list of insertion points
-			var newInsertionPoints [][]string
+			// build up a new list of insertion points. every entry contributes at least one
+			newInsertionPoints := make([][]string, 0, len(rootList))
+
+			// the points for the entries only differ by their index
+			entryPrefix := foundSelection.Name + ":"
+			if foundSelection.Alias != "" {
+				entryPrefix = foundSelection.Alias + ":"
+			}
 
 			// each value in the result contributes an insertion point
 			for entryI, iEntry := range rootList {
+				// there's nothing to insert into a null entry
+				if iEntry == nil {
+					continue
+				}
+
 				resultEntry, ok := iEntry.(map[string]interface{})
 				if !ok {
 					return nil, errors.New("entry in result wasn't a map")
 				}
 
 				// the point we are going to add to the list
-				entryPoint := fmt.Sprintf("%s:%v", foundSelection.Name, entryI)
-				if foundSelection.Alias != "" {
-					entryPoint = fmt.Sprintf("%s:%v", foundSelection.Alias, entryI)
-				}
-				ctx.logger.Debug("Adding ", entryPoint, " to list")
-
-				var newBranchSet [][]string
-				for _, c := range oldBranch {
-					newBranchSet = append(newBranchSet, copyStrings(c))
+				entryPoint := entryPrefix + strconv.Itoa(entryI)
+
+				// every branch has room for the rest of the target so the points that get
+				// added further down don't have to copy it again
+				newBranchSet := make([][]string, len(oldBranch))
+				for i, c := range oldBranch {
+					newBranchSet[i] = make([]string, len(c), len(targetPoints))
+					copy(newBranchSet[i], c)
 				}
 
 				// if we are adding to an existing branch
@@ -443,23 +1004,26 @@ func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex,
 							// look for an id
 							id, ok := resultEntry["id"]
 							if !ok {
-								return nil, errors.New("Could not find the id for elements in target list")
+								if missingID == nil || !missingID(resultEntry) {
+									return nil, errors.New("Could not find the id for elements in target list")
+								}
+							} else {
+								// add the id to the entry so that the executor can use it to form its query
+								entryPoint = entryPoint + "#" + executorPointID(id)
 							}
-
-							// add the id to the entry so that the executor can use it to form its query
-							entryPoint = fmt.Sprintf("%s#%v", entryPoint, id)
-
 						}
 
 						// add the point for this entry in the list
 						newBranchSet[i] = append(newBranch, entryPoint)
 					}
 				} else {
-					newBranchSet = append(newBranchSet, []string{entryPoint})
+					branch := make([]string, 1, len(targetPoints))
+					branch[0] = entryPoint
+					newBranchSet = [][]string{branch}
 				}
 
 				// compute the insertion points for that entry
-				entryInsertionPoints, err := executorFindInsertionPoints(ctx, resultLock, targetPoints, selectionSetRoot, resultEntry, newBranchSet, fragmentDefs)
+				entryInsertionPoints, err := executorFindInsertionPointsFrom(ctx, resultLock, targetPoints, selectionSetRoot, resultEntry, newBranchSet, fragmentDefs, missingID, selections)
 				if err != nil {
 					return nil, err
 				}
@@ -504,7 +1068,7 @@ func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex,
 
 					// ctx.logger.Debug("Adding id to ", oldBranch[i][pointI])
 
-					oldBranch[i][pointI] = fmt.Sprintf("%s:%v#%v", oldBranch[i][pointI], i, id)
+					oldBranch[i][pointI] = oldBranch[i][pointI] + ":" + strconv.Itoa(i) + "#" + executorPointID(id)
 
 				}
 			} else {
@@ -520,7 +1084,7 @@ func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex,
 						return nil, errors.New("Could not find the id for the object")
 					}
 
-					oldBranch[i][pointI] = fmt.Sprintf("%s#%v", oldBranch[i][pointI], id)
+					oldBranch[i][pointI] = oldBranch[i][pointI] + "#" + executorPointID(id)
 				}
 			}
 		}
@@ -531,6 +1095,14 @@ func executorFindInsertionPoints(ctx *ExecutionContext, resultLock *sync.Mutex,
 	return oldBranch, nil
 }
 
+// executorPointID formats the id of an object the same way as fmt's %v without going through fmt for the usual string ids
+func executorPointID(id interface{}) string {
+	if id, ok := id.(string); ok {
+		return id
+	}
+	return fmt.Sprint(id)
+}
+
 func isListElement(path string) bool {
 	if hashLocation := strings.Index(path, "#"); hashLocation > 0 {
 		path = path[:hashLocation]
@@ -538,6 +1110,10 @@ func isListElement(path string) bool {
 	return strings.Contains(path, ":")
 }
 
+// errNullInsertionPoint is returned by executorExtractValue when the path goes through a value that came back
+// null. There is nothing to stitch into so callers skip the point instead of failing the whole response.
+var errNullInsertionPoint = errors.New("insertion point goes through a null value")
+
 func executorExtractValue(ctx *ExecutionContext, source map[string]interface{}, resultLock *sync.Mutex, path []string) (interface{}, error) {
 	// a pointer to the objects we are modifying
 	var recent interface{} = source
@@ -557,16 +1133,20 @@ func executorExtractValue(ctx *ExecutionContext, source map[string]interface{},
 			}
 
 			// if the field does not exist
-			if _, ok := recentObj[pointData.Field]; !ok {
-				resultLock.Lock()
-				recentObj[pointData.Field] = []interface{}{}
-				resultLock.Unlock()
+			resultLock.Lock()
+			field, exists := recentObj[pointData.Field]
+			if !exists {
+				field = []interface{}{}
+				recentObj[pointData.Field] = field
+			}
+			resultLock.Unlock()
+
+			// a list that came back null has nothing to insert into
+			if field == nil {
+				return nil, errNullInsertionPoint
 			}
 
 			// it should be a list
-			resultLock.Lock()
-			field := recentObj[pointData.Field]
-			resultLock.Unlock()
 
 			targetList, ok := field.([]interface{})
 			if !ok {
@@ -585,10 +1165,16 @@ func executorExtractValue(ctx *ExecutionContext, source map[string]interface{},
 				resultLock.Unlock()
 			}
 
-			// focus on the right element
+			// focus on the right element. if we know its id, we don't have to trust that the list is
+			// in the same order as when we computed the insertion point
 			resultLock.Lock()
-			recent = targetList[pointData.Index]
+			recent = targetList[executorListEntryIndex(targetList, pointData)]
 			resultLock.Unlock()
+
+			// so does an entry that came back null
+			if i != len(path)-1 && recent == nil {
+				return nil, errNullInsertionPoint
+			}
 		} else {
 			// it's possible that there's an id
 			pointData, err := executorGetPointData(point)
@@ -605,9 +1191,14 @@ func executorExtractValue(ctx *ExecutionContext, source map[string]interface{},
 
 			// we are add an object value
 			resultLock.Lock()
-			targetObject := recentObj[pointField]
+			targetObject, exists := recentObj[pointField]
 			resultLock.Unlock()
 
+			// an object that came back null (because of an error, say) stays that way
+			if i != len(path)-1 && exists && targetObject == nil {
+				return nil, errNullInsertionPoint
+			}
+
 			if i != len(path)-1 && targetObject == nil {
 				resultLock.Lock()
 				recentObj[pointField] = map[string]interface{}{}
@@ -626,11 +1217,41 @@ func executorExtractValue(ctx *ExecutionContext, source map[string]interface{},
 	return recent, nil
 }
 
+// executorListEntryIndex returns the index of the entry in the list that the point refers to. Entries
+// are matched by their id when the point has one, falling back to the index of the point.
+func executorListEntryIndex(list []interface{}, pointData *extractorPointData) int {
+	if pointData.ID == "" || entryHasID(list[pointData.Index], pointData.ID) {
+		return pointData.Index
+	}
+
+	for i, entry := range list {
+		if entryHasID(entry, pointData.ID) {
+			return i
+		}
+	}
+
+	return pointData.Index
+}
+
+func entryHasID(entry interface{}, id string) bool {
+	object, ok := entry.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	value, ok := object["id"]
+	return ok && fmt.Sprintf("%v", value) == id
+}
+
 func executorInsertObject(ctx *ExecutionContext, target map[string]interface{}, resultLock *sync.Mutex, path []string, value interface{}) error {
 	// ctx.logger.Debug("Inserting object\n    Target: ", target, "\n    Path: ", path, "\n    Value: ", value)
 	if len(path) > 0 {
 		// a pointer to the objects we are modifying
 		obj, err := executorExtractValue(ctx, target, resultLock, path)
+		if err == errNullInsertionPoint {
+			// part of the response came back null so there is nowhere to put the value
+			ctx.logger.Debug("Skipping insertion under a null value at ", path)
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -8,11 +8,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vektah/gqlparser/v2/ast"
 )
 
@@ -241,6 +246,114 @@ func TestExecutor_emptyPlansWithDependencies(t *testing.T) {
 	}, result)
 }
 
+func TestExecutor_skipsStepsWithoutObjects(t *testing.T) {
+	t.Parallel()
+	// the query we want to execute is
+	// {
+	// 		users {                  <- from serviceA
+	//      	photoGallery {       <- from serviceA
+	// 				likedBy {        <- from serviceA
+	//					firstName    <- from serviceB
+	//				}
+	// 			}
+	// 		}
+	// }
+	userType := ast.NamedType("User", &ast.Position{})
+	likedByStep := &QueryPlanStep{
+		ParentType:     "User",
+		InsertionPoint: []string{"users", "photoGallery", "likedBy"},
+		SelectionSet: ast.SelectionSet{
+			&ast.Field{
+				Name:       "firstName",
+				Definition: &ast.FieldDefinition{Type: ast.NamedType("String", &ast.Position{})},
+			},
+		},
+		// none of the photos are liked so there's nobody to look up
+		Queryer: graphql.QueryerFunc(func(*graphql.QueryInput) (interface{}, error) {
+			return nil, errors.New("the step should not have been sent")
+		}),
+	}
+
+	var dispatched int64
+	skipped := []*QueryPlanStep{}
+	var skippedLock sync.Mutex
+	result, err := (&ParallelExecutor{}).Execute(&ExecutionContext{
+		logger:         &DefaultLogger{},
+		RequestContext: context.Background(),
+		BeforeStep: func(context.Context, *QueryPlanStep) {
+			atomic.AddInt64(&dispatched, 1)
+		},
+		SkippedStep: func(_ context.Context, step *QueryPlanStep) {
+			skippedLock.Lock()
+			defer skippedLock.Unlock()
+			skipped = append(skipped, step)
+		},
+		Plan: &QueryPlan{
+			RootStep: &QueryPlanStep{
+				Then: []*QueryPlanStep{
+					{
+						ParentType:     typeNameQuery,
+						InsertionPoint: []string{},
+						SelectionSet: ast.SelectionSet{
+							&ast.Field{
+								Name:       "users",
+								Definition: &ast.FieldDefinition{Type: ast.ListType(userType, &ast.Position{})},
+								SelectionSet: ast.SelectionSet{
+									&ast.Field{
+										Name:       "photoGallery",
+										Definition: &ast.FieldDefinition{Type: ast.ListType(ast.NamedType("Photo", &ast.Position{}), &ast.Position{})},
+										SelectionSet: ast.SelectionSet{
+											&ast.Field{
+												Name:       "likedBy",
+												Definition: &ast.FieldDefinition{Type: ast.ListType(userType, &ast.Position{})},
+												SelectionSet: ast.SelectionSet{
+													&ast.Field{
+														Name:       "id",
+														Definition: &ast.FieldDefinition{Type: ast.NamedType("ID", &ast.Position{})},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						Queryer: &graphql.MockSuccessQueryer{Value: map[string]interface{}{
+							"users": []interface{}{
+								map[string]interface{}{"photoGallery": []interface{}{
+									map[string]interface{}{"likedBy": []interface{}{}},
+									map[string]interface{}{"likedBy": nil},
+								}},
+								map[string]interface{}{"photoGallery": nil},
+								map[string]interface{}{"photoGallery": []interface{}{}},
+							},
+						}},
+						Then: []*QueryPlanStep{likedByStep},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"photoGallery": []interface{}{
+				map[string]interface{}{"likedBy": []interface{}{}},
+				map[string]interface{}{"likedBy": nil},
+			}},
+			map[string]interface{}{"photoGallery": nil},
+			map[string]interface{}{"photoGallery": []interface{}{}},
+		},
+	}, result)
+
+	// only the query for the users should have been sent
+	assert.Equal(t, int64(1), atomic.LoadInt64(&dispatched))
+	if assert.Len(t, skipped, 1) {
+		assert.Equal(t, likedByStep.InsertionPoint, skipped[0].InsertionPoint)
+	}
+}
+
 func TestExecutor_insertIntoFragmentSpread(t *testing.T) {
 	t.Parallel()
 	// the query we want to execute is
@@ -2019,6 +2132,253 @@ func TestExecutor_threadsVariables(t *testing.T) {
 	}
 }
 
+func TestExecutor_concurrencyLimit(t *testing.T) {
+	t.Parallel()
+	const limit = 2
+
+	// keep track of how many queries are in flight
+	var inFlight, maxInFlight int64
+	queryer := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		current := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			previous := atomic.LoadInt64(&maxInFlight)
+			if current <= previous || atomic.CompareAndSwapInt64(&maxInFlight, previous, current) {
+				break
+			}
+		}
+
+		// give the other queries a chance to pile up
+		time.Sleep(5 * time.Millisecond)
+		return map[string]interface{}{}, nil
+	})
+
+	// a plan with a lot of independent steps
+	steps := []*QueryPlanStep{}
+	for i := 0; i < 10; i++ {
+		steps = append(steps, &QueryPlanStep{
+			ParentType: typeNameQuery,
+			SelectionSet: ast.SelectionSet{
+				&ast.Field{
+					Name: fmt.Sprintf("field%d", i),
+					Definition: &ast.FieldDefinition{
+						Type: ast.NamedType("String", &ast.Position{}),
+					},
+				},
+			},
+			Queryer: queryer,
+		})
+	}
+
+	_, err := (&ParallelExecutor{}).Execute(&ExecutionContext{
+		logger:         &DefaultLogger{},
+		RequestContext: context.Background(),
+		Concurrency:    make(chan struct{}, limit),
+		Plan: &QueryPlan{
+			RootStep: &QueryPlanStep{Then: steps},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.LessOrEqual(t, atomic.LoadInt64(&maxInFlight), int64(limit))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&inFlight))
+}
+
+func TestExecutor_concurrencyReleasedOnError(t *testing.T) {
+	t.Parallel()
+	concurrency := make(chan struct{}, 1)
+
+	// the step fails after it got its slot: the service is down and the fields can't be nulled out
+	// since the fragment they come from is missing
+	_, err := (&ParallelExecutor{}).Execute(&ExecutionContext{
+		logger:         &DefaultLogger{},
+		RequestContext: context.Background(),
+		Concurrency:    concurrency,
+		NullDataPolicy: NullDataFailField,
+		Plan: &QueryPlan{
+			RootStep: &QueryPlanStep{Then: []*QueryPlanStep{{
+				ParentType:   typeNameQuery,
+				SelectionSet: ast.SelectionSet{&ast.FragmentSpread{Name: "Missing"}},
+				Queryer: graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+					return nil, errors.New("service is down")
+				}),
+			}}},
+		},
+	})
+	assert.Error(t, err)
+
+	// the slot is free for the next query
+	assert.Len(t, concurrency, 0)
+}
+
+func TestExecutor_concurrencyTimeout(t *testing.T) {
+	t.Parallel()
+
+	// every slot is already taken
+	concurrency := make(chan struct{}, 1)
+	concurrency <- struct{}{}
+
+	_, err := (&ParallelExecutor{}).Execute(&ExecutionContext{
+		logger:             &DefaultLogger{},
+		RequestContext:     context.Background(),
+		Concurrency:        concurrency,
+		ConcurrencyTimeout: 10 * time.Millisecond,
+		Plan: &QueryPlan{
+			RootStep: &QueryPlanStep{Then: []*QueryPlanStep{{
+				ParentType: typeNameQuery,
+				SelectionSet: ast.SelectionSet{
+					&ast.Field{
+						Name: "value",
+						Definition: &ast.FieldDefinition{
+							Type: ast.NamedType("String", &ast.Position{}),
+						},
+					},
+				},
+				Queryer: graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+					return map[string]interface{}{"value": "hello"}, nil
+				}),
+			}}},
+		},
+	})
+	backpressure := findBackpressureError(err)
+	if !assert.NotNil(t, backpressure) {
+		return
+	}
+	assert.Equal(t, 10*time.Millisecond, backpressure.RetryAfter)
+}
+
+func TestExecutor_trimsUnusedVariables(t *testing.T) {
+	t.Parallel()
+	// the parent operation defines two variables
+	fullVariables := map[string]interface{}{
+		"hello":   "world",
+		"goodbye": "moon",
+	}
+	idDef := &ast.VariableDefinition{
+		Variable: "id",
+		Type:     ast.NonNullNamedType("ID", &ast.Position{}),
+	}
+	helloDef := &ast.VariableDefinition{
+		Variable: "hello",
+		Type:     ast.NamedType("String", &ast.Position{}),
+	}
+	goodbyeDef := &ast.VariableDefinition{
+		Variable: "goodbye",
+		Type:     ast.NamedType("String", &ast.Position{}),
+	}
+
+	// the child step's document defines more variables than it uses
+	childDocument := &ast.QueryDocument{
+		Operations: ast.OperationList{
+			{
+				Operation:           ast.Query,
+				VariableDefinitions: ast.VariableDefinitionList{idDef, helloDef, goodbyeDef},
+				SelectionSet: ast.SelectionSet{
+					&ast.Field{
+						Name: "node",
+						Arguments: ast.ArgumentList{
+							{Name: "id", Value: &ast.Value{Kind: ast.Variable, Raw: "id"}},
+						},
+						SelectionSet: ast.SelectionSet{
+							&ast.InlineFragment{
+								TypeCondition: "User",
+								SelectionSet: ast.SelectionSet{
+									&ast.Field{
+										Name: "farewell",
+										Arguments: ast.ArgumentList{
+											{Name: "to", Value: &ast.Value{Kind: ast.Variable, Raw: "goodbye"}},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	result, err := (&ParallelExecutor{}).Execute(&ExecutionContext{
+		logger:         &DefaultLogger{},
+		RequestContext: context.Background(),
+		Variables:      fullVariables,
+		Plan: &QueryPlan{
+			Operation: &ast.OperationDefinition{
+				Operation:           ast.Query,
+				VariableDefinitions: ast.VariableDefinitionList{helloDef, goodbyeDef},
+			},
+			RootStep: &QueryPlanStep{
+				Then: []*QueryPlanStep{
+					{
+						// this is equivalent to
+						// query ($hello: String) { user(name: $hello) { id } }
+						ParentType: typeNameQuery,
+						SelectionSet: ast.SelectionSet{
+							&ast.Field{
+								Name: "user",
+								Definition: &ast.FieldDefinition{
+									Type: ast.NamedType("User", &ast.Position{}),
+								},
+								SelectionSet: ast.SelectionSet{
+									&ast.Field{
+										Name: "id",
+										Definition: &ast.FieldDefinition{
+											Type: ast.NamedType("ID", &ast.Position{}),
+										},
+									},
+								},
+							},
+						},
+						Variables: Set{"hello": true},
+						Queryer: graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+							assert.Equal(t, map[string]interface{}{"hello": "world"}, input.Variables)
+							return map[string]interface{}{"user": map[string]interface{}{"id": "1"}}, nil
+						}),
+						Then: []*QueryPlanStep{
+							{
+								ParentType:     "User",
+								InsertionPoint: []string{"user"},
+								SelectionSet: ast.SelectionSet{
+									&ast.Field{
+										Name: "farewell",
+										Definition: &ast.FieldDefinition{
+											Type: ast.NamedType("String", &ast.Position{}),
+										},
+									},
+								},
+								QueryDocument: childDocument,
+								Variables:     Set{"goodbye": true},
+								Queryer: graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+									// the child only receives the variable it uses
+									assert.Equal(t, map[string]interface{}{"goodbye": "moon", "id": "1"}, input.Variables)
+									assert.Equal(t, ast.VariableDefinitionList{idDef, goodbyeDef}, input.QueryDocument.Operations[0].VariableDefinitions)
+									assert.NotContains(t, input.Query, "$hello")
+									return map[string]interface{}{"node": map[string]interface{}{"farewell": "moon"}}, nil
+								}),
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":       "1",
+			"farewell": "moon",
+		},
+	}, result)
+
+	// the shared plan should not have been modified
+	assert.Len(t, childDocument.Operations[0].VariableDefinitions, 3)
+}
+
 func TestFindInsertionPoint_rootList(t *testing.T) {
 	t.Parallel()
 	// in this example, the step before would have just resolved (need to be inserted at)
@@ -2133,7 +2493,7 @@ func TestFindInsertionPoint_rootList(t *testing.T) {
 		},
 	}
 
-	generatedPoint, err := executorFindInsertionPoints(&ExecutionContext{logger: &DefaultLogger{}}, &sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, startingPoint, nil)
+	generatedPoint, err := executorFindInsertionPoints(&ExecutionContext{logger: &DefaultLogger{}}, &sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, startingPoint, nil, nil)
 	if err != nil {
 		t.Error(t, err)
 		return
@@ -2430,7 +2790,7 @@ func TestFindInsertionPoint_bailOnNil(t *testing.T) {
 		},
 	}
 
-	generatedPoint, err := executorFindInsertionPoints(&ExecutionContext{logger: &DefaultLogger{}}, &sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, [][]string{}, nil)
+	generatedPoint, err := executorFindInsertionPoints(&ExecutionContext{logger: &DefaultLogger{}}, &sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, [][]string{}, nil, nil)
 	if err != nil {
 		t.Error(t, err)
 		return
@@ -2439,6 +2799,43 @@ func TestFindInsertionPoint_bailOnNil(t *testing.T) {
 	assert.Equal(t, expected, generatedPoint)
 }
 
+func TestFindInsertionPoint_missingID(t *testing.T) {
+	t.Parallel()
+	// a search that returns a user and a photo without an id
+	result := map[string]interface{}{
+		"search": []interface{}{
+			map[string]interface{}{"id": "1", typenameField: "User"},
+			map[string]interface{}{typenameField: "Photo"},
+		},
+	}
+	stepSelectionSet := ast.SelectionSet{
+		&ast.Field{
+			Name: "search",
+			Definition: &ast.FieldDefinition{
+				Type: ast.ListType(ast.NamedType("SearchResult", &ast.Position{}), &ast.Position{}),
+			},
+		},
+	}
+	find := func(possibleTypes Set) ([][]string, error) {
+		return executorFindInsertionPoints(&ExecutionContext{logger: &DefaultLogger{}}, &sync.Mutex{}, []string{"search"}, stepSelectionSet, result, [][]string{{}}, nil, executorOtherTypes(possibleTypes))
+	}
+
+	// a step that applies to every type needs every id
+	_, err := find(nil)
+	assert.Error(t, err)
+
+	// so does a step that applies to the photo
+	_, err = find(Set{"Photo": true})
+	assert.Error(t, err)
+
+	// a step that only applies to users doesn't need the id of the photo
+	points, err := find(Set{"User": true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, [][]string{{"search:0#1"}, {"search:1"}}, points)
+}
+
 func TestFindInsertionPoint_stitchIntoObject(t *testing.T) {
 	t.Parallel()
 	// we want the list of insertion points that point to
@@ -2507,7 +2904,7 @@ func TestFindInsertionPoint_stitchIntoObject(t *testing.T) {
 		},
 	}
 
-	generatedPoint, err := executorFindInsertionPoints(&ExecutionContext{logger: &DefaultLogger{}}, &sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, startingPoint, nil)
+	generatedPoint, err := executorFindInsertionPoints(&ExecutionContext{logger: &DefaultLogger{}}, &sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, startingPoint, nil, nil)
 	if err != nil {
 		t.Error(t, err)
 		return
@@ -2723,3 +3120,247 @@ func TestExecutor_plansWithManyDeepDependencies(t *testing.T) {
 		},
 	}, result)
 }
+
+func TestExecutorInsertObject_matchListElementsByID(t *testing.T) {
+	t.Parallel()
+	// the service returned the list in a different order than when we computed the insertion points
+	source := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"id": "2"},
+			map[string]interface{}{"id": "1"},
+			map[string]interface{}{"name": "no id"},
+		},
+	}
+
+	// insert the result for the user with id 1, which used to be first
+	err := executorInsertObject(&ExecutionContext{logger: &DefaultLogger{}}, source, &sync.Mutex{}, []string{"users:0#1"}, map[string]interface{}{
+		"firstName": "one",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// objects without an id are still found by their index
+	err = executorInsertObject(&ExecutionContext{logger: &DefaultLogger{}}, source, &sync.Mutex{}, []string{"users:2#3"}, map[string]interface{}{
+		"firstName": "three",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"id": "2"},
+			map[string]interface{}{"id": "1", "firstName": "one"},
+			map[string]interface{}{"name": "no id", "firstName": "three"},
+		},
+	}, source)
+}
+
+func TestExecutorInsertObject_nullIntermediateValues(t *testing.T) {
+	t.Parallel()
+	// parts of the response came back null, probably alongside an error
+	source := map[string]interface{}{
+		"user":    nil,
+		"friends": nil,
+		"users": []interface{}{
+			nil,
+			map[string]interface{}{"id": "2", "address": nil},
+		},
+	}
+
+	for _, path := range [][]string{
+		{"user", "address"},
+		{"friends:0#1"},
+		{"users:0#1", "address"},
+		{"users:1#2", "address", "city"},
+	} {
+		err := executorInsertObject(&ExecutionContext{logger: &DefaultLogger{}}, source, &sync.Mutex{}, path, map[string]interface{}{
+			"name": "hello",
+		})
+		assert.NoError(t, err, path)
+	}
+
+	// nothing was inserted and the nulls are left alone
+	assert.Equal(t, map[string]interface{}{
+		"user":    nil,
+		"friends": nil,
+		"users": []interface{}{
+			nil,
+			map[string]interface{}{"id": "2", "address": nil},
+		},
+	}, source)
+}
+
+func TestExecutor_singleStepPartialSuccess(t *testing.T) {
+	t.Parallel()
+	// a plan that only talks to one service should keep the data that came back alongside the errors
+	result, err := (&ParallelExecutor{}).Execute(&ExecutionContext{
+		logger:         &DefaultLogger{},
+		RequestContext: context.Background(),
+		Plan: &QueryPlan{
+			RootStep: &QueryPlanStep{
+				Then: []*QueryPlanStep{
+					{
+						ParentType: typeNameQuery,
+						SelectionSet: ast.SelectionSet{
+							&ast.Field{Name: "values", Alias: "values"},
+						},
+						Queryer: graphql.QueryerFunc(
+							func(input *graphql.QueryInput) (interface{}, error) {
+								return map[string]interface{}{"values": []interface{}{"hello", nil}}, graphql.ErrorList{errors.New("one"), errors.New("two")}
+							},
+						),
+					},
+				},
+			},
+		},
+	})
+
+	var list graphql.ErrorList
+	if !assert.True(t, errors.As(err, &list)) {
+		return
+	}
+	assert.Len(t, list, 2)
+	assert.Equal(t, map[string]interface{}{"values": []interface{}{"hello", nil}}, result)
+}
+
+func TestExecutor_nonObjectResponse(t *testing.T) {
+	t.Parallel()
+	// a service that responds with a list on the other end of the network
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": ["hello", "world"]}`)
+	}))
+	defer server.Close()
+
+	funcQueryer := func(value interface{}) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return value, nil
+		})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		queryer graphql.Queryer
+		kind    string
+	}{
+		{"list", funcQueryer([]interface{}{"hello"}), "a list"},
+		{"string", funcQueryer("hello"), "a string"},
+		{"number", funcQueryer(float64(1)), "a number"},
+		{"boolean", funcQueryer(true), "a boolean"},
+		{"network", graphql.NewSingleRequestQueryer(server.URL), "a list"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			// the service is closed when the test returns so the sub-tests can't run in parallel
+			result, err := (&ParallelExecutor{}).Execute(&ExecutionContext{
+				logger:         &DefaultLogger{},
+				RequestContext: context.Background(),
+				Plan: &QueryPlan{
+					RootStep: &QueryPlanStep{
+						Then: []*QueryPlanStep{
+							{
+								ParentType: typeNameQuery,
+								Location:   "url1",
+								SelectionSet: ast.SelectionSet{
+									&ast.Field{Name: "values", Alias: "values"},
+								},
+								Queryer: tc.queryer,
+							},
+						},
+					},
+				},
+			})
+			assert.Empty(t, result)
+
+			var list graphql.ErrorList
+			require.ErrorAs(t, err, &list)
+			require.Len(t, list, 1)
+			var gqlErr *graphql.Error
+			require.ErrorAs(t, list[0], &gqlErr)
+			assert.Equal(t, "the service at url1 responded with "+tc.kind+" instead of an object", gqlErr.Message)
+			assert.Equal(t, "BAD_UPSTREAM_RESPONSE", gqlErr.Extensions["code"])
+			assert.Equal(t, "url1", gqlErr.Extensions["serviceUrl"])
+		})
+	}
+}
+
+func BenchmarkExecutor_singleStep(b *testing.B) {
+	plan := &QueryPlan{
+		RootStep: &QueryPlanStep{
+			Then: []*QueryPlanStep{
+				{
+					ParentType: typeNameQuery,
+					SelectionSet: ast.SelectionSet{
+						&ast.Field{Name: "values", Alias: "values"},
+					},
+					Queryer: &graphql.MockSuccessQueryer{Value: map[string]interface{}{
+						"values": []interface{}{"hello", "world"},
+					}},
+				},
+			},
+		},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := (&ParallelExecutor{}).Execute(&ExecutionContext{
+			logger:         &DefaultLogger{},
+			RequestContext: context.Background(),
+			Plan:           plan,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindInsertionPoints_wideList(b *testing.B) {
+	listField := func(name string, typeName string, selectionSet ast.SelectionSet) *ast.Field {
+		return &ast.Field{
+			Name:         name,
+			Definition:   &ast.FieldDefinition{Type: ast.ListType(ast.NamedType(typeName, &ast.Position{}), &ast.Position{})},
+			SelectionSet: selectionSet,
+		}
+	}
+	selectionSet := ast.SelectionSet{
+		listField("users", "User", ast.SelectionSet{
+			listField("photoGallery", "Photo", ast.SelectionSet{
+				listField("likedBy", "User", ast.SelectionSet{
+					&ast.Field{Name: "id", Definition: &ast.FieldDefinition{Type: ast.NamedType("ID", &ast.Position{})}},
+				}),
+			}),
+		}),
+	}
+
+	// 10 users with 10 photos liked by 10 users each gives 1000 insertion points
+	const width = 10
+	users := make([]interface{}, width)
+	for i := range users {
+		photos := make([]interface{}, width)
+		for j := range photos {
+			likedBy := make([]interface{}, width)
+			for k := range likedBy {
+				likedBy[k] = map[string]interface{}{"id": strconv.Itoa(i*width*width + j*width + k)}
+			}
+			photos[j] = map[string]interface{}{"likedBy": likedBy}
+		}
+		users[i] = map[string]interface{}{"photoGallery": photos}
+	}
+	result := map[string]interface{}{"users": users}
+	targetPoints := []string{"users", "photoGallery", "likedBy"}
+	ctx := &ExecutionContext{logger: &DefaultLogger{}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		points, err := executorFindInsertionPoints(ctx, &sync.Mutex{}, targetPoints, selectionSet, result, [][]string{}, nil, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(points) != width*width*width {
+			b.Fatalf("expected %d insertion points, found %d", width*width*width, len(points))
+		}
+	}
+}
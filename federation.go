@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"bytes"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+)
+
+// the names of the field and type that federated gateways use to ask a service for its schema
+const (
+	serviceFieldName = "_service"
+	serviceTypeName  = "_Service"
+)
+
+// WithFederationServiceField returns an Option that adds the `_service { sdl }` field of the federation spec
+// to the gateway's schema so that the gateway can be used as a service of a federated gateway. The field
+// is resolved by the gateway with the SDL of the schema it composed.
+func WithFederationServiceField(enabled bool) Option {
+	return func(g *Gateway) {
+		g.federationServiceField = enabled
+	}
+}
+
+// composedSDL returns the SDL of the schema built by the gateway, without the field the SDL is served from
+func composedSDL(schema *ast.Schema) string {
+	// we don't want to modify the schema the gateway uses
+	published := *schema
+	published.Types = map[string]*ast.Definition{}
+	for name, definition := range schema.Types {
+		if name != serviceTypeName {
+			published.Types[name] = definition
+		}
+	}
+
+	query := *schema.Query
+	query.Fields = ast.FieldList{}
+	for _, field := range schema.Query.Fields {
+		if field.Name != serviceFieldName {
+			query.Fields = append(query.Fields, field)
+		}
+	}
+	published.Query = &query
+	published.Types[query.Name] = &query
+
+	buf := &bytes.Buffer{}
+	formatter.NewFormatter(buf).FormatSchema(&published)
+	return buf.String()
+}
+
+// resolveServiceField returns the value of the _service field for the given selection set
+func (g *Gateway) resolveServiceField(selectionSet ast.SelectionSet) map[string]interface{} {
+	service := map[string]interface{}{}
+	for _, field := range graphql.SelectedFields(selectionSet) {
+		switch field.Name {
+		case "sdl":
+			service[field.Alias] = g.serviceSDL
+		case typenameField:
+			service[field.Alias] = serviceTypeName
+		}
+	}
+	return service
+}
@@ -0,0 +1,452 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// WithFieldLocationOverrides returns an Option that sends the fields in the map to the given services instead of
+// the ones computed from the schemas. The keys look like the ones of the computed map, ie. "User.avatar", and
+// every service a field is sent to has to declare it.
+func WithFieldLocationOverrides(overrides FieldURLMap) Option {
+	return func(g *Gateway) {
+		if g.fieldLocationOverrides == nil {
+			g.fieldLocationOverrides = FieldURLMap{}
+		}
+		for key, urls := range overrides {
+			g.fieldLocationOverrides[key] = urls
+		}
+	}
+}
+
+// WithFieldPin returns an Option that always sends the field to the service at the given url, even if other
+// services can resolve it and the priorities say otherwise. Unlike a priority, a pin never falls back: if the
+// service can't resolve the field, queries that ask for it fail to plan. This is meant for hot paths that need
+// to be routed the same way every time.
+func WithFieldPin(typeName string, field string, url string) Option {
+	return func(g *Gateway) {
+		if g.fieldPins == nil {
+			g.fieldPins = FieldURLMap{}
+		}
+		g.fieldPins[g.fieldPins.keyFor(typeName, field)] = []string{url}
+	}
+}
+
+// DescribeField returns the locations of the services that can resolve the field, starting with the one the
+// planner sends it to when nothing else in the query decides for it: the service the field is pinned to, or
+// the first of the location priorities that can resolve it. In a query, the planner also prefers the service
+// that resolved the parent object and the ones it already has to visit for the field's siblings. Returns nil
+// if the gateway doesn't know where to find the field.
+func (g *Gateway) DescribeField(typeName string, field string) []string {
+	possibleLocations, err := g.currentFieldURLs().URLFor(typeName, field)
+	if err != nil || len(possibleLocations) == 0 {
+		return nil
+	}
+
+	// ask the planner which one it would pick
+	planner := &MinQueriesPlanner{LocationPriorities: g.locationPriorities}
+	location, err := planner.selectFieldLocation(&PlanningContext{Gateway: g, Schema: g.schema}, typeName, &ast.Field{Name: field}, possibleLocations, &extractSelectionConfig{}, nil)
+	if err != nil {
+		return append([]string{}, possibleLocations...)
+	}
+
+	locations := []string{location}
+	for _, possibleLocation := range possibleLocations {
+		if possibleLocation != location {
+			locations = append(locations, possibleLocation)
+		}
+	}
+	return locations
+}
+
+// ServiceInfo describes what the gateway sends to one of its services
+type ServiceInfo struct {
+	// URL is the location of the service
+	URL string `json:"url"`
+	// Types holds the sorted names of the fields of each type that are sent to the service. A field named *
+	// stands for the fields of the type that aren't listed on their own.
+	Types map[string][]string `json:"types"`
+	// FieldCount is the number of fields that are sent to the service
+	FieldCount int `json:"fieldCount"`
+}
+
+// Services returns the services the gateway was built with, in the order they were given, along with the
+// fields that are currently sent to each of them.
+func (g *Gateway) Services() []ServiceInfo {
+	services := []ServiceInfo{}
+	byURL := map[string]*ServiceInfo{}
+	for _, source := range g.sources {
+		services = append(services, ServiceInfo{URL: source.URL, Types: map[string][]string{}})
+	}
+	for i := range services {
+		byURL[services[i].URL] = &services[i]
+	}
+
+	for key, urls := range g.currentFieldURLs() {
+		// every service can resolve __typename so it doesn't tell us anything
+		typeName, field, ok := splitFieldKey(key)
+		if !ok || strings.HasPrefix(field, "__") {
+			continue
+		}
+		for _, url := range urls {
+			if service, ok := byURL[url]; ok {
+				service.Types[typeName] = append(service.Types[typeName], field)
+				service.FieldCount++
+			}
+		}
+	}
+
+	for _, service := range services {
+		for _, fields := range service.Types {
+			sort.Strings(fields)
+		}
+	}
+
+	return services
+}
+
+// SetFieldLocation sends the field to the given services instead of the ones it was sent to before. Every
+// service has to declare the field. Plans that are already in the query plan cache keep the locations they
+// were built with.
+func (g *Gateway) SetFieldLocation(typeName string, field string, urls ...string) error {
+	g.fieldURLsLock.Lock()
+	defer g.fieldURLsLock.Unlock()
+
+	if err := validateFieldLocation(g.schema, g.declaredFieldURLs, typeName, field, urls); err != nil {
+		return err
+	}
+
+	// the planners that are running hold onto the current map so we have to replace it instead of changing it
+	locations := FieldURLMap{}
+	for key, value := range g.fieldURLs {
+		locations[key] = value
+	}
+	locations[locations.keyFor(typeName, field)] = append([]string{}, urls...)
+	g.fieldURLs = locations
+
+	return nil
+}
+
+// FieldURLs returns a copy of the map of the services each field is sent to
+func (g *Gateway) FieldURLs() FieldURLMap {
+	locations := FieldURLMap{}
+	for key, urls := range g.currentFieldURLs() {
+		locations[key] = append([]string{}, urls...)
+	}
+	return locations
+}
+
+// currentFieldURLs returns the map of the services each field is sent to. It must not be modified.
+func (g *Gateway) currentFieldURLs() FieldURLMap {
+	g.fieldURLsLock.RLock()
+	defer g.fieldURLsLock.RUnlock()
+	return g.fieldURLs
+}
+
+// applyFieldLocationOverrides replaces the computed locations of the fields that the user chose to send somewhere else
+func applyFieldLocationOverrides(schema *ast.Schema, locations FieldURLMap, overrides FieldURLMap) error {
+	// make sure every override is valid before we touch the map the others are checked against
+	for key, urls := range overrides {
+		typeName, field, ok := splitFieldKey(key)
+		if !ok {
+			return fmt.Errorf("invalid field location override %q, expected a key like Type.field", key)
+		}
+		if err := validateFieldLocation(schema, locations, typeName, field, urls); err != nil {
+			return err
+		}
+	}
+
+	for key, urls := range overrides {
+		locations[key] = append([]string{}, urls...)
+	}
+
+	return nil
+}
+
+// validateFieldLocation makes sure that the field exists and that every one of the urls declares it
+func validateFieldLocation(schema *ast.Schema, declared FieldURLMap, typeName string, field string, urls []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("%s.%s must be sent to at least one service", typeName, field)
+	}
+
+	definition, ok := schema.Types[typeName]
+	if !ok {
+		return fmt.Errorf("cannot set the location of %s.%s: unknown type %s", typeName, field, typeName)
+	}
+	if field != typenameField && definition.Fields.ForName(field) == nil {
+		return fmt.Errorf("cannot set the location of %s.%s: %s does not have a field named %s", typeName, field, typeName, field)
+	}
+
+	declaredURLs, err := declared.URLFor(typeName, field)
+	if err != nil {
+		return err
+	}
+	declaredBy := Set{}
+	for _, url := range declaredURLs {
+		declaredBy.Add(url)
+	}
+	for _, url := range urls {
+		if !declaredBy.Has(url) {
+			return fmt.Errorf("cannot send %s.%s to %s: the service does not declare the field", typeName, field, url)
+		}
+	}
+
+	return nil
+}
+
+// splitFieldKey breaks a key of a FieldURLMap into the type and the field
+func splitFieldKey(key string) (string, string, bool) {
+	dot := strings.Index(key, ".")
+	if dot <= 0 || dot == len(key)-1 {
+		return "", "", false
+	}
+	return key[:dot], key[dot+1:], true
+}
+
+// BoundaryCycleError is returned by Validate when the services can send a query back and forth between them for
+// as long as the query keeps nesting fields. Each trip around a cycle is another round trip to a service that
+// can't start until the one before it is done.
+type BoundaryCycleError struct {
+	// Cycles holds a path for each cycle, ie. "User.posts (users) -> Post.author (posts) -> User (users)"
+	Cycles []string
+}
+
+func (e *BoundaryCycleError) Error() string {
+	return fmt.Sprintf("the services form boundary cycles: %s", strings.Join(e.Cycles, "; "))
+}
+
+// Validate looks at how the fields are spread across the services for problems that don't stop the gateway from
+// working but could make some queries expensive to plan and execute. It returns a *BoundaryCycleError if a type
+// resolved by one service has a field that leads, through the objects of other services, back to the same type
+// in the same service. Call it once the gateway is built to warn about (or refuse) such a setup.
+func (g *Gateway) Validate() error {
+	cycles := boundaryCycles(g.schema, g.currentFieldURLs())
+	if len(cycles) == 0 {
+		return nil
+	}
+	return &BoundaryCycleError{Cycles: cycles}
+}
+
+// boundaryEdge leads from the objects of a type resolved by one service to the objects that one of their fields
+// returns. If the service can't resolve every field of those objects, it also leads to the services that can.
+type boundaryEdge struct {
+	field string
+	to    string
+	hop   bool
+}
+
+// boundaryNode is the name of the node in the boundary graph for the objects of a type resolved by a service
+func boundaryNode(typeName string, location string) string {
+	return fmt.Sprintf("%s (%s)", typeName, location)
+}
+
+// boundaryGraph builds the graph of the ways a query can move between the services, keyed by boundaryNode
+func boundaryGraph(schema *ast.Schema, locations FieldURLMap) map[string][]boundaryEdge {
+	graph := map[string][]boundaryEdge{}
+
+	// the services that have to be visited for the fields of a type the given service can't resolve
+	otherLocations := func(definition *ast.Definition, location string) []string {
+		others := Set{}
+		for _, field := range definition.Fields {
+			fieldLocations, err := locations.URLFor(definition.Name, field.Name)
+			if err != nil || strings.HasPrefix(field.Name, "__") {
+				continue
+			}
+			resolved := false
+			for _, fieldLocation := range fieldLocations {
+				resolved = resolved || fieldLocation == location
+			}
+			if !resolved {
+				for _, fieldLocation := range fieldLocations {
+					others.Add(fieldLocation)
+				}
+			}
+		}
+
+		result := make([]string, 0, len(others))
+		for other := range others {
+			result = append(result, other)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	for _, definition := range schema.Types {
+		if definition.Kind != ast.Object && definition.Kind != ast.Interface {
+			continue
+		}
+
+		for _, field := range definition.Fields {
+			if strings.HasPrefix(field.Name, "__") {
+				continue
+			}
+			fieldType := schema.Types[field.Type.Name()]
+			if fieldType == nil || (fieldType.Kind != ast.Object && fieldType.Kind != ast.Interface) {
+				continue
+			}
+			fieldLocations, err := locations.URLFor(definition.Name, field.Name)
+			if err != nil {
+				continue
+			}
+
+			for _, location := range fieldLocations {
+				from := boundaryNode(definition.Name, location)
+				label := fmt.Sprintf("%s.%s (%s)", definition.Name, field.Name, location)
+
+				// the service that resolved the field can keep going with the fields of the objects it returned
+				graph[from] = append(graph[from], boundaryEdge{field: label, to: boundaryNode(fieldType.Name, location)})
+				// and the rest of the fields are looked up in the other services by the objects' ids
+				for _, other := range otherLocations(fieldType, location) {
+					graph[from] = append(graph[from], boundaryEdge{field: label, to: boundaryNode(fieldType.Name, other), hop: true})
+				}
+			}
+		}
+	}
+
+	return graph
+}
+
+// boundaryCycles returns a path around each group of types in the boundary graph that a query can keep going
+// around while moving from one service to another. Nesting the fields of a single service is free so the cycles
+// that stay in one service aren't reported.
+func boundaryCycles(schema *ast.Schema, locations FieldURLMap) []string {
+	graph := boundaryGraph(schema, locations)
+
+	// visit the nodes in a stable order so the same cycles are always reported
+	nodes := make([]string, 0, len(graph))
+	for node := range graph {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	cycles := []string{}
+	for _, component := range boundaryComponents(graph, nodes) {
+		// a component that never leaves its service doesn't cost any round trips
+		for _, node := range nodes {
+			if !component[node] {
+				continue
+			}
+
+			var hop *boundaryEdge
+			for i, edge := range graph[node] {
+				if edge.hop && component[edge.to] {
+					hop = &graph[node][i]
+					break
+				}
+			}
+			if hop == nil {
+				continue
+			}
+
+			// find the shortest way back to where the hop started
+			path := boundaryPath(graph, component, hop.to, node)
+			cycle := []string{hop.field}
+			cycle = append(cycle, path...)
+			cycles = append(cycles, strings.Join(append(cycle, node), " -> "))
+			break
+		}
+	}
+
+	return cycles
+}
+
+// boundaryComponents returns the strongly connected components of the boundary graph that have a cycle in them
+func boundaryComponents(graph map[string][]boundaryEdge, nodes []string) []map[string]bool {
+	index := map[string]int{}
+	lowLink := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	components := []map[string]bool{}
+
+	var connect func(node string)
+	connect = func(node string) {
+		index[node] = len(index)
+		lowLink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+
+		for _, edge := range graph[node] {
+			if _, visited := index[edge.to]; !visited {
+				connect(edge.to)
+				if lowLink[edge.to] < lowLink[node] {
+					lowLink[node] = lowLink[edge.to]
+				}
+			} else if onStack[edge.to] && index[edge.to] < lowLink[node] {
+				lowLink[node] = index[edge.to]
+			}
+		}
+
+		// if the node is the root of a component, everything above it on the stack belongs to it
+		if lowLink[node] != index[node] {
+			return
+		}
+		component := map[string]bool{}
+		for {
+			last := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[last] = false
+			component[last] = true
+			if last == node {
+				break
+			}
+		}
+
+		// a node on its own is only a cycle if it leads back to itself
+		if len(component) == 1 {
+			selfLoop := false
+			for _, edge := range graph[node] {
+				selfLoop = selfLoop || edge.to == node
+			}
+			if !selfLoop {
+				return
+			}
+		}
+		components = append(components, component)
+	}
+
+	for _, node := range nodes {
+		if _, visited := index[node]; !visited {
+			connect(node)
+		}
+	}
+
+	return components
+}
+
+// boundaryPath returns the fields on the shortest path between two nodes of a component, without the last node
+func boundaryPath(graph map[string][]boundaryEdge, component map[string]bool, from string, to string) []string {
+	type step struct {
+		node  string
+		field string
+		prev  *step
+	}
+
+	visited := map[string]bool{from: true}
+	queue := []*step{{node: from}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, edge := range graph[current.node] {
+			if !component[edge.to] || visited[edge.to] && edge.to != to {
+				continue
+			}
+			next := &step{node: edge.to, field: edge.field, prev: current}
+			if edge.to == to {
+				// walk back to where we started
+				path := []string{}
+				for s := next; s.prev != nil; s = s.prev {
+					path = append([]string{s.field}, path...)
+				}
+				return path
+			}
+			visited[edge.to] = true
+			queue = append(queue, next)
+		}
+	}
+
+	return nil
+}
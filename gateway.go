@@ -1,13 +1,22 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/validator"
 
 	"github.com/nautilus/graphql"
 )
@@ -25,15 +34,130 @@ type Gateway struct {
 	middlewares        MiddlewareList
 	queryFields        []*QueryField
 	queryerFactory     *QueryerFactory
+	upstreamQueryers   map[string]graphql.Queryer
 	queryPlanCache     QueryPlanCache
 	locationPriorities []string
+	unknownDirectives  UnknownDirectivePolicy
+	maxBatchSize       int
+	maxRequestBodySize int64
+	playgroundUI       PlaygroundUI
+	maxTimeout         time.Duration
+	bubbleNulls        bool
+	extensionsMerger   ExtensionsMerger
+	headerForwarder    UpstreamHeaderForwarder
+	rateLimiter        RateLimiter
+	concurrency        int
+	globalConcurrency  chan struct{}
+	concurrencyTimeout time.Duration
+	// the number of parsed queries the planner holds onto
+	parsedQueryCacheSize int
+
+	// the functions used to read and write the JSON sent over HTTP
+	jsonMarshal   func(interface{}) ([]byte, error)
+	jsonUnmarshal func([]byte, interface{}) error
 
 	// group up the list of middlewares at startup to avoid it during execution
 	requestMiddlewares  []graphql.NetworkMiddleware
 	responseMiddlewares []ResponseMiddleware
+	// locationMiddlewares are the request middlewares that only apply to one service
+	locationMiddlewares map[string][]graphql.NetworkMiddleware
 
-	// the urls we have to visit to access certain fields
-	fieldURLs FieldURLMap
+	// the urls we have to visit to access certain fields. SetFieldLocation replaces the map so it's
+	// guarded by fieldURLsLock
+	fieldURLs     FieldURLMap
+	fieldURLsLock sync.RWMutex
+	// declaredFieldURLs are the urls of the services that declare each field
+	declaredFieldURLs FieldURLMap
+	// the arguments of fields that are filled in with other fields of their parent
+	requirements fieldRequirements
+	// keys are the fields that identify the types that aren't stitched together by their id
+	keys typeKeys
+	// upstreamOperationNamer picks the name of the operation sent to the services for each step
+	upstreamOperationNamer UpstreamOperationNamer
+	// upstreamQueryRewriter adapts the document sent to the services for each step
+	upstreamQueryRewriter UpstreamQueryRewriter
+	// requestLocationPriorities picks the locations to prefer for a request sent over HTTP
+	requestLocationPriorities func(r *http.Request) []string
+	// planCacheBypass decides if a request sent over HTTP can skip the query plan cache with the NoPlanCacheHeader
+	planCacheBypass func(r *http.Request) bool
+	// contextBuilder creates the context that a request sent over HTTP is handled under
+	contextBuilder func(r *http.Request) context.Context
+	// persistedQueryHasher identifies the queries in the automatic query plan cache
+	persistedQueryHasher func(query string) string
+	// federationServiceField adds the _service field of the federation spec to the schema
+	federationServiceField bool
+	// serviceSDL is the SDL of the composed schema served by the _service field
+	serviceSDL string
+	// stripErrorLocations removes the locations from the errors returned by the services
+	stripErrorLocations bool
+	// the functions called around the query of each step and for the steps that don't need to run
+	beforeStep  BeforeStepHook
+	afterStep   AfterStepHook
+	skippedStep SkippedStepHook
+	// withoutNode leaves the Node interface and the node field out of the schema
+	withoutNode bool
+	// forwardedExtensionKeys are the extensions of the client's request that are sent to the services
+	forwardedExtensionKeys []string
+	// nullDataPolicy decides what happens to the fields of a step whose service only returned errors
+	nullDataPolicy NullDataPolicy
+	// fieldLocationOverrides are the locations the user picked for fields instead of the computed ones
+	fieldLocationOverrides FieldURLMap
+	// requestIDHeader is the header that carries the id of each request, if they get one
+	requestIDHeader    string
+	requestIDGenerator func() string
+	// readOnly rejects every mutation
+	readOnly bool
+	// withoutAbstractTypenames stops the planner from asking for the __typename of interfaces and unions
+	withoutAbstractTypenames bool
+	// upstreamClient is the client of the queryers built for the services if it isn't the shared one. It's
+	// built from the timeout, transport, and user agent of the options
+	upstreamClient        *http.Client
+	upstreamTimeout       time.Duration
+	upstreamBaseTransport *http.Transport
+	// stepTimeout limits how long the query of each step can take
+	stepTimeout time.Duration
+	// scalarValidators check the values of custom scalars in the variables of each request
+	scalarValidators map[string]ScalarValidator
+	// lenientFragments drops the fragments of a query that can never apply instead of rejecting the query
+	lenientFragments bool
+	// withoutIntrospection rejects queries for __schema and __type and turns off the IntrospectionHandler
+	withoutIntrospection bool
+	// fieldPins are the services that fields always have to be sent to
+	fieldPins FieldURLMap
+	// requestSlots limits the number of requests the GraphQLHandler serves at once
+	requestSlots        chan struct{}
+	requestQueuePolicy  RequestQueuePolicy
+	requestQueueTimeout time.Duration
+	// internalQueryer resolves the fields the gateway adds itself if something other than the gateway should
+	internalQueryerFactory func(g *Gateway) graphql.Queryer
+	internalQueryer        graphql.Queryer
+	// alwaysOK responds with a 200 to operations that can't be planned
+	alwaysOK bool
+	// userAgent is the User-Agent of the requests sent to the services
+	userAgent string
+	// maxUploadFiles limits the number of files in a multipart request
+	maxUploadFiles int
+	// unknownFieldFallback is the service that fields without a location are sent to
+	unknownFieldFallback string
+	// orderErrors sorts the errors of a response by their path and dedupErrors drops the repeated ones
+	orderErrors bool
+	dedupErrors bool
+	// headerPropagationPredicate picks the headers of the client's request that are sent to the services
+	headerPropagationPredicate func(name string) bool
+	// responseVisitor is called for every field of the responses
+	responseVisitor ResponseVisitor
+	// nullabilityMergeStrategy is given to the DefaultMerger
+	nullabilityMergeStrategy NullabilityMergeStrategy
+	// responseInfoHeaders adds the hash of the schema and the version of the gateway to the responses
+	responseInfoHeaders bool
+	schemaHash          string
+	version             string
+	// maxResponseBytes limits the size of the responses of the GraphQLHandler
+	maxResponseBytes int64
+	// strictVariables rejects the operations sent with variables they don't declare
+	strictVariables bool
+	// mergeConflictReporter hears about the conflicts between the schemas that the DefaultMerger reconciles
+	mergeConflictReporter func(MergeConflict)
 }
 
 // RequestContext holds all of the information required to satisfy the user's query
@@ -43,58 +167,256 @@ type RequestContext struct {
 	OperationName string
 	Variables     map[string]interface{}
 	CacheKey      string
+	// Extensions are the extensions the client sent along with the operation
+	Extensions map[string]interface{}
+	// Request and ResponseWriter are only set when the operation was sent over HTTP
+	Request        *http.Request
+	ResponseWriter http.ResponseWriter
+	// ResponseExtensions is set by Execute to the extensions that belong in the response
+	ResponseExtensions map[string]interface{}
+	// LocationPriorities are the locations to prefer when a field can be resolved by more
+	// than one service, ahead of the ones passed to WithLocationPriorities
+	LocationPriorities []string
+	// SkipPlanCache plans the query without looking in the query plan cache or saving the plan to it.
+	// It has no effect if the client only sent the hash of the query.
+	SkipPlanCache bool
+	// Stream asks for the entries of the lists marked with @stream past their initialCount to be sent on their
+	// own once they are resolved, if the executor can
+	Stream bool
+	// StreamedItems is set by Execute when there are entries to stream. Their response middlewares have already
+	// run and the channel is closed after the last one, which has to be read for the operation to finish.
+	StreamedItems <-chan *StreamedItem
 }
 
 func (g *Gateway) GetPlans(ctx *RequestContext) (QueryPlanList, error) {
-	// let the persister grab the plan for us
-	return g.queryPlanCache.Retrieve(&PlanningContext{
-		Query:     ctx.Query,
-		Schema:    g.schema,
-		Gateway:   g,
-		Locations: g.fieldURLs,
-	}, &ctx.CacheKey, g.planner)
+	planningContext := &PlanningContext{
+		Query:              ctx.Query,
+		Schema:             g.schema,
+		Gateway:            g,
+		Locations:          g.currentFieldURLs(),
+		LocationPriorities: ctx.LocationPriorities,
+	}
+
+	var plans QueryPlanList
+	var err error
+	if (len(ctx.LocationPriorities) > 0 || ctx.SkipPlanCache) && ctx.Query != "" {
+		// a cached plan could have been built for different priorities (or the request doesn't want it) so we
+		// have to plan the query ourselves. If the client only sent the hash of the query, the cached plan is the
+		// best we can do.
+		plans, err = g.planner.Plan(planningContext)
+	} else {
+		// let the persister grab the plan for us
+		plans, err = g.queryPlanCache.Retrieve(planningContext, &ctx.CacheKey, g.planner)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a document with more than one operation is only valid if we know which one to run
+	if _, err := planForOperation(ctx, plans); err != nil {
+		return nil, err
+	}
+
+	return plans, nil
+}
+
+// planForOperation returns the plan in the list that the request wants to execute
+func planForOperation(ctx *RequestContext, plans QueryPlanList) (*QueryPlan, error) {
+	// if there is only one plan (one operation) then use it
+	if len(plans) == 1 {
+		return plans[0], nil
+	}
+
+	// if we weren't given an operation name then we don't know which one to send
+	if ctx.OperationName == "" {
+		return nil, graphql.ErrorList{graphql.NewError(
+			"BAD_USER_INPUT",
+			fmt.Sprintf("the document contains %d operations, please provide an operationName to choose which one to run", len(plans)),
+		)}
+	}
+
+	// find the plan for the right operation
+	plan, err := plans.ForOperation(ctx.OperationName)
+	if err != nil {
+		return nil, graphql.ErrorList{graphql.NewError("BAD_USER_INPUT", err.Error())}
+	}
+
+	return plan, nil
 }
 
 // Execute takes a query string, executes it, and returns the response
 func (g *Gateway) Execute(ctx *RequestContext, plans QueryPlanList) (map[string]interface{}, error) {
 	// the plan we mean to execute
-	var plan *QueryPlan
+	plan, err := planForOperation(ctx, plans)
+	if err != nil {
+		return nil, err
+	}
 
-	// if there is only one plan (one operation) then use it
-	if len(plans) == 1 {
-		plan = plans[0]
-	} else {
-		// if we weren't given an operation name then we don't know which one to send
-		if ctx.OperationName == "" {
-			return nil, errors.New("please provide an operation name")
+	// a read only gateway can't change anything
+	if g.readOnly && plan.Operation != nil && plan.Operation.Operation == ast.Mutation {
+		return nil, graphql.ErrorList{graphql.NewError("FORBIDDEN", "mutations are disabled on this gateway")}
+	}
+
+	// so can one that doesn't want to share its schema
+	if g.withoutIntrospection && plan.Operation != nil {
+		if err := rejectIntrospection(plan); err != nil {
+			return nil, err
+		}
+	}
+
+	// coerce the variables against the operation's definitions so that the upstream
+	// services see any default values and we reject invalid input before dispatching
+	variables := ctx.Variables
+	if plan.Operation != nil {
+		// a variable that the operation doesn't declare is probably a bug in the client
+		if g.strictVariables {
+			if err := rejectUndeclaredVariables(plan.Operation, ctx.Variables); err != nil {
+				return nil, graphql.ErrorList{err}
+			}
 		}
 
-		// find the plan for the right operation
-		operationPlan, err := plans.ForOperation(ctx.OperationName)
+		coerced, err := validator.VariableValues(g.schema, plan.Operation, ctx.Variables)
 		if err != nil {
-			return nil, err
+			return nil, graphql.ErrorList{graphql.NewError("BAD_USER_INPUT", err.Error())}
+		}
+		variables = coerced
+
+		// the validator doesn't know about input objects that take exactly one field
+		if err := validateOneOf(g.schema, plan.Operation, plan.FragmentDefinitions, variables); err != nil {
+			return nil, graphql.ErrorList{err}
 		}
 
-		// use the one for the operation
-		plan = operationPlan
+		// custom scalars can be checked once here instead of by every service
+		if err := g.validateScalars(plan.Operation, variables); err != nil {
+			return nil, graphql.ErrorList{err}
+		}
+	}
+
+	// the context that the plan is executed under
+	requestContext := ctx.Context
+	if requestContext == nil {
+		requestContext = context.Background()
+	}
+
+	// operations that didn't come through the GraphQLHandler still need an id
+	if g.requestIDHeader != "" && RequestID(requestContext) == "" {
+		requestContext = withRequestID(requestContext, g.requestIDGenerator())
+	}
+
+	// if the operation asked for a deadline then we need to apply it
+	timeout, err := g.operationTimeout(plan.Operation, variables)
+	if err != nil {
+		return nil, graphql.ErrorList{graphql.NewError("BAD_USER_INPUT", err.Error())}
+	}
+	// the deadline has to last as long as the entries that are streamed after we return
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		requestContext, cancel = context.WithTimeout(requestContext, timeout)
+	}
+	defer func() {
+		if ctx.StreamedItems == nil {
+			cancel()
+		}
+	}()
+
+	// the lists marked with @stream have to be streamed the way the client asked
+	if ctx.Stream {
+		if _, err := planStreamedLists(plan, variables); err != nil {
+			return nil, graphql.ErrorList{graphql.NewError("BAD_USER_INPUT", err.Error())}
+		}
+	}
+
+	// some of the client's extensions might have to be passed along to the services
+	if forwarded := g.forwardedExtensions(ctx.Extensions); len(forwarded) > 0 {
+		requestContext = withForwardedExtensions(requestContext, forwarded)
+	}
+
+	// some of the client's headers might have to be passed along too
+	if g.headerPropagationPredicate != nil && ctx.Request != nil {
+		if propagated := g.propagatedHeaders(ctx.Request); len(propagated) > 0 {
+			requestContext = withPropagatedHeaders(requestContext, propagated)
+		}
+	}
+
+	// if we need to pass along parts of the upstream responses, we have to capture them
+	var collector *upstreamCollector
+	if g.extensionsMerger != nil || (g.headerForwarder != nil && ctx.ResponseWriter != nil) {
+		collector = &upstreamCollector{captureExtensions: g.extensionsMerger != nil}
+		requestContext = withUpstreamCollector(requestContext, collector)
 	}
 
 	// build up the execution context
 	executionContext := &ExecutionContext{
-		logger:             g.logger,
-		RequestContext:     ctx.Context,
-		RequestMiddlewares: g.requestMiddlewares,
-		Plan:               plan,
-		Variables:          ctx.Variables,
+		logger:              g.requestLogger(requestContext),
+		RequestContext:      requestContext,
+		RequestMiddlewares:  g.requestMiddlewares,
+		LocationMiddlewares: g.locationMiddlewares,
+		Plan:                plan,
+		Variables:           variables,
+		Request:             ctx.Request,
+		ResponseWriter:      ctx.ResponseWriter,
+		BubbleNulls:         g.bubbleNulls,
+		Concurrency:         g.globalConcurrency,
+		ConcurrencyTimeout:  g.concurrencyTimeout,
+		StripErrorLocations: g.stripErrorLocations,
+		BeforeStep:          g.beforeStep,
+		AfterStep:           g.afterStep,
+		SkippedStep:         g.skippedStep,
+		NullDataPolicy:      g.nullDataPolicy,
+		StepTimeout:         g.stepTimeout,
+	}
+
+	// if there is a limit for each request then it gets its own slots
+	if g.concurrency > 0 {
+		executionContext.Concurrency = make(chan struct{}, g.concurrency)
 	}
 
 	// TODO: handle plans of more than one query
 	// execute the plan and return the results
-	result, executeErr := g.executor.Execute(executionContext)
+	var result map[string]interface{}
+	var executeErr error
+	var streamedItems <-chan *StreamedItem
+	if streamer, ok := g.executor.(StreamingExecutor); ok && ctx.Stream {
+		result, streamedItems, executeErr = streamer.ExecuteStream(executionContext)
+	} else {
+		result, executeErr = g.executor.Execute(executionContext)
+	}
+
+	// if we ran out of time, let the user know alongside whatever data we did get
+	if timeout > 0 && errors.Is(requestContext.Err(), context.DeadlineExceeded) {
+		timeoutErr := graphql.NewError("TIMEOUT", fmt.Sprintf("operation exceeded its timeout of %s", timeout))
+
+		switch err := executeErr.(type) {
+		case nil:
+			executeErr = graphql.ErrorList{timeoutErr}
+		case graphql.ErrorList:
+			executeErr = append(err, timeoutErr)
+		default:
+			executeErr = graphql.ErrorList{err, timeoutErr}
+		}
+	}
+
 	if executeErr != nil && len(result) == 0 {
 		result = nil
 	}
 
+	// the errors of steps that ran at the same time could be in any order
+	executeErr = g.arrangeErrors(executeErr)
+
+	// combine the extensions from every service we visited
+	if collector != nil && g.extensionsMerger != nil {
+		ctx.ResponseExtensions = g.extensionsMerger(collector.Extensions())
+	}
+
+	// pass along whatever headers the services agree on
+	if collector != nil && g.headerForwarder != nil && ctx.ResponseWriter != nil {
+		for key, values := range g.headerForwarder(collector.Headers()) {
+			for _, value := range values {
+				ctx.ResponseWriter.Header().Add(key, value)
+			}
+		}
+	}
+
 	// now that we have our response, throw it through the list of middlewarse
 	for _, ware := range g.responseMiddlewares {
 		if err := ware(executionContext, result); err != nil {
@@ -102,13 +424,160 @@ func (g *Gateway) Execute(ctx *RequestContext, plans QueryPlanList) (map[string]
 		}
 	}
 
+	// the streamed entries go through the same middlewares once they are resolved
+	if streamedItems != nil {
+		forwarded := make(chan *StreamedItem)
+		ctx.StreamedItems = forwarded
+		go g.forwardStreamedItems(executionContext, streamedItems, forwarded, cancel)
+	}
+
 	// we're done here
 	return result, executeErr
 }
 
+// forwardStreamedItems passes the entries streamed by the executor through the response middlewares
+func (g *Gateway) forwardStreamedItems(ctx *ExecutionContext, items <-chan *StreamedItem, forwarded chan<- *StreamedItem, cancel context.CancelFunc) {
+	defer cancel()
+	defer close(forwarded)
+
+	for item := range items {
+		for _, ware := range g.responseMiddlewares {
+			if err := ware(ctx, item.Data); err != nil {
+				item.Data = nil
+				item.Errors = append(item.Errors, executorErrorList(err)...)
+				break
+			}
+		}
+		forwarded <- item
+	}
+}
+
+// Queryer returns a graphql.Queryer that executes operations against the gateway's composed schema
+// in the same process, following the same planning and execution as the GraphQLHandler. Whatever
+// data could be resolved is decoded into the receiver alongside any errors. The queryer is safe to
+// use from multiple goroutines at the same time.
+func (g *Gateway) Queryer() graphql.Queryer {
+	return &gatewayQueryer{gateway: g}
+}
+
+// gatewayQueryer sends queries through the full gateway
+type gatewayQueryer struct {
+	gateway *Gateway
+}
+
+// Query plans and executes the query and decodes the result into the receiver
+func (q *gatewayQueryer) Query(ctx context.Context, input *graphql.QueryInput, receiver interface{}) error {
+	// we plan from the query string so we might have to print the document
+	query := input.Query
+	if query == "" && input.QueryDocument != nil {
+		printed, err := graphql.PrintQuery(input.QueryDocument)
+		if err != nil {
+			return err
+		}
+		query = printed
+	}
+
+	requestContext := &RequestContext{
+		Context:       ctx,
+		Query:         query,
+		OperationName: input.OperationName,
+		Variables:     input.Variables,
+	}
+
+	plans, err := q.gateway.GetPlans(requestContext)
+	if err != nil {
+		return err
+	}
+
+	result, executeErr := q.gateway.Execute(requestContext, plans)
+
+	// hand back whatever data we got, even if something went wrong
+	if result != nil {
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			TagName: "json",
+			Result:  receiver,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := decoder.Decode(result); err != nil {
+			return err
+		}
+	}
+
+	return executeErr
+}
+
+// operationTimeout returns the deadline requested by the operation's @timeout directive, capped
+// by the gateway's maximum. A zero duration means the operation should not be given a deadline.
+func (g *Gateway) operationTimeout(operation *ast.OperationDefinition, variables map[string]interface{}) (time.Duration, error) {
+	// the operation might not have asked for a deadline
+	var directive *ast.Directive
+	if operation != nil {
+		directive = operation.Directives.ForName(timeoutDirective)
+	}
+	if directive == nil {
+		return 0, nil
+	}
+
+	arg := directive.Arguments.ForName("ms")
+	if arg == nil {
+		return 0, fmt.Errorf("@%s requires an ms argument", timeoutDirective)
+	}
+
+	value, err := arg.Value.Value(variables)
+	if err != nil {
+		return 0, err
+	}
+
+	// the value could come from the document or from the variables
+	ms, ok := numberValue(value)
+	if !ok {
+		return 0, fmt.Errorf("@%s ms must be an integer", timeoutDirective)
+	}
+	if ms != math.Trunc(ms) {
+		return 0, fmt.Errorf("@%s ms must be an integer", timeoutDirective)
+	}
+	// anything less would not give the operation a deadline at all
+	if ms < 1 {
+		return 0, fmt.Errorf("@%s ms must be at least 1", timeoutDirective)
+	}
+
+	timeout := time.Duration(ms) * time.Millisecond
+
+	// make sure the client can't ask for more time than we are willing to give
+	if g.maxTimeout > 0 && timeout > g.maxTimeout {
+		timeout = g.maxTimeout
+	}
+
+	return timeout, nil
+}
+
+// numberValue returns the numeric value of an argument, which could have come from
+// the document or from the variables
+func numberValue(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int64:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case float64:
+		return value, true
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	default:
+		return 0, false
+	}
+}
+
 func (g *Gateway) internalSchema() (*ast.Schema, error) {
 	// we start off with the internal schema
 	schema, err := graphql.LoadSchema(`
+		directive @timeout(ms: Int!) on QUERY | MUTATION
+		directive @stream(initialCount: Int = 0, label: String) on FIELD
+
 		interface Node {
 			id: ID!
 		}
@@ -121,6 +590,34 @@ func (g *Gateway) internalSchema() (*ast.Schema, error) {
 		return nil, fmt.Errorf("Syntax error in schema string: %w", err)
 	}
 
+	// if there is nothing to stitch together then there's no need for the node field
+	if g.withoutNode {
+		delete(schema.Types, "Node")
+		delete(schema.PossibleTypes, "Node")
+		queryFields := ast.FieldList{}
+		for _, field := range schema.Query.Fields {
+			if field.Name != "node" {
+				queryFields = append(queryFields, field)
+			}
+		}
+		schema.Query.Fields = queryFields
+	}
+
+	// federated gateways ask their services for their schema with the _service field
+	if g.federationServiceField {
+		schema.Types[serviceTypeName] = &ast.Definition{
+			Kind: ast.Object,
+			Name: serviceTypeName,
+			Fields: ast.FieldList{
+				{Name: "sdl", Type: ast.NamedType("String", nil)},
+			},
+		}
+		schema.Query.Fields = append(schema.Query.Fields, &ast.FieldDefinition{
+			Name: serviceFieldName,
+			Type: ast.NonNullNamedType(serviceTypeName, nil),
+		})
+	}
+
 	// then we have to add any query fields we have
 	for _, field := range g.queryFields {
 		if field.Name != "node" { // skip internal Query field name
@@ -143,15 +640,35 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 		return nil, errors.New("a gateway must have at least one schema")
 	}
 
+	// make sure that every source is something we can work with
+	sourceErrs := graphql.ErrorList{}
+	seenURLs := map[string]bool{}
+	for ix, source := range sources {
+		if source == nil || source.Schema == nil {
+			sourceErrs = append(sourceErrs, fmt.Errorf("source %d does not have a schema", ix))
+			continue
+		}
+		if seenURLs[source.URL] {
+			sourceErrs = append(sourceErrs, fmt.Errorf("source %d has a duplicate url: %s", ix, source.URL))
+		}
+		seenURLs[source.URL] = true
+	}
+	if len(sourceErrs) > 0 {
+		return nil, sourceErrs
+	}
+
 	// set any default values before we start doing stuff with it
 	gateway := &Gateway{
 		sources:        sources,
 		planner:        &MinQueriesPlanner{},
 		executor:       &ParallelExecutor{},
 		logger:         &DefaultLogger{},
-		merger:         MergerFunc(mergeSchemas),
+		merger:         &DefaultMerger{},
 		queryFields:    []*QueryField{makeNodeField()},
 		queryPlanCache: &NoQueryPlanCache{},
+		jsonMarshal:    json.Marshal,
+		jsonUnmarshal:  json.Unmarshal,
+		userAgent:      DefaultUpstreamUserAgent,
 	}
 
 	// pass the gateway through any Options
@@ -159,6 +676,9 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 		config(gateway)
 	}
 
+	// the queryers the gateway builds share one client
+	gateway.upstreamClient = gateway.buildUpstreamClient()
+
 	// if we have a queryer factory to assign
 	if gateway.queryerFactory != nil {
 		// if the planner can accept the factory
@@ -167,6 +687,14 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 		}
 	}
 
+	// if we have queryers registered for specific urls
+	if gateway.upstreamQueryers != nil {
+		// if the planner can accept the queryers
+		if planner, ok := gateway.planner.(PlannerWithUpstreamQueryers); ok {
+			gateway.planner = planner.WithUpstreamQueryers(gateway.upstreamQueryers)
+		}
+	}
+
 	// if we have location priorities to assign
 	if gateway.locationPriorities != nil {
 		// if the planner can accept the priorities
@@ -175,13 +703,72 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 		}
 	}
 
+	// if unknown directives should be treated differently than the default
+	if gateway.unknownDirectives != UnknownDirectivesError {
+		// if the planner can accept the policy
+		if planner, ok := gateway.planner.(PlannerWithUnknownDirectivePolicy); ok {
+			gateway.planner = planner.WithUnknownDirectivePolicy(gateway.unknownDirectives)
+		}
+	}
+
+	// if we should hold onto the queries we've parsed
+	if gateway.parsedQueryCacheSize > 0 {
+		// if the planner can cache them
+		if planner, ok := gateway.planner.(PlannerWithParsedQueryCacheSize); ok {
+			gateway.planner = planner.WithParsedQueryCacheSize(gateway.parsedQueryCacheSize)
+		}
+	}
+
+	// services don't have to use the conventional names for their root types so
+	// we need to line them up before we can look at the schemas together
+	normalizedSources := []*graphql.RemoteSchema{}
+	for _, source := range sources {
+		normalizedSources = append(normalizedSources, &graphql.RemoteSchema{
+			URL:    source.URL,
+			Schema: normalizeRootTypes(source.Schema),
+		})
+	}
+
+	// the gateway stitches types together with the node field so it can only be left out if there
+	// is nothing to stitch: the services don't share any types and the gateway doesn't resolve any fields
+	if gateway.withoutNode {
+		if shared := sharedTypeName(normalizedSources); shared != "" {
+			return nil, fmt.Errorf("the node field is needed to stitch %s together", shared)
+		}
+		for _, field := range gateway.queryFields {
+			if field.Name != "node" {
+				return nil, fmt.Errorf("the node field is needed to stitch the query field %s", field.Name)
+			}
+		}
+		gateway.queryFields = []*QueryField{}
+	}
+
 	internal, err := gateway.internalSchema()
 	if err != nil {
 		return nil, err
 	}
+
+	// some fields need other fields of their parent to be resolved
+	requirements, err := collectFieldRequirements(normalizedSources)
+	if err != nil {
+		return nil, err
+	}
+	if err := requirements.checkCycles(); err != nil {
+		return nil, err
+	}
+
+	// and some types are identified by a field other than their id
+	keys, err := collectTypeKeys(normalizedSources)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range normalizedSources {
+		source.Schema = stripKeyDirectives(source.Schema)
+	}
+
 	// find the field URLs before we merge schemas. We need to make sure to include
 	// the fields defined by the gateway's internal schema
-	urls := fieldURLs(sources, true).Concat(
+	urls := fieldURLs(normalizedSources, true).Concat(
 		fieldURLs([]*graphql.RemoteSchema{
 			{
 				URL:    internalSchemaLocation,
@@ -192,9 +779,37 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 		),
 	)
 
+	// a field that's non-null because one of the services says so can only be trusted if the gateway enforces it
+	if gateway.nullabilityMergeStrategy == NullabilityMostStrict && !gateway.bubbleNulls {
+		return nil, errors.New("the NullabilityMostStrict merge strategy needs WithNullBubbling")
+	}
+
+	// the default merger might have to be more lenient about nullability
+	if merger, ok := gateway.merger.(*DefaultMerger); ok && gateway.nullabilityMergeStrategy != NullabilityStrict {
+		withNullability := *merger
+		withNullability.Nullability = gateway.nullabilityMergeStrategy
+		gateway.merger = &withNullability
+	}
+
+	// and tell someone about the conflicts it reconciles, along with the services involved
+	if merger, ok := gateway.merger.(*DefaultMerger); ok && gateway.mergeConflictReporter != nil {
+		withReporter := *merger
+		withReporter.ConflictReporter = func(conflict MergeConflict) {
+			for _, ix := range conflict.Sources {
+				url := internalSchemaLocation
+				if ix < len(sources) {
+					url = sources[ix].URL
+				}
+				conflict.URLs = append(conflict.URLs, url)
+			}
+			gateway.mergeConflictReporter(conflict)
+		}
+		gateway.merger = &withReporter
+	}
+
 	// grab the schemas within each source
 	sourceSchemas := []*ast.Schema{}
-	for _, source := range sources {
+	for _, source := range normalizedSources {
 		sourceSchemas = append(sourceSchemas, source.Schema)
 	}
 	sourceSchemas = append(sourceSchemas, internal)
@@ -202,12 +817,31 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 	// merge them into one
 	schema, err := gateway.merger.Merge(sourceSchemas)
 	if err != nil {
+		// if the merge failed because of a conflict, point to the services that were involved
+		var mergeErr *MergeError
+		if errors.As(err, &mergeErr) {
+			mergeErr.URLs = nil
+			for _, ix := range mergeErr.Sources {
+				url := internalSchemaLocation
+				if ix < len(sources) {
+					url = sources[ix].URL
+				}
+				mergeErr.URLs = append(mergeErr.URLs, url)
+			}
+		}
+
 		// if something went wrong during the merge, return the result
 		return nil, err
 	}
 
 	// the default request middlewares
 	requestMiddlewares := []graphql.NetworkMiddleware{}
+	if gateway.requestIDHeader != "" {
+		requestMiddlewares = append(requestMiddlewares, gateway.forwardRequestID)
+	}
+	if gateway.headerPropagationPredicate != nil {
+		requestMiddlewares = append(requestMiddlewares, gateway.propagateHeaders)
+	}
 	// before we do anything that the user tells us to, we have to scrub the fields
 	responseMiddlewares := []ResponseMiddleware{scrubInsertionIDs}
 
@@ -223,18 +857,57 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 		}
 	}
 
+	// the visitor sees the response once everyone else is done with it
+	if gateway.responseVisitor != nil {
+		responseMiddlewares = append(responseMiddlewares, gateway.visitResponse)
+	}
+
 	// we should be able to ask for the id under a gateway field without going to another service
 	// that requires that the gateway knows that it is a place it can get the `id`
 	for _, field := range gateway.queryFields {
 		urls.RegisterURL(field.Type.Name(), "id", internalSchemaLocation)
 	}
 
+	// the user can pick which of the services that declare a field it is sent to
+	declaredURLs := FieldURLMap{}
+	for key, value := range urls {
+		declaredURLs[key] = value
+	}
+	if err := applyFieldLocationOverrides(schema, urls, gateway.fieldLocationOverrides); err != nil {
+		return nil, err
+	}
+
+	// clients can't provide the arguments that the gateway fills in
+	requirements.hideArguments(schema)
+
+	// the SDL doesn't change so we only have to render it once
+	if gateway.federationServiceField {
+		gateway.serviceSDL = composedSDL(schema)
+	}
+
+	// the schema doesn't change either so neither does its hash, and the binary doesn't change its version
+	if gateway.responseInfoHeaders {
+		gateway.schemaHash = persistedQueryHash(composedSDL(schema))
+		gateway.version = moduleVersion()
+		if gateway.version == "" {
+			gateway.version = "(devel)"
+		}
+	}
+
 	// assign the computed values
 	gateway.schema = schema
+	gateway.requirements = requirements
+	gateway.keys = keys
 	gateway.fieldURLs = urls
+	gateway.declaredFieldURLs = declaredURLs
 	gateway.requestMiddlewares = requestMiddlewares
 	gateway.responseMiddlewares = responseMiddlewares
 
+	// the internal queryer wraps the gateway so it can only be built once everything else is in place
+	if gateway.internalQueryerFactory != nil {
+		gateway.internalQueryer = gateway.internalQueryerFactory(gateway)
+	}
+
 	// we're done here
 	return gateway, nil
 }
@@ -257,13 +930,33 @@ func WithExecutor(e Executor) Option {
 	}
 }
 
-// WithMerger returns an Option that sets the merger of the gateway
+// WithMerger returns an Option that sets the merger of the gateway. Pass a DefaultMerger with some
+// of its hooks set to change how one kind of definition is merged and keep the rest of the behavior.
 func WithMerger(m Merger) Option {
 	return func(g *Gateway) {
 		g.merger = m
 	}
 }
 
+// WithNullabilityMergeStrategy returns an Option that decides what to do with fields of object and interface
+// types that are nullable in some services and non-null in others. By default (NullabilityStrict), the
+// schemas can't be merged. Only the DefaultMerger looks at the strategy. Whatever the choice, some of the
+// services return values that don't match the merged type so read the documentation of each strategy first.
+func WithNullabilityMergeStrategy(strategy NullabilityMergeStrategy) Option {
+	return func(g *Gateway) {
+		g.nullabilityMergeStrategy = strategy
+	}
+}
+
+// WithMergeConflictReporter returns an Option that calls the reporter whenever the schemas of the services disagree
+// but can be merged anyway, for example when they give the same field different descriptions and the first one
+// is kept. It's a way to keep an eye on the services drifting apart. Only the DefaultMerger reports conflicts.
+func WithMergeConflictReporter(reporter func(MergeConflict)) Option {
+	return func(g *Gateway) {
+		g.mergeConflictReporter = reporter
+	}
+}
+
 // WithMiddlewares returns an Option that adds middlewares to the gateway
 func WithMiddlewares(middlewares ...Middleware) Option {
 	return func(g *Gateway) {
@@ -271,6 +964,18 @@ func WithMiddlewares(middlewares ...Middleware) Option {
 	}
 }
 
+// WithMiddlewareFor returns an Option that adds a request middleware that only applies to the queries sent to
+// the service at the given url, for example to authenticate with services that expect different credentials.
+// It runs after the middlewares that apply to every service.
+func WithMiddlewareFor(url string, middleware RequestMiddleware) Option {
+	return func(g *Gateway) {
+		if g.locationMiddlewares == nil {
+			g.locationMiddlewares = map[string][]graphql.NetworkMiddleware{}
+		}
+		g.locationMiddlewares[url] = append(g.locationMiddlewares[url], graphql.NetworkMiddleware(middleware))
+	}
+}
+
 // WithQueryFields returns an Option that adds the given query fields to the gateway
 func WithQueryFields(fields ...*QueryField) Option {
 	return func(g *Gateway) {
@@ -286,12 +991,348 @@ func WithQueryerFactory(factory *QueryerFactory) Option {
 	}
 }
 
+// WithUpstreamQueryer returns an Option that sends every query for the given url to the provided
+// queryer instead of the one built by the planner. This allows services to be embedded in the
+// same process as the gateway without a network hop.
+func WithUpstreamQueryer(url string, queryer graphql.Queryer) Option {
+	return func(g *Gateway) {
+		if g.upstreamQueryers == nil {
+			g.upstreamQueryers = map[string]graphql.Queryer{}
+		}
+		g.upstreamQueryers[url] = queryer
+	}
+}
+
 func WithLocationPriorities(priorities []string) Option {
 	return func(g *Gateway) {
 		g.locationPriorities = priorities
 	}
 }
 
+// WithRequestLocationPriorities returns an Option that picks the locations to prefer for each request
+// sent over HTTP, ie. to send the fields of a client that just wrote some data to the primary service.
+// The locations come before the ones passed to WithLocationPriorities.
+func WithRequestLocationPriorities(priorities func(r *http.Request) []string) Option {
+	return func(g *Gateway) {
+		g.requestLocationPriorities = priorities
+	}
+}
+
+// WithPlanCacheBypass returns an Option that lets the requests sent over HTTP for which allowed returns true skip
+// the query plan cache by setting the NoPlanCacheHeader. Planning is much more expensive than looking up a plan
+// so the header is ignored unless the gateway is built with this option, which should only allow trusted
+// requests, for example the ones of an admin while debugging the planner.
+func WithPlanCacheBypass(allowed func(r *http.Request) bool) Option {
+	return func(g *Gateway) {
+		g.planCacheBypass = allowed
+	}
+}
+
+// WithStepTimeout returns an Option that limits how long the query of each step of a plan can take, whatever
+// queryer sends it. The step fails with a TIMEOUT error while the rest of the plan carries on. The deadline of
+// the operation still applies, so the shorter of the two wins, and WithUpstreamRequestTimeout limits each
+// HTTP request sent by the queryers the gateway builds on top of that. A value of 0 (the default) doesn't
+// limit the steps.
+func WithStepTimeout(timeout time.Duration) Option {
+	return func(g *Gateway) {
+		g.stepTimeout = timeout
+	}
+}
+
+// WithMaxTimeout returns an Option that caps the deadline an operation can ask for with the
+// @timeout directive. A value of 0 (the default) does not cap the requested deadline.
+func WithMaxTimeout(timeout time.Duration) Option {
+	return func(g *Gateway) {
+		g.maxTimeout = timeout
+	}
+}
+
+// WithExecutionConcurrency returns an Option that limits the number of queries each operation can
+// have in flight at once. A value of 0 (the default) does not limit the number of queries. When
+// set, this takes the place of any limit set with WithGlobalExecutionConcurrency.
+func WithExecutionConcurrency(n int) Option {
+	return func(g *Gateway) {
+		g.concurrency = n
+	}
+}
+
+// WithGlobalExecutionConcurrency returns an Option that limits the number of queries that can be
+// in flight at once across every operation the gateway is executing.
+func WithGlobalExecutionConcurrency(n int) Option {
+	return func(g *Gateway) {
+		if n > 0 {
+			g.globalConcurrency = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithNullBubbling returns an Option that enforces the non-null fields of an operation. When a service
+// returns null for a non-null field, the nearest nullable parent is set to null and an error is added
+// to the response, as described by the GraphQL specification.
+func WithNullBubbling() Option {
+	return func(g *Gateway) {
+		g.bubbleNulls = true
+	}
+}
+
+// WithStripUpstreamErrorLocations returns an Option that removes the locations from the errors returned
+// by the services. Those locations point into the query the gateway sent to the service which isn't the
+// document the client wrote. Only the queryers that return *gqlerror.Error values have locations to strip:
+// the errors of the services the gateway reaches over HTTP are parsed into *graphql.Error values, which
+// have no locations, so those never reach the client whether or not this option is set.
+func WithStripUpstreamErrorLocations(strip bool) Option {
+	return func(g *Gateway) {
+		g.stripErrorLocations = strip
+	}
+}
+
+// WithoutNodeInterface returns an Option that leaves the Node interface and the node field the gateway adds
+// out of its schema. They are only needed to stitch types that are spread across services so New returns an
+// error if any service shares an object or interface type with another, or if the gateway has query fields
+// of its own.
+func WithoutNodeInterface(without bool) Option {
+	return func(g *Gateway) {
+		g.withoutNode = without
+	}
+}
+
+// WithReadOnly returns an Option that rejects every mutation with a FORBIDDEN error before anything is sent
+// to the services, for deployments like read replicas or maintenance windows. Queries are not affected.
+func WithReadOnly(readOnly bool) Option {
+	return func(g *Gateway) {
+		g.readOnly = readOnly
+	}
+}
+
+// WithStrictVariables returns an Option that rejects operations sent with variables they don't declare with a
+// BAD_USER_INPUT error instead of ignoring the extra variables, which is the default.
+func WithStrictVariables(strict bool) Option {
+	return func(g *Gateway) {
+		g.strictVariables = strict
+	}
+}
+
+// rejectUndeclaredVariables returns an error naming the variables that the operation doesn't declare
+func rejectUndeclaredVariables(operation *ast.OperationDefinition, variables map[string]interface{}) error {
+	undeclared := []string{}
+	for name := range variables {
+		if operation.VariableDefinitions.ForName(name) == nil {
+			undeclared = append(undeclared, "$"+name)
+		}
+	}
+	if len(undeclared) == 0 {
+		return nil
+	}
+	sort.Strings(undeclared)
+
+	return graphql.NewError("BAD_USER_INPUT", fmt.Sprintf("the operation does not declare the variables: %s", strings.Join(undeclared, ", ")))
+}
+
+// WithLenientFragments returns an Option that quietly drops the fragments of a query whose type condition
+// doesn't exist or can never match the type they are used in, like "... on Photo" inside of a User. By
+// default those queries fail validation, which is what the spec asks for, but some older clients rely
+// on servers that were more forgiving.
+func WithLenientFragments(lenient bool) Option {
+	return func(g *Gateway) {
+		g.lenientFragments = lenient
+	}
+}
+
+// WithIntrospection returns an Option that decides whether clients can look at the gateway's schema. It's
+// enabled by default. When disabled, queries for __schema or __type fail with a FORBIDDEN error and the
+// IntrospectionHandler responds with a 404, which is usually what you want in production.
+func WithIntrospection(enabled bool) Option {
+	return func(g *Gateway) {
+		g.withoutIntrospection = !enabled
+	}
+}
+
+// rejectIntrospection returns an error if the plan's operation asks for the schema
+func rejectIntrospection(plan *QueryPlan) error {
+	selection, err := graphql.ApplyFragments(plan.Operation.SelectionSet, plan.FragmentDefinitions)
+	if err != nil {
+		return err
+	}
+	for _, field := range graphql.SelectedFields(selection) {
+		if field.Name == "__schema" || field.Name == "__type" {
+			return graphql.ErrorList{graphql.NewError("FORBIDDEN", "introspection is disabled on this gateway")}
+		}
+	}
+	return nil
+}
+
+// WithInternalQueryer returns an Option that changes the queryer used for the fields the gateway resolves
+// itself, like node and the introspection fields. The function is called once, at the end of New, with
+// the gateway, which is the queryer used by default. The returned queryer can wrap the gateway to add
+// instrumentation or caching, or resolve some of the fields on its own.
+func WithInternalQueryer(factory func(g *Gateway) graphql.Queryer) Option {
+	return func(g *Gateway) {
+		g.internalQueryerFactory = factory
+	}
+}
+
+// WithUnknownFieldFallback returns an Option that sends the fields the gateway doesn't know the location of to
+// the service at the given url instead of failing to plan the query, which can help while a field moves between
+// services. The service either resolves the field or responds with an error of its own. Queries are still
+// validated against the gateway's schema so the field has to be part of it. This hides mistakes in the
+// gateway's configuration so it's off by default and every field sent to the fallback is logged.
+func WithUnknownFieldFallback(url string) Option {
+	return func(g *Gateway) {
+		g.unknownFieldFallback = url
+	}
+}
+
+// WithoutAbstractTypenames returns an Option that stops the planner from asking the services for the
+// __typename of every interface and union in a query. The gateway asks for it by default, like Apollo and
+// Relay clients do, and leaves it out of the response if the client didn't ask for it.
+func WithoutAbstractTypenames(without bool) Option {
+	return func(g *Gateway) {
+		g.withoutAbstractTypenames = without
+	}
+}
+
+// WithBeforeStep returns an Option that calls the hook before the query of each step of a plan is sent to
+// its service. Steps run in parallel so the hook has to be safe to call from more than one goroutine.
+func WithBeforeStep(hook BeforeStepHook) Option {
+	return func(g *Gateway) {
+		g.beforeStep = hook
+	}
+}
+
+// WithAfterStep returns an Option that calls the hook with the response of the service for each step of a
+// plan. Steps run in parallel so the hook has to be safe to call from more than one goroutine.
+func WithAfterStep(hook AfterStepHook) Option {
+	return func(g *Gateway) {
+		g.afterStep = hook
+	}
+}
+
+// WithSkippedStep returns an Option that calls the hook for each step of a plan that isn't sent to its service
+// because the objects it applies to came back null or as an empty list, for example to keep track of the
+// upstream queries that a plan didn't need. The hook has to be safe to call from more than one goroutine.
+func WithSkippedStep(hook SkippedStepHook) Option {
+	return func(g *Gateway) {
+		g.skippedStep = hook
+	}
+}
+
+// WithMaxRequestBodySize returns an Option that limits the size of the bodies the GraphQLHandler reads,
+// uploads included. Requests with bigger bodies are rejected with a 413. A value of 0 or less removes the limit.
+// By default, JSON bodies are limited to 1MB and multipart bodies are not limited.
+func WithMaxRequestBodySize(bytes int64) Option {
+	return func(g *Gateway) {
+		g.maxRequestBodySize = bytes
+		if bytes <= 0 {
+			g.maxRequestBodySize = -1
+		}
+	}
+}
+
+// WithUnknownDirectivePolicy returns an Option that sets what happens to queries that use directives
+// the gateway's schema does not declare. By default, those queries are rejected.
+func WithUnknownDirectivePolicy(policy UnknownDirectivePolicy) Option {
+	return func(g *Gateway) {
+		g.unknownDirectives = policy
+	}
+}
+
+// WithNullDataPolicy returns an Option that sets what happens to the fields of a step when its service
+// responds with errors and no data. By default, the errors are passed along and the fields are left out.
+func WithNullDataPolicy(policy NullDataPolicy) Option {
+	return func(g *Gateway) {
+		g.nullDataPolicy = policy
+	}
+}
+
+// WithJSONCodec returns an Option that sets the functions used to parse incoming requests and
+// write responses, including batches and incremental responses. By default, the gateway uses encoding/json.
+func WithJSONCodec(marshal func(interface{}) ([]byte, error), unmarshal func([]byte, interface{}) error) Option {
+	return func(g *Gateway) {
+		if marshal != nil {
+			g.jsonMarshal = marshal
+		}
+		if unmarshal != nil {
+			g.jsonUnmarshal = unmarshal
+		}
+	}
+}
+
+// WithUseJSONNumber returns an Option that decodes the numbers in incoming requests as json.Number instead of
+// float64, so large integers like 64-bit ids reach the services exactly as the client sent them. It replaces the
+// unmarshal function set with WithJSONCodec, and WithJSONCodec replaces this one if it comes after. Passing
+// false leaves the unmarshal function alone.
+func WithUseJSONNumber(useNumber bool) Option {
+	return func(g *Gateway) {
+		if useNumber {
+			g.jsonUnmarshal = jsonUnmarshalUseNumber
+		}
+	}
+}
+
+// jsonUnmarshalUseNumber behaves like json.Unmarshal but decodes numbers as json.Number
+func jsonUnmarshalUseNumber(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	// json.Unmarshal doesn't allow anything after the value either
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
+// UpstreamOperationNamer returns the name of the operation sent to a service for a step of the plan,
+// given the name of the operation the client sent (which could be empty). The result has to be a valid
+// GraphQL name. An empty result keeps the name of the client's operation.
+type UpstreamOperationNamer func(clientName string, step *QueryPlanStep) string
+
+// WithUpstreamOperationNamer returns an Option that sets the name of the operations sent to the services
+// so that their logs can be attributed to the gateway. By default, the services see the name of the
+// client's operation.
+func WithUpstreamOperationNamer(namer UpstreamOperationNamer) Option {
+	return func(g *Gateway) {
+		g.upstreamOperationNamer = namer
+	}
+}
+
+// UpstreamQueryRewriter returns the document sent to a service for a step of the plan. It is given the
+// document built by the planner and can modify it in place or return a new one (nil keeps the original).
+// The executor only sends the values of the step's Variables (along with the id it looks up) so any other
+// variable definitions are dropped from the document once the rewriter is done with it.
+type UpstreamQueryRewriter func(step *QueryPlanStep, document *ast.QueryDocument) *ast.QueryDocument
+
+// WithUpstreamQueryRewriter returns an Option that rewrites the documents sent to the services, for
+// services that need queries to look a certain way. The rewriter runs once per step when the plan is
+// built, before the document is printed.
+func WithUpstreamQueryRewriter(rewriter UpstreamQueryRewriter) Option {
+	return func(g *Gateway) {
+		g.upstreamQueryRewriter = rewriter
+	}
+}
+
+// WithParsedQueryCacheSize returns an Option that keeps the parsed and validated documents of the
+// given number of recently used queries so that queries sent by value aren't parsed on every request.
+// Documents are tied to the schema they were validated against. A size of 0 (the default) disables the cache.
+func WithParsedQueryCacheSize(size int) Option {
+	return func(g *Gateway) {
+		g.parsedQueryCacheSize = size
+	}
+}
+
+// WithContextBuilder returns an Option that creates the context each request sent over HTTP is handled
+// under. The context is built once, before anything else happens, so it's the place to pull auth tokens,
+// tenant ids, or request ids out of the request. It is available to every part of the gateway that handles
+// the request, including the rate limiter, the executor, and the request middlewares. The builder should
+// derive the context from the one of the request. A nil context keeps the request's context.
+func WithContextBuilder(builder func(r *http.Request) context.Context) Option {
+	return func(g *Gateway) {
+		g.contextBuilder = builder
+	}
+}
+
 // WithLogger returns an Option that sets the logger of the gateway
 func WithLogger(l Logger) Option {
 	return func(g *Gateway) {
@@ -299,6 +1340,29 @@ func WithLogger(l Logger) Option {
 	}
 }
 
+// sharedTypeName returns the name of an object or interface type that is defined by more than one service
+// (whose fields have to be stitched together), or an empty string if there isn't one
+func sharedTypeName(sources []*graphql.RemoteSchema) string {
+	definedBy := map[string]int{}
+	for _, source := range sources {
+		for name, definition := range source.Schema.Types {
+			// the root types and the introspection types are expected to be everywhere
+			if definition.BuiltIn || strings.HasPrefix(name, "__") || name == typeNameQuery || name == typeNameMutation || name == typeNameSubscription {
+				continue
+			}
+			if definition.Kind != ast.Object && definition.Kind != ast.Interface {
+				continue
+			}
+
+			definedBy[name]++
+			if definedBy[name] > 1 {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
 func makeNodeField() *QueryField {
 	return &QueryField{
 		Name: "node",
@@ -338,7 +1402,7 @@ func fieldURLs(schemas []*graphql.RemoteSchema, stripInternal bool) FieldURLMap
 			// if the type is part of the introspection (and can't be left up to the backing services)
 			if !strings.HasPrefix(typeDef.Name, "__") || !stripInternal {
 				// you can ask for __typename at any service that defines the type
-				locations.RegisterURL(name, "__typename", remoteSchema.URL)
+				locations.RegisterURL(name, typenameField, remoteSchema.URL)
 
 				// each field of each type can be found here
 				for _, fieldDef := range typeDef.Fields {
@@ -361,7 +1425,11 @@ func fieldURLs(schemas []*graphql.RemoteSchema, stripInternal bool) FieldURLMap
 // FieldURLMap holds the intformation for retrieving the valid locations one can find the value for the field
 type FieldURLMap map[string][]string
 
-// URLFor returns the list of locations one can find parent.field.
+// fieldURLWildcard is the field name used to register a location for every field of a type
+const fieldURLWildcard = "*"
+
+// URLFor returns the list of locations one can find parent.field. Locations registered for the
+// specific field take priority over the ones registered for the entire type.
 func (m FieldURLMap) URLFor(parent string, field string) ([]string, error) {
 	// compute the key for the field
 	key := m.keyFor(parent, field)
@@ -369,6 +1437,11 @@ func (m FieldURLMap) URLFor(parent string, field string) ([]string, error) {
 	// look up the value in the map
 	value, exists := m[key]
 
+	// if there is nothing for the field, fall back to the type's default locations
+	if !exists {
+		value, exists = m[m.keyFor(parent, fieldURLWildcard)]
+	}
+
 	// if it doesn't exist
 	if !exists {
 		return []string{}, fmt.Errorf("Could not find location for %s", key)
@@ -416,6 +1489,14 @@ func (m FieldURLMap) RegisterURL(parent string, field string, locations ...strin
 	}
 }
 
+// RegisterTypeURL adds a new location to the list of places to find any field of parent that
+// has not been registered with RegisterURL. It is meant for maps built by hand, like the Locations
+// of a PlanningContext handed to a planner. The map the gateway computes from the schemas keeps
+// the locations of every field since FieldURLs and Services report them field by field.
+func (m FieldURLMap) RegisterTypeURL(parent string, locations ...string) {
+	m.RegisterURL(parent, fieldURLWildcard, locations...)
+}
+
 func (m FieldURLMap) keyFor(parent string, field string) string {
 	return fmt.Sprintf("%s.%s", parent, field)
 }
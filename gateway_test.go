@@ -2,18 +2,25 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
 )
 
 type schemaTableRow struct {
@@ -88,6 +95,37 @@ func TestGateway(t *testing.T) {
 		}
 	})
 
+	t.Run("Invalid sources", func(t *testing.T) {
+		t.Parallel()
+		_, err := New([]*graphql.RemoteSchema{
+			sources[0],
+			{URL: "url3"},
+			{URL: "url1", Schema: sources[1].Schema},
+		})
+		assert.EqualError(t, err, "source 1 does not have a schema. source 2 has a duplicate url: url1")
+	})
+
+	t.Run("Merge conflicts name the services", func(t *testing.T) {
+		t.Parallel()
+		conflicting, err := graphql.LoadSchema(`
+			type User {
+				firstName: Int!
+			}
+		`)
+		require.NoError(t, err)
+
+		_, err = New([]*graphql.RemoteSchema{
+			sources[0],
+			{URL: "url3", Schema: conflicting},
+		})
+		var mergeErr *MergeError
+		require.True(t, errors.As(err, &mergeErr), "unexpected error: %v", err)
+		assert.Equal(t, "User", mergeErr.Type)
+		assert.Equal(t, "firstName", mergeErr.Field)
+		assert.Equal(t, []string{"url1", "url3"}, mergeErr.URLs)
+		assert.Contains(t, err.Error(), "encountered error merging User.firstName between url1 and url3: ")
+	})
+
 	t.Run("Options", func(t *testing.T) {
 		t.Parallel()
 		// create a new schema with the sources and some configuration
@@ -136,6 +174,25 @@ func TestGateway(t *testing.T) {
 		assert.Equal(t, &factory, gateway.planner.(*MinQueriesPlanner).QueryerFactory)
 	})
 
+	t.Run("WithUpstreamQueryer", func(t *testing.T) {
+		t.Parallel()
+		queryer1 := &graphql.MockSuccessQueryer{Value: map[string]interface{}{"allUsers": []string{"a"}}}
+		queryer2 := &graphql.MockSuccessQueryer{Value: map[string]interface{}{"allUsers": []string{"b"}}}
+
+		gateway, err := New(sources, WithUpstreamQueryer("url1", queryer1), WithUpstreamQueryer("url2", queryer2))
+		if err != nil {
+			t.Error(err.Error())
+			return
+		}
+
+		planner := gateway.planner.(*MinQueriesPlanner)
+		assert.Equal(t, map[string]graphql.Queryer{"url1": queryer1, "url2": queryer2}, planner.UpstreamQueryers)
+
+		// the registered queryers take priority over the default
+		assert.Equal(t, queryer1, planner.GetQueryer(&PlanningContext{Gateway: gateway}, "url1"))
+		assert.IsType(t, &graphql.SingleRequestQueryer{}, planner.GetQueryer(&PlanningContext{Gateway: gateway}, "url3"))
+	})
+
 	t.Run("WithLocationPriorities", func(t *testing.T) {
 		t.Parallel()
 		priorities := []string{"url1", "url2"}
@@ -685,6 +742,32 @@ func TestFieldURLs_concat(t *testing.T) {
 	assert.Equal(t, []string{"url2"}, urlLocations3)
 }
 
+func TestFieldURLs_typeURL(t *testing.T) {
+	t.Parallel()
+	first := FieldURLMap{}
+	first.RegisterTypeURL("Parent", "url1")
+	first.RegisterURL("Parent", "field1", "url2")
+
+	second := FieldURLMap{}
+	second.RegisterTypeURL("Parent", "url3")
+
+	sum := first.Concat(second)
+
+	// explicitly registered fields take priority over the type
+	urlLocations1, err := sum.URLFor("Parent", "field1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"url2"}, urlLocations1)
+
+	// any other field falls back to the type's locations
+	urlLocations2, err := sum.URLFor("Parent", "field2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"url1", "url3"}, urlLocations2)
+
+	// other types are still missing
+	_, err = sum.URLFor("Other", "field1")
+	assert.EqualError(t, err, "Could not find location for Other.field1")
+}
+
 // Verifies fix for https://github.com/nautilus/gateway/issues/199
 func TestIncludeIfVariable(t *testing.T) {
 	t.Parallel()
@@ -956,3 +1039,3536 @@ type Bar implements Node {
 		}
 	`, resp.Body.String())
 }
+
+type blockingQueryer struct{}
+
+func (blockingQueryer) Query(ctx context.Context, input *graphql.QueryInput, receiver interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestGatewayExecuteTimeoutDirective(t *testing.T) {
+	t.Parallel()
+	fastSchema, err := graphql.LoadSchema(`
+type Query {
+	fast: String
+}
+`)
+	require.NoError(t, err)
+	slowSchema, err := graphql.LoadSchema(`
+type Query {
+	slow: String
+}
+`)
+	require.NoError(t, err)
+	queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		if url == "slow" {
+			return blockingQueryer{}
+		}
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"fast": "fast"}, nil
+		})
+	})
+
+	for _, tc := range []struct {
+		description string
+		maxTimeout  time.Duration
+		query       string
+		variables   map[string]interface{}
+	}{
+		{
+			description: "literal",
+			query:       `query @timeout(ms: 20) { fast slow }`,
+		},
+		{
+			description: "variable",
+			query:       `query ($ms: Int!) @timeout(ms: $ms) { fast slow }`,
+			variables:   map[string]interface{}{"ms": float64(20)},
+		},
+		{
+			description: "capped by max",
+			maxTimeout:  20 * time.Millisecond,
+			query:       `query @timeout(ms: 600000) { fast slow }`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+			gateway, err := New([]*graphql.RemoteSchema{
+				{Schema: fastSchema, URL: "fast"},
+				{Schema: slowSchema, URL: "slow"},
+			}, WithQueryerFactory(&queryerFactory), WithMaxTimeout(tc.maxTimeout))
+			require.NoError(t, err)
+
+			reqCtx := &RequestContext{
+				Context:   context.Background(),
+				Query:     tc.query,
+				Variables: tc.variables,
+			}
+			plans, err := gateway.GetPlans(reqCtx)
+			require.NoError(t, err)
+
+			result, err := gateway.Execute(reqCtx, plans)
+			assert.Equal(t, "fast", result["fast"])
+
+			var errs graphql.ErrorList
+			require.True(t, errors.As(err, &errs), "unexpected error: %v", err)
+			var timeoutErr *graphql.Error
+			require.True(t, errors.As(errs[len(errs)-1], &timeoutErr))
+			assert.Equal(t, "TIMEOUT", timeoutErr.Extensions["code"])
+		})
+	}
+}
+
+func TestGatewayExecuteTimeoutDirective_invalid(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+type Query {
+	fast: String
+}
+`)
+	require.NoError(t, err)
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithUpstreamQueryer("url1", graphql.QueryerFunc(
+		func(input *graphql.QueryInput) (interface{}, error) {
+			t.Error("the operation should not be executed")
+			return map[string]interface{}{"fast": "fast"}, nil
+		},
+	)))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		description string
+		query       string
+		variables   map[string]interface{}
+		message     string
+	}{
+		{
+			description: "zero",
+			query:       `query @timeout(ms: 0) { fast }`,
+			message:     "@timeout ms must be at least 1",
+		},
+		{
+			description: "negative",
+			query:       `query @timeout(ms: -5) { fast }`,
+			message:     "@timeout ms must be at least 1",
+		},
+		{
+			description: "fraction",
+			query:       `query ($ms: Int!) @timeout(ms: $ms) { fast }`,
+			variables:   map[string]interface{}{"ms": 0.5},
+			message:     "@timeout ms must be an integer",
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+			reqCtx := &RequestContext{
+				Context:   context.Background(),
+				Query:     tc.query,
+				Variables: tc.variables,
+			}
+			plans, err := gateway.GetPlans(reqCtx)
+			require.NoError(t, err)
+
+			// the operation never runs without the deadline the client asked for
+			result, err := gateway.Execute(reqCtx, plans)
+			assert.Nil(t, result)
+			var errs graphql.ErrorList
+			require.True(t, errors.As(err, &errs), "unexpected error: %v", err)
+			var inputErr *graphql.Error
+			require.True(t, errors.As(errs[0], &inputErr))
+			assert.Equal(t, "BAD_USER_INPUT", inputErr.Extensions["code"])
+			assert.Equal(t, tc.message, inputErr.Message)
+		})
+	}
+}
+
+func TestGatewayExecuteCoercesVariables(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+type Query {
+	greet(name: String!, punctuation: String): String
+}
+`)
+	require.NoError(t, err)
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+		var sentVariables map[string]interface{}
+		queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+			return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				sentVariables = input.Variables
+				return map[string]interface{}{"greet": "hello"}, nil
+			})
+		})
+		gateway, err := New([]*graphql.RemoteSchema{
+			{Schema: schema, URL: "url1"},
+		}, WithQueryerFactory(&queryerFactory))
+		require.NoError(t, err)
+
+		reqCtx := &RequestContext{
+			Context:   context.Background(),
+			Query:     `query ($name: String!, $punctuation: String = "!") { greet(name: $name, punctuation: $punctuation) }`,
+			Variables: map[string]interface{}{"name": "world"},
+		}
+		plans, err := gateway.GetPlans(reqCtx)
+		require.NoError(t, err)
+
+		_, err = gateway.Execute(reqCtx, plans)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"name":        "world",
+			"punctuation": "!",
+		}, sentVariables)
+	})
+
+	t.Run("missing required", func(t *testing.T) {
+		t.Parallel()
+		queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+			return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				t.Error("the upstream should not have been called")
+				return nil, nil
+			})
+		})
+		gateway, err := New([]*graphql.RemoteSchema{
+			{Schema: schema, URL: "url1"},
+		}, WithQueryerFactory(&queryerFactory))
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "query ($name: String!) { greet(name: $name) }"}`))
+		resp := httptest.NewRecorder()
+		gateway.GraphQLHandler(resp, req)
+		assert.JSONEq(t, `
+			{
+				"data": null,
+				"errors": [
+					{
+						"message": "input: variable.name must be defined",
+						"extensions": {"code": "BAD_USER_INPUT"}
+					}
+				]
+			}
+		`, resp.Body.String())
+	})
+}
+
+func TestGatewayQueryer(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+type Query {
+	greet(name: String!): String
+	broken: String
+}
+`)
+	require.NoError(t, err)
+	queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{
+					"greet":  fmt.Sprintf("hello %s", input.Variables["name"]),
+					"broken": nil,
+				}, graphql.ErrorList{
+					&graphql.Error{
+						Message: "broken is broken",
+						Path:    []interface{}{"broken"},
+					},
+				}
+		})
+	})
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema, URL: "url1"},
+	}, WithQueryerFactory(&queryerFactory))
+	require.NoError(t, err)
+
+	var result map[string]interface{}
+	err = gateway.Queryer().Query(context.Background(), &graphql.QueryInput{
+		Query:     `query ($name: String!) { greet(name: $name) broken }`,
+		Variables: map[string]interface{}{"name": "world"},
+	}, &result)
+
+	// the data and the errors should both come back
+	assert.Equal(t, map[string]interface{}{"greet": "hello world", "broken": nil}, result)
+	assert.EqualError(t, err, "broken is broken")
+}
+
+func TestGatewayExecuteNullBubbling(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+type User {
+	name: String!
+	friend: User
+}
+
+type Query {
+	user: User
+	users: [User!]
+	requiredUser: User!
+}
+`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		description    string
+		query          string
+		response       map[string]interface{}
+		responseErrs   graphql.ErrorList
+		expectedResult map[string]interface{}
+		expectedErrs   graphql.ErrorList
+	}{
+		{
+			description: "nullable parent",
+			query:       `{ user { name } }`,
+			response: map[string]interface{}{
+				"user": map[string]interface{}{"name": nil},
+			},
+			expectedResult: map[string]interface{}{"user": nil},
+			expectedErrs: graphql.ErrorList{
+				&graphql.Error{
+					Message: "Cannot return null for non-nullable field name",
+					Path:    []interface{}{"user", "name"},
+				},
+			},
+		},
+		{
+			description: "nested nullable parent",
+			query:       `{ user { friend { name } } }`,
+			response: map[string]interface{}{
+				"user": map[string]interface{}{
+					"friend": map[string]interface{}{"name": nil},
+				},
+			},
+			expectedResult: map[string]interface{}{
+				"user": map[string]interface{}{"friend": nil},
+			},
+			expectedErrs: graphql.ErrorList{
+				&graphql.Error{
+					Message: "Cannot return null for non-nullable field name",
+					Path:    []interface{}{"user", "friend", "name"},
+				},
+			},
+		},
+		{
+			description: "non-null list entries",
+			query:       `{ users { name } }`,
+			response: map[string]interface{}{
+				"users": []interface{}{
+					map[string]interface{}{"name": "a"},
+					map[string]interface{}{"name": nil},
+				},
+			},
+			expectedResult: map[string]interface{}{"users": nil},
+			expectedErrs: graphql.ErrorList{
+				&graphql.Error{
+					Message: "Cannot return null for non-nullable field name",
+					Path:    []interface{}{"users", 1, "name"},
+				},
+			},
+		},
+		{
+			description: "non-null root field",
+			query:       `{ requiredUser { name } }`,
+			response: map[string]interface{}{
+				"requiredUser": map[string]interface{}{"name": nil},
+			},
+			expectedResult: nil,
+			expectedErrs: graphql.ErrorList{
+				&graphql.Error{
+					Message: "Cannot return null for non-nullable field name",
+					Path:    []interface{}{"requiredUser", "name"},
+				},
+			},
+		},
+		{
+			description: "already reported",
+			query:       `{ user { name } }`,
+			response: map[string]interface{}{
+				"user": map[string]interface{}{"name": nil},
+			},
+			responseErrs: graphql.ErrorList{
+				&graphql.Error{
+					Message: "name is broken",
+					Path:    []interface{}{"user", "name"},
+				},
+			},
+			expectedResult: map[string]interface{}{"user": nil},
+			expectedErrs: graphql.ErrorList{
+				&graphql.Error{
+					Message: "name is broken",
+					Path:    []interface{}{"user", "name"},
+				},
+			},
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+			queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+				return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+					if tc.responseErrs != nil {
+						return tc.response, tc.responseErrs
+					}
+					return tc.response, nil
+				})
+			})
+			gateway, err := New([]*graphql.RemoteSchema{
+				{Schema: schema, URL: "url1"},
+			}, WithQueryerFactory(&queryerFactory), WithNullBubbling())
+			require.NoError(t, err)
+
+			reqCtx := &RequestContext{
+				Context: context.Background(),
+				Query:   tc.query,
+			}
+			plans, err := gateway.GetPlans(reqCtx)
+			require.NoError(t, err)
+
+			result, err := gateway.Execute(reqCtx, plans)
+			assert.Equal(t, tc.expectedResult, result)
+			assert.Equal(t, tc.expectedErrs, err)
+		})
+	}
+}
+
+func TestGatewayExtensionsMerger(t *testing.T) {
+	t.Parallel()
+	// each service reports the cost of the query it resolved
+	newService := func(field string, cost int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"data": {%q: "value"}, "extensions": {"cost": %d}}`, field, cost)
+		}))
+	}
+	serviceA := newService("a", 2)
+	defer serviceA.Close()
+	serviceB := newService("b", 3)
+	defer serviceB.Close()
+
+	schemaA, err := graphql.LoadSchema(`type Query { a: String }`)
+	require.NoError(t, err)
+	schemaB, err := graphql.LoadSchema(`type Query { b: String }`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schemaA, URL: serviceA.URL},
+		{Schema: schemaB, URL: serviceB.URL},
+	}, WithExtensionsMerger(func(perService []map[string]interface{}) map[string]interface{} {
+		total := 0.0
+		for _, extensions := range perService {
+			total += extensions["cost"].(float64)
+		}
+		return map[string]interface{}{"cost": total}
+	}))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ a b }"}`))
+	resp := httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `
+		{
+			"data": {
+				"a": "value",
+				"b": "value"
+			},
+			"extensions": {
+				"cost": 5
+			}
+		}
+	`, resp.Body.String())
+}
+
+func TestGatewayUpstreamHeaderForwarding(t *testing.T) {
+	t.Parallel()
+	// each service sets its own cache policy
+	newService := func(field string, cacheControl string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", cacheControl)
+			fmt.Fprintf(w, `{"data": {%q: "value"}}`, field)
+		}))
+	}
+	serviceA := newService("a", "max-age=60")
+	defer serviceA.Close()
+	serviceB := newService("b", "max-age=60")
+	defer serviceB.Close()
+	serviceC := newService("c", "no-store")
+	defer serviceC.Close()
+
+	schemaA, err := graphql.LoadSchema(`type Query { a: String }`)
+	require.NoError(t, err)
+	schemaB, err := graphql.LoadSchema(`type Query { b: String }`)
+	require.NoError(t, err)
+	schemaC, err := graphql.LoadSchema(`type Query { c: String }`)
+	require.NoError(t, err)
+
+	// only pass the cache policy along when every service agrees on it
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schemaA, URL: serviceA.URL},
+		{Schema: schemaB, URL: serviceB.URL},
+		{Schema: schemaC, URL: serviceC.URL},
+	}, WithUpstreamHeaderForwarding(func(perService []http.Header) http.Header {
+		header := http.Header{}
+		for i, serviceHeader := range perService {
+			if i > 0 && serviceHeader.Get("Cache-Control") != header.Get("Cache-Control") {
+				return http.Header{}
+			}
+			header.Set("Cache-Control", serviceHeader.Get("Cache-Control"))
+		}
+		return header
+	}))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		query        string
+		cacheControl string
+	}{
+		{query: "{ a b }", cacheControl: "max-age=60"},
+		{query: "{ a c }", cacheControl: ""},
+	} {
+		tc := tc
+		// the services are closed when the test returns so the sub-tests can't run in parallel
+		t.Run(tc.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"query": %q}`, tc.query)))
+			resp := httptest.NewRecorder()
+			gateway.GraphQLHandler(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, tc.cacheControl, resp.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestGatewayForwardRequestExtensions(t *testing.T) {
+	t.Parallel()
+	// the service reports the extensions it was sent
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		extensions, err := json.Marshal(input["extensions"])
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"extensions": %q}}`, extensions)
+	}))
+	defer service.Close()
+
+	schema, err := graphql.LoadSchema(`type Query { extensions: String }`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name       string
+		options    []Option
+		extensions string
+	}{
+		{
+			name:       "nothing by default",
+			extensions: `null`,
+		},
+		{
+			name:       "selected keys",
+			options:    []Option{WithForwardRequestExtensions("clientLibrary", "missing")},
+			extensions: `{"clientLibrary": {"name": "app"}}`,
+		},
+	} {
+		tc := tc
+		// the service is closed when the test returns so the sub-tests can't run in parallel
+		t.Run(tc.name, func(t *testing.T) {
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}}, tc.options...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{
+				"query": "{ extensions }",
+				"extensions": {"clientLibrary": {"name": "app"}, "secret": "shh"}
+			}`))
+			resp := httptest.NewRecorder()
+			gateway.GraphQLHandler(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+
+			var result struct {
+				Data struct {
+					Extensions string
+				}
+			}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+			assert.JSONEq(t, tc.extensions, result.Data.Extensions)
+		})
+	}
+}
+
+func TestGatewayAbstractTypeBoundaries(t *testing.T) {
+	t.Parallel()
+	// the search service knows which results are users but not their names
+	searchService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"data": {
+				"search": [
+					{"__typename": "User", "id": "1"},
+					{"__typename": "Photo", "url": "photo.jpg"}
+				]
+			}
+		}`)
+	}))
+	defer searchService.Close()
+
+	// the user service only knows about users
+	var userQueries []map[string]interface{}
+	var userQueriesLock sync.Mutex
+	userService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		userQueriesLock.Lock()
+		userQueries = append(userQueries, input)
+		userQueriesLock.Unlock()
+
+		fmt.Fprint(w, `{"data": {"node": {"firstName": "Alice"}}}`)
+	}))
+	defer userService.Close()
+
+	searchSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+		}
+
+		type Photo {
+			url: String!
+		}
+
+		union SearchResult = User | Photo
+
+		type Query {
+			search: [SearchResult!]!
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			firstName: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: searchSchema, URL: searchService.URL},
+		{Schema: userSchema, URL: userService.URL},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ search { ... on User { firstName } ... on Photo { url } } }"}`))
+	resp := httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `
+		{
+			"data": {
+				"search": [
+					{"firstName": "Alice"},
+					{"url": "photo.jpg"}
+				]
+			}
+		}
+	`, resp.Body.String())
+
+	// the user service should only have been asked about the user, without needing to know about the union
+	require.Len(t, userQueries, 1)
+	assert.Equal(t, map[string]interface{}{"id": "1"}, userQueries[0]["variables"])
+	assert.NotContains(t, userQueries[0]["query"], "SearchResult")
+}
+
+func TestGatewayFieldRequirements(t *testing.T) {
+	t.Parallel()
+	// the inventory service knows how heavy each product is
+	inventoryService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"data": {
+				"allProducts": [
+					{"id": "1", "weight": 2},
+					{"id": "2", "weight": 5}
+				]
+			}
+		}`)
+	}))
+	defer inventoryService.Close()
+
+	// the shipping service needs the weight to estimate the cost of shipping
+	var shippingQueries []map[string]interface{}
+	var shippingQueriesLock sync.Mutex
+	shippingService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		shippingQueriesLock.Lock()
+		shippingQueries = append(shippingQueries, input)
+		shippingQueriesLock.Unlock()
+
+		variables, _ := input["variables"].(map[string]interface{})
+		weight, _ := variables["_requires_weight"].(float64)
+		fmt.Fprintf(w, `{"data": {"node": {"shippingEstimate": %v}}}`, weight*10)
+	}))
+	defer shippingService.Close()
+
+	inventorySchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Product implements Node {
+			id: ID!
+			weight: Float!
+		}
+
+		type Query {
+			allProducts: [Product!]!
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	shippingSchema, err := graphql.LoadSchema(`
+		directive @requires(fields: String!) on FIELD_DEFINITION
+
+		interface Node {
+			id: ID!
+		}
+
+		type Product implements Node {
+			id: ID!
+			shippingEstimate(weight: Float): Float! @requires(fields: "weight")
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: inventorySchema, URL: inventoryService.URL},
+		{Schema: shippingSchema, URL: shippingService.URL},
+	})
+	require.NoError(t, err)
+
+	// clients can't provide the argument themselves
+	assert.Empty(t, gateway.schema.Types["Product"].Fields.ForName("shippingEstimate").Arguments)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ allProducts { shippingEstimate } }"}`))
+	resp := httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `
+		{
+			"data": {
+				"allProducts": [
+					{"shippingEstimate": 20},
+					{"shippingEstimate": 50}
+				]
+			}
+		}
+	`, resp.Body.String())
+
+	// the shipping service should have been given the weight of each product
+	require.Len(t, shippingQueries, 2)
+	for _, query := range shippingQueries {
+		assert.Contains(t, query["query"], "shippingEstimate(weight: $_requires_weight)")
+	}
+}
+
+func TestGatewayFieldRequirements_cycles(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name      string
+		inventory string
+		shipping  string
+		err       string
+	}{
+		{
+			name: "across services",
+			inventory: `
+				weight(shippingEstimate: Float): Float! @requires(fields: "shippingEstimate")
+			`,
+			shipping: `
+				shippingEstimate(weight: Float): Float! @requires(fields: "weight")
+			`,
+			err: "the @requires directives form a cycle: Product.shippingEstimate -> Product.weight -> Product.shippingEstimate",
+		},
+		{
+			name: "through other fields",
+			inventory: `
+				weight(volume: Float): Float! @requires(fields: "volume")
+				volume(shippingEstimate: Float): Float! @requires(fields: "shippingEstimate")
+			`,
+			shipping: `
+				shippingEstimate(weight: Float): Float! @requires(fields: "weight")
+			`,
+			err: "the @requires directives form a cycle: Product.shippingEstimate -> Product.weight -> Product.volume -> Product.shippingEstimate",
+		},
+		{
+			name: "itself",
+			inventory: `
+				weight: Float!
+			`,
+			shipping: `
+				shippingEstimate(shippingEstimate: Float): Float! @requires(fields: "shippingEstimate")
+			`,
+			err: "the @requires directives form a cycle: Product.shippingEstimate -> Product.shippingEstimate",
+		},
+		{
+			name: "chain",
+			inventory: `
+				weight(volume: Float): Float! @requires(fields: "volume")
+				volume: Float!
+			`,
+			shipping: `
+				shippingEstimate(weight: Float): Float! @requires(fields: "weight")
+			`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			inventorySchema, err := graphql.LoadSchema(`
+				directive @requires(fields: String!) on FIELD_DEFINITION
+
+				type Product {
+					id: ID!
+					` + tc.inventory + `
+				}
+
+				type Query {
+					allProducts: [Product!]!
+				}
+			`)
+			require.NoError(t, err)
+			shippingSchema, err := graphql.LoadSchema(`
+				directive @requires(fields: String!) on FIELD_DEFINITION
+
+				type Product {
+					id: ID!
+					` + tc.shipping + `
+				}
+
+				type Query {
+					cheapest: Product
+				}
+			`)
+			require.NoError(t, err)
+
+			_, err = New([]*graphql.RemoteSchema{
+				{Schema: inventorySchema, URL: "inventory"},
+				{Schema: shippingSchema, URL: "shipping"},
+			})
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestGatewayTypeKeys(t *testing.T) {
+	t.Parallel()
+	// the catalog service identifies its products by their sku
+	catalogService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"data": {
+				"products": [
+					{"id": "shoe", "name": "Shoe"},
+					{"id": "hat", "name": "Hat"}
+				]
+			}
+		}`)
+	}))
+	defer catalogService.Close()
+
+	// the pricing service looks products up by the same value
+	var pricingIDs []string
+	var pricingIDsLock sync.Mutex
+	pricingService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		variables, _ := input["variables"].(map[string]interface{})
+		id, _ := variables["id"].(string)
+
+		pricingIDsLock.Lock()
+		pricingIDs = append(pricingIDs, id)
+		pricingIDsLock.Unlock()
+
+		fmt.Fprintf(w, `{"data": {"node": {"price": %d}}}`, len(id))
+	}))
+	defer pricingService.Close()
+
+	catalogSchema, err := graphql.LoadSchema(`
+		directive @key(fields: String!) on OBJECT
+
+		type Product @key(fields: "sku") {
+			sku: String!
+			name: String!
+		}
+
+		type Query {
+			products: [Product!]!
+		}
+	`)
+	require.NoError(t, err)
+	pricingSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Product implements Node {
+			id: ID!
+			price: Float!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: catalogSchema, URL: catalogService.URL},
+		{Schema: pricingSchema, URL: pricingService.URL},
+	})
+	require.NoError(t, err)
+
+	// the catalog is asked for the sku under the name the gateway uses to stitch objects together
+	plans, err := gateway.GetPlans(&RequestContext{
+		Context: context.Background(),
+		Query:   "{ products { name price } }",
+	})
+	require.NoError(t, err)
+	assert.Contains(t, plans[0].RootStep.Then[0].QueryString, "id: sku")
+
+	// the sku is sent as the argument of the pricing service's node field so the variable has to be an ID
+	// like the argument, not a String like the key
+	pricingStep := plans[0].RootStep.Then[0].Then[0]
+	assert.Equal(t, "ID!", pricingStep.QueryDocument.Operations[0].VariableDefinitions.ForName("id").Type.String())
+	assert.Empty(t, validator.Validate(pricingSchema, pricingStep.QueryDocument))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ products { name price } }"}`))
+	resp := httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{
+		"data": {
+			"products": [
+				{"name": "Shoe", "price": 4},
+				{"name": "Hat", "price": 3}
+			]
+		}
+	}`, resp.Body.String())
+	assert.ElementsMatch(t, []string{"shoe", "hat"}, pricingIDs)
+}
+
+func TestGatewayTypeKeys_disagree(t *testing.T) {
+	t.Parallel()
+	schemaA, err := graphql.LoadSchema(`
+		directive @key(fields: String!) on OBJECT
+
+		type Product @key(fields: "sku") {
+			sku: String!
+			upc: String!
+		}
+
+		type Query {
+			products: [Product!]!
+		}
+	`)
+	require.NoError(t, err)
+	schemaB, err := graphql.LoadSchema(`
+		directive @key(fields: String!) on OBJECT
+
+		type Product @key(fields: "upc") {
+			upc: String!
+		}
+
+		type Query {
+			product: Product
+		}
+	`)
+	require.NoError(t, err)
+
+	_, err = New([]*graphql.RemoteSchema{
+		{Schema: schemaA, URL: "a"},
+		{Schema: schemaB, URL: "b"},
+	})
+	assert.EqualError(t, err, "services disagree on the key for Product: sku and upc")
+}
+
+func TestGatewayTypeKeys_nodeArgument(t *testing.T) {
+	t.Parallel()
+	catalogSchema, err := graphql.LoadSchema(`
+		directive @key(fields: String!) on OBJECT
+
+		type Product @key(fields: "sku") {
+			sku: String!
+			name: String!
+		}
+
+		type Query {
+			products: [Product!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	// the key is a String but the node field has to take an ID like the gateway's
+	pricingSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Product implements Node {
+			id: ID!
+			price: Float!
+		}
+
+		type Query {
+			node(id: String!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	for _, nullability := range []NullabilityMergeStrategy{NullabilityStrict, NullabilityMostNullable} {
+		_, err = New([]*graphql.RemoteSchema{
+			{Schema: catalogSchema, URL: "catalog"},
+			{Schema: pricingSchema, URL: "pricing"},
+		}, WithNullabilityMergeStrategy(nullability))
+		assert.ErrorContains(t, err, "encountered error merging Query.node")
+	}
+}
+
+func TestGatewayExecuteOneOf(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+directive @oneOf on INPUT_OBJECT
+
+input UserBy @oneOf {
+	id: ID
+	email: String
+}
+
+type Query {
+	user(by: UserBy!): String
+}
+`)
+	require.NoError(t, err)
+
+	queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"user": "hello"}, nil
+		})
+	})
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema, URL: "url1"},
+	}, WithQueryerFactory(&queryerFactory))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		err       string
+	}{
+		{
+			name:  "literal with no fields",
+			query: `{ user(by: {}) }`,
+			err:   "OneOf input object UserBy must specify exactly one field",
+		},
+		{
+			name:  "literal with one field",
+			query: `{ user(by: {id: "1"}) }`,
+		},
+		{
+			name:  "literal with two fields",
+			query: `{ user(by: {id: "1", email: "a@b.c"}) }`,
+			err:   "OneOf input object UserBy must specify exactly one field",
+		},
+		{
+			name:  "literal with null field",
+			query: `{ user(by: {id: null}) }`,
+			err:   "field UserBy.id must be non-null",
+		},
+		{
+			name:      "variable with no fields",
+			query:     `query ($by: UserBy!) { user(by: $by) }`,
+			variables: map[string]interface{}{"by": map[string]interface{}{}},
+			err:       "OneOf input object UserBy must specify exactly one field",
+		},
+		{
+			name:      "variable with one field",
+			query:     `query ($by: UserBy!) { user(by: $by) }`,
+			variables: map[string]interface{}{"by": map[string]interface{}{"email": "a@b.c"}},
+		},
+		{
+			name:      "variable with two fields",
+			query:     `query ($by: UserBy!) { user(by: $by) }`,
+			variables: map[string]interface{}{"by": map[string]interface{}{"id": "1", "email": "a@b.c"}},
+			err:       "OneOf input object UserBy must specify exactly one field",
+		},
+		{
+			name:      "field from a null variable",
+			query:     `query ($id: ID) { user(by: {id: $id}) }`,
+			variables: map[string]interface{}{"id": nil},
+			err:       "field UserBy.id must be non-null",
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			reqCtx := &RequestContext{
+				Context:   context.Background(),
+				Query:     tc.query,
+				Variables: tc.variables,
+			}
+			plans, err := gateway.GetPlans(reqCtx)
+			require.NoError(t, err)
+
+			result, err := gateway.Execute(reqCtx, plans)
+			if tc.err == "" {
+				require.NoError(t, err)
+				assert.Equal(t, map[string]interface{}{"user": "hello"}, result)
+				return
+			}
+
+			var errs graphql.ErrorList
+			require.True(t, errors.As(err, &errs), "unexpected error: %v", err)
+			var oneOfErr *graphql.Error
+			require.True(t, errors.As(errs[0], &oneOfErr))
+			assert.Equal(t, tc.err, oneOfErr.Message)
+			assert.Equal(t, "BAD_USER_INPUT", oneOfErr.Extensions["code"])
+		})
+	}
+}
+
+func TestGatewayUpstreamOperationNamer(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+type Query {
+	greet(name: String!): String
+}
+`)
+	require.NoError(t, err)
+
+	var sentNames []string
+	var sentQueries []string
+	var sentLock sync.Mutex
+	queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			sentLock.Lock()
+			defer sentLock.Unlock()
+			sentNames = append(sentNames, input.OperationName)
+			sentQueries = append(sentQueries, input.Query)
+			return map[string]interface{}{"greet": "hello"}, nil
+		})
+	})
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema, URL: "url1"},
+	},
+		WithQueryerFactory(&queryerFactory),
+		WithUpstreamOperationNamer(func(clientName string, step *QueryPlanStep) string {
+			return fmt.Sprintf("gateway_%s_%s", clientName, step.ParentType)
+		}),
+	)
+	require.NoError(t, err)
+
+	reqCtx := &RequestContext{
+		Context:       context.Background(),
+		Query:         `query Greeting { greet(name: "world") }`,
+		OperationName: "Greeting",
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+
+	_, err = gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+
+	// the name in the document has to match the one we send along with it
+	assert.Equal(t, []string{"gateway_Greeting_Query"}, sentNames)
+	if assert.Len(t, sentQueries, 1) {
+		assert.Contains(t, sentQueries[0], "query gateway_Greeting_Query")
+	}
+}
+
+func TestGatewayUpstreamQueryRewriter(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+type Query {
+	greet(name: String!): String
+}
+`)
+	require.NoError(t, err)
+
+	var sentQueries []string
+	var sentLock sync.Mutex
+	queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			sentLock.Lock()
+			defer sentLock.Unlock()
+			sentQueries = append(sentQueries, input.Query)
+			return map[string]interface{}{"greet": "hello"}, nil
+		})
+	})
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema, URL: "url1"},
+	},
+		WithQueryerFactory(&queryerFactory),
+		WithUpstreamQueryRewriter(func(step *QueryPlanStep, document *ast.QueryDocument) *ast.QueryDocument {
+			// tag every root field for the service
+			for _, selection := range document.Operations[0].SelectionSet {
+				if field, ok := selection.(*ast.Field); ok {
+					field.Directives = append(field.Directives, &ast.Directive{Name: "connection"})
+				}
+			}
+			return document
+		}),
+	)
+	require.NoError(t, err)
+
+	reqCtx := &RequestContext{
+		Context: context.Background(),
+		Query:   `{ greet(name: "world") }`,
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+
+	result, err := gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"greet": "hello"}, result)
+
+	if assert.Len(t, sentQueries, 1) {
+		assert.Contains(t, sentQueries[0], `greet(name: "world") @connection`)
+	}
+}
+
+func TestGatewayRequestLocationPriorities(t *testing.T) {
+	t.Parallel()
+	// both services can greet the user
+	schema, err := graphql.LoadSchema(`
+type Query {
+	greeting: String
+}
+`)
+	require.NoError(t, err)
+
+	queryerFactory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"greeting": url}, nil
+		})
+	})
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema, URL: "replica"},
+		{Schema: schema, URL: "primary"},
+	},
+		WithQueryerFactory(&queryerFactory),
+		WithLocationPriorities([]string{"replica"}),
+		WithAutomaticQueryPlanCache(),
+		WithRequestLocationPriorities(func(r *http.Request) []string {
+			if r.Header.Get("X-Read-Your-Writes") != "" {
+				return []string{"primary"}
+			}
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	// the same query should go wherever the request asks, even once its plan has been cached
+	for _, tc := range []struct {
+		readYourWrites bool
+		expected       string
+	}{
+		{readYourWrites: false, expected: "replica"},
+		{readYourWrites: true, expected: "primary"},
+		{readYourWrites: false, expected: "replica"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ greeting }"}`))
+		if tc.readYourWrites {
+			req.Header.Set("X-Read-Your-Writes", "true")
+		}
+		resp := httptest.NewRecorder()
+		gateway.GraphQLHandler(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var result struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+		assert.Equal(t, map[string]interface{}{"greeting": tc.expected}, result.Data)
+	}
+}
+
+func TestGatewayAliasedRootFields(t *testing.T) {
+	t.Parallel()
+	// the user service answers each alias with the user it was asked for
+	var userQueries []map[string]interface{}
+	var userQueriesLock sync.Mutex
+	userService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		userQueriesLock.Lock()
+		userQueries = append(userQueries, input)
+		userQueriesLock.Unlock()
+
+		variables, _ := input["variables"].(map[string]interface{})
+		fmt.Fprintf(w, `{"data": {"a": {"id": %q, "name": "user %v"}, "b": {"id": %q, "name": "user %v"}}}`,
+			variables["first"], variables["first"], variables["second"], variables["second"])
+	}))
+	defer userService.Close()
+
+	// the profile service knows the email of every user
+	profileService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		variables, _ := input["variables"].(map[string]interface{})
+		fmt.Fprintf(w, `{"data": {"node": {"email": "%v@example.com"}}}`, variables["id"])
+	}))
+	defer profileService.Close()
+
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			user(id: ID!): User
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	profileSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			email: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: userSchema, URL: userService.URL},
+		{Schema: profileSchema, URL: profileService.URL},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{
+		"query": "query($first: ID!, $second: ID!) { a: user(id: $first) { name email } b: user(id: $second) { name email } }",
+		"variables": {"first": "1", "second": "2"}
+	}`))
+	resp := httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `
+		{
+			"data": {
+				"a": {"name": "user 1", "email": "1@example.com"},
+				"b": {"name": "user 2", "email": "2@example.com"}
+			}
+		}
+	`, resp.Body.String())
+
+	// both aliases should have been sent to the user service with their own argument
+	require.Len(t, userQueries, 1)
+	assert.Contains(t, userQueries[0]["query"], "a: user(id: $first)")
+	assert.Contains(t, userQueries[0]["query"], "b: user(id: $second)")
+	assert.Equal(t, map[string]interface{}{"first": "1", "second": "2"}, userQueries[0]["variables"])
+}
+
+func TestGatewayFederationServiceField(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type User {
+			name: String!
+		}
+
+		type Query {
+			me: User
+		}
+	`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithFederationServiceField(true))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ _service { sdl __typename } }"}`))
+	resp := httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	var result struct {
+		Data struct {
+			Service struct {
+				SDL      string `json:"sdl"`
+				Typename string `json:"__typename"`
+			} `json:"_service"`
+		}
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.Equal(t, "_Service", result.Data.Service.Typename)
+
+	// the sdl should describe the composed schema without the field it's served from
+	sdl := result.Data.Service.SDL
+	assert.Contains(t, sdl, "type User {")
+	assert.Contains(t, sdl, "me: User")
+	assert.Contains(t, sdl, "node(id: ID!): Node")
+	assert.NotContains(t, sdl, "_service")
+	assert.NotContains(t, sdl, "_Service")
+
+	// the sdl has to be something a federated gateway can load
+	_, err = graphql.LoadSchema(sdl)
+	assert.NoError(t, err)
+
+	// the field isn't there unless the gateway is told to add it
+	gateway, err = New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}})
+	require.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ _service { sdl } }"}`))
+	resp = httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestGatewayStripUpstreamErrorLocations(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String
+		}
+	`)
+	require.NoError(t, err)
+
+	// the service points to a line of the query the gateway sent it
+	upstream := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		return map[string]interface{}{"value": nil}, gqlerror.List{{
+			Message:   "something went wrong",
+			Path:      ast.Path{ast.PathName("value")},
+			Locations: []gqlerror.Location{{Line: 2, Column: 3}},
+		}}
+	})
+
+	for _, tc := range []struct {
+		name     string
+		strip    bool
+		expected string
+	}{
+		{
+			name:     "kept by default",
+			expected: `{"data": {"value": null}, "errors": [{"message": "something went wrong", "path": ["value"], "locations": [{"line": 2, "column": 3}]}]}`,
+		},
+		{
+			name:     "stripped",
+			strip:    true,
+			expected: `{"data": {"value": null}, "errors": [{"message": "something went wrong", "path": ["value"]}]}`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+				WithUpstreamQueryer("url1", upstream),
+				WithStripUpstreamErrorLocations(tc.strip),
+			)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ value }"}`))
+			resp := httptest.NewRecorder()
+			gateway.GraphQLHandler(resp, req)
+			assert.JSONEq(t, tc.expected, resp.Body.String())
+		})
+	}
+}
+
+func TestGatewayStripUpstreamErrorLocations_network(t *testing.T) {
+	t.Parallel()
+	// the service points to a line of the query the gateway sent it
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"data": {"value": null},
+			"errors": [{"message": "something went wrong", "path": ["value"], "locations": [{"line": 2, "column": 3}]}]
+		}`)
+	}))
+	defer service.Close()
+
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String
+		}
+	`)
+	require.NoError(t, err)
+
+	// the errors of a service reached over HTTP never have locations, stripped or not
+	for _, strip := range []bool{false, true} {
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}},
+			WithStripUpstreamErrorLocations(strip),
+		)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ value }"}`))
+		resp := httptest.NewRecorder()
+		gateway.GraphQLHandler(resp, req)
+		assert.JSONEq(t, `{"data": {"value": null}, "errors": [{"message": "something went wrong", "path": ["value"], "extensions": null}]}`, resp.Body.String())
+	}
+}
+
+func TestGatewayStepHooks(t *testing.T) {
+	t.Parallel()
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			firstName: String!
+		}
+
+		type Query {
+			users: [User!]!
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	profileSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			lastName: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	users := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		return map[string]interface{}{
+			"users": []interface{}{
+				map[string]interface{}{"id": "1", "firstName": "Alice"},
+				map[string]interface{}{"id": "2", "firstName": "Bob"},
+			},
+		}, nil
+	})
+	profiles := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		return map[string]interface{}{
+			"node": map[string]interface{}{"lastName": fmt.Sprintf("Smith %v", input.Variables["id"])},
+		}, nil
+	})
+
+	var before, after []string
+	var hookLock sync.Mutex
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+		{Schema: profileSchema, URL: "profiles"},
+	},
+		WithUpstreamQueryer("users", users),
+		WithUpstreamQueryer("profiles", profiles),
+		WithBeforeStep(func(ctx context.Context, step *QueryPlanStep) {
+			hookLock.Lock()
+			defer hookLock.Unlock()
+			before = append(before, fmt.Sprintf("%s %s", step.Location, step.ParentType))
+		}),
+		WithAfterStep(func(ctx context.Context, step *QueryPlanStep, result map[string]interface{}, err error) {
+			hookLock.Lock()
+			defer hookLock.Unlock()
+			assert.NoError(t, err)
+			assert.NotEmpty(t, result)
+			after = append(after, fmt.Sprintf("%s %s", step.Location, step.ParentType))
+		}),
+	)
+	require.NoError(t, err)
+
+	reqCtx := &RequestContext{
+		Context: context.Background(),
+		Query:   `{ users { firstName lastName } }`,
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+
+	result, err := gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"firstName": "Alice", "lastName": "Smith 1"},
+			map[string]interface{}{"firstName": "Bob", "lastName": "Smith 2"},
+		},
+	}, result)
+
+	// the hooks are called once for every query that was sent
+	expected := []string{"users Query", "profiles User", "profiles User"}
+	assert.ElementsMatch(t, expected, before)
+	assert.ElementsMatch(t, expected, after)
+}
+
+func TestGatewayWithoutNodeInterface(t *testing.T) {
+	t.Parallel()
+	userSchema, err := graphql.LoadSchema(`
+		type User {
+			name: String!
+		}
+
+		type Query {
+			me: User
+		}
+	`)
+	require.NoError(t, err)
+	catalogSchema, err := graphql.LoadSchema(`
+		type Product {
+			title: String!
+		}
+
+		type Query {
+			products: [Product!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	users := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		return map[string]interface{}{"me": map[string]interface{}{"name": "Alice"}}, nil
+	})
+	catalog := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		return map[string]interface{}{"products": []interface{}{map[string]interface{}{"title": "Book"}}}, nil
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+		{Schema: catalogSchema, URL: "catalog"},
+	},
+		WithUpstreamQueryer("users", users),
+		WithUpstreamQueryer("catalog", catalog),
+		WithoutNodeInterface(true),
+	)
+	require.NoError(t, err)
+
+	// the schema should not have any trace of the node field
+	assert.Nil(t, gateway.schema.Query.Fields.ForName("node"))
+	assert.Nil(t, gateway.schema.Types["Node"])
+
+	// the services can still be queried together
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ me { name } products { title } __type(name: \"Node\") { name } }"}`))
+	resp := httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"data": {"me": {"name": "Alice"}, "products": [{"title": "Book"}], "__type": null}}`, resp.Body.String())
+
+	// the node field is needed to stitch types that are spread across services
+	profileSchema, err := graphql.LoadSchema(`
+		type User {
+			email: String!
+		}
+
+		type Query {
+			profile: User
+		}
+	`)
+	require.NoError(t, err)
+	_, err = New([]*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+		{Schema: profileSchema, URL: "profiles"},
+	}, WithoutNodeInterface(true))
+	assert.EqualError(t, err, "the node field is needed to stitch User together")
+
+	// and to stitch the fields resolved by the gateway
+	_, err = New([]*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+	}, WithoutNodeInterface(true), WithQueryFields(&QueryField{
+		Name:     "viewer",
+		Type:     ast.NamedType("User", &ast.Position{}),
+		Resolver: func(context.Context, map[string]interface{}) (string, error) { return "1", nil },
+	}))
+	assert.EqualError(t, err, "the node field is needed to stitch the query field viewer")
+}
+
+func TestGatewayNullDataPolicy(t *testing.T) {
+	t.Parallel()
+	// the user service knows the first name of every user
+	userService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": {"allUsers": [{"id": "1", "firstName": "Alice"}, {"id": "2", "firstName": "Bob"}]}}`)
+	}))
+	defer userService.Close()
+
+	// but the profile service can't resolve anything
+	profileService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": null, "errors": [{"message": "profiles are unavailable"}]}`)
+	}))
+	defer profileService.Close()
+
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			firstName: String!
+		}
+
+		type Query {
+			allUsers: [User]!
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	profileSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			lastName: String
+			email: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		options  []Option
+		query    string
+		expected string
+	}{
+		{
+			name:     "propagate errors",
+			query:    "{ allUsers { firstName lastName } }",
+			expected: `{"allUsers": [{"firstName": "Alice"}, {"firstName": "Bob"}]}`,
+		},
+		{
+			name:     "fail field",
+			options:  []Option{WithNullDataPolicy(NullDataFailField)},
+			query:    "{ allUsers { firstName lastName } }",
+			expected: `{"allUsers": [{"firstName": "Alice", "lastName": null}, {"firstName": "Bob", "lastName": null}]}`,
+		},
+		{
+			name:     "fail non-null field",
+			options:  []Option{WithNullDataPolicy(NullDataFailField), WithNullBubbling()},
+			query:    "{ allUsers { firstName email } }",
+			expected: `{"allUsers": [null, null]}`,
+		},
+	} {
+		tc := tc
+		// the services are closed when the test returns so the sub-tests can't run in parallel
+		t.Run(tc.name, func(t *testing.T) {
+			gateway, err := New([]*graphql.RemoteSchema{
+				{Schema: userSchema, URL: userService.URL},
+				{Schema: profileSchema, URL: profileService.URL},
+			}, tc.options...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"query": %q}`, tc.query)))
+			resp := httptest.NewRecorder()
+			gateway.GraphQLHandler(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+
+			var result struct {
+				Data   json.RawMessage
+				Errors []struct {
+					Message string
+				}
+			}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+			assert.JSONEq(t, tc.expected, string(result.Data))
+
+			// the errors of the service are always passed along
+			if assert.NotEmpty(t, result.Errors) {
+				assert.Equal(t, "profiles are unavailable", result.Errors[0].Message)
+			}
+		})
+	}
+}
+
+func TestGatewayFieldLocationOverrides(t *testing.T) {
+	t.Parallel()
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			me: User
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	cdnSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	sources := []*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+		{Schema: cdnSchema, URL: "cdn"},
+	}
+
+	// the steps of the plan for the query as "location: fields"
+	planLocations := func(gw *Gateway) []string {
+		plans, err := gw.GetPlans(&RequestContext{Context: context.Background(), Query: "{ me { avatar } }"})
+		require.NoError(t, err)
+
+		locations := []string{}
+		steps := plans[0].RootStep.Then
+		for len(steps) > 0 {
+			step := steps[0]
+			fields := []string{}
+			for _, field := range graphql.SelectedFields(step.SelectionSet) {
+				fields = append(fields, field.Name)
+				for _, subField := range graphql.SelectedFields(field.SelectionSet) {
+					fields = append(fields, subField.Name)
+				}
+			}
+			locations = append(locations, step.Location+": "+strings.Join(fields, " "))
+			steps = step.Then
+		}
+		return locations
+	}
+
+	// without an override the avatar comes from the service that found the user
+	gw, err := New(sources)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"users: me avatar"}, planLocations(gw))
+
+	t.Run("option", func(t *testing.T) {
+		t.Parallel()
+		gw, err := New(sources, WithFieldLocationOverrides(FieldURLMap{"User.avatar": {"cdn"}}))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"users: me id", "cdn: avatar"}, planLocations(gw))
+
+		locations, err := gw.FieldURLs().URLFor("User", "avatar")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cdn"}, locations)
+	})
+
+	t.Run("set after construction", func(t *testing.T) {
+		t.Parallel()
+		gw, err := New(sources)
+		require.NoError(t, err)
+		require.NoError(t, gw.SetFieldLocation("User", "avatar", "cdn"))
+		assert.Equal(t, []string{"users: me id", "cdn: avatar"}, planLocations(gw))
+
+		// and back again
+		require.NoError(t, gw.SetFieldLocation("User", "avatar", "users"))
+		assert.Equal(t, []string{"users: me avatar"}, planLocations(gw))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		gw, err := New(sources)
+		require.NoError(t, err)
+
+		assert.EqualError(t, gw.SetFieldLocation("User", "avatar", "billing"), "cannot send User.avatar to billing: the service does not declare the field")
+		assert.EqualError(t, gw.SetFieldLocation("User", "email", "users"), "cannot set the location of User.email: User does not have a field named email")
+		assert.EqualError(t, gw.SetFieldLocation("Account", "id", "users"), "cannot set the location of Account.id: unknown type Account")
+		assert.EqualError(t, gw.SetFieldLocation("User", "avatar"), "User.avatar must be sent to at least one service")
+
+		// a field only the users service declares can't be sent to the cdn
+		assert.EqualError(t, gw.SetFieldLocation("Query", "me", "cdn"), "cannot send Query.me to cdn: the service does not declare the field")
+
+		_, err = New(sources, WithFieldLocationOverrides(FieldURLMap{"avatar": {"cdn"}}))
+		assert.EqualError(t, err, `invalid field location override "avatar", expected a key like Type.field`)
+		_, err = New(sources, WithFieldLocationOverrides(FieldURLMap{"User.avatar": {"billing"}}))
+		assert.EqualError(t, err, "cannot send User.avatar to billing: the service does not declare the field")
+	})
+}
+
+func TestGatewayFieldPins(t *testing.T) {
+	t.Parallel()
+	schema := `
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			me: User
+			node(id: ID!): Node
+		}
+	`
+	userSchema, err := graphql.LoadSchema(schema)
+	require.NoError(t, err)
+	cdnSchema, err := graphql.LoadSchema(strings.Replace(schema, "me: User", "", 1))
+	require.NoError(t, err)
+	sources := []*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+		{Schema: cdnSchema, URL: "cdn"},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		pin        string
+		priorities []string
+		steps      []string
+		err        string
+	}{
+		{
+			name:  "not pinned",
+			steps: []string{"users"},
+		},
+		{
+			name:  "pinned to another service",
+			pin:   "cdn",
+			steps: []string{"users", "cdn"},
+		},
+		{
+			name:       "pin beats priorities",
+			pin:        "users",
+			priorities: []string{"cdn"},
+			steps:      []string{"users"},
+		},
+		{
+			name:       "priorities without a pin",
+			priorities: []string{"cdn"},
+			steps:      []string{"users", "cdn"},
+		},
+		{
+			name: "pinned to a service without the field",
+			pin:  "images",
+			err:  "could not plan field avatar on type User: it is pinned to images which is not one of its locations",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			options := []Option{}
+			if tc.pin != "" {
+				options = append(options, WithFieldPin("User", "avatar", tc.pin))
+			}
+			gw, err := New(sources, options...)
+			require.NoError(t, err)
+
+			plans, err := gw.GetPlans(&RequestContext{
+				Context:            context.Background(),
+				Query:              "{ me { avatar } }",
+				LocationPriorities: tc.priorities,
+			})
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			steps := []string{}
+			for step := plans[0].RootStep.Then; len(step) > 0; step = step[0].Then {
+				steps = append(steps, step[0].Location)
+			}
+			assert.Equal(t, tc.steps, steps)
+		})
+	}
+}
+
+func TestGatewayDescribeField(t *testing.T) {
+	t.Parallel()
+	schema := `
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			me: User
+			node(id: ID!): Node
+		}
+	`
+	userSchema, err := graphql.LoadSchema(schema)
+	require.NoError(t, err)
+	cdnSchema, err := graphql.LoadSchema(strings.Replace(schema, "me: User", "", 1))
+	require.NoError(t, err)
+	sources := []*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+		{Schema: cdnSchema, URL: "cdn"},
+	}
+
+	for _, tc := range []struct {
+		name      string
+		options   []Option
+		typeName  string
+		field     string
+		locations []string
+	}{
+		{
+			name:      "one location",
+			typeName:  "Query",
+			field:     "me",
+			locations: []string{"users"},
+		},
+		{
+			name:      "many locations",
+			typeName:  "User",
+			field:     "avatar",
+			locations: []string{"users", "cdn"},
+		},
+		{
+			name:      "priorities",
+			options:   []Option{WithLocationPriorities([]string{"cdn"})},
+			typeName:  "User",
+			field:     "avatar",
+			locations: []string{"cdn", "users"},
+		},
+		{
+			name:      "pinned",
+			options:   []Option{WithLocationPriorities([]string{"users"}), WithFieldPin("User", "avatar", "cdn")},
+			typeName:  "User",
+			field:     "avatar",
+			locations: []string{"cdn", "users"},
+		},
+		{
+			name:     "unknown field",
+			typeName: "User",
+			field:    "name",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			gw, err := New(sources, tc.options...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.locations, gw.DescribeField(tc.typeName, tc.field))
+		})
+	}
+
+	t.Run("logs the planner's choice", func(t *testing.T) {
+		t.Parallel()
+		logger := newRecordingLogger()
+		gw, err := New(sources, WithLogger(logger), WithLocationPriorities([]string{"cdn"}))
+		require.NoError(t, err)
+
+		_, err = gw.GetPlans(&RequestContext{Context: context.Background(), Query: "{ me { avatar } }"})
+		require.NoError(t, err)
+
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		assert.Contains(t, *logger.messages, "sending User.avatar to cdn out of [users cdn]")
+	})
+}
+
+// recordingLogger records the debug and warning messages that were logged and the fields that were added to the logger
+type recordingLogger struct {
+	*DefaultLogger
+	mu       *sync.Mutex
+	messages *[]string
+	fields   *[]LoggerFields
+}
+
+func newRecordingLogger() recordingLogger {
+	return recordingLogger{DefaultLogger: &DefaultLogger{}, mu: &sync.Mutex{}, messages: &[]string{}, fields: &[]LoggerFields{}}
+}
+
+func (l recordingLogger) Debug(args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.messages = append(*l.messages, fmt.Sprint(args...))
+}
+
+func (l recordingLogger) Warn(args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.messages = append(*l.messages, fmt.Sprint(args...))
+}
+
+func (l recordingLogger) WithFields(fields LoggerFields) Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.fields = append(*l.fields, fields)
+	return l
+}
+
+func TestGatewayRequestID(t *testing.T) {
+	t.Parallel()
+	// the service reports the id it was sent, along with an error
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": {"requestId": %q}, "errors": [{"message": "oops"}]}`, r.Header.Get("X-Trace"))
+	}))
+	defer service.Close()
+
+	schema, err := graphql.LoadSchema(`type Query { requestId: String }`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		clientID string
+		id       string
+	}{
+		{
+			name: "generated",
+			id:   "generated-id",
+		},
+		{
+			name:     "sent by the client",
+			clientID: "client-id",
+			id:       "client-id",
+		},
+	} {
+		tc := tc
+		// the service is closed when the test returns so the sub-tests can't run in parallel
+		t.Run(tc.name, func(t *testing.T) {
+			logger := newRecordingLogger()
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}},
+				WithRequestID("X-Trace", func() string { return "generated-id" }),
+				WithLogger(logger),
+			)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ requestId }"}`))
+			if tc.clientID != "" {
+				req.Header.Set("X-Trace", tc.clientID)
+			}
+			resp := httptest.NewRecorder()
+			gateway.GraphQLHandler(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+
+			// the id is sent back to the client, to the service, and is part of the errors
+			assert.Equal(t, tc.id, resp.Header().Get("X-Trace"))
+			assert.JSONEq(t, fmt.Sprintf(`{
+				"data": {"requestId": %q},
+				"errors": [{"message": "oops", "extensions": {"requestId": %q}}]
+			}`, tc.id, tc.id), resp.Body.String())
+
+			// and the logs of the request
+			logger.mu.Lock()
+			defer logger.mu.Unlock()
+			assert.Contains(t, *logger.fields, LoggerFields{"requestId": tc.id})
+		})
+	}
+
+	// operations that are executed directly get a random id
+	t.Run("execute", func(t *testing.T) {
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}}, WithRequestID("X-Trace", nil))
+		require.NoError(t, err)
+
+		reqCtx := &RequestContext{Context: context.Background(), Query: "{ requestId }"}
+		plans, err := gateway.GetPlans(reqCtx)
+		require.NoError(t, err)
+		result, _ := gateway.Execute(reqCtx, plans)
+		assert.Len(t, result["requestId"], 32)
+	})
+}
+
+func TestGatewayReadOnly(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+
+		type Mutation {
+			setValue(value: String!): String!
+		}
+	`)
+	require.NoError(t, err)
+
+	mutations := 0
+	service := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		if input.QueryDocument.Operations[0].Operation == ast.Mutation {
+			mutations++
+			return map[string]interface{}{"setValue": "updated"}, nil
+		}
+		return map[string]interface{}{"value": "hello"}, nil
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithUpstreamQueryer("url1", service),
+		WithReadOnly(true),
+	)
+	require.NoError(t, err)
+
+	// queries still work
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ value }"}`))
+	resp := httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"data": {"value": "hello"}}`, resp.Body.String())
+
+	// but mutations never make it to the service
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "mutation { setValue(value: \"bye\") }"}`))
+	resp = httptest.NewRecorder()
+	gateway.GraphQLHandler(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"data": null, "errors": [{"message": "mutations are disabled on this gateway", "extensions": {"code": "FORBIDDEN"}}]}`, resp.Body.String())
+	assert.Equal(t, 0, mutations)
+}
+
+func TestGatewayStrictVariables(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			greeting(name: String!, punctuation: String): String!
+		}
+	`)
+	require.NoError(t, err)
+
+	service := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		return map[string]interface{}{"greeting": fmt.Sprintf("hello %v", input.Variables["name"])}, nil
+	})
+	query := `query ($name: String!, $punctuation: String) { greeting(name: $name, punctuation: $punctuation) }`
+
+	for _, tc := range []struct {
+		name      string
+		strict    bool
+		variables map[string]interface{}
+		err       string
+	}{
+		{
+			name:      "exact variables",
+			strict:    true,
+			variables: map[string]interface{}{"name": "world", "punctuation": "!"},
+		},
+		{
+			name:      "optional variable left out",
+			strict:    true,
+			variables: map[string]interface{}{"name": "world"},
+		},
+		{
+			name:      "extra variables",
+			strict:    true,
+			variables: map[string]interface{}{"name": "world", "unused": true, "another": 1},
+			err:       "the operation does not declare the variables: $another, $unused",
+		},
+		{
+			name:      "extra variables ignored by default",
+			variables: map[string]interface{}{"name": "world", "unused": true},
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+				WithUpstreamQueryer("url1", service),
+				WithStrictVariables(tc.strict),
+			)
+			require.NoError(t, err)
+
+			reqCtx := &RequestContext{Context: context.Background(), Query: query, Variables: tc.variables}
+			plans, err := gateway.GetPlans(reqCtx)
+			require.NoError(t, err)
+			result, err := gateway.Execute(reqCtx, plans)
+			if tc.err == "" {
+				require.NoError(t, err)
+				assert.Equal(t, map[string]interface{}{"greeting": "hello world"}, result)
+				return
+			}
+
+			var errs graphql.ErrorList
+			require.True(t, errors.As(err, &errs), "unexpected error: %v", err)
+			var variablesErr *graphql.Error
+			require.True(t, errors.As(errs[0], &variablesErr))
+			assert.Equal(t, tc.err, variablesErr.Message)
+			assert.Equal(t, "BAD_USER_INPUT", variablesErr.Extensions["code"])
+		})
+	}
+}
+
+func TestGatewayScalarValidator(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		scalar DateTime @specifiedBy(url: "https://scalars.graphql.org/andimarek/date-time")
+
+		input Range {
+			from: DateTime!
+			to: DateTime
+		}
+
+		type Query {
+			at(time: DateTime): String!
+			between(range: Range!): String!
+			any(times: [DateTime!]!): String!
+		}
+	`)
+	require.NoError(t, err)
+
+	queries := 0
+	service := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		queries++
+		return map[string]interface{}{"value": "ok"}, nil
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithUpstreamQueryer("url1", service),
+		WithScalarValidator("DateTime", func(value interface{}) error {
+			str, ok := value.(string)
+			if !ok {
+				return errors.New("expected a string")
+			}
+			_, err := time.Parse(time.RFC3339, str)
+			return err
+		}),
+	)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		err       string
+	}{
+		{
+			name:      "valid",
+			query:     "query($time: DateTime) { value: at(time: $time) }",
+			variables: map[string]interface{}{"time": "2021-01-02T15:04:05Z"},
+		},
+		{
+			name:      "null",
+			query:     "query($time: DateTime) { value: at(time: $time) }",
+			variables: map[string]interface{}{"time": nil},
+		},
+		{
+			name:      "invalid",
+			query:     "query($time: DateTime) { value: at(time: $time) }",
+			variables: map[string]interface{}{"time": "yesterday"},
+			err:       "variable $time has an invalid DateTime value",
+		},
+		{
+			name:      "invalid type",
+			query:     "query($time: DateTime) { value: at(time: $time) }",
+			variables: map[string]interface{}{"time": 12},
+			err:       "variable $time has an invalid DateTime value: expected a string",
+		},
+		{
+			name:      "input object field",
+			query:     "query($range: Range!) { value: between(range: $range) }",
+			variables: map[string]interface{}{"range": map[string]interface{}{"from": "2021-01-02T15:04:05Z", "to": "tomorrow"}},
+			err:       "variable $range has an invalid DateTime value",
+		},
+		{
+			name:      "list entry",
+			query:     "query($times: [DateTime!]!) { value: any(times: $times) }",
+			variables: map[string]interface{}{"times": []interface{}{"2021-01-02T15:04:05Z", "later"}},
+			err:       "variable $times has an invalid DateTime value",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			// the gateway counts the queries that make it through so the sub-tests can't run in parallel
+			before := queries
+			reqCtx := &RequestContext{
+				Context:   context.Background(),
+				Query:     tc.query,
+				Variables: tc.variables,
+			}
+			plans, err := gateway.GetPlans(reqCtx)
+			require.NoError(t, err)
+
+			result, err := gateway.Execute(reqCtx, plans)
+			if tc.err == "" {
+				require.NoError(t, err)
+				assert.Equal(t, map[string]interface{}{"value": "ok"}, result)
+				assert.Equal(t, before+1, queries)
+				return
+			}
+
+			var errs graphql.ErrorList
+			require.ErrorAs(t, err, &errs)
+			require.Len(t, errs, 1)
+			var gqlErr *graphql.Error
+			require.ErrorAs(t, errs[0], &gqlErr)
+			assert.Contains(t, gqlErr.Message, tc.err)
+			assert.Equal(t, "BAD_USER_INPUT", gqlErr.Extensions["code"])
+			assert.Equal(t, before, queries)
+		})
+	}
+}
+
+func TestGatewayInternalQueryer(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	// a queryer that counts the internal queries and answers the ones for __type on its own
+	internalQueries := 0
+	var wrapped *Gateway
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithInternalQueryer(func(g *Gateway) graphql.Queryer {
+			wrapped = g
+			return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				internalQueries++
+				if graphql.SelectedFields(input.QueryDocument.Operations[0].SelectionSet)[0].Name == "__type" {
+					return map[string]interface{}{"__type": map[string]interface{}{"name": "Cached"}}, nil
+				}
+
+				result := map[string]interface{}{}
+				err := g.Query(context.Background(), input, &result)
+				return result, err
+			})
+		}),
+	)
+	require.NoError(t, err)
+	assert.Same(t, gateway, wrapped)
+
+	for _, tc := range []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "{ __schema { queryType { name } } }",
+			expected: `{"__schema": {"queryType": {"name": "Query"}}}`,
+		},
+		{
+			query:    `{ __type(name: "Query") { name } }`,
+			expected: `{"__type": {"name": "Cached"}}`,
+		},
+	} {
+		reqCtx := &RequestContext{Context: context.Background(), Query: tc.query}
+		plans, err := gateway.GetPlans(reqCtx)
+		require.NoError(t, err)
+		result, err := gateway.Execute(reqCtx, plans)
+		require.NoError(t, err)
+		// the introspection fields are pointers so we compare what the client would see
+		body, err := json.Marshal(result)
+		require.NoError(t, err)
+		assert.JSONEq(t, tc.expected, string(body))
+	}
+	assert.Equal(t, 2, internalQueries)
+}
+
+// extraPlanner adds the location of each step to its Extra
+type extraPlanner struct {
+	planner QueryPlanner
+}
+
+func (p extraPlanner) Plan(ctx *PlanningContext) (QueryPlanList, error) {
+	plans, err := p.planner.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, plan := range plans {
+		for _, step := range plan.RootStep.Then {
+			step.Extra = map[string]interface{}{"routingKey": "key-" + step.Location}
+		}
+	}
+	return plans, nil
+}
+
+func TestGatewayStepExtra(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+	sources := []*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}
+
+	// the built-in planner leaves it alone
+	gateway, err := New(sources)
+	require.NoError(t, err)
+	reqCtx := &RequestContext{Context: context.Background(), Query: "{ value }"}
+	plans, err := gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+	assert.Nil(t, plans[0].RootStep.Then[0].Extra)
+
+	// but a custom planner can pass anything along to a custom executor
+	gateway, err = New(sources,
+		WithPlanner(extraPlanner{planner: &MinQueriesPlanner{}}),
+		WithExecutor(ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			step := ctx.Plan.RootStep.Then[0]
+			return map[string]interface{}{"value": step.Extra["routingKey"]}, nil
+		})),
+	)
+	require.NoError(t, err)
+	plans, err = gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+	result, err := gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"value": "key-url1"}, result)
+
+	// and the hooks see it too
+	assert.Equal(t, plans[0].RootStep.Then[0].Extra, executorSnapshotStep(plans[0].RootStep.Then[0]).Extra)
+}
+
+func TestGatewayLenientFragments(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type User {
+			name: String!
+		}
+
+		type Photo {
+			url: String!
+		}
+
+		type Query {
+			me: User!
+		}
+	`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		lenient  bool
+		query    string
+		err      string
+		upstream string
+	}{
+		{
+			name:  "rejects unrelated inline fragment",
+			query: "{ me { name ... on Photo { url } } }",
+			err:   `Fragment cannot be spread here as objects of type "User" can never be of type "Photo".`,
+		},
+		{
+			name:  "rejects unknown type",
+			query: "{ me { name ... on NonExistentType { url } } }",
+			err:   `Unknown type "NonExistentType".`,
+		},
+		{
+			name:     "drops unrelated inline fragment",
+			lenient:  true,
+			query:    "{ me { name ... on Photo { url } } }",
+			upstream: "query { me { name } }",
+		},
+		{
+			name:     "drops unknown type",
+			lenient:  true,
+			query:    "{ me { name ... on NonExistentType { url } } }",
+			upstream: "query { me { name } }",
+		},
+		{
+			name:     "drops unrelated fragment spread",
+			lenient:  true,
+			query:    "{ me { name ...PhotoFields } } fragment PhotoFields on Photo { url }",
+			upstream: "query { me { name } }",
+		},
+		{
+			name:     "keeps matching fragments",
+			lenient:  true,
+			query:    "{ me { ... on User { name } } }",
+			upstream: "query { me { ... on User { name } } }",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var upstreamQuery string
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+				WithUpstreamQueryer("url1", graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+					upstreamQuery = input.Query
+					return map[string]interface{}{"me": map[string]interface{}{"name": "alice"}}, nil
+				})),
+				WithLenientFragments(tc.lenient),
+			)
+			require.NoError(t, err)
+
+			reqCtx := &RequestContext{
+				Context: context.Background(),
+				Query:   tc.query,
+			}
+			plans, err := gateway.GetPlans(reqCtx)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			result, err := gateway.Execute(reqCtx, plans)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"me": map[string]interface{}{"name": "alice"}}, result)
+			assert.Equal(t, strings.Join(strings.Fields(tc.upstream), " "), strings.Join(strings.Fields(upstreamQuery), " "))
+		})
+	}
+}
+
+func TestGatewayAbstractTypenames(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		interface SearchResult {
+			title: String!
+		}
+
+		type User implements SearchResult {
+			title: String!
+			name: String!
+		}
+
+		type Photo implements SearchResult {
+			title: String!
+			url: String!
+		}
+
+		type Query {
+			search: [SearchResult!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name             string
+		options          []Option
+		query            string
+		upstreamTypename bool
+		response         string
+	}{
+		{
+			name:             "added and scrubbed",
+			query:            "{ search { ... on User { name } ... on Photo { url } } }",
+			upstreamTypename: true,
+			response:         `{"data": {"search": [{"name": "Alice"}, {"url": "photo.jpg"}]}}`,
+		},
+		{
+			name:             "asked for by the client",
+			query:            "{ search { __typename ... on User { name } ... on Photo { url } } }",
+			upstreamTypename: true,
+			response:         `{"data": {"search": [{"__typename": "User", "name": "Alice"}, {"__typename": "Photo", "url": "photo.jpg"}]}}`,
+		},
+		{
+			name:             "disabled",
+			options:          []Option{WithoutAbstractTypenames(true)},
+			query:            "{ search { ... on User { name } ... on Photo { url } } }",
+			upstreamTypename: false,
+			response:         `{"data": {"search": [{"name": "Alice"}, {"url": "photo.jpg"}]}}`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			// the service answers like a real one would, with the __typename only if it was asked for
+			var upstreamQuery string
+			service := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				upstreamQuery = input.Query
+				user := map[string]interface{}{"name": "Alice"}
+				photo := map[string]interface{}{"url": "photo.jpg"}
+				if strings.Contains(input.Query, typenameField) {
+					user[typenameField] = "User"
+					photo[typenameField] = "Photo"
+				}
+				return map[string]interface{}{"search": []interface{}{user, photo}}, nil
+			})
+
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+				append(tc.options, WithUpstreamQueryer("url1", service))...,
+			)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf(`{"query": %q}`, tc.query)))
+			resp := httptest.NewRecorder()
+			gateway.GraphQLHandler(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, tc.response, resp.Body.String())
+			assert.Equal(t, tc.upstreamTypename, strings.Contains(upstreamQuery, typenameField), upstreamQuery)
+		})
+	}
+}
+
+func TestGatewayTimeouts(t *testing.T) {
+	t.Parallel()
+	// the slow service takes a second to respond unless the gateway gives up first
+	slowService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+		fmt.Fprint(w, `{"data": {"slow": "done"}}`)
+	}))
+	defer slowService.Close()
+	fastService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": {"fast": "done"}}`)
+	}))
+	defer fastService.Close()
+
+	slowSchema, err := graphql.LoadSchema(`type Query { slow: String }`)
+	require.NoError(t, err)
+	fastSchema, err := graphql.LoadSchema(`type Query { fast: String }`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		options []Option
+		message string
+	}{
+		{
+			name:    "step",
+			options: []Option{WithStepTimeout(50 * time.Millisecond)},
+			message: fmt.Sprintf("the query to %s exceeded its timeout of 50ms", slowService.URL),
+		},
+		{
+			// the error comes from the http client
+			name:    "upstream request",
+			options: []Option{WithUpstreamRequestTimeout(50 * time.Millisecond)},
+		},
+		{
+			// the shorter of the two wins
+			name:    "both",
+			options: []Option{WithStepTimeout(50 * time.Millisecond), WithUpstreamRequestTimeout(10 * time.Second)},
+			message: fmt.Sprintf("the query to %s exceeded its timeout of 50ms", slowService.URL),
+		},
+	} {
+		tc := tc
+		// the services are closed when the test returns so the sub-tests can't run in parallel
+		t.Run(tc.name, func(t *testing.T) {
+			gateway, err := New([]*graphql.RemoteSchema{
+				{Schema: slowSchema, URL: slowService.URL},
+				{Schema: fastSchema, URL: fastService.URL},
+			}, tc.options...)
+			require.NoError(t, err)
+
+			start := time.Now()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ slow fast }"}`))
+			resp := httptest.NewRecorder()
+			gateway.GraphQLHandler(resp, req)
+			assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+			// the slow step fails on its own
+			var result struct {
+				Data   map[string]interface{}
+				Errors []struct {
+					Message string
+				}
+			}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result), resp.Body.String())
+			assert.Equal(t, "done", result.Data["fast"])
+			require.Len(t, result.Errors, 1)
+			if tc.message != "" {
+				assert.Equal(t, tc.message, result.Errors[0].Message)
+			}
+		})
+	}
+}
+
+func TestGatewayUpstreamUserAgent(t *testing.T) {
+	t.Parallel()
+	// the service responds with the user agent it was sent
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": {"userAgent": %q}}`, r.Header.Get("User-Agent"))
+	}))
+	defer service.Close()
+
+	schema, err := graphql.LoadSchema(`type Query { userAgent: String! }`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		options   []Option
+		userAgent string
+	}{
+		{
+			name:      "default",
+			userAgent: DefaultUpstreamUserAgent,
+		},
+		{
+			name:      "custom",
+			options:   []Option{WithUpstreamUserAgent("my-gateway/1.2.3")},
+			userAgent: "my-gateway/1.2.3",
+		},
+		{
+			name:      "http client",
+			options:   []Option{WithUpstreamUserAgent("")},
+			userAgent: "Go-http-client/1.1",
+		},
+		{
+			name: "middleware",
+			options: []Option{WithMiddlewares(RequestMiddleware(func(r *http.Request) error {
+				r.Header.Set("User-Agent", "from-middleware")
+				return nil
+			}))},
+			userAgent: "from-middleware",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			// the service is closed when the test returns so the sub-tests can't run in parallel
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}}, tc.options...)
+			require.NoError(t, err)
+
+			reqCtx := &RequestContext{Context: context.Background(), Query: "{ userAgent }"}
+			plans, err := gateway.GetPlans(reqCtx)
+			require.NoError(t, err)
+			result, err := gateway.Execute(reqCtx, plans)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"userAgent": tc.userAgent}, result)
+		})
+	}
+
+	assert.True(t, strings.HasPrefix(DefaultUpstreamUserAgent, "nautilus-gateway"))
+}
+
+
+func TestGatewayErrorOrdering(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			users: [String]
+			photos: [String]
+		}
+	`)
+	require.NoError(t, err)
+
+	errs := graphql.ErrorList{
+		&graphql.Error{Message: "photo failed", Path: []interface{}{"photos", 0}},
+		&graphql.Error{Message: "user failed", Path: []interface{}{"users", 10}},
+		&graphql.Error{Message: "user failed", Path: []interface{}{"users", 2}},
+		errors.New("service unavailable"),
+		&graphql.Error{Message: "user failed", Path: []interface{}{"users", 2}},
+		&graphql.Error{Message: "another user failed", Path: []interface{}{"users", 2}},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		options  []Option
+		expected []string
+	}{
+		{
+			name:    "ordered",
+			options: []Option{WithErrorOrdering(true)},
+			expected: []string{
+				"service unavailable []",
+				"photo failed [photos 0]",
+				"another user failed [users 2]",
+				"user failed [users 2]",
+				"user failed [users 2]",
+				"user failed [users 10]",
+			},
+		},
+		{
+			name:    "ordered without duplicates",
+			options: []Option{WithErrorOrdering(true), WithErrorDedup(true)},
+			expected: []string{
+				"service unavailable []",
+				"photo failed [photos 0]",
+				"another user failed [users 2]",
+				"user failed [users 2]",
+				"user failed [users 10]",
+			},
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			// the steps finish in a different order every time
+			var runs int
+			var runsLock sync.Mutex
+			executor := WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+				runsLock.Lock()
+				defer runsLock.Unlock()
+				runs++
+				offset := runs % len(errs)
+				return map[string]interface{}{}, append(append(graphql.ErrorList{}, errs[offset:]...), errs[:offset]...)
+			}))
+
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, append(tc.options, executor)...)
+			require.NoError(t, err)
+
+			for i := 0; i < len(errs); i++ {
+				reqCtx := &RequestContext{
+					Context: context.Background(),
+					Query:   "{ users photos }",
+				}
+				plan, err := gateway.GetPlans(reqCtx)
+				require.NoError(t, err)
+
+				_, err = gateway.Execute(reqCtx, plan)
+				var list graphql.ErrorList
+				require.True(t, errors.As(err, &list))
+
+				reported := []string{}
+				for _, listErr := range list {
+					message, path := errorMessageAndPath(listErr)
+					reported = append(reported, fmt.Sprintf("%s %v", message, path))
+				}
+				assert.Equal(t, tc.expected, reported)
+			}
+		})
+	}
+}
+
+func TestGatewayHeaderPropagationPredicate(t *testing.T) {
+	t.Parallel()
+	// the service responds with the tracing headers it was sent
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": {"trace": %q}}`, strings.Join([]string{
+			r.Header.Get("Traceparent"),
+			r.Header.Get("X-B3-Traceid"),
+			r.Header.Get("X-B3-Spanid"),
+			r.Header.Get("Authorization"),
+		}, ","))
+	}))
+	defer service.Close()
+
+	schema, err := graphql.LoadSchema(`type Query { trace: String! }`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}}, WithHeaderPropagationPredicate(func(name string) bool {
+		return name == "traceparent" || strings.HasPrefix(name, "x-b3-")
+	}))
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ trace }"}`))
+	request.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	request.Header.Set("X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7")
+	request.Header.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+	request.Header.Set("Authorization", "Bearer secret")
+	responseRecorder := httptest.NewRecorder()
+	gateway.GraphQLHandler(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.JSONEq(t, `{
+		"data": {
+			"trace": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01,80f198ee56343ba864fe8b2a57d3eff7,e457b5a2e4d86bd1,"
+		}
+	}`, responseRecorder.Body.String())
+
+	// operations that don't come over HTTP don't have any headers to propagate
+	reqCtx := &RequestContext{Context: context.Background(), Query: "{ trace }"}
+	plans, err := gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+	result, err := gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"trace": ",,,"}, result)
+}
+
+func TestGatewayHeaderPropagationPredicate_connectionHeaders(t *testing.T) {
+	t.Parallel()
+	// the service responds with the headers it was sent
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": {"headers": %q}}`, strings.Join([]string{
+			r.Header.Get("Content-Type"),
+			r.Header.Get("Upgrade"),
+			r.Header.Get("X-Private"),
+			r.Header.Get("X-Trace"),
+		}, ","))
+	}))
+	defer service.Close()
+
+	schema, err := graphql.LoadSchema(`type Query { headers: String! }`)
+	require.NoError(t, err)
+
+	// a queryer the gateway didn't build propagates the headers too
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}},
+		WithUpstreamQueryer(service.URL, graphql.NewSingleRequestQueryer(service.URL)),
+		WithHeaderPropagationPredicate(func(name string) bool {
+			return true
+		}),
+	)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ headers }"}`))
+	request.Header.Set("Content-Type", "application/json; charset=latin1")
+	request.Header.Set("Connection", "Upgrade, X-Private")
+	request.Header.Set("Upgrade", "h2c")
+	request.Header.Set("X-Private", "hop")
+	request.Header.Set("X-Trace", "abc")
+	responseRecorder := httptest.NewRecorder()
+	gateway.GraphQLHandler(responseRecorder, request)
+
+	// only the header that isn't about the client's request or connection made it
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.JSONEq(t, `{"data": {"headers": "application/json,,,abc"}}`, responseRecorder.Body.String())
+}
+
+func TestGatewayResponseVisitor(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		scalar EmailAddress
+
+		type User {
+			name: String!
+			email: EmailAddress
+			backupEmails: [EmailAddress!]!
+			friends: [User!]!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	var paths []string
+	var pathsLock sync.Mutex
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"users": []interface{}{
+					map[string]interface{}{
+						"name":         "alice",
+						"contact":      "alice@example.com",
+						"backupEmails": []interface{}{"a@example.com", "b@example.com"},
+						"friends": []interface{}{
+							map[string]interface{}{"name": "bob", "contact": nil},
+						},
+					},
+				},
+			}, nil
+		})),
+		WithResponseVisitor(func(path []string, typeName string, value interface{}) (interface{}, bool) {
+			if typeName != "EmailAddress" {
+				return nil, false
+			}
+			pathsLock.Lock()
+			paths = append(paths, strings.Join(path, "."))
+			pathsLock.Unlock()
+			return "***", true
+		}),
+	)
+	require.NoError(t, err)
+
+	reqCtx := &RequestContext{
+		Context: context.Background(),
+		Query: `
+			{
+				users {
+					name
+					contact: email
+					...Emails
+					friends {
+						name
+						contact: email
+					}
+				}
+			}
+
+			fragment Emails on User {
+				backupEmails
+			}
+		`,
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+	result, err := gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{
+				"name":         "alice",
+				"contact":      "***",
+				"backupEmails": []interface{}{"***", "***"},
+				"friends": []interface{}{
+					map[string]interface{}{"name": "bob", "contact": nil},
+				},
+			},
+		},
+	}, result)
+	assert.Equal(t, []string{"users.0.contact", "users.0.backupEmails.0", "users.0.backupEmails.1"}, paths)
+}
+
+func TestGatewayServices(t *testing.T) {
+	t.Parallel()
+	schema1, err := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+	require.NoError(t, err)
+	schema2, err := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			avatars: [User!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema1, URL: "url1"},
+		{Schema: schema2, URL: "url2"},
+	}, WithFieldLocationOverrides(FieldURLMap{"User.id": {"url2"}}))
+	require.NoError(t, err)
+
+	assert.Equal(t, []ServiceInfo{
+		{
+			URL: "url1",
+			Types: map[string][]string{
+				"Query": {"users"},
+				"User":  {"name"},
+			},
+			FieldCount: 2,
+		},
+		{
+			URL: "url2",
+			Types: map[string][]string{
+				"Query": {"avatars"},
+				"User":  {"avatar", "id"},
+			},
+			FieldCount: 3,
+		},
+	}, gateway.Services())
+
+	// the services follow the fields that are moved around
+	require.NoError(t, gateway.SetFieldLocation("User", "id", "url1", "url2"))
+	services := gateway.Services()
+	assert.Equal(t, []string{"id", "name"}, services[0].Types["User"])
+	assert.Equal(t, 3, services[0].FieldCount)
+}
+
+func TestGatewayMiddlewareFor(t *testing.T) {
+	t.Parallel()
+	// each service responds with the headers it was sent
+	newService := func(field string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"data": {%q: %q}}`, field, r.Header.Get("Authorization")+"|"+r.Header.Get("X-Client-Cert")+"|"+r.Header.Get("X-Everyone"))
+		}))
+	}
+	serviceA := newService("a")
+	defer serviceA.Close()
+	serviceB := newService("b")
+	defer serviceB.Close()
+
+	schemaA, err := graphql.LoadSchema(`type Query { a: String! }`)
+	require.NoError(t, err)
+	schemaB, err := graphql.LoadSchema(`type Query { b: String! }`)
+	require.NoError(t, err)
+
+	var calledA int64
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schemaA, URL: serviceA.URL},
+		{Schema: schemaB, URL: serviceB.URL},
+	},
+		WithMiddlewareFor(serviceA.URL, func(r *http.Request) error {
+			atomic.AddInt64(&calledA, 1)
+			r.Header.Set("Authorization", "Bearer token")
+			return nil
+		}),
+		WithMiddlewareFor(serviceB.URL, func(r *http.Request) error {
+			r.Header.Set("X-Client-Cert", "cert")
+			return nil
+		}),
+		WithMiddlewares(RequestMiddleware(func(r *http.Request) error {
+			r.Header.Set("X-Everyone", "yes")
+			return nil
+		})),
+	)
+	require.NoError(t, err)
+
+	reqCtx := &RequestContext{Context: context.Background(), Query: "{ a b }"}
+	plans, err := gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+	result, err := gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": "Bearer token||yes",
+		"b": "|cert|yes",
+	}, result)
+
+	// the middleware for service A doesn't run for queries that only go to service B
+	reqCtx = &RequestContext{Context: context.Background(), Query: "{ b }"}
+	plans, err = gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+	_, err = gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calledA))
+}
+
+// newConnectionCountingGateway returns a gateway that stitches the email of a list of users from a second
+// service, one node query per user, along with a function that counts the connections each service accepted
+func newConnectionCountingGateway(tb testing.TB, users int, options ...Option) (*Gateway, func() (int64, int64)) {
+	tb.Helper()
+
+	// the services count the connections they accept
+	newService := func(connections *int64, handler http.HandlerFunc) *httptest.Server {
+		service := httptest.NewUnstartedServer(handler)
+		service.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt64(connections, 1)
+			}
+		}
+		service.Start()
+		tb.Cleanup(service.Close)
+		return service
+	}
+
+	var userConnections, profileConnections int64
+	userService := newService(&userConnections, func(w http.ResponseWriter, r *http.Request) {
+		ids := []string{}
+		for i := 0; i < users; i++ {
+			ids = append(ids, fmt.Sprintf(`{"id": "%d"}`, i))
+		}
+		fmt.Fprintf(w, `{"data": {"users": [%s]}}`, strings.Join(ids, ","))
+	})
+	profileService := newService(&profileConnections, func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		variables, _ := input["variables"].(map[string]interface{})
+		fmt.Fprintf(w, `{"data": {"node": {"email": "%v@example.com"}}}`, variables["id"])
+	})
+
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+		}
+
+		type Query {
+			users: [User!]!
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(tb, err)
+	profileSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			email: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(tb, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: userSchema, URL: userService.URL},
+		{Schema: profileSchema, URL: profileService.URL},
+	}, options...)
+	require.NoError(tb, err)
+
+	return gateway, func() (int64, int64) {
+		return atomic.LoadInt64(&userConnections), atomic.LoadInt64(&profileConnections)
+	}
+}
+
+func TestGatewayUpstreamTransport(t *testing.T) {
+	t.Parallel()
+	// a transport that only ever opens one connection to each service
+	transport := &http.Transport{MaxConnsPerHost: 1, MaxIdleConnsPerHost: 1}
+	defer transport.CloseIdleConnections()
+
+	gateway, connections := newConnectionCountingGateway(t, 10, WithUpstreamTransport(transport), WithUpstreamRequestTimeout(10*time.Second))
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ users { email } }"}`))
+		resp := httptest.NewRecorder()
+		gateway.GraphQLHandler(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `{"email":"9@example.com"}`)
+	}
+
+	// every query of both requests went through the same connections
+	userConnections, profileConnections := connections()
+	assert.Equal(t, int64(1), userConnections)
+	assert.Equal(t, int64(1), profileConnections)
+}
+
+func BenchmarkGateway_upstreamConnectionReuse(b *testing.B) {
+	for _, bc := range []struct {
+		name      string
+		transport *http.Transport
+	}{
+		{name: "default transport"},
+		{name: "tuned transport", transport: &http.Transport{MaxIdleConnsPerHost: 100}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			options := []Option{}
+			if bc.transport != nil {
+				defer bc.transport.CloseIdleConnections()
+				options = append(options, WithUpstreamTransport(bc.transport))
+			}
+			gateway, connections := newConnectionCountingGateway(b, 20, options...)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query": "{ users { email } }"}`))
+				resp := httptest.NewRecorder()
+				gateway.GraphQLHandler(resp, req)
+				if resp.Code != http.StatusOK {
+					b.Fatal(resp.Body.String())
+				}
+			}
+			b.StopTimer()
+
+			// each request sends a node query for every user so reuse shows up as fewer connections than queries
+			userConnections, profileConnections := connections()
+			b.ReportMetric(float64(userConnections+profileConnections)/float64(b.N), "conns/op")
+		})
+	}
+}
+
+func TestGatewayStream(t *testing.T) {
+	t.Parallel()
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			firstName: String!
+		}
+
+		type Query {
+			users: [User!]!
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	profileSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			lastName: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	users := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		return map[string]interface{}{
+			"users": []interface{}{
+				map[string]interface{}{"id": "1", "firstName": "Alice"},
+				map[string]interface{}{"id": "2", "firstName": "Bob"},
+				map[string]interface{}{"id": "3", "firstName": "Carol"},
+			},
+		}, nil
+	})
+	// the last name of the last user takes until the test says so
+	release := make(chan struct{})
+	profiles := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		if input.Variables["id"] == "3" {
+			<-release
+		}
+		return map[string]interface{}{
+			"node": map[string]interface{}{"lastName": fmt.Sprintf("Smith %v", input.Variables["id"])},
+		}, nil
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+		{Schema: profileSchema, URL: "profiles"},
+	},
+		WithUpstreamQueryer("users", users),
+		WithUpstreamQueryer("profiles", profiles),
+	)
+	require.NoError(t, err)
+
+	reqCtx := &RequestContext{
+		Context: context.Background(),
+		Query:   `{ users @stream(initialCount: 1, label: "users") { firstName lastName } }`,
+		Stream:  true,
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	require.NoError(t, err)
+
+	// the rest of the result doesn't have to wait for the entries that are streamed
+	result, err := gateway.Execute(reqCtx, plans)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"firstName": "Alice", "lastName": "Smith 1"},
+		},
+	}, result)
+	require.NotNil(t, reqCtx.StreamedItems)
+
+	nextItem := func() *StreamedItem {
+		select {
+		case item := <-reqCtx.StreamedItems:
+			return item
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a streamed entry")
+			return nil
+		}
+	}
+
+	// the entries that are done don't wait for the ones that aren't
+	item := nextItem()
+	require.NotNil(t, item)
+	assert.Equal(t, "users", item.Label)
+	assert.Equal(t, []interface{}{"users", 1}, item.Path)
+	assert.Equal(t, map[string]interface{}{"firstName": "Bob", "lastName": "Smith 2"}, item.Value())
+	assert.False(t, item.Last)
+	select {
+	case item := <-reqCtx.StreamedItems:
+		t.Fatalf("the last entry was streamed before it was resolved: %v", item)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	item = nextItem()
+	require.NotNil(t, item)
+	assert.Equal(t, []interface{}{"users", 2}, item.Path)
+	assert.Equal(t, map[string]interface{}{"firstName": "Carol", "lastName": "Smith 3"}, item.Value())
+	assert.Empty(t, item.Errors)
+	assert.True(t, item.Last)
+
+	_, open := <-reqCtx.StreamedItems
+	assert.False(t, open)
+}
+
+func TestGatewayValidate(t *testing.T) {
+	t.Parallel()
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+			posts: [Post!]!
+		}
+
+		type Post implements Node {
+			id: ID!
+		}
+
+		type Query {
+			me: User
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name       string
+		postSchema string
+		cycles     []string
+	}{
+		{
+			name: "cycle",
+			postSchema: `
+				interface Node {
+					id: ID!
+				}
+
+				type User implements Node {
+					id: ID!
+				}
+
+				type Post implements Node {
+					id: ID!
+					title: String!
+					author: User!
+				}
+
+				type Query {
+					node(id: ID!): Node
+				}
+			`,
+			// the users service needs the posts service for the title of a post, which needs the users
+			// service for the name of its author, and so on
+			cycles: []string{"Post.author (posts) -> User.posts (users) -> Post (posts)"},
+		},
+		{
+			name: "no cycle",
+			postSchema: `
+				interface Node {
+					id: ID!
+				}
+
+				type Post implements Node {
+					id: ID!
+					title: String!
+				}
+
+				type Query {
+					node(id: ID!): Node
+				}
+			`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			postSchema, err := graphql.LoadSchema(tc.postSchema)
+			require.NoError(t, err)
+
+			gateway, err := New([]*graphql.RemoteSchema{
+				{Schema: userSchema, URL: "users"},
+				{Schema: postSchema, URL: "posts"},
+			})
+			require.NoError(t, err)
+
+			err = gateway.Validate()
+			if tc.cycles == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var cycleErr *BoundaryCycleError
+			if assert.True(t, errors.As(err, &cycleErr)) {
+				assert.Equal(t, tc.cycles, cycleErr.Cycles)
+			}
+		})
+	}
+}
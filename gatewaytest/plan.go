@@ -0,0 +1,140 @@
+// Package gatewaytest provides helpers for testing the query plans built by the gateway's planners.
+package gatewaytest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nautilus/gateway"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// ExpectedStep describes a step of a query plan. Steps are compared by their rendered form so the
+// order of the steps in Then doesn't matter.
+type ExpectedStep struct {
+	// ParentType is the type the step's selection set applies to
+	ParentType string
+	// Location is the url of the service the step is sent to
+	Location string
+	// InsertionPoint is the path where the step's result is inserted into the response
+	InsertionPoint []string
+	// SelectionSet is the step's selection set, written like PlanString renders it. For example: "{ user { id } }"
+	SelectionSet string
+	// Then holds the steps that depend on this one
+	Then []ExpectedStep
+}
+
+// AssertPlanSteps fails the test if the steps of the plan are not the expected ones. It returns true if they are.
+func AssertPlanSteps(t assert.TestingT, plan *gateway.QueryPlan, expected []ExpectedStep) bool {
+	if helper, ok := t.(interface{ Helper() }); ok {
+		helper.Helper()
+	}
+
+	builder := &strings.Builder{}
+	writeExpectedSteps(builder, expected, 0)
+
+	return assert.Equal(t, builder.String(), PlanString(plan))
+}
+
+// PlanString renders the steps of a plan with one line per step and the steps that depend on it indented
+// below. Each line holds the parent type, the location, the insertion point, and the selection set of the
+// step, for example:
+//
+//	Query from url1 { user { id firstName } }
+//	  User from url2 at user { lastName }
+//
+// Sibling steps are sorted so that the same plan always renders the same way.
+func PlanString(plan *gateway.QueryPlan) string {
+	if plan == nil || plan.RootStep == nil {
+		return ""
+	}
+
+	builder := &strings.Builder{}
+	writeSteps(builder, plan.RootStep.Then, 0)
+	return builder.String()
+}
+
+func writeSteps(builder *strings.Builder, steps []*gateway.QueryPlanStep, depth int) {
+	// render each step on its own so that they can be sorted
+	rendered := make([]string, len(steps))
+	for i, step := range steps {
+		stepBuilder := &strings.Builder{}
+		writeStepLine(stepBuilder, step.ParentType, step.Location, step.InsertionPoint, SelectionSetString(step.SelectionSet), depth)
+		writeSteps(stepBuilder, step.Then, depth+1)
+		rendered[i] = stepBuilder.String()
+	}
+	sort.Strings(rendered)
+
+	for _, step := range rendered {
+		builder.WriteString(step)
+	}
+}
+
+func writeExpectedSteps(builder *strings.Builder, steps []ExpectedStep, depth int) {
+	rendered := make([]string, len(steps))
+	for i, step := range steps {
+		stepBuilder := &strings.Builder{}
+		writeStepLine(stepBuilder, step.ParentType, step.Location, step.InsertionPoint, step.SelectionSet, depth)
+		writeExpectedSteps(stepBuilder, step.Then, depth+1)
+		rendered[i] = stepBuilder.String()
+	}
+	sort.Strings(rendered)
+
+	for _, step := range rendered {
+		builder.WriteString(step)
+	}
+}
+
+func writeStepLine(builder *strings.Builder, parentType string, location string, insertionPoint []string, selectionSet string, depth int) {
+	builder.WriteString(strings.Repeat("  ", depth))
+	fmt.Fprintf(builder, "%s from %s", parentType, location)
+	if len(insertionPoint) > 0 {
+		fmt.Fprintf(builder, " at %s", strings.Join(insertionPoint, "."))
+	}
+	fmt.Fprintf(builder, " %s\n", selectionSet)
+}
+
+// SelectionSetString renders a selection set on one line, for example: { a: user(id: $id) { id ... on User { name } } }
+func SelectionSetString(selectionSet ast.SelectionSet) string {
+	builder := &strings.Builder{}
+	writeSelectionSet(builder, selectionSet)
+	return builder.String()
+}
+
+func writeSelectionSet(builder *strings.Builder, selectionSet ast.SelectionSet) {
+	builder.WriteString("{")
+	for _, selection := range selectionSet {
+		builder.WriteString(" ")
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Alias != "" && selection.Alias != selection.Name {
+				fmt.Fprintf(builder, "%s: ", selection.Alias)
+			}
+			builder.WriteString(selection.Name)
+			if len(selection.Arguments) > 0 {
+				arguments := []string{}
+				for _, argument := range selection.Arguments {
+					arguments = append(arguments, fmt.Sprintf("%s: %s", argument.Name, argument.Value.String()))
+				}
+				fmt.Fprintf(builder, "(%s)", strings.Join(arguments, ", "))
+			}
+			if len(selection.SelectionSet) > 0 {
+				builder.WriteString(" ")
+				writeSelectionSet(builder, selection.SelectionSet)
+			}
+		case *ast.InlineFragment:
+			builder.WriteString("...")
+			if selection.TypeCondition != "" {
+				fmt.Fprintf(builder, " on %s", selection.TypeCondition)
+			}
+			builder.WriteString(" ")
+			writeSelectionSet(builder, selection.SelectionSet)
+		case *ast.FragmentSpread:
+			fmt.Fprintf(builder, "...%s", selection.Name)
+		}
+	}
+	builder.WriteString(" }")
+}
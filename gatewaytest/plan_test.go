@@ -0,0 +1,123 @@
+package gatewaytest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nautilus/gateway"
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPlan(t *testing.T, query string) *gateway.QueryPlan {
+	t.Helper()
+	userSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			firstName: String!
+		}
+
+		type Query {
+			user(id: ID!): User
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+	profileSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			lastName: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	gw, err := gateway.New([]*graphql.RemoteSchema{
+		{Schema: userSchema, URL: "users"},
+		{Schema: profileSchema, URL: "profiles"},
+	})
+	require.NoError(t, err)
+
+	plans, err := gw.GetPlans(&gateway.RequestContext{
+		Context: context.Background(),
+		Query:   query,
+	})
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+
+	return plans[0]
+}
+
+func TestPlanString(t *testing.T) {
+	t.Parallel()
+	plan := testPlan(t, `query($id: ID!) { a: user(id: $id) { firstName lastName } b: user(id: "2") { ... on User { firstName } } }`)
+
+	assert.Equal(t, `Query from users { a: user(id: $id) { firstName id } b: user(id: "2") { ... on User { firstName } } }
+  User from profiles at a { lastName }
+`, PlanString(plan))
+}
+
+func TestPlanString_noPlan(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "", PlanString(nil))
+	assert.Equal(t, "", PlanString(&gateway.QueryPlan{}))
+}
+
+func TestAssertPlanSteps(t *testing.T) {
+	t.Parallel()
+	plan := testPlan(t, `{ user(id: "1") { firstName lastName } }`)
+
+	AssertPlanSteps(t, plan, []ExpectedStep{
+		{
+			ParentType:   "Query",
+			Location:     "users",
+			SelectionSet: `{ user(id: "1") { firstName id } }`,
+			Then: []ExpectedStep{
+				{
+					ParentType:     "User",
+					Location:       "profiles",
+					InsertionPoint: []string{"user"},
+					SelectionSet:   "{ lastName }",
+				},
+			},
+		},
+	})
+}
+
+// recordingT records the failures of an assertion so we can check that it fails
+type recordingT struct {
+	failures []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+}
+
+func TestAssertPlanSteps_mismatch(t *testing.T) {
+	t.Parallel()
+	plan := testPlan(t, `{ user(id: "1") { firstName lastName } }`)
+
+	recorder := &recordingT{}
+	ok := AssertPlanSteps(recorder, plan, []ExpectedStep{
+		{
+			ParentType:   "Query",
+			Location:     "profiles",
+			SelectionSet: `{ user(id: "1") { firstName lastName } }`,
+		},
+	})
+	assert.False(t, ok)
+	assert.Len(t, recorder.failures, 1)
+}
@@ -10,6 +10,24 @@ import (
 type PlaygroundConfig struct {
 	Endpoint string             `json:"endpoint"`
 	Settings PlaygroundSettings `json:"settings"`
+
+	// SubscriptionEndpoint is the url subscriptions are sent to, if it's different from the endpoint
+	SubscriptionEndpoint string `json:"subscriptionEndpoint,omitempty"`
+	// Tabs are opened when the playground loads, each with its own query, variables, and headers
+	Tabs []PlaygroundTab `json:"tabs,omitempty"`
+	// Title is the title of the page. Defaults to "GraphQL Playground"
+	Title string `json:"-"`
+}
+
+// PlaygroundTab is a tab that is open when the playground UI loads
+type PlaygroundTab struct {
+	// Endpoint defaults to the endpoint of the playground
+	Endpoint string `json:"endpoint"`
+	Name     string `json:"name,omitempty"`
+	Query    string `json:"query"`
+	// Variables is the JSON encoded variables for the query
+	Variables string            `json:"variables,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
 }
 
 // PlaygroundSettings contains settings for setting up a playground UI.
@@ -23,16 +41,65 @@ type PlaygroundSettings struct {
 	RequestGlobalHeaders map[string]string `json:"request.globalHeaders"`
 }
 
-func writePlayground(w io.Writer, config PlaygroundConfig) error {
-	return playgroundTemplate.Execute(w, config)
+// PlaygroundUI is the interface served by the playground handlers on GET requests
+type PlaygroundUI int
+
+const (
+	// PlaygroundUIGraphQLPlayground serves GraphQL Playground. This is the default.
+	PlaygroundUIGraphQLPlayground PlaygroundUI = iota
+	// PlaygroundUIGraphiQL serves GraphiQL
+	PlaygroundUIGraphiQL
+	// PlaygroundUIApolloSandbox serves the embedded Apollo Sandbox
+	PlaygroundUIApolloSandbox
+	// PlaygroundUIDisabled responds to GET requests with a 404. POST requests are still handled.
+	PlaygroundUIDisabled
+)
+
+// WithPlaygroundUI returns an Option that sets the interface served by the PlaygroundHandler
+// and StaticPlaygroundHandler
+func WithPlaygroundUI(ui PlaygroundUI) Option {
+	return func(g *Gateway) {
+		g.playgroundUI = ui
+	}
 }
 
-var playgroundTemplate = template.Must(template.New("").Funcs(map[string]interface{}{
+func writePlayground(w io.Writer, ui PlaygroundUI, config PlaygroundConfig) error {
+	if config.Title == "" {
+		config.Title = "GraphQL Playground"
+	}
+
+	// the playground won't load a tab without an endpoint
+	tabs := make([]PlaygroundTab, len(config.Tabs))
+	for i, tab := range config.Tabs {
+		if tab.Endpoint == "" {
+			tab.Endpoint = config.Endpoint
+		}
+		tabs[i] = tab
+	}
+	config.Tabs = tabs
+
+	switch ui {
+	case PlaygroundUIGraphiQL:
+		return graphiqlTemplate.Execute(w, config)
+	case PlaygroundUIApolloSandbox:
+		return sandboxTemplate.Execute(w, config)
+	default:
+		return playgroundTemplate.Execute(w, config)
+	}
+}
+
+var templateFuncs = map[string]interface{}{
 	"toJSON": func(v interface{}) (string, error) {
 		bytes, err := json.Marshal(v)
 		return string(bytes), err
 	},
-}).Parse(playgroundContent))
+}
+
+var (
+	playgroundTemplate = template.Must(template.New("").Funcs(templateFuncs).Parse(playgroundContent))
+	graphiqlTemplate   = template.Must(template.New("").Funcs(templateFuncs).Parse(graphiqlContent))
+	sandboxTemplate    = template.Must(template.New("").Funcs(templateFuncs).Parse(sandboxContent))
+)
 
 // playgroundContent sourced from here: https://github.com/graphql/graphql-playground/blob/main/packages/graphql-playground-html/minimal.html
 const playgroundContent = `
@@ -42,7 +109,7 @@ const playgroundContent = `
 <head>
   <meta charset=utf-8/>
   <meta name="viewport" content="user-scalable=no, initial-scale=1.0, minimum-scale=1.0, maximum-scale=1.0, minimal-ui">
-  <title>GraphQL Playground</title>
+  <title>{{ .Title | html }}</title>
   <link rel="stylesheet" href="//cdn.jsdelivr.net/npm/graphql-playground-react/build/static/css/index.css" />
   <link rel="shortcut icon" href="//cdn.jsdelivr.net/npm/graphql-playground-react/build/favicon.png" />
   <script src="//cdn.jsdelivr.net/npm/graphql-playground-react/build/static/js/middleware.js"></script>
@@ -83,7 +150,7 @@ const playgroundContent = `
     </style>
     <img src='//cdn.jsdelivr.net/npm/graphql-playground-react/build/logo.png' alt=''>
     <div class="loading"> Loading
-      <span class="title">GraphQL Playground</span>
+      <span class="title">{{ .Title | html }}</span>
     </div>
   </div>
   <script>window.addEventListener('load', function (event) {
@@ -93,3 +160,96 @@ const playgroundContent = `
 
 </html>
 `
+
+// graphiqlContent is based on the CDN example here: https://github.com/graphql/graphiql/blob/main/examples/graphiql-cdn/index.html
+const graphiqlContent = `
+<!DOCTYPE html>
+<html>
+
+<head>
+  <meta charset=utf-8/>
+  <title>{{ .Title | html }}</title>
+  <style>
+    body {
+      height: 100%;
+      margin: 0;
+      width: 100%;
+      overflow: hidden;
+    }
+
+    #graphiql {
+      height: 100vh;
+    }
+  </style>
+  <script crossorigin src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+  <link rel="stylesheet" href="https://unpkg.com/graphiql/graphiql.min.css" />
+</head>
+
+<body>
+  <div id="graphiql">Loading...</div>
+  <script src="https://unpkg.com/graphiql/graphiql.min.js" type="application/javascript"></script>
+  <script>
+    const root = ReactDOM.createRoot(document.getElementById('graphiql'));
+    root.render(
+      React.createElement(GraphiQL, {
+        fetcher: GraphiQL.createFetcher({
+          url: {{ .Endpoint | toJSON }},
+          subscriptionUrl: {{ if .SubscriptionEndpoint }}{{ .SubscriptionEndpoint | toJSON }}{{ else }}undefined{{ end }},
+          headers: {{ with .Settings.RequestGlobalHeaders }}{{ . | toJSON }}{{ else }}{}{{ end }},
+        }),
+        {{- with .Tabs }}{{ with index . 0 }}
+        defaultQuery: {{ .Query | toJSON }},
+        variables: {{ .Variables | toJSON }},
+        headers: {{ with .Headers }}{{ . | toJSON | toJSON }}{{ else }}""{{ end }},
+        {{- end }}{{ end }}
+      }),
+    );
+  </script>
+</body>
+
+</html>
+`
+
+// sandboxContent is based on the embedding instructions here: https://www.apollographql.com/docs/graphos/explorer/sandbox#embedding-sandbox
+const sandboxContent = `
+<!DOCTYPE html>
+<html>
+
+<head>
+  <meta charset=utf-8/>
+  <title>{{ .Title | html }}</title>
+  <style>
+    body {
+      height: 100%;
+      margin: 0;
+      width: 100%;
+      overflow: hidden;
+    }
+
+    #embedded-sandbox {
+      height: 100vh;
+    }
+  </style>
+</head>
+
+<body>
+  <div id="embedded-sandbox"></div>
+  <script src="https://embeddable-sandbox.cdn.apollographql.com/_latest/embeddable-sandbox.umd.production.min.js"></script>
+  <script>
+    new window.EmbeddedSandbox({
+      target: '#embedded-sandbox',
+      initialEndpoint: {{ .Endpoint | toJSON }},
+      {{- with .Tabs }}{{ with index . 0 }}
+      initialState: {
+        document: {{ .Query | toJSON }},
+        variables: {{ if .Variables }}JSON.parse({{ .Variables | toJSON }}){{ else }}{}{{ end }},
+        headers: {{ with .Headers }}{{ . | toJSON }}{{ else }}{}{{ end }},
+      },
+      {{- end }}{{ end }}
+    });
+  </script>
+</body>
+
+</html>
+`
@@ -1,14 +1,17 @@
 package gateway
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/99designs/gqlgen/graphql/introspection"
 	"github.com/nautilus/graphql"
 )
 
@@ -19,12 +22,42 @@ type PersistedQuerySpecification struct {
 
 // HTTPOperation is the incoming payload when sending POST requests to the gateway
 type HTTPOperation struct {
-	Query         string                 `json:"query"`
-	Variables     map[string]interface{} `json:"variables"`
-	OperationName string                 `json:"operationName"`
-	Extensions    struct {
-		QueryPlanCache *PersistedQuerySpecification `json:"persistedQuery"`
-	} `json:"extensions"`
+	Query         string                  `json:"query"`
+	Variables     map[string]interface{}  `json:"variables"`
+	OperationName string                  `json:"operationName"`
+	Extensions    HTTPOperationExtensions `json:"extensions"`
+}
+
+// HTTPOperationExtensions holds the extensions sent along with an operation
+type HTTPOperationExtensions struct {
+	QueryPlanCache *PersistedQuerySpecification `json:"persistedQuery"`
+	// Values holds every extension that was sent, the persisted query included
+	Values map[string]interface{} `json:"-"`
+
+	// the extensions as they were sent, until the gateway decodes them with its codec
+	raw []byte
+}
+
+// UnmarshalJSON holds onto the extensions so the gateway can decode them with the codec it was given
+func (e *HTTPOperationExtensions) UnmarshalJSON(data []byte) error {
+	e.raw = append([]byte(nil), data...)
+	return nil
+}
+
+// decodeExtensions reads the extensions of an operation with the gateway's codec
+func (g *Gateway) decodeExtensions(e *HTTPOperationExtensions) error {
+	if e.raw == nil {
+		return nil
+	}
+	data := e.raw
+	e.raw = nil
+
+	// an alias of the type doesn't have the method so we don't end up back in UnmarshalJSON
+	type knownExtensions HTTPOperationExtensions
+	if err := g.jsonUnmarshal(data, (*knownExtensions)(e)); err != nil {
+		return err
+	}
+	return g.jsonUnmarshal(data, &e.Values)
 }
 
 func formatErrors(err error) map[string]interface{} {
@@ -48,22 +81,104 @@ func formatErrorsWithCode(data map[string]interface{}, err error, code string) m
 	}
 }
 
+// WithMaxBatchSize returns an Option that limits the number of operations that can be sent
+// in a single batched request. A value of 0 (the default) does not limit the batch size.
+func WithMaxBatchSize(size int) Option {
+	return func(g *Gateway) {
+		g.maxBatchSize = size
+	}
+}
+
+// WithMaxUploadFiles returns an Option that limits the number of files that can be uploaded in a single
+// multipart request. Requests with more files are rejected with a 422 as soon as the file over the limit
+// shows up, without reading it or any of the files after it. A value of 0 (the default) does not limit
+// the number of files.
+func WithMaxUploadFiles(n int) Option {
+	return func(g *Gateway) {
+		g.maxUploadFiles = n
+	}
+}
+
 // GraphQLHandler returns a http.HandlerFunc that should be used as the
 // primary endpoint for the gateway API. The endpoint will respond
 // to queries on both GET and POST requests. POST requests can either be
 // a single object with { query, variables, operationName } or a list
-// of that object.
+// of that object. Each operation in a list is planned and executed on its own
+// so an error in one operation does not affect the others.
 func (g *Gateway) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
-	operations, batchMode, parseStatusCode, payloadErr := parseRequest(r)
+	// give the user a chance to set up the context that the request is handled under
+	if g.contextBuilder != nil {
+		if ctx := g.contextBuilder(r); ctx != nil {
+			r = r.WithContext(ctx)
+		}
+	}
+
+	// the headers that describe the gateway go on every response, errors included
+	if g.responseInfoHeaders {
+		g.setResponseInfoHeaders(w)
+	}
+
+	// every request can be tracked through the services and the logs with its id
+	jsonMarshal := g.jsonMarshal
+	requestID := g.requestIDFor(r)
+	if requestID != "" {
+		r = r.WithContext(withRequestID(r.Context(), requestID))
+		w.Header().Set(g.requestIDHeader, requestID)
+		jsonMarshal = func(response interface{}) ([]byte, error) {
+			addRequestIDToErrors(response, requestID)
+			return g.jsonMarshal(response)
+		}
+	}
+
+	// clients that understand the GraphQL over HTTP media type get stricter status codes
+	mediaType := negotiateResponseMediaType(r)
+
+	// the gateway doesn't support subscriptions so there's nothing to upgrade the connection to
+	if isWebSocketUpgrade(r) {
+		response := g.encodeErrorResponse(r, jsonMarshal, formatErrorsWithCode(nil, errors.New("subscriptions are not enabled on this gateway, websocket connections are not supported"), "BAD_REQUEST"))
+		emitResponseAs(w, mediaType, http.StatusBadRequest, response)
+		return
+	}
+
+	// if the gateway is already serving as many requests as it can, this one has to wait its turn or go away
+	if g.requestSlots != nil {
+		if err := g.acquireRequestSlot(r.Context()); err != nil {
+			response := g.encodeErrorResponse(r, jsonMarshal, formatErrorsWithCode(nil, err, "UNAVAILABLE"))
+			setRetryAfter(w, err.RetryAfter)
+			emitResponseAs(w, mediaType, http.StatusServiceUnavailable, response)
+			return
+		}
+		defer g.releaseRequestSlot()
+	}
+
+	// make sure we don't read more of the body than we are willing to hold onto
+	limit := g.requestBodyLimit(r)
+	var body *countingReadCloser
+	if limit > 0 && r.Body != nil {
+		body = &countingReadCloser{ReadCloser: r.Body}
+		r.Body = http.MaxBytesReader(w, body, limit)
+	}
+
+	operations, batchMode, parseStatusCode, payloadErr := g.parseRequest(r)
+	defer closeUploads(operations)
+
+	// if the body was bigger than we allow then the error is not the client's payload
+	if payloadErr != nil && body != nil && body.count > limit {
+		payloadErr = fmt.Errorf("request body exceeds the maximum size of %d bytes", limit)
+		parseStatusCode = http.StatusRequestEntityTooLarge
+	}
 
 	// if there was an error retrieving the payload
 	if payloadErr != nil {
-		response := formatErrors(payloadErr)
-		w.WriteHeader(parseStatusCode)
-		err := json.NewEncoder(w).Encode(response)
-		if err != nil {
-			g.logger.Warn("Failed to encode error response:", err.Error())
-		}
+		response := g.encodeErrorResponse(r, jsonMarshal, formatErrors(payloadErr))
+		emitResponseAs(w, mediaType, parseStatusCode, response+"\n")
+		return
+	}
+
+	// if we were given more operations than we are willing to handle in a single request
+	if batchMode && g.maxBatchSize > 0 && len(operations) > g.maxBatchSize {
+		response := g.encodeErrorResponse(r, jsonMarshal, formatErrorsWithCode(nil, fmt.Errorf("batch contains %d operations, the maximum is %d", len(operations), g.maxBatchSize), "BAD_USER_INPUT"))
+		emitResponseAs(w, mediaType, http.StatusUnprocessableEntity, response)
 		return
 	}
 
@@ -75,6 +190,9 @@ func (g *Gateway) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
 	// the status code to report
 	statusCode := http.StatusOK
 
+	// the entries of the lists marked with @stream that the client asked to receive after the initial payload
+	var streamedItems <-chan *StreamedItem
+
 	for _, operation := range operations {
 		// there might be a query plan cache key embedded in the operation
 		cacheKey := ""
@@ -94,32 +212,82 @@ func (g *Gateway) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
 
 		// this might get mutated by the query plan cache so we have to pull it out
 		requestContext := &RequestContext{
-			Context:       r.Context(),
-			Query:         operation.Query,
-			OperationName: operation.OperationName,
-			Variables:     operation.Variables,
-			CacheKey:      cacheKey,
+			Context:        r.Context(),
+			Query:          operation.Query,
+			OperationName:  operation.OperationName,
+			Variables:      operation.Variables,
+			CacheKey:       cacheKey,
+			Extensions:     operation.Extensions.Values,
+			Request:        r,
+			ResponseWriter: w,
+			SkipPlanCache:  g.skipPlanCache(r),
+			// only a client that can handle a multipart response gets the entries on their own
+			Stream: !batchMode && acceptsIncrementalDelivery(r),
+		}
+		if g.requestLocationPriorities != nil {
+			requestContext.LocationPriorities = g.requestLocationPriorities(r)
 		}
 
 		// Get the plan, and return a 400 if we can't get the plan
 		plan, err := g.GetPlans(requestContext)
+		if err != nil && batchMode {
+			// one bad operation in a batch should not prevent the others from executing
+			if planStatusCode := g.planningErrorStatus(mediaType); planStatusCode != http.StatusOK {
+				statusCode = planStatusCode
+			}
+			results = append(results, formatErrorsWithCode(nil, err, "GRAPHQL_VALIDATION_FAILED"))
+			continue
+		}
 		if err != nil {
-			response, err := json.Marshal(formatErrorsWithCode(nil, err, "GRAPHQL_VALIDATION_FAILED"))
-			if err != nil {
-				// if we couldn't serialize the response then we're in internal error territory
-				response, err = json.Marshal(formatErrors(err))
-				if err != nil {
-					response, _ = json.Marshal(formatErrors(err))
-				}
+			response := g.encodeErrorResponse(r, jsonMarshal, formatErrorsWithCode(nil, err, "GRAPHQL_VALIDATION_FAILED"))
+			emitResponseAs(w, mediaType, g.planningErrorStatus(mediaType), response)
+			return
+		}
+
+		// make sure the client is allowed to run the operation
+		if err := g.rateLimitOperation(w, requestContext, plan); err != nil {
+			if batchMode {
+				statusCode = http.StatusTooManyRequests
+				results = append(results, formatErrorsWithCode(nil, err, "RATE_LIMITED"))
+				continue
 			}
-			emitResponse(w, http.StatusBadRequest, string(response))
+			response := g.encodeErrorResponse(r, jsonMarshal, formatErrorsWithCode(nil, err, "RATE_LIMITED"))
+			emitResponseAs(w, mediaType, http.StatusTooManyRequests, response)
 			return
 		}
 
 		// fire the query with the request context passed through to execution
 		result, err := g.Execute(requestContext, plan)
+		streamedItems = requestContext.StreamedItems
+		if err != nil && mediaType == mediaTypeGraphQLResponse && isRequestError(result, err) {
+			// the operation never ran so there is no data to report
+			statusCode = http.StatusBadRequest
+			payload := formatErrorsWithCode(nil, err, "BAD_USER_INPUT")
+			delete(payload, "data")
+			results = append(results, payload)
+			continue
+		}
 		if err != nil {
-			results = append(results, formatErrorsWithCode(result, err, "INTERNAL_SERVER_ERROR"))
+			// a middleware that rejected the operation decides what the client sees
+			if abort := findMiddlewareAbort(err); abort != nil {
+				if abort.StatusCode != 0 {
+					statusCode = abort.StatusCode
+				}
+				results = append(results, formatErrorsWithCode(nil, graphql.ErrorList{graphql.NewError(abort.Code, abort.Message)}, abort.Code))
+				continue
+			}
+
+			// if the services are too busy, let the client know when to try again
+			if backpressure := findBackpressureError(err); backpressure != nil {
+				setRetryAfter(w, backpressure.RetryAfter)
+				statusCode = http.StatusServiceUnavailable
+			}
+
+			payload := formatErrorsWithCode(result, err, "INTERNAL_SERVER_ERROR")
+			if len(requestContext.ResponseExtensions) > 0 {
+				payload["extensions"] = requestContext.ResponseExtensions
+			}
+			results = append(results, payload)
 
 			continue
 		}
@@ -127,21 +295,35 @@ func (g *Gateway) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
 		// the result for this operation
 		payload := map[string]interface{}{"data": result}
 
+		// the extensions for the response start with the ones from the services
+		extensions := map[string]interface{}{}
+		for key, value := range requestContext.ResponseExtensions {
+			extensions[key] = value
+		}
+
 		// if there was a cache key associated with this query
 		if requestContext.CacheKey != "" {
 			// embed the cache key in the response
-			payload["extensions"] = map[string]interface{}{
-				"persistedQuery": map[string]interface{}{
-					"sha265Hash": requestContext.CacheKey,
-					"version":    "1",
-				},
+			extensions["persistedQuery"] = map[string]interface{}{
+				"sha265Hash": requestContext.CacheKey,
+				"version":    "1",
 			}
 		}
 
+		if len(extensions) > 0 {
+			payload["extensions"] = extensions
+		}
+
 		// add this result to the list
 		results = append(results, payload)
 	}
 
+	// if there are parts of the response still to send then we have to respond in pieces
+	if streamedItems != nil {
+		emitIncrementalResponse(w, jsonMarshal, results[0], streamedItems)
+		return
+	}
+
 	// the final result depends on whether we are executing in batch mode or not
 	var finalResponse interface{}
 	if batchMode {
@@ -151,31 +333,97 @@ func (g *Gateway) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// serialized the response
-	response, err := json.Marshal(finalResponse)
+	var response []byte
+	var err error
+	if g.maxResponseBytes > 0 {
+		// the errors are encoded one at a time so they have to be tagged up front
+		if requestID != "" {
+			addRequestIDToErrors(finalResponse, requestID)
+		}
+
+		// stop encoding as soon as the response is bigger than we are willing to send
+		buffer := &limitedBuffer{limit: g.maxResponseBytes}
+		err = encodeResponse(buffer, jsonMarshal, finalResponse)
+		if err == errResponseTooLarge {
+			err = graphql.ErrorList{graphql.NewError("RESPONSE_TOO_LARGE", fmt.Sprintf("the response is larger than the limit of %d bytes", g.maxResponseBytes))}
+		}
+		response = buffer.Bytes()
+	} else {
+		response, err = jsonMarshal(finalResponse)
+	}
 	if err != nil {
 		// if we couldn't serialize the response then we're in internal error territory
 		statusCode = http.StatusInternalServerError
-		response, err = json.Marshal(formatErrors(err))
-		if err != nil {
-			response, _ = json.Marshal(formatErrors(err))
-		}
+		response = []byte(g.encodeErrorResponse(r, jsonMarshal, formatErrors(err)))
 	}
 
 	// send the result to the user
-	emitResponse(w, statusCode, string(response))
+	emitResponseAs(w, mediaType, statusCode, string(response))
+}
+
+// WithAlwaysOK returns an Option that responds with a 200 when an operation can't be planned, for example
+// because it doesn't pass validation, with the errors in the body like any other GraphQL error. Some
+// clients don't look at the body of a 400. Clients that ask for the application/graphql-response+json
+// media type still get a 400, as the GraphQL over HTTP spec requires.
+func WithAlwaysOK(alwaysOK bool) Option {
+	return func(g *Gateway) {
+		g.alwaysOK = alwaysOK
+	}
+}
+
+// WithMaxResponseBytes returns an Option that limits the size of the body of the responses of the GraphQLHandler,
+// for example to catch an unbounded list before it reaches the client. A response that would be larger is
+// replaced with an error. The response is encoded one value at a time when there is a limit so the gateway
+// stops as soon as it goes over, and only the values that aren't objects or lists go through the JSON codec.
+// Incremental responses are not limited. A value of 0 (the default) does not limit the response size.
+func WithMaxResponseBytes(n int64) Option {
+	return func(g *Gateway) {
+		g.maxResponseBytes = n
+	}
+}
+
+// SchemaHashHeader is the header of the responses of the GraphQLHandler that holds the hex encoded sha256
+// of the composed schema, when the gateway is built WithResponseInfoHeaders
+const SchemaHashHeader = "X-Gateway-Schema-Hash"
+
+// VersionHeader is the header of the responses of the GraphQLHandler that holds the version of the
+// gateway module, when the gateway is built WithResponseInfoHeaders
+const VersionHeader = "X-Gateway-Version"
+
+// WithResponseInfoHeaders returns an Option that adds the SchemaHashHeader and the VersionHeader to every
+// response of the GraphQLHandler. Clients and CDNs can use the hash to tell when the schema changed, for
+// example to drop the persisted queries they hold onto. The version is "(devel)" if the binary doesn't know it.
+func WithResponseInfoHeaders(enabled bool) Option {
+	return func(g *Gateway) {
+		g.responseInfoHeaders = enabled
+	}
+}
+
+// setResponseInfoHeaders adds the headers that describe the gateway to a response
+func (g *Gateway) setResponseInfoHeaders(w http.ResponseWriter) {
+	w.Header().Set(SchemaHashHeader, g.schemaHash)
+	w.Header().Set(VersionHeader, g.version)
+}
+
+// planningErrorStatus returns the status code of the response to an operation that couldn't be planned
+func (g *Gateway) planningErrorStatus(mediaType string) int {
+	if g.alwaysOK && mediaType == mediaTypeJSON {
+		return http.StatusOK
+	}
+	return http.StatusBadRequest
 }
 
 // Parses request to operations (single or batch mode).
 // Returns an error and an error status code if the request is invalid.
-func parseRequest(r *http.Request) (operations []*HTTPOperation, batchMode bool, errStatusCode int, payloadErr error) {
+func (g *Gateway) parseRequest(r *http.Request) (operations []*HTTPOperation, batchMode bool, errStatusCode int, payloadErr error) {
 	// this handler can handle multiple operations sent in the same query. Internally,
 	// it models a single operation as a list of one.
 	operations = []*HTTPOperation{}
 	switch r.Method {
 	case http.MethodGet:
-		operations, payloadErr = parseGetRequest(r)
+		operations, payloadErr = g.parseGetRequest(r)
 	case http.MethodPost:
-		operations, batchMode, payloadErr = parsePostRequest(r)
+		operations, batchMode, payloadErr = g.parsePostRequest(r)
 	default:
 		errStatusCode = http.StatusMethodNotAllowed
 		payloadErr = errors.New(http.StatusText(http.StatusMethodNotAllowed))
@@ -187,7 +435,7 @@ func parseRequest(r *http.Request) (operations []*HTTPOperation, batchMode bool,
 }
 
 // Parses get request to list of operations
-func parseGetRequest(r *http.Request) (operations []*HTTPOperation, payloadErr error) {
+func (g *Gateway) parseGetRequest(r *http.Request) (operations []*HTTPOperation, payloadErr error) {
 	parameters := r.URL.Query()
 
 	// the operation we have to perform
@@ -204,7 +452,7 @@ func parseGetRequest(r *http.Request) (operations []*HTTPOperation, payloadErr e
 	if variableInput, ok := parameters["variables"]; ok {
 		variables := map[string]interface{}{}
 
-		err := json.Unmarshal([]byte(variableInput[0]), &variables)
+		err := g.jsonUnmarshal([]byte(variableInput[0]), &variables)
 		if err != nil {
 			payloadErr = errors.New("variables must be a json object")
 		}
@@ -221,7 +469,9 @@ func parseGetRequest(r *http.Request) (operations []*HTTPOperation, payloadErr e
 	// if the request defined any extensions
 	if extensionString, hasExtensions := parameters["extensions"]; hasExtensions {
 		// copy the extension information into the operation
-		if err := json.NewDecoder(strings.NewReader(extensionString[0])).Decode(&operation.Extensions); err != nil {
+		if err := g.jsonUnmarshal([]byte(extensionString[0]), &operation.Extensions); err != nil {
+			payloadErr = err
+		} else if err := g.decodeExtensions(&operation.Extensions); err != nil {
 			payloadErr = err
 		}
 	}
@@ -231,7 +481,7 @@ func parseGetRequest(r *http.Request) (operations []*HTTPOperation, payloadErr e
 }
 
 // Parses post request (plain or multipart) to list of operations
-func parsePostRequest(r *http.Request) (operations []*HTTPOperation, batchMode bool, payloadErr error) {
+func (g *Gateway) parsePostRequest(r *http.Request) (operations []*HTTPOperation, batchMode bool, payloadErr error) {
 	contentTypes := strings.Split(r.Header.Get("Content-Type"), ";")
 	if len(contentTypes) == 0 {
 		return nil, false, errors.New("no content-type specified")
@@ -245,58 +495,203 @@ func parsePostRequest(r *http.Request) (operations []*HTTPOperation, batchMode b
 			payloadErr = fmt.Errorf("encountered error reading body: %w", err)
 			return
 		}
-		return parseOperations(operationsJSON)
+		return g.parseOperations(operationsJSON)
 	case "multipart/form-data":
+		return g.parseMultipartRequest(r)
+	default:
+		payloadErr = errors.New("unknown content-type: " + contentType)
+		return
+	}
+}
 
-		const maxPartSize = 32 << 20 // 32 Mebibytes
-		parseErr := r.ParseMultipartForm(maxPartSize)
-		if parseErr != nil {
-			payloadErr = errors.New("error parse multipart request: " + parseErr.Error())
-			return
-		}
+// parseMultipartRequest reads the parts of a multipart request one at a time so the limits on
+// the request are enforced before the files behind them are read
+func (g *Gateway) parseMultipartRequest(r *http.Request) ([]*HTTPOperation, bool, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, false, errors.New("error parse multipart request: " + err.Error())
+	}
 
-		operationsJSON := []byte(r.Form.Get("operations"))
-		operations, batchMode, payloadErr = parseOperations(operationsJSON)
+	// there's no point in holding more in memory than the body is allowed to contain
+	memory := int64(32 << 20) // 32 Mebibytes
+	if limit := g.requestBodyLimit(r); limit > 0 && limit < memory {
+		memory = limit
+	}
 
-		var filePosMap map[string][]string
-		if err := json.Unmarshal([]byte(r.Form.Get("map")), &filePosMap); err != nil {
-			payloadErr = errors.New("error parsing file map " + err.Error())
-			return
+	var operations []*HTTPOperation
+	var batchMode bool
+	var filePosMap map[string][]string
+	received := map[string]bool{}
+	files := 0
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return operations, batchMode, errors.New("error parse multipart request: " + err.Error())
 		}
 
-		for filePos, paths := range filePosMap {
-			file, header, err := r.FormFile(filePos)
+		// operations and map are the only fields we care about
+		if part.FileName() == "" {
+			value, err := readMultipartField(part, &memory)
 			if err != nil {
-				payloadErr = errors.New("file with index not found: " + filePos)
-				return
+				return operations, batchMode, errors.New("error parse multipart request: " + err.Error())
 			}
 
-			fileMeta := graphql.Upload{
-				File:     file,
-				FileName: header.Filename,
+			switch part.FormName() {
+			case "operations":
+				operations, batchMode, err = g.parseOperations(value)
+				if err != nil {
+					return operations, batchMode, err
+				}
+				if batchMode && g.maxBatchSize > 0 && len(operations) > g.maxBatchSize {
+					return operations, batchMode, graphql.ErrorList{graphql.NewError("BAD_USER_INPUT", fmt.Sprintf("batch contains %d operations, the maximum is %d", len(operations), g.maxBatchSize))}
+				}
+			case "map":
+				if err := g.jsonUnmarshal(value, &filePosMap); err != nil {
+					return operations, batchMode, errors.New("error parsing file map " + err.Error())
+				}
+				if g.maxUploadFiles > 0 && len(filePosMap) > g.maxUploadFiles {
+					return operations, batchMode, g.tooManyUploadsError(len(filePosMap))
+				}
 			}
+			continue
+		}
 
-			if err := injectFile(operations, fileMeta, paths, batchMode); err != nil {
-				payloadErr = err
-				return
-			}
+		// every file counts against the limit, even the ones that aren't in the map and never get read
+		files++
+		if g.maxUploadFiles > 0 && files > g.maxUploadFiles {
+			return operations, batchMode, g.tooManyUploadsError(files)
+		}
+
+		// the spec puts the files last so they can be handed over as soon as they are read
+		if operations == nil || filePosMap == nil {
+			return operations, batchMode, errors.New("multipart request has a file before its operations and map")
+		}
+
+		paths, ok := filePosMap[part.FormName()]
+		if !ok || received[part.FormName()] {
+			continue
+		}
+		received[part.FormName()] = true
+
+		file, err := spoolUpload(part, &memory)
+		if err != nil {
+			return operations, batchMode, errors.New("error parse multipart request: " + err.Error())
+		}
+		if err := injectFile(operations, graphql.Upload{File: file, FileName: part.FileName()}, paths, batchMode); err != nil {
+			_ = file.Close()
+			return operations, batchMode, err
+		}
+	}
+
+	if operations == nil {
+		return g.parseOperations(nil)
+	}
+	if filePosMap == nil {
+		return operations, batchMode, errors.New("multipart request is missing the file map")
+	}
+	for filePos := range filePosMap {
+		if !received[filePos] {
+			return operations, batchMode, errors.New("file with index not found: " + filePos)
+		}
+	}
+	return operations, batchMode, nil
+}
+
+func (g *Gateway) tooManyUploadsError(files int) error {
+	return graphql.ErrorList{graphql.NewError("BAD_USER_INPUT", fmt.Sprintf("request contains %d files, the maximum is %d", files, g.maxUploadFiles))}
+}
+
+// readMultipartField reads a form field out of a multipart request as long as it fits in the memory that's left
+func readMultipartField(part io.Reader, memory *int64) ([]byte, error) {
+	value, err := io.ReadAll(io.LimitReader(part, *memory+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(value)) > *memory {
+		return nil, errors.New("multipart fields are too large")
+	}
+	*memory -= int64(len(value))
+	return value, nil
+}
+
+// spoolUpload holds onto a file from a multipart request so the rest of the request can be read.
+// Files are kept in memory while there's room and go to a temporary file once there isn't.
+func spoolUpload(part io.Reader, memory *int64) (graphql.File, error) {
+	var buf bytes.Buffer
+	size, err := io.Copy(&buf, io.LimitReader(part, *memory+1))
+	if err != nil {
+		return nil, err
+	}
+	if size <= *memory {
+		*memory -= size
+		return io.NopCloser(&buf), nil
+	}
+
+	file, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &spooledFile{File: file}
+	if _, err := io.Copy(file, io.MultiReader(&buf, part)); err != nil {
+		_ = spooled.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		_ = spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+// spooledFile is an upload that didn't fit in memory, it's removed from disk once it's closed
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	closeErr := f.File.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// closeUploads lets go of the files a multipart request handed to its operations
+func closeUploads(operations []*HTTPOperation) {
+	for _, operation := range operations {
+		if operation != nil {
+			closeUploadsIn(operation.Variables)
+		}
+	}
+}
+
+func closeUploadsIn(value interface{}) {
+	switch value := value.(type) {
+	case graphql.Upload:
+		_ = value.File.Close()
+	case map[string]interface{}:
+		for _, item := range value {
+			closeUploadsIn(item)
+		}
+	case []interface{}:
+		for _, item := range value {
+			closeUploadsIn(item)
 		}
-		return
-	default:
-		payloadErr = errors.New("unknown content-type: " + contentType)
-		return
 	}
 }
 
 // Parses json operations string
-func parseOperations(operationsJSON []byte) (operations []*HTTPOperation, batchMode bool, payloadErr error) {
+func (g *Gateway) parseOperations(operationsJSON []byte) (operations []*HTTPOperation, batchMode bool, payloadErr error) {
 	// there are two possible options for receiving information from a post request
 	// the first is that the user provides an object in the form of { query, variables, operationName }
 	// the second option is a list of that object
 
 	singleQuery := &HTTPOperation{}
 	// if we were given a single object
-	if err := json.Unmarshal(operationsJSON, &singleQuery); err == nil {
+	if err := g.jsonUnmarshal(operationsJSON, &singleQuery); err == nil {
 		// add it to the list of operations
 		operations = append(operations, singleQuery)
 		// we weren't given an object
@@ -304,7 +699,7 @@ func parseOperations(operationsJSON []byte) (operations []*HTTPOperation, batchM
 		// but we could have been given a list
 		batch := []*HTTPOperation{}
 
-		if err = json.Unmarshal(operationsJSON, &batch); err != nil {
+		if err = g.jsonUnmarshal(operationsJSON, &batch); err != nil {
 			payloadErr = fmt.Errorf("encountered error parsing operationsJSON: %w", err)
 		} else {
 			operations = batch
@@ -314,6 +709,16 @@ func parseOperations(operationsJSON []byte) (operations []*HTTPOperation, batchM
 		batchMode = true
 	}
 
+	// the extensions are read with the same codec as the rest of the operation
+	for _, operation := range operations {
+		if operation == nil || payloadErr != nil {
+			continue
+		}
+		if err := g.decodeExtensions(&operation.Extensions); err != nil {
+			payloadErr = fmt.Errorf("encountered error parsing extensions: %w", err)
+		}
+	}
+
 	return operations, batchMode, payloadErr
 }
 
@@ -388,12 +793,294 @@ func injectFile(operations []*HTTPOperation, file graphql.Upload, paths []string
 	return nil
 }
 
+// defaultMaxRequestBodySize is the largest JSON body the GraphQLHandler accepts unless told otherwise
+const defaultMaxRequestBodySize = 1 << 20 // 1 Mebibyte
+
+// requestBodyLimit returns the number of bytes the body of the request can contain, or 0 if there is no limit
+func (g *Gateway) requestBodyLimit(r *http.Request) int64 {
+	// if the user told us what to do, there's nothing to decide
+	if g.maxRequestBodySize != 0 {
+		return max64(g.maxRequestBodySize, 0)
+	}
+
+	// by default, uploads are left alone
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return 0
+	}
+	return defaultMaxRequestBodySize
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// countingReadCloser keeps track of the number of bytes read from the underlying reader
+type countingReadCloser struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// errResponseTooLarge is returned by a limitedBuffer that was asked to hold more than its limit
+var errResponseTooLarge = errors.New("response is too large")
+
+// limitedBuffer holds onto the bytes written to it as long as there are no more than its limit
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.limit {
+		return 0, errResponseTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// encodeResponse writes the JSON encoding of the response to w one value at a time so that a writer that
+// refuses more bytes stops the encoding there. Objects and lists are written here, with the keys of objects
+// sorted like encoding/json does, and every other value is encoded with marshal.
+func encodeResponse(w io.Writer, marshal func(interface{}) ([]byte, error), response interface{}) error {
+	write := func(value []byte) error {
+		_, err := w.Write(value)
+		return err
+	}
+
+	switch response := response.(type) {
+	case map[string]interface{}:
+		if response == nil {
+			return write([]byte("null"))
+		}
+		keys := make([]string, 0, len(response))
+		for key := range response {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if err := write([]byte("{")); err != nil {
+			return err
+		}
+		for i, key := range keys {
+			if i > 0 {
+				if err := write([]byte(",")); err != nil {
+					return err
+				}
+			}
+			encodedKey, err := marshal(key)
+			if err != nil {
+				return err
+			}
+			if err := write(append(encodedKey, ':')); err != nil {
+				return err
+			}
+			if err := encodeResponse(w, marshal, response[key]); err != nil {
+				return err
+			}
+		}
+		return write([]byte("}"))
+	case []interface{}:
+		if response == nil {
+			return write([]byte("null"))
+		}
+		if err := write([]byte("[")); err != nil {
+			return err
+		}
+		for i, entry := range response {
+			if i > 0 {
+				if err := write([]byte(",")); err != nil {
+					return err
+				}
+			}
+			if err := encodeResponse(w, marshal, entry); err != nil {
+				return err
+			}
+		}
+		return write([]byte("]"))
+	case []map[string]interface{}:
+		// the payloads of a batch
+		entries := make([]interface{}, len(response))
+		for i, payload := range response {
+			entries[i] = payload
+		}
+		if response == nil {
+			entries = nil
+		}
+		return encodeResponse(w, marshal, entries)
+	}
+
+	value, err := marshal(response)
+	if err != nil {
+		return err
+	}
+	return write(value)
+}
+
+// NoPlanCacheHeader is the header that a request sent to the GraphQLHandler can set to true to have its
+// operations planned without using the query plan cache, for example while debugging the planner. It is
+// ignored unless the gateway was built WithPlanCacheBypass and allows the request.
+const NoPlanCacheHeader = "X-Gateway-No-Cache"
+
+// skipPlanCache returns true if the request asked to skip the query plan cache and is allowed to
+func (g *Gateway) skipPlanCache(r *http.Request) bool {
+	if g.planCacheBypass == nil || !strings.EqualFold(r.Header.Get(NoPlanCacheHeader), "true") {
+		return false
+	}
+	return g.planCacheBypass(r)
+}
+
+// unencodableErrorResponse is sent in place of an error response that the JSON codec couldn't encode
+const unencodableErrorResponse = `{"data":null,"errors":[{"message":"could not encode the error response","extensions":{"code":"INTERNAL_SERVER_ERROR"}}]}`
+
+// encodeErrorResponse encodes a response that reports an error, or returns unencodableErrorResponse if the codec fails
+func (g *Gateway) encodeErrorResponse(r *http.Request, jsonMarshal func(interface{}) ([]byte, error), response map[string]interface{}) string {
+	encoded, err := jsonMarshal(response)
+	if err != nil {
+		g.requestLogger(r.Context()).Warn("Failed to encode error response:", err.Error())
+		return unencodableErrorResponse
+	}
+	return string(encoded)
+}
+
 func emitResponse(w http.ResponseWriter, code int, response string) {
-	w.Header().Set("Content-Type", "application/json")
+	emitResponseAs(w, mediaTypeJSON, code, response)
+}
+
+func emitResponseAs(w http.ResponseWriter, mediaType string, code int, response string) {
+	// the body is always encoded as utf-8 so we say so instead of leaving clients to guess
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
 	w.WriteHeader(code)
 	fmt.Fprint(w, response)
 }
 
+const (
+	// mediaTypeJSON is the media type that every client understands. Errors in the operation are
+	// reported with a 200 unless the operation couldn't be planned.
+	mediaTypeJSON = "application/json"
+	// mediaTypeGraphQLResponse is the media type defined by the GraphQL over HTTP spec. Clients that
+	// ask for it also get a 400 when the operation couldn't be run because of its input.
+	mediaTypeGraphQLResponse = "application/graphql-response+json"
+)
+
+// isWebSocketUpgrade returns true if the request is trying to open a websocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	for _, protocol := range strings.Split(r.Header.Get("Upgrade"), ",") {
+		if strings.EqualFold(strings.TrimSpace(protocol), "websocket") {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateResponseMediaType picks the media type of the response based on the Accept header of the request.
+// The media type the client prefers the most wins, with ties going to the one that comes first.
+func negotiateResponseMediaType(r *http.Request) string {
+	mediaType := mediaTypeJSON
+	bestQuality := -1.0
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		parts := strings.Split(accepted, ";")
+
+		// figure out how much the client wants the media type
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality <= bestQuality || quality == 0 {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case mediaTypeGraphQLResponse:
+			mediaType, bestQuality = mediaTypeGraphQLResponse, quality
+		case mediaTypeJSON, "application/*", "*/*":
+			mediaType, bestQuality = mediaTypeJSON, quality
+		}
+	}
+
+	return mediaType
+}
+
+// isRequestError returns true if the error from executing an operation means that it was never run,
+// ie. when its variables were rejected
+func isRequestError(result map[string]interface{}, err error) bool {
+	if result != nil {
+		return false
+	}
+
+	var errList graphql.ErrorList
+	if !errors.As(err, &errList) || len(errList) == 0 {
+		return false
+	}
+	for _, listErr := range errList {
+		var gqlErr *graphql.Error
+		if !errors.As(listErr, &gqlErr) || gqlErr.Extensions["code"] != "BAD_USER_INPUT" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IntrospectionHandler responds to GET requests with the result of the standard introspection query over
+// the gateway's schema, for tools like graphql-codegen that want the JSON instead of the SDL. It's not
+// available when introspection is disabled.
+func (g *Gateway) IntrospectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if g.withoutIntrospection {
+		http.NotFound(w, r)
+		return
+	}
+
+	// run the query through the same queryer that resolves the introspection fields of every other request
+	result := map[string]interface{}{}
+	err := g.Query(r.Context(), &graphql.QueryInput{Query: introspection.Query}, &result)
+	if err != nil {
+		emitResponse(w, http.StatusInternalServerError, g.encodeErrorResponse(r, g.jsonMarshal, formatErrors(err)))
+		return
+	}
+
+	response, err := g.jsonMarshal(result)
+	if err != nil {
+		g.requestLogger(r.Context()).Warn("Failed to encode introspection response:", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	emitResponse(w, http.StatusOK, string(response))
+}
+
+// ServicesHandler responds to GET requests with the services of the gateway and the fields sent to each of
+// them (see Services), for admin or debug endpoints.
+func (g *Gateway) ServicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	response, err := g.jsonMarshal(g.Services())
+	if err != nil {
+		g.requestLogger(r.Context()).Warn("Failed to encode services response:", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	emitResponse(w, http.StatusOK, string(response))
+}
+
 // PlaygroundHandler returns a combined UI and API http.HandlerFunc.
 // On POST requests, executes the designated query.
 // On all other requests, shows the user an interface that they can use to interact with the API.
@@ -404,8 +1091,15 @@ func (g *Gateway) PlaygroundHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// if there is no playground, there's nothing to show
+	if g.playgroundUI == PlaygroundUIDisabled {
+		http.NotFound(w, r)
+		return
+	}
+
 	// we are not handling a POST request so we have to show the user the playground
-	err := writePlayground(w, PlaygroundConfig{
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := writePlayground(w, g.playgroundUI, PlaygroundConfig{
 		Endpoint: r.URL.String(),
 	})
 	if err != nil {
@@ -420,7 +1114,12 @@ func (g *Gateway) StaticPlaygroundHandler(config PlaygroundConfig) http.Handler
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		err := writePlayground(w, config)
+		if g.playgroundUI == PlaygroundUIDisabled {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := writePlayground(w, g.playgroundUI, config)
 		if err != nil {
 			g.logger.Warn("failed writing playground UI:", err.Error())
 		}
@@ -13,12 +13,15 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"golang.org/x/net/html"
 
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vektah/gqlparser/v2/ast"
 )
 
@@ -613,6 +616,58 @@ func TestPlaygroundHandler_getRequest(t *testing.T) {
 	}
 }
 
+func TestPlaygroundHandler_ui(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+	`)
+	require.NoError(t, err)
+	executor := WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+		return map[string]interface{}{"allUsers": []interface{}{}}, nil
+	}))
+
+	for _, tc := range []struct {
+		name       string
+		ui         PlaygroundUI
+		statusCode int
+		contains   string
+	}{
+		{name: "GraphQL Playground", ui: PlaygroundUIGraphQLPlayground, statusCode: http.StatusOK, contains: "GraphQLPlayground.init"},
+		{name: "GraphiQL", ui: PlaygroundUIGraphiQL, statusCode: http.StatusOK, contains: "GraphiQL.createFetcher"},
+		{name: "Apollo Sandbox", ui: PlaygroundUIApolloSandbox, statusCode: http.StatusOK, contains: "EmbeddedSandbox"},
+		{name: "Disabled", ui: PlaygroundUIDisabled, statusCode: http.StatusNotFound},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, executor, WithPlaygroundUI(tc.ui))
+			require.NoError(t, err)
+
+			for name, handler := range map[string]http.Handler{
+				"dynamic": http.HandlerFunc(gateway.PlaygroundHandler),
+				"static":  gateway.StaticPlaygroundHandler(PlaygroundConfig{Endpoint: "/graphql"}),
+			} {
+				responseRecorder := httptest.NewRecorder()
+				handler.ServeHTTP(responseRecorder, httptest.NewRequest(http.MethodGet, "/graphql", nil))
+
+				assert.Equal(t, tc.statusCode, responseRecorder.Code, name)
+				if tc.contains != "" {
+					assert.Equal(t, "text/html; charset=utf-8", responseRecorder.Header().Get("Content-Type"), name)
+					assert.Contains(t, responseRecorder.Body.String(), tc.contains, name)
+					assert.Contains(t, responseRecorder.Body.String(), `"/graphql"`, name)
+				}
+			}
+
+			// queries are still handled
+			responseRecorder := httptest.NewRecorder()
+			gateway.PlaygroundHandler(responseRecorder, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ allUsers }"}`)))
+			assert.Equal(t, http.StatusOK, responseRecorder.Code)
+		})
+	}
+}
+
 func TestGraphQLHandler_postWithFile(t *testing.T) {
 	t.Parallel()
 	schema, err := graphql.LoadSchema(`
@@ -971,6 +1026,162 @@ func TestGraphQLHandler_postBatchWithMultipleFiles(t *testing.T) {
 	assert.Equal(t, http.StatusOK, result.StatusCode)
 }
 
+func TestGraphQLHandler_multipartLimits(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		scalar Upload
+
+		type Query {
+			file(id: String!): String
+		}
+
+		type Mutation {
+			upload(file: Upload!): String!
+			uploadMulti(files: [Upload!]!): [String!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	multiQuery := `{"query": "mutation ($files: [Upload!]!) { uploadMulti(files: $files) }", "variables": {"files": [null, null, null]}}`
+	singleQuery := `{"query": "mutation ($file: Upload!) { upload(file: $file) }", "variables": {"file": null}}`
+
+	for _, tc := range []struct {
+		name       string
+		options    []Option
+		operations string
+		fileMap    string
+		files      int
+		status     int
+		err        string
+	}{
+		{
+			name:       "too many files",
+			options:    []Option{WithMaxUploadFiles(2)},
+			operations: multiQuery,
+			fileMap:    `{"0": ["variables.files.0"], "1": ["variables.files.1"], "2": ["variables.files.2"]}`,
+			files:      3,
+			status:     http.StatusUnprocessableEntity,
+			err:        "request contains 3 files, the maximum is 2",
+		},
+		{
+			name:       "too many unmapped files",
+			options:    []Option{WithMaxUploadFiles(2)},
+			operations: singleQuery,
+			fileMap:    `{"0": ["variables.file"]}`,
+			files:      3,
+			status:     http.StatusUnprocessableEntity,
+			err:        "request contains 3 files, the maximum is 2",
+		},
+		{
+			name:       "files at the limit",
+			options:    []Option{WithMaxUploadFiles(3)},
+			operations: multiQuery,
+			fileMap:    `{"0": ["variables.files.0"], "1": ["variables.files.1"], "2": ["variables.files.2"]}`,
+			files:      3,
+			status:     http.StatusOK,
+		},
+		{
+			name:       "batch too big",
+			options:    []Option{WithMaxBatchSize(1)},
+			operations: "[" + singleQuery + "," + singleQuery + "]",
+			fileMap:    `{"0": ["0.variables.file"], "1": ["1.variables.file"]}`,
+			files:      2,
+			status:     http.StatusUnprocessableEntity,
+			err:        "batch contains 2 operations, the maximum is 1",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			executed := false
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url-file-upload"}}, append(tc.options, WithExecutor(ExecutorFunc(
+				func(*ExecutionContext) (map[string]interface{}, error) {
+					executed = true
+					return map[string]interface{}{"uploadMulti": []string{"a", "b", "c"}}, nil
+				},
+			)))...)
+			require.NoError(t, err)
+
+			files := [][]byte{}
+			for i := 0; i < tc.files; i++ {
+				files = append(files, []byte(fmt.Sprintf("Test file content %d", i)))
+			}
+			request, err := createMultipartRequest([]byte(tc.operations), []byte(tc.fileMap), files...)
+			require.NoError(t, err)
+
+			responseRecorder := httptest.NewRecorder()
+			gateway.GraphQLHandler(responseRecorder, request)
+			assert.Equal(t, tc.status, responseRecorder.Code)
+			if tc.err == "" {
+				assert.True(t, executed)
+				return
+			}
+
+			assert.False(t, executed)
+			assert.JSONEq(t, fmt.Sprintf(`{"data": null, "errors": [{"message": %q, "extensions": {"code": "BAD_USER_INPUT"}}]}`, tc.err), responseRecorder.Body.String())
+		})
+	}
+}
+
+func TestGraphQLHandler_multipartLimitsStopReading(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		scalar Upload
+
+		type Query {
+			file(id: String!): String
+		}
+
+		type Mutation {
+			upload(file: Upload!): String!
+		}
+	`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url-file-upload"}}, WithMaxUploadFiles(1))
+	require.NoError(t, err)
+
+	// the body stops being readable right after the headers of the file over the limit
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	require.NoError(t, w.WriteField("operations", `{"query": "mutation ($file: Upload!) { upload(file: $file) }", "variables": {"file": null}}`))
+	require.NoError(t, w.WriteField("map", `{"0": ["variables.file"]}`))
+	for i := 0; i < 2; i++ {
+		fw, err := w.CreateFormFile(strconv.Itoa(i), fmt.Sprintf("file%d.txt", i))
+		require.NoError(t, err)
+		if i == 0 {
+			_, err = fw.Write([]byte("Test file content 0"))
+			require.NoError(t, err)
+		}
+	}
+	readable := body.Len()
+	_, err = body.Write([]byte("Test file content 1"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	rest := &readRecorder{Reader: bytes.NewReader(body.Bytes()[readable:])}
+	request := httptest.NewRequest(http.MethodPost, "/graphql", io.MultiReader(bytes.NewReader(body.Bytes()[:readable]), rest))
+	request.Header.Set("Content-Type", w.FormDataContentType())
+
+	responseRecorder := httptest.NewRecorder()
+	gateway.GraphQLHandler(responseRecorder, request)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Code)
+	assert.JSONEq(t, `{"data": null, "errors": [{"message": "request contains 2 files, the maximum is 1", "extensions": {"code": "BAD_USER_INPUT"}}]}`, responseRecorder.Body.String())
+	assert.False(t, rest.read, "the file over the limit was read")
+}
+
+// readRecorder remembers if anything tried to read from it
+type readRecorder struct {
+	io.Reader
+	read bool
+}
+
+func (r *readRecorder) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
 func TestGraphQLHandler_postFilesWithError(t *testing.T) {
 	t.Parallel()
 	schema, err := graphql.LoadSchema(`
@@ -1437,6 +1648,29 @@ func TestStaticPlaygroundHandler(t *testing.T) {
 		assert.Contains(t, responseRecorder.Body.String(), "some-url")
 	})
 
+	t.Run("custom UI", func(t *testing.T) {
+		t.Parallel()
+		request := httptest.NewRequest(http.MethodGet, "/graphql", strings.NewReader(""))
+		responseRecorder := httptest.NewRecorder()
+		gateway.StaticPlaygroundHandler(PlaygroundConfig{
+			Endpoint:             "some-url",
+			SubscriptionEndpoint: "ws://some-url",
+			Title:                "Users <API>",
+			Tabs: []PlaygroundTab{{
+				Name:      "All users",
+				Query:     "{ allUsers }",
+				Variables: `{"first": 10}`,
+				Headers:   map[string]string{"Authorization": "Bearer token"},
+			}},
+		}).ServeHTTP(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Code)
+		body := responseRecorder.Body.String()
+		assert.Contains(t, body, "<title>Users &lt;API&gt;</title>")
+		assert.Contains(t, body, `"subscriptionEndpoint":"ws://some-url"`)
+		assert.Contains(t, body, `"tabs":[{"endpoint":"some-url","name":"All users","query":"{ allUsers }","variables":"{\"first\": 10}","headers":{"Authorization":"Bearer token"}}]`)
+	})
+
 	t.Run("queries fail", func(t *testing.T) {
 		t.Parallel()
 		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`query { allUsers { firstName } }`))
@@ -1483,3 +1717,1745 @@ func TestGraphQLHandler_OptionsMethod(t *testing.T) {
   ]
 }`, response.Body.String())
 }
+
+func TestGraphQLHandler_postBatch(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type User {
+			id: ID!
+		}
+	`)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// a field to query
+	aField := &QueryField{
+		Name: "a",
+		Type: ast.NamedType("User", &ast.Position{}),
+		Resolver: func(ctx context.Context, arguments map[string]interface{}) (string, error) {
+			return "a", nil
+		},
+	}
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithQueryFields(aField), WithMaxBatchSize(2))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	t.Run("invalid operations are isolated", func(t *testing.T) {
+		t.Parallel()
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`
+			[
+				{ "query": "{ a { id } }" },
+				{ "query": "{ b { id } }" }
+			]
+		`))
+		responseRecorder := httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+
+		response := responseRecorder.Result()
+		defer response.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		result := []map[string]interface{}{}
+		if err := json.NewDecoder(response.Body).Decode(&result); !assert.NoError(t, err) {
+			return
+		}
+		if !assert.Len(t, result, 2) {
+			return
+		}
+
+		// the first operation should have been executed even though the second one was invalid
+		assert.Nil(t, result[0]["errors"])
+		assert.Equal(t, map[string]interface{}{"a": map[string]interface{}{"id": "a"}}, result[0]["data"])
+		assert.Nil(t, result[1]["data"])
+		assert.NotNil(t, result[1]["errors"])
+	})
+
+	t.Run("batch too large", func(t *testing.T) {
+		t.Parallel()
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`
+			[
+				{ "query": "{ a { id } }" },
+				{ "query": "{ a { id } }" },
+				{ "query": "{ a { id } }" }
+			]
+		`))
+		responseRecorder := httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Code)
+		result, err := readResultWithErrors(responseRecorder, t)
+		if !assert.NoError(t, err) || !assert.Len(t, result.Errors, 1) {
+			return
+		}
+		assert.Equal(t, "BAD_USER_INPUT", result.Errors[0].Extensions["code"])
+	})
+}
+
+func TestGraphQLHandler_responseMiddlewareHTTPAccess(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// a middleware that sets the cache policy based on the incoming request
+	cacheControl := ResponseMiddleware(func(ctx *ExecutionContext, response map[string]interface{}) error {
+		if ctx.Request == nil || ctx.ResponseWriter == nil {
+			return nil
+		}
+		if ctx.Request.Header.Get("X-Cacheable") != "" {
+			ctx.ResponseWriter.Header().Set("Cache-Control", "max-age=60")
+		}
+		return nil
+	})
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithMiddlewares(cacheControl), WithExecutor(ExecutorFunc(
+		func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		},
+	)))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ value }"}`))
+	request.Header.Set("X-Cacheable", "true")
+	responseRecorder := httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, "max-age=60", responseRecorder.Header().Get("Cache-Control"))
+
+	// the middleware should still work when the gateway is executed outside of an HTTP request
+	reqCtx := &RequestContext{Context: context.Background(), Query: "{ value }"}
+	plans, err := gw.GetPlans(reqCtx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	result, err := gw.Execute(reqCtx, plans)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"value": "hello"}, result)
+}
+
+func TestGraphQLHandler_stream(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			values: [String!]!
+		}
+	`)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithUpstreamQueryer("url1", graphql.QueryerFunc(
+		func(*graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"values": []interface{}{"a", "b", "c"}}, nil
+		},
+	)))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	body := `{"query": "{ values @stream(initialCount: 1) }"}`
+
+	t.Run("multipart", func(t *testing.T) {
+		t.Parallel()
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Accept", "multipart/mixed, application/json")
+		responseRecorder := httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Code)
+		assert.Equal(t, `multipart/mixed; boundary="-"`, responseRecorder.Header().Get("Content-Type"))
+
+		// pull the json out of each part of the response
+		parts := []map[string]interface{}{}
+		for _, chunk := range strings.Split(responseRecorder.Body.String(), "\r\n---") {
+			index := strings.Index(chunk, "\r\n\r\n")
+			if index < 0 {
+				continue
+			}
+			part := map[string]interface{}{}
+			if !assert.NoError(t, json.Unmarshal([]byte(chunk[index+4:]), &part)) {
+				return
+			}
+			parts = append(parts, part)
+		}
+
+		assert.Equal(t, []map[string]interface{}{
+			{
+				"data":    map[string]interface{}{"values": []interface{}{"a"}},
+				"hasNext": true,
+			},
+			{
+				"incremental": []interface{}{map[string]interface{}{
+					"items": []interface{}{"b"},
+					"path":  []interface{}{"values", float64(1)},
+				}},
+				"hasNext": true,
+			},
+			{
+				"incremental": []interface{}{map[string]interface{}{
+					"items": []interface{}{"c"},
+					"path":  []interface{}{"values", float64(2)},
+				}},
+				"hasNext": false,
+			},
+		}, parts)
+	})
+
+	t.Run("clients that cannot handle multipart get the whole list", func(t *testing.T) {
+		t.Parallel()
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		responseRecorder := httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Code)
+		assert.JSONEq(t, `{"data": {"values": ["a", "b", "c"]}}`, responseRecorder.Body.String())
+	})
+}
+
+func TestGraphQLHandler_jsonCodec(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			values: [String!]!
+		}
+	`)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// a codec that marks everything it writes so we can tell it was used
+	mark := func(payload map[string]interface{}) map[string]interface{} {
+		copied := map[string]interface{}{"codec": true}
+		for key, value := range payload {
+			copied[key] = value
+		}
+		return copied
+	}
+	marshal := func(v interface{}) ([]byte, error) {
+		switch payload := v.(type) {
+		case map[string]interface{}:
+			v = mark(payload)
+		case []map[string]interface{}:
+			marked := []map[string]interface{}{}
+			for _, entry := range payload {
+				marked = append(marked, mark(entry))
+			}
+			v = marked
+		}
+		return json.Marshal(v)
+	}
+	unmarshalCalls := 0
+	unmarshal := func(data []byte, v interface{}) error {
+		unmarshalCalls++
+		return json.Unmarshal(data, v)
+	}
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithJSONCodec(marshal, unmarshal), WithUpstreamQueryer("url1", graphql.QueryerFunc(
+		func(*graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"values": []interface{}{"a", "b"}}, nil
+		},
+	)))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// a single operation
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ values }"}`))
+	responseRecorder := httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.JSONEq(t, `{"codec": true, "data": {"values": ["a", "b"]}}`, responseRecorder.Body.String())
+
+	// a batch
+	request = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`[{"query": "{ values }"}]`))
+	responseRecorder = httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.JSONEq(t, `[{"codec": true, "data": {"values": ["a", "b"]}}]`, responseRecorder.Body.String())
+
+	// an incremental response
+	request = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ values @stream(initialCount: 1) }"}`))
+	request.Header.Set("Accept", "multipart/mixed")
+	responseRecorder = httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, 2, strings.Count(responseRecorder.Body.String(), `"codec":true`))
+
+	// every request body went through the codec (the batch is tried as a single object first)
+	assert.Equal(t, 4, unmarshalCalls)
+}
+
+func TestGraphQLHandler_unencodableErrorResponse(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		options []Option
+		body    string
+		header  http.Header
+		status  int
+	}{
+		{
+			name:   "bad payload",
+			body:   `not json`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "websocket",
+			body:   `{"query": "{ value }"}`,
+			header: http.Header{"Upgrade": []string{"websocket"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "planning error",
+			body:   `{"query": "{ missing }"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "rate limited",
+			options: []Option{WithRateLimiter(RateLimiterFunc(func(context.Context, string) (bool, time.Duration) {
+				return false, time.Second
+			}))},
+			body:   `{"query": "{ value }"}`,
+			status: http.StatusTooManyRequests,
+		},
+		{
+			name:   "response",
+			body:   `{"query": "{ value }"}`,
+			status: http.StatusInternalServerError,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			// a codec that can't encode anything
+			gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, append(tc.options, WithJSONCodec(
+				func(interface{}) ([]byte, error) {
+					return nil, errors.New("broken codec")
+				},
+				json.Unmarshal,
+			), WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+				return map[string]interface{}{"value": "hello"}, nil
+			})))...)
+			require.NoError(t, err)
+
+			// the client still finds out that something went wrong
+			request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tc.body))
+			for key, values := range tc.header {
+				request.Header[key] = values
+			}
+			responseRecorder := httptest.NewRecorder()
+			gw.GraphQLHandler(responseRecorder, request)
+			assert.Equal(t, tc.status, responseRecorder.Code)
+			assert.JSONEq(t, unencodableErrorResponse, responseRecorder.Body.String())
+		})
+	}
+}
+
+func TestGraphQLHandler_rateLimiter(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// only the operation named Allowed gets through
+	limiter := RateLimiterFunc(func(ctx context.Context, operationName string) (bool, time.Duration) {
+		return operationName == "Allowed", 1500 * time.Millisecond
+	})
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithRateLimiter(limiter), WithExecutor(ExecutorFunc(
+		func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		},
+	)))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for _, tc := range []struct {
+		name       string
+		body       string
+		statusCode int
+		retryAfter string
+		response   string
+	}{
+		{
+			name:       "allowed",
+			body:       `{"query": "query Allowed { value }"}`,
+			statusCode: http.StatusOK,
+			response:   `{"data": {"value": "hello"}}`,
+		},
+		{
+			name:       "denied",
+			body:       `{"query": "query Denied { value }"}`,
+			statusCode: http.StatusTooManyRequests,
+			retryAfter: "2",
+			response:   `{"data": null, "errors": [{"message": "too many requests for operation Denied", "extensions": {"code": "RATE_LIMITED"}}]}`,
+		},
+		{
+			name:       "selected by operation name",
+			body:       `{"query": "query Allowed { value } query Denied { value }", "operationName": "Denied"}`,
+			statusCode: http.StatusTooManyRequests,
+			retryAfter: "2",
+			response:   `{"data": null, "errors": [{"message": "too many requests for operation Denied", "extensions": {"code": "RATE_LIMITED"}}]}`,
+		},
+		{
+			name:       "batch",
+			body:       `[{"query": "query Allowed { value }"}, {"query": "query Denied { value }"}]`,
+			statusCode: http.StatusTooManyRequests,
+			retryAfter: "2",
+			response: `[
+				{"data": {"value": "hello"}},
+				{"data": null, "errors": [{"message": "too many requests for operation Denied", "extensions": {"code": "RATE_LIMITED"}}]}
+			]`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tc.body))
+			responseRecorder := httptest.NewRecorder()
+			gw.GraphQLHandler(responseRecorder, request)
+
+			assert.Equal(t, tc.statusCode, responseRecorder.Code)
+			assert.Equal(t, tc.retryAfter, responseRecorder.Header().Get("Retry-After"))
+			assert.JSONEq(t, tc.response, responseRecorder.Body.String())
+		})
+	}
+}
+
+func TestGraphQLHandler_backpressure(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// pretend the services are too busy to take the query
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithExecutor(ExecutorFunc(
+		func(*ExecutionContext) (map[string]interface{}, error) {
+			return nil, graphql.ErrorList{&BackpressureError{Message: "too busy", RetryAfter: 1500 * time.Millisecond}}
+		},
+	)))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ value }"}`))
+	responseRecorder := httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, responseRecorder.Code)
+	assert.Equal(t, "2", responseRecorder.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"data": null, "errors": [{"message": "too busy", "extensions": {"code": "UNAVAILABLE"}}]}`, responseRecorder.Body.String())
+}
+
+func TestGraphQLHandler_leafSelections(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type User {
+			name: String!
+		}
+
+		type Query {
+			user: User
+		}
+	`)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for _, tc := range []struct {
+		name       string
+		query      string
+		statusCode int
+	}{
+		{
+			name:       "object with selection",
+			query:      "{ user { name } }",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "object without selection",
+			query:      "{ user }",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "object with empty selection",
+			query:      "{ user { } }",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "scalar with selection",
+			query:      "{ user { name { length } } }",
+			statusCode: http.StatusBadRequest,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		// ignoring unknown directives validates the query on its own
+		for policyName, policy := range map[string]UnknownDirectivePolicy{
+			"reject unknown directives": UnknownDirectivesError,
+			"ignore unknown directives": UnknownDirectivesIgnore,
+		} {
+			policy := policy
+			t.Run(fmt.Sprintf("%s, %s", tc.name, policyName), func(t *testing.T) {
+				t.Parallel()
+				gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}},
+					WithUnknownDirectivePolicy(policy),
+					WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+						return map[string]interface{}{"user": map[string]interface{}{"name": "hello"}}, nil
+					})),
+				)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				body, err := json.Marshal(map[string]interface{}{"query": tc.query})
+				if !assert.NoError(t, err) {
+					return
+				}
+				request := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+				responseRecorder := httptest.NewRecorder()
+				gw.GraphQLHandler(responseRecorder, request)
+
+				assert.Equal(t, tc.statusCode, responseRecorder.Code)
+				if tc.statusCode == http.StatusBadRequest {
+					var response struct {
+						Errors []struct {
+							Extensions map[string]interface{}
+						}
+					}
+					if assert.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &response)) && assert.NotEmpty(t, response.Errors) {
+						assert.Equal(t, "GRAPHQL_VALIDATION_FAILED", response.Errors[0].Extensions["code"])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestGraphQLHandler_maxRequestBodySize(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		scalar Upload
+
+		type Query {
+			value(input: String): String!
+		}
+
+		type Mutation {
+			upload(file: Upload!): String!
+		}
+	`)
+	require.NoError(t, err)
+
+	executor := WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+		return map[string]interface{}{"value": "hello", "upload": "file-id"}, nil
+	}))
+
+	defaultGateway, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, executor)
+	require.NoError(t, err)
+	limitedGateway, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, executor, WithMaxRequestBodySize(512))
+	require.NoError(t, err)
+
+	// a query whose body is roughly the given size
+	jsonRequest := func(size int) *http.Request {
+		body := fmt.Sprintf(`{"query": "query($input: String) { value(input: $input) }", "variables": {"input": %q}}`, strings.Repeat("a", size))
+		return httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	}
+	uploadRequest := func(size int) *http.Request {
+		request, err := createMultipartRequest(
+			[]byte(`{"query": "mutation ($someFile: Upload!) { upload(file: $someFile) }", "variables": {"someFile": null}}`),
+			[]byte(`{"0": ["variables.someFile"]}`),
+			bytes.Repeat([]byte("a"), size),
+		)
+		require.NoError(t, err)
+		return request
+	}
+
+	for _, tc := range []struct {
+		name       string
+		gateway    *Gateway
+		request    *http.Request
+		statusCode int
+	}{
+		{name: "small json", gateway: defaultGateway, request: jsonRequest(10), statusCode: http.StatusOK},
+		{name: "json over the default limit", gateway: defaultGateway, request: jsonRequest(2 << 20), statusCode: http.StatusRequestEntityTooLarge},
+		{name: "uploads are not limited by default", gateway: defaultGateway, request: uploadRequest(2 << 20), statusCode: http.StatusOK},
+		{name: "json under a custom limit", gateway: limitedGateway, request: jsonRequest(10), statusCode: http.StatusOK},
+		{name: "json over a custom limit", gateway: limitedGateway, request: jsonRequest(1024), statusCode: http.StatusRequestEntityTooLarge},
+		{name: "upload over a custom limit", gateway: limitedGateway, request: uploadRequest(1024), statusCode: http.StatusRequestEntityTooLarge},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			responseRecorder := httptest.NewRecorder()
+			tc.gateway.GraphQLHandler(responseRecorder, tc.request)
+
+			assert.Equal(t, tc.statusCode, responseRecorder.Code)
+			if tc.statusCode == http.StatusRequestEntityTooLarge {
+				assert.Contains(t, responseRecorder.Body.String(), "request body exceeds the maximum size")
+			}
+		})
+	}
+}
+
+func TestGraphQLHandler_responseMediaType(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			greet(name: String!): String
+		}
+	`)
+	require.NoError(t, err)
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithExecutor(ExecutorFunc(
+		func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"greet": "hello"}, nil
+		},
+	)))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name        string
+		accept      string
+		statusCode  int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "legacy",
+			accept:      "application/json",
+			statusCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"data": null, "errors": [{"message": "input: variable.name must be defined", "extensions": {"code": "BAD_USER_INPUT"}}]}`,
+		},
+		{
+			name:        "no preference",
+			accept:      "",
+			statusCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"data": null, "errors": [{"message": "input: variable.name must be defined", "extensions": {"code": "BAD_USER_INPUT"}}]}`,
+		},
+		{
+			name:        "graphql response",
+			accept:      "application/graphql-response+json, application/json",
+			statusCode:  http.StatusBadRequest,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			body:        `{"errors": [{"message": "input: variable.name must be defined", "extensions": {"code": "BAD_USER_INPUT"}}]}`,
+		},
+		{
+			name:        "prefers legacy",
+			accept:      "application/graphql-response+json;q=0.5, application/json",
+			statusCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"data": null, "errors": [{"message": "input: variable.name must be defined", "extensions": {"code": "BAD_USER_INPUT"}}]}`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "query ($name: String!) { greet(name: $name) }"}`))
+			request.Header.Set("Accept", tc.accept)
+			responseRecorder := httptest.NewRecorder()
+			gw.GraphQLHandler(responseRecorder, request)
+
+			response := responseRecorder.Result()
+			assert.Equal(t, tc.statusCode, response.StatusCode)
+			assert.Equal(t, tc.contentType, response.Header.Get("Content-Type"))
+			assert.JSONEq(t, tc.body, responseRecorder.Body.String())
+		})
+	}
+
+	// operations that run are still reported with a 200
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ greet(name: \"world\") }"}`))
+	request.Header.Set("Accept", "application/graphql-response+json")
+	responseRecorder := httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, "application/graphql-response+json; charset=utf-8", responseRecorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"data": {"greet": "hello"}}`, responseRecorder.Body.String())
+}
+
+func TestGraphQLHandler_contentType(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		scalar Upload
+
+		type Query {
+			greeting: String
+			upload(file: Upload!): String
+		}
+	`)
+	require.NoError(t, err)
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithExecutor(ExecutorFunc(
+		func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"greeting": "hello"}, nil
+		},
+	)))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name        string
+		request     func() (*http.Request, error)
+		statusCode  int
+		contentType string
+	}{
+		{
+			name: "single",
+			request: func() (*http.Request, error) {
+				return httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ greeting }"}`)), nil
+			},
+			statusCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+		},
+		{
+			name: "batch",
+			request: func() (*http.Request, error) {
+				return httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`[{"query": "{ greeting }"}, {"query": "{ greeting }"}]`)), nil
+			},
+			statusCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+		},
+		{
+			name: "multipart",
+			request: func() (*http.Request, error) {
+				return createMultipartRequest(
+					[]byte(`{"query": "query ($file: Upload!) { upload(file: $file) }", "variables": {"file": null}}`),
+					[]byte(`{"0": ["variables.file"]}`),
+					[]byte("hello"),
+				)
+			},
+			statusCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+		},
+		{
+			name: "get",
+			request: func() (*http.Request, error) {
+				return httptest.NewRequest(http.MethodGet, "/graphql?query={greeting}", nil), nil
+			},
+			statusCode:  http.StatusOK,
+			contentType: "application/json; charset=utf-8",
+		},
+		{
+			name: "invalid payload",
+			request: func() (*http.Request, error) {
+				return httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": `)), nil
+			},
+			statusCode:  http.StatusUnprocessableEntity,
+			contentType: "application/json; charset=utf-8",
+		},
+		{
+			name: "graphql response",
+			request: func() (*http.Request, error) {
+				request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ greeting }"}`))
+				request.Header.Set("Accept", "application/graphql-response+json")
+				return request, nil
+			},
+			statusCode:  http.StatusOK,
+			contentType: "application/graphql-response+json; charset=utf-8",
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			request, err := tc.request()
+			require.NoError(t, err)
+
+			responseRecorder := httptest.NewRecorder()
+			gw.GraphQLHandler(responseRecorder, request)
+			assert.Equal(t, tc.statusCode, responseRecorder.Code, responseRecorder.Body.String())
+			assert.Equal(t, tc.contentType, responseRecorder.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestGraphQLHandler_multipleOperations(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			greeting: String
+		}
+	`)
+	require.NoError(t, err)
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithExecutor(ExecutorFunc(
+		func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"greeting": "hello"}, nil
+		},
+	)))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name          string
+		query         string
+		operationName string
+		statusCode    int
+		message       string
+		code          string
+	}{
+		{
+			name:       "duplicate names",
+			query:      "query Greet { greeting } query Greet { greeting }",
+			statusCode: http.StatusBadRequest,
+			message:    `There can be only one operation named "Greet".`,
+			code:       "GRAPHQL_VALIDATION_FAILED",
+		},
+		{
+			name:       "anonymous alongside named",
+			query:      "{ greeting } query Greet { greeting }",
+			statusCode: http.StatusBadRequest,
+			message:    "This anonymous operation must be the only defined operation.",
+			code:       "GRAPHQL_VALIDATION_FAILED",
+		},
+		{
+			name:       "missing operation name",
+			query:      "query Greet { greeting } query Welcome { greeting }",
+			statusCode: http.StatusBadRequest,
+			message:    "the document contains 2 operations, please provide an operationName to choose which one to run",
+			code:       "BAD_USER_INPUT",
+		},
+		{
+			name:          "unknown operation name",
+			query:         "query Greet { greeting } query Welcome { greeting }",
+			operationName: "Goodbye",
+			statusCode:    http.StatusBadRequest,
+			message:       "could not find query for operation Goodbye",
+			code:          "BAD_USER_INPUT",
+		},
+		{
+			name:          "operation name",
+			query:         "query Greet { greeting } query Welcome { greeting }",
+			operationName: "Welcome",
+			statusCode:    http.StatusOK,
+		},
+		{
+			name:       "single anonymous operation",
+			query:      "{ greeting }",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "single named operation",
+			query:      "query Greet { greeting }",
+			statusCode: http.StatusOK,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			body, err := json.Marshal(map[string]interface{}{
+				"query":         tc.query,
+				"operationName": tc.operationName,
+			})
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+			responseRecorder := httptest.NewRecorder()
+			gw.GraphQLHandler(responseRecorder, request)
+			assert.Equal(t, tc.statusCode, responseRecorder.Code)
+
+			var result struct {
+				Errors []struct {
+					Message    string                 `json:"message"`
+					Extensions map[string]interface{} `json:"extensions"`
+				} `json:"errors"`
+			}
+			require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &result))
+			if tc.message == "" {
+				assert.Empty(t, result.Errors)
+				return
+			}
+			if assert.Len(t, result.Errors, 1) {
+				assert.Contains(t, result.Errors[0].Message, tc.message)
+				assert.Equal(t, tc.code, result.Errors[0].Extensions["code"])
+			}
+		})
+	}
+}
+
+func TestGraphQLHandler_contextBuilder(t *testing.T) {
+	t.Parallel()
+	type tenantKey struct{}
+
+	// the service tells us which tenant it was asked about
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": {"tenant": %q}}`, r.Header.Get("X-Tenant"))
+	}))
+	defer upstream.Close()
+
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			tenant: String
+		}
+	`)
+	require.NoError(t, err)
+
+	var limitedTenant interface{}
+	gw, err := New([]*graphql.RemoteSchema{{URL: upstream.URL, Schema: schema}},
+		WithContextBuilder(func(r *http.Request) context.Context {
+			return context.WithValue(r.Context(), tenantKey{}, r.Header.Get("X-Tenant-ID"))
+		}),
+		WithRateLimiter(RateLimiterFunc(func(ctx context.Context, operationName string) (bool, time.Duration) {
+			limitedTenant = ctx.Value(tenantKey{})
+			return true, 0
+		})),
+		WithMiddlewares(RequestMiddleware(func(r *http.Request) error {
+			tenant, _ := r.Context().Value(tenantKey{}).(string)
+			r.Header.Set("X-Tenant", tenant)
+			return nil
+		})),
+	)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ tenant }"}`))
+	request.Header.Set("X-Tenant-ID", "acme")
+	responseRecorder := httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.JSONEq(t, `{"data": {"tenant": "acme"}}`, responseRecorder.Body.String())
+	assert.Equal(t, "acme", limitedTenant)
+}
+
+func TestGraphQLHandler_websocketUpgrade(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// a client that tries to open a websocket for subscriptions
+	request := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "WebSocket")
+	request.Header.Set("Sec-WebSocket-Protocol", "graphql-transport-ws")
+	responseRecorder := httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+	assert.JSONEq(t, `{
+		"data": null,
+		"errors": [{
+			"message": "subscriptions are not enabled on this gateway, websocket connections are not supported",
+			"extensions": {"code": "BAD_REQUEST"}
+		}]
+	}`, responseRecorder.Body.String())
+}
+
+func TestIntrospectionHandler(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/schema.json", nil)
+	responseRecorder := httptest.NewRecorder()
+	gw.IntrospectionHandler(responseRecorder, request)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, "application/json; charset=utf-8", responseRecorder.Header().Get("Content-Type"))
+
+	var result struct {
+		Schema struct {
+			QueryType struct {
+				Name string `json:"name"`
+			} `json:"queryType"`
+			Types []struct {
+				Name   string `json:"name"`
+				Fields []struct {
+					Name string `json:"name"`
+				} `json:"fields"`
+			} `json:"types"`
+		} `json:"__schema"`
+	}
+	require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &result))
+	assert.Equal(t, "Query", result.Schema.QueryType.Name)
+
+	var queryFields []string
+	for _, typ := range result.Schema.Types {
+		if typ.Name == "Query" {
+			for _, field := range typ.Fields {
+				queryFields = append(queryFields, field.Name)
+			}
+		}
+	}
+	assert.Contains(t, queryFields, "value")
+
+	// the handler only serves GETs
+	request = httptest.NewRequest(http.MethodPost, "/schema.json", nil)
+	responseRecorder = httptest.NewRecorder()
+	gw.IntrospectionHandler(responseRecorder, request)
+	assert.Equal(t, http.StatusMethodNotAllowed, responseRecorder.Code)
+}
+
+func TestIntrospectionHandler_disabled(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}},
+		WithUpstreamQueryer("url1", graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		})),
+		WithIntrospection(false),
+	)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/schema.json", nil)
+	responseRecorder := httptest.NewRecorder()
+	gw.IntrospectionHandler(responseRecorder, request)
+	assert.Equal(t, http.StatusNotFound, responseRecorder.Code)
+
+	// clients can't get around it by sending the query themselves
+	for _, query := range []string{
+		`{ __schema { queryType { name } } }`,
+		`{ __type(name: \"Query\") { name } }`,
+		`query { ...Schema } fragment Schema on Query { __schema { queryType { name } } }`,
+	} {
+		request = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "`+query+`"}`))
+		responseRecorder = httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+		assert.JSONEq(t, `{"data": null, "errors": [{"message": "introspection is disabled on this gateway", "extensions": {"code": "FORBIDDEN"}}]}`, responseRecorder.Body.String(), query)
+	}
+
+	// the rest of the schema still works
+	request = httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ value }"}`))
+	responseRecorder = httptest.NewRecorder()
+	gw.GraphQLHandler(responseRecorder, request)
+	assert.JSONEq(t, `{"data": {"value": "hello"}}`, responseRecorder.Body.String())
+}
+
+func TestServicesHandler(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/services", nil)
+	responseRecorder := httptest.NewRecorder()
+	gw.ServicesHandler(responseRecorder, request)
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, "application/json; charset=utf-8", responseRecorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[{"url": "url1", "types": {"Query": ["value"]}, "fieldCount": 1}]`, responseRecorder.Body.String())
+
+	request = httptest.NewRequest(http.MethodPost, "/services", nil)
+	responseRecorder = httptest.NewRecorder()
+	gw.ServicesHandler(responseRecorder, request)
+	assert.Equal(t, http.StatusMethodNotAllowed, responseRecorder.Code)
+}
+
+func TestGraphQLHandler_maxConcurrentRequests(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	// a gateway that serves one request at a time and a way to hold onto that request
+	setup := func(t *testing.T, options ...Option) (gw *Gateway, started chan struct{}, release chan struct{}) {
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+		gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, append([]Option{
+			WithMaxConcurrentRequests(1),
+			WithUpstreamQueryer("url1", graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				started <- struct{}{}
+				<-release
+				return map[string]interface{}{"value": "hello"}, nil
+			})),
+		}, options...)...)
+		require.NoError(t, err)
+		return gw, started, release
+	}
+	query := func(gw *Gateway) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ value }"}`))
+		responseRecorder := httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+		return responseRecorder
+	}
+	// sends a request that holds onto the only slot until it's released
+	holdSlot := func(gw *Gateway, started chan struct{}) chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			done <- query(gw)
+		}()
+		<-started
+		return done
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		t.Parallel()
+		gw, started, release := setup(t, WithRequestQueuePolicy(RequestQueueReject))
+		first := holdSlot(gw, started)
+
+		response := query(gw)
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+		assert.Empty(t, response.Header().Get("Retry-After"))
+		assert.JSONEq(t, `{"data": null, "errors": [{"message": "the gateway is serving too many requests", "extensions": {"code": "UNAVAILABLE"}}]}`, response.Body.String())
+
+		close(release)
+		assert.Equal(t, http.StatusOK, (<-first).Code)
+
+		// once the first request is done there's room again
+		assert.Equal(t, http.StatusOK, query(gw).Code)
+	})
+
+	t.Run("queue timeout", func(t *testing.T) {
+		t.Parallel()
+		gw, started, release := setup(t, WithRequestQueueTimeout(10*time.Millisecond))
+		first := holdSlot(gw, started)
+
+		response := query(gw)
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+		assert.Equal(t, "1", response.Header().Get("Retry-After"))
+
+		close(release)
+		assert.Equal(t, http.StatusOK, (<-first).Code)
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		t.Parallel()
+		gw, started, release := setup(t)
+		first := holdSlot(gw, started)
+
+		second := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			second <- query(gw)
+		}()
+
+		// the second request can't get to the service until the first one is done
+		select {
+		case <-started:
+			t.Fatal("the second request was served before the first one finished")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(release)
+		assert.Equal(t, http.StatusOK, (<-first).Code)
+		<-started
+		assert.Equal(t, http.StatusOK, (<-second).Code)
+	})
+}
+
+// countingPlanner counts the number of queries it plans
+type countingPlanner struct {
+	planner QueryPlanner
+	count   int64
+}
+
+func (p *countingPlanner) Plan(ctx *PlanningContext) (QueryPlanList, error) {
+	atomic.AddInt64(&p.count, 1)
+	return p.planner.Plan(ctx)
+}
+
+func TestGraphQLHandler_skipPlanCache(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	planner := &countingPlanner{planner: &MinQueriesPlanner{}}
+	cache := NewAutomaticQueryPlanCache()
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}},
+		WithPlanner(planner),
+		WithQueryPlanCache(cache),
+		WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		})),
+		// only the admin can skip the cache
+		WithPlanCacheBypass(func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "admin"
+		}),
+	)
+	require.NoError(t, err)
+
+	sendAs := func(authorization string, body string, skipCache bool) {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Authorization", authorization)
+		if skipCache {
+			request.Header.Set(NoPlanCacheHeader, "true")
+		}
+		responseRecorder := httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+		require.Equal(t, http.StatusOK, responseRecorder.Code, responseRecorder.Body.String())
+		assert.Contains(t, responseRecorder.Body.String(), `"data":{"value":"hello"}`)
+	}
+	send := func(body string, skipCache bool) {
+		sendAs("admin", body, skipCache)
+	}
+	hash := persistedQueryHash("{ value }")
+	withQuery := fmt.Sprintf(`{"query": "{ value }", "extensions": {"persistedQuery": {"version": 1, "sha256Hash": %q}}}`, hash)
+	hashOnly := fmt.Sprintf(`{"extensions": {"persistedQuery": {"version": 1, "sha256Hash": %q}}}`, hash)
+
+	// the first request fills the cache and the second one uses it
+	send(withQuery, false)
+	send(withQuery, false)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&planner.count))
+	assert.Equal(t, uint64(1), cache.CacheStats().Hits)
+
+	// a request that skips the cache is planned again, even though there is a plan for it
+	send(withQuery, true)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&planner.count))
+	assert.Equal(t, QueryPlanCacheStats{Hits: 1, Misses: 1}, cache.CacheStats())
+
+	// without the query, the cached plan is the only one we have
+	send(hashOnly, true)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&planner.count))
+	assert.Equal(t, uint64(2), cache.CacheStats().Hits)
+
+	// anyone else asking to skip the cache gets the cached plan
+	sendAs("someone", withQuery, true)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&planner.count))
+	assert.Equal(t, uint64(3), cache.CacheStats().Hits)
+}
+
+func TestGraphQLHandler_skipPlanCacheNotAllowed(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	// the gateway wasn't told to let anyone skip the cache
+	planner := &countingPlanner{planner: &MinQueriesPlanner{}}
+	gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}},
+		WithPlanner(planner),
+		WithQueryPlanCache(NewAutomaticQueryPlanCache()),
+		WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		})),
+	)
+	require.NoError(t, err)
+
+	hash := persistedQueryHash("{ value }")
+	body := fmt.Sprintf(`{"query": "{ value }", "extensions": {"persistedQuery": {"version": 1, "sha256Hash": %q}}}`, hash)
+	for i := 0; i < 3; i++ {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set(NoPlanCacheHeader, "true")
+		responseRecorder := httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+		require.Equal(t, http.StatusOK, responseRecorder.Code, responseRecorder.Body.String())
+	}
+
+	// the query was only planned once
+	assert.Equal(t, int64(1), atomic.LoadInt64(&planner.count))
+}
+
+func TestGraphQLHandler_alwaysOK(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		alwaysOK bool
+		accept   string
+		body     string
+		status   int
+	}{
+		{
+			name:   "default",
+			body:   `{"query": "{ missing }"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:     "always ok",
+			alwaysOK: true,
+			body:     `{"query": "{ missing }"}`,
+			status:   http.StatusOK,
+		},
+		{
+			name:     "always ok in a batch",
+			alwaysOK: true,
+			body:     `[{"query": "{ missing }"}]`,
+			status:   http.StatusOK,
+		},
+		{
+			name:     "graphql response media type",
+			alwaysOK: true,
+			accept:   "application/graphql-response+json",
+			body:     `{"query": "{ missing }"}`,
+			status:   http.StatusBadRequest,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithAlwaysOK(tc.alwaysOK))
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tc.body))
+			if tc.accept != "" {
+				request.Header.Set("Accept", tc.accept)
+			}
+			responseRecorder := httptest.NewRecorder()
+			gw.GraphQLHandler(responseRecorder, request)
+			assert.Equal(t, tc.status, responseRecorder.Code)
+
+			// the errors are in the body either way
+			assert.Contains(t, responseRecorder.Body.String(), `Cannot query field \"missing\" on type \"Query\".`)
+			assert.Contains(t, responseRecorder.Body.String(), "GRAPHQL_VALIDATION_FAILED")
+		})
+	}
+}
+
+func TestGraphQLHandler_useJSONNumber(t *testing.T) {
+	t.Parallel()
+	// the service responds with the variables it was sent, exactly as it got them
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Variables  json.RawMessage `json:"variables"`
+			Extensions json.RawMessage `json:"extensions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"user": %q, "extensions": %q}}`, string(payload.Variables), string(payload.Extensions))
+	}))
+	defer service.Close()
+
+	schema, err := graphql.LoadSchema(`
+		input Filter {
+			ids: [ID!]!
+		}
+
+		type Query {
+			user(id: ID, filter: Filter, limit: Int): String
+			extensions: String
+		}
+	`)
+	require.NoError(t, err)
+
+	// 2^53 + 1 can't be represented by a float64
+	query := "query($id: ID, $filter: Filter, $limit: Int) { user(id: $id, filter: $filter, limit: $limit) extensions }"
+	for _, tc := range []struct {
+		name               string
+		options            []Option
+		variables          string
+		extensions         string
+		expected           string
+		expectedExtensions string
+		err                string
+	}{
+		{
+			name:      "float64",
+			variables: `{"limit": 9007199254740993}`,
+			expected:  `{"limit": 9007199254740992}`,
+		},
+		{
+			name:      "float64 ids",
+			variables: `{"id": 9007199254740993}`,
+			err:       "cannot use float64 as ID",
+		},
+		{
+			name:      "json.Number",
+			options:   []Option{WithUseJSONNumber(true)},
+			variables: `{"id": 9007199254740993, "filter": {"ids": [9007199254740993]}, "limit": 9007199254740993}`,
+			expected:  `{"id": 9007199254740993, "filter": {"ids": [9007199254740993]}, "limit": 9007199254740993}`,
+		},
+		{
+			name:               "json.Number with forwarded extensions",
+			options:            []Option{WithUseJSONNumber(true), WithForwardRequestExtensions("trace")},
+			variables:          `{"id": 9007199254740993, "limit": 9007199254740993}`,
+			extensions:         `{"trace": {"span": 9007199254740993}, "other": 1}`,
+			expected:           `{"id": 9007199254740993, "limit": 9007199254740993}`,
+			expectedExtensions: `{"trace": {"span": 9007199254740993}}`,
+		},
+		{
+			name: "extensions decoded with the codec",
+			options: []Option{WithJSONCodec(nil, func(data []byte, v interface{}) error {
+				decoder := json.NewDecoder(bytes.NewReader(data))
+				decoder.UseNumber()
+				return decoder.Decode(v)
+			}), WithForwardRequestExtensions("trace")},
+			variables:          `{"limit": 9007199254740993}`,
+			extensions:         `{"trace": {"span": 9007199254740993}}`,
+			expected:           `{"limit": 9007199254740993}`,
+			expectedExtensions: `{"trace": {"span": 9007199254740993}}`,
+		},
+		{
+			name: "json.Number turned off after a codec",
+			options: []Option{WithJSONCodec(nil, func(data []byte, v interface{}) error {
+				decoder := json.NewDecoder(bytes.NewReader(data))
+				decoder.UseNumber()
+				return decoder.Decode(v)
+			}), WithUseJSONNumber(false)},
+			variables: `{"limit": 9007199254740993}`,
+			expected:  `{"limit": 9007199254740993}`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			// the service is closed when the test returns so the sub-tests can't run in parallel
+			gw, err := New([]*graphql.RemoteSchema{{URL: service.URL, Schema: schema}}, tc.options...)
+			require.NoError(t, err)
+
+			body := fmt.Sprintf(`{"query": %q, "variables": %s}`, query, tc.variables)
+			if tc.extensions != "" {
+				body = fmt.Sprintf(`{"query": %q, "variables": %s, "extensions": %s}`, query, tc.variables, tc.extensions)
+			}
+			request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+			responseRecorder := httptest.NewRecorder()
+			gw.GraphQLHandler(responseRecorder, request)
+			require.Equal(t, http.StatusOK, responseRecorder.Code, responseRecorder.Body.String())
+			if tc.err != "" {
+				assert.Contains(t, responseRecorder.Body.String(), tc.err)
+				return
+			}
+
+			var response struct {
+				Data struct {
+					User       string `json:"user"`
+					Extensions string `json:"extensions"`
+				} `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &response))
+
+			// JSONEq would compare the numbers as float64
+			exactly := func(value string) interface{} {
+				decoder := json.NewDecoder(strings.NewReader(value))
+				decoder.UseNumber()
+				var decoded interface{}
+				require.NoError(t, decoder.Decode(&decoded))
+				return decoded
+			}
+			assert.Equal(t, exactly(tc.expected), exactly(response.Data.User))
+			if tc.expectedExtensions != "" {
+				assert.Equal(t, exactly(tc.expectedExtensions), exactly(response.Data.Extensions))
+			}
+		})
+	}
+
+	t.Run("trailing data", func(t *testing.T) {
+		gw, err := New([]*graphql.RemoteSchema{{URL: service.URL, Schema: schema}}, WithUseJSONNumber(true))
+		require.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ user(id: 1) }"} {}`))
+		responseRecorder := httptest.NewRecorder()
+		gw.GraphQLHandler(responseRecorder, request)
+		assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Code)
+	})
+}
+
+func TestGraphQLHandler_responseInfoHeaders(t *testing.T) {
+	t.Parallel()
+	// the handler of a gateway built with the given schema
+	handlerFor := func(sdl string, options ...Option) http.HandlerFunc {
+		schema, err := graphql.LoadSchema(sdl)
+		require.NoError(t, err)
+
+		gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, append(options, WithExecutor(ExecutorFunc(
+			func(*ExecutionContext) (map[string]interface{}, error) {
+				return map[string]interface{}{"greeting": "hello"}, nil
+			},
+		)))...)
+		require.NoError(t, err)
+		return gw.GraphQLHandler
+	}
+
+	// the headers of the response to a query, or to a query that doesn't pass validation
+	headersOf := func(handler http.HandlerFunc, query string) http.Header {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(fmt.Sprintf(`{"query": %q}`, query)))
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response.Result().Header
+	}
+
+	original := handlerFor(`type Query { greeting: String }`, WithResponseInfoHeaders(true))
+	headers := headersOf(original, "{ greeting }")
+	hash := headers.Get(SchemaHashHeader)
+	assert.Len(t, hash, 64)
+	assert.NotEmpty(t, headers.Get(VersionHeader))
+
+	// errors get the headers too
+	assert.Equal(t, hash, headersOf(original, "{ nope }").Get(SchemaHashHeader))
+
+	// the same schema has the same hash
+	same := handlerFor(`type Query { greeting: String }`, WithResponseInfoHeaders(true))
+	assert.Equal(t, hash, headersOf(same, "{ greeting }").Get(SchemaHashHeader))
+
+	// and the hash changes with the schema
+	changed := handlerFor(`type Query { greeting: String, farewell: String }`, WithResponseInfoHeaders(true))
+	changedHash := headersOf(changed, "{ greeting }").Get(SchemaHashHeader)
+	assert.Len(t, changedHash, 64)
+	assert.NotEqual(t, hash, changedHash)
+
+	// the headers are only there when asked for
+	headers = headersOf(handlerFor(`type Query { greeting: String }`), "{ greeting }")
+	assert.Empty(t, headers.Get(SchemaHashHeader))
+	assert.Empty(t, headers.Get(VersionHeader))
+}
+
+func TestGraphQLHandler_middlewareAbort(t *testing.T) {
+	t.Parallel()
+	var queried int64
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&queried, 1)
+		fmt.Fprint(w, `{"data": {"value": "hello"}}`)
+	}))
+	defer service.Close()
+
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	// the token of the client's request is passed to the middleware through the context
+	type tokenKey struct{}
+	gw, err := New([]*graphql.RemoteSchema{{URL: service.URL, Schema: schema}},
+		WithContextBuilder(func(r *http.Request) context.Context {
+			return context.WithValue(r.Context(), tokenKey{}, r.Header.Get("Authorization"))
+		}),
+		WithMiddlewares(RequestMiddleware(func(r *http.Request) error {
+			if r.Context().Value(tokenKey{}) == "expired" {
+				return &MiddlewareAbort{StatusCode: http.StatusUnauthorized, Code: "UNAUTHENTICATED", Message: "the token has expired"}
+			}
+			return nil
+		})),
+	)
+	require.NoError(t, err)
+
+	// the service is closed when the test returns so the sub-tests can't run in parallel
+	for _, tc := range []struct {
+		name       string
+		token      string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{
+			name:       "valid token",
+			token:      "valid",
+			body:       `{"query": "{ value }"}`,
+			statusCode: http.StatusOK,
+			response:   `{"data":{"value":"hello"}}`,
+		},
+		{
+			name:       "expired token",
+			token:      "expired",
+			body:       `{"query": "{ value }"}`,
+			statusCode: http.StatusUnauthorized,
+			response:   `{"data":null,"errors":[{"message":"the token has expired","extensions":{"code":"UNAUTHENTICATED"}}]}`,
+		},
+		{
+			name:       "expired token in a batch",
+			token:      "expired",
+			body:       `[{"query": "{ value }"}, {"query": "{ value }"}]`,
+			statusCode: http.StatusUnauthorized,
+			response:   `[{"data":null,"errors":[{"message":"the token has expired","extensions":{"code":"UNAUTHENTICATED"}}]},{"data":null,"errors":[{"message":"the token has expired","extensions":{"code":"UNAUTHENTICATED"}}]}]`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := atomic.LoadInt64(&queried)
+
+			request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tc.body))
+			request.Header.Set("Authorization", tc.token)
+			response := httptest.NewRecorder()
+			gw.GraphQLHandler(response, request)
+
+			assert.Equal(t, tc.statusCode, response.Code)
+			assert.JSONEq(t, tc.response, response.Body.String())
+			if tc.statusCode != http.StatusOK {
+				// the rejected operations never reached the service
+				assert.Equal(t, before, atomic.LoadInt64(&queried))
+			}
+		})
+	}
+}
+
+func TestGraphQLHandler_maxResponseBytes(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type Query {
+			values: [String!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	// an unbounded list of values
+	values := []interface{}{}
+	for i := 0; i < 1000; i++ {
+		values = append(values, "hello")
+	}
+	executor := WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+		return map[string]interface{}{"values": values}, nil
+	}))
+
+	for _, tc := range []struct {
+		name       string
+		limit      int64
+		statusCode int
+	}{
+		{name: "no limit", limit: 0, statusCode: http.StatusOK},
+		{name: "under the limit", limit: 1 << 20, statusCode: http.StatusOK},
+		{name: "over the limit", limit: 1024, statusCode: http.StatusInternalServerError},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			// keep track of how many values the gateway encodes
+			var marshaled int64
+			codec := WithJSONCodec(func(value interface{}) ([]byte, error) {
+				atomic.AddInt64(&marshaled, 1)
+				return json.Marshal(value)
+			}, json.Unmarshal)
+
+			gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, executor, codec, WithMaxResponseBytes(tc.limit))
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query": "{ values }"}`))
+			response := httptest.NewRecorder()
+			gw.GraphQLHandler(response, request)
+
+			assert.Equal(t, tc.statusCode, response.Code)
+			if tc.statusCode == http.StatusOK {
+				// the response is the same however it was encoded
+				expected, err := json.Marshal(map[string]interface{}{"data": map[string]interface{}{"values": values}})
+				require.NoError(t, err)
+				assert.Equal(t, string(expected), response.Body.String())
+				return
+			}
+
+			// the gateway stopped encoding once it went over the limit
+			assert.Less(t, atomic.LoadInt64(&marshaled), int64(len(values)))
+			assert.JSONEq(t, `{
+				"data": null,
+				"errors": [{"message": "the response is larger than the limit of 1024 bytes", "extensions": {"code": "RESPONSE_TOO_LARGE"}}]
+			}`, response.Body.String())
+		})
+	}
+}
+
+func TestGraphQLHandler_undeclaredVariables(t *testing.T) {
+	t.Parallel()
+	schema, err := graphql.LoadSchema(`
+		type User {
+			name: String!
+			friends(first: Int): [User!]!
+		}
+
+		type Query {
+			me: User
+		}
+	`)
+	require.NoError(t, err)
+
+	// the planner validates the query itself when it has to change it first
+	for _, tc := range []struct {
+		name    string
+		options []Option
+	}{
+		{name: "default"},
+		{name: "ignored directives", options: []Option{WithPlanner(&MinQueriesPlanner{UnknownDirectivePolicy: UnknownDirectivesIgnore})}},
+		{name: "lenient fragments", options: []Option{WithLenientFragments(true)}},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			gw, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, append(tc.options, WithExecutor(ExecutorFunc(
+				func(*ExecutionContext) (map[string]interface{}, error) {
+					t.Error("the operation should not be executed")
+					return nil, nil
+				},
+			)))...)
+			require.NoError(t, err)
+
+			for _, query := range []string{
+				`query { me { friends { friends(first: $count) { name } } } }`,
+				`query ($first: Int) { me { friends(first: $first) { ...Friends } } } fragment Friends on User { friends(first: $count) { name } }`,
+			} {
+				request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(fmt.Sprintf(`{"query": %q}`, query)))
+				responseRecorder := httptest.NewRecorder()
+				gw.GraphQLHandler(responseRecorder, request)
+				assert.Equal(t, http.StatusBadRequest, responseRecorder.Code, query)
+
+				var response struct {
+					Errors []*graphql.Error
+				}
+				require.NoError(t, json.Unmarshal(responseRecorder.Body.Bytes(), &response))
+				require.Len(t, response.Errors, 1)
+				assert.Equal(t, "GRAPHQL_VALIDATION_FAILED", response.Errors[0].Extensions["code"])
+				assert.Contains(t, response.Errors[0].Message, `Variable "$count" is not defined`)
+			}
+		})
+	}
+}
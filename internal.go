@@ -16,6 +16,9 @@ import (
 // that points to the gateway's internal schema.
 const internalSchemaLocation = "🎉"
 
+// timeoutDirective is the name of the operation directive clients use to ask for a deadline
+const timeoutDirective = "timeout"
+
 // Introspection schema field names
 const (
 	introspectArgs              = "args"
@@ -93,6 +96,8 @@ func (g *Gateway) Query(ctx context.Context, input *graphql.QueryInput, receiver
 				// we found the type so introspect it
 				result[field.Alias] = g.introspectType(introspectedType, field.SelectionSet)
 			}
+		case serviceFieldName:
+			result[field.Alias] = g.resolveServiceField(field.SelectionSet)
 		// to get this far and not be one of the above means that the field is a query field
 		default:
 
@@ -413,12 +413,10 @@ func TestSchema_resolveNodeMissingIDArg(t *testing.T) {
 	`
 	variables := map[string]interface{}{} // missing ID arg
 
+	// the missing variable is caught before the node field is ever resolved
 	err := schemaTestLoadQuery(query, result, variables)
 	assert.Equal(t, graphql.ErrorList{
-		&graphql.Error{
-			Message: "argument 'id' is required",
-			Path:    []interface{}{"node"},
-		},
+		graphql.NewError("BAD_USER_INPUT", "input: variable.id must be defined"),
 	}, err)
 	assert.Equal(t, Result{
 		Node: Node{ID: nil},
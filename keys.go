@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+
+	"github.com/nautilus/graphql"
+)
+
+// keyDirective is the name of the directive services put on a type to pick the field that identifies
+// it when the gateway stitches it together with other services, ie:
+//
+//	type Product @key(fields: "sku") {
+//		sku: String!
+//	}
+//
+// The value of the field is passed to the node query of the other services in place of the id.
+const keyDirective = "key"
+
+// typeKeys holds the field that identifies each type that doesn't use its id, keyed by the name of the type
+type typeKeys map[string]string
+
+// collectTypeKeys looks for types marked with @key in each of the sources and makes sure that
+// every service that declares a key for a type agrees on it
+func collectTypeKeys(sources []*graphql.RemoteSchema) (typeKeys, error) {
+	keys := typeKeys{}
+
+	for _, source := range sources {
+		for _, definition := range source.Schema.Types {
+			directive := definition.Directives.ForName(keyDirective)
+			if directive == nil {
+				continue
+			}
+
+			fieldsArg := directive.Arguments.ForName("fields")
+			if fieldsArg == nil || fieldsArg.Value == nil {
+				return nil, fmt.Errorf("@%s on %s is missing its fields", keyDirective, definition.Name)
+			}
+
+			fields := strings.Fields(fieldsArg.Value.Raw)
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("@%s on %s must name exactly one field, found %q", keyDirective, definition.Name, fieldsArg.Value.Raw)
+			}
+			key := fields[0]
+
+			if definition.Fields.ForName(key) == nil {
+				return nil, fmt.Errorf("%s is keyed by %s but does not have a field with that name", definition.Name, key)
+			}
+
+			// the key is sent to the service in place of the id so the two can't both be there
+			if key != "id" && definition.Fields.ForName("id") != nil {
+				return nil, fmt.Errorf("%s is keyed by %s but also has an id field", definition.Name, key)
+			}
+
+			if existing, ok := keys[definition.Name]; ok && existing != key {
+				return nil, fmt.Errorf("services disagree on the key for %s: %s and %s", definition.Name, existing, key)
+			}
+
+			keys[definition.Name] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// field returns the selection the gateway adds to a query to identify an object of the given type
+func (k typeKeys) field(typeName string) *ast.Field {
+	key, ok := k[typeName]
+	if !ok || key == "id" {
+		return &ast.Field{Name: "id"}
+	}
+
+	// the rest of the gateway looks for the identity of an object under id
+	return &ast.Field{Name: key, Alias: "id"}
+}
+
+// stripKeyDirectives returns a version of the schema without any @key directives on its types. The
+// gateway is the one that consumes them so they shouldn't get in the way of merging the schemas.
+func stripKeyDirectives(schema *ast.Schema) *ast.Schema {
+	stripped := *schema
+	stripped.Types = map[string]*ast.Definition{}
+
+	changed := false
+	for name, definition := range schema.Types {
+		if definition.Directives.ForName(keyDirective) != nil {
+			copied := *definition
+			copied.Directives = ast.DirectiveList{}
+			for _, directive := range definition.Directives {
+				if directive.Name != keyDirective {
+					copied.Directives = append(copied.Directives, directive)
+				}
+			}
+			definition = &copied
+			changed = true
+		}
+
+		stripped.Types[name] = definition
+	}
+
+	// if nothing had a key there's nothing to do
+	if !changed {
+		return schema
+	}
+
+	// point the root types at their new definitions
+	if schema.Query != nil {
+		stripped.Query = stripped.Types[schema.Query.Name]
+	}
+	if schema.Mutation != nil {
+		stripped.Mutation = stripped.Types[schema.Mutation.Name]
+	}
+	if schema.Subscription != nil {
+		stripped.Subscription = stripped.Types[schema.Subscription.Name]
+	}
+
+	return &stripped
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
 )
 
 // Merger is an interface for structs that are capable of taking a list of schemas and returning something that resembles
@@ -23,9 +24,200 @@ func (m MergerFunc) Merge(sources []*ast.Schema) (*ast.Schema, error) {
 	return m(sources)
 }
 
-// mergeSchemas takes in a bunch of schemas and merges them into one. Following the strategies outlined here:
-// https://github.com/nautilus/gateway/blob/master/docs/mergingStrategies.md
+// DefinitionMerger combines two definitions of the same type into one, or returns an error if they
+// can't be reconciled. previous holds everything that has been merged so far.
+type DefinitionMerger func(previous *ast.Definition, new *ast.Definition) (*ast.Definition, error)
+
+// DirectiveMerger combines two definitions of the same directive into one
+type DirectiveMerger func(previous *ast.DirectiveDefinition, new *ast.DirectiveDefinition) (*ast.DirectiveDefinition, error)
+
+// DefaultMerger is the Merger used by the gateway unless another one is passed to WithMerger. Each hook
+// replaces how the definitions of one kind are combined while the rest of the schemas are merged following
+// the usual strategies. Hooks that are left nil use the built-in behavior.
+type DefaultMerger struct {
+	Objects      DefinitionMerger
+	Interfaces   DefinitionMerger
+	InputObjects DefinitionMerger
+	Enums        DefinitionMerger
+	Scalars      DefinitionMerger
+	Unions       DefinitionMerger
+	Directives   DirectiveMerger
+	// Nullability decides what the built-in merge of object and interface types does with fields
+	// that are nullable in some services and non-null in others
+	Nullability NullabilityMergeStrategy
+	// ConflictReporter is called whenever the built-in behavior reconciles two definitions that disagree
+	// instead of failing the merge, like two different descriptions of the same field
+	ConflictReporter func(MergeConflict)
+}
+
+// MergeConflict describes two definitions that disagreed but were merged anyway
+type MergeConflict struct {
+	// Type is the name of the type whose definitions disagreed, or the name of the directive prefixed with @
+	Type string
+	// Field is the name of the field or enum value that the definitions disagreed on, if the conflict was in one
+	Field string
+	// Sources holds the index of the two schemas in the list passed to the merger
+	Sources []int
+	// URLs holds the locations of the two services, when they are known
+	URLs []string
+	// Resolution describes what the merge did about the conflict
+	Resolution string
+}
+
+// mergeConflictReporter is given to the merge helpers to report the conflicts they reconcile in the definitions of
+// one type, along with the field or enum value involved (if any)
+type mergeConflictReporter func(field string, resolution string)
+
+// report passes the conflict along if anyone is listening
+func (r mergeConflictReporter) report(field string, resolution string) {
+	if r != nil {
+		r(field, resolution)
+	}
+}
+
+// reportDescriptions reports two descriptions that disagree, knowing that the first one is kept
+func (r mergeConflictReporter) reportDescriptions(field string, description1 string, description2 string) {
+	if description1 != "" && description2 != "" && description1 != description2 {
+		r.report(field, "kept the first description")
+	}
+}
+
+// NullabilityMergeStrategy decides what happens when services disagree on whether a field of an object or
+// interface type can be null. Arguments and the fields of input types always have to agree since a service
+// could reject a value that another one allows.
+type NullabilityMergeStrategy int
+
+const (
+	// NullabilityStrict refuses to merge fields with different nullability. This is the default.
+	NullabilityStrict NullabilityMergeStrategy = iota
+	// NullabilityMostNullable makes the field nullable if any service says it is. Clients never see a null
+	// they weren't told about but the services that declared the field non-null lose that guarantee.
+	NullabilityMostNullable
+	// NullabilityMostStrict makes the field non-null if any service says it is. New refuses it unless the
+	// gateway is built WithNullBubbling, which reports an error for a null returned by one of the services
+	// that declared the field nullable and nulls out its parent.
+	NullabilityMostStrict
+)
+
+// definitionMerger returns the function that combines definitions of the given kind
+func (m *DefaultMerger) definitionMerger(kind ast.DefinitionKind, report mergeConflictReporter) DefinitionMerger {
+	var hook, builtIn DefinitionMerger
+	switch kind {
+	case ast.Object:
+		hook, builtIn = m.Objects, func(previous *ast.Definition, new *ast.Definition) (*ast.Definition, error) {
+			return mergeObjectTypes(previous, new, m.Nullability, report)
+		}
+	case ast.Interface:
+		hook, builtIn = m.Interfaces, func(previous *ast.Definition, new *ast.Definition) (*ast.Definition, error) {
+			return mergeInterfaces(previous, new, m.Nullability, report)
+		}
+	case ast.InputObject:
+		hook, builtIn = m.InputObjects, func(previous *ast.Definition, new *ast.Definition) (*ast.Definition, error) {
+			return mergeInputObjects(previous, new, report)
+		}
+	case ast.Enum:
+		hook, builtIn = m.Enums, func(previous *ast.Definition, new *ast.Definition) (*ast.Definition, error) {
+			return mergeEnums(previous, new, report)
+		}
+	case ast.Scalar:
+		hook, builtIn = m.Scalars, func(previous *ast.Definition, new *ast.Definition) (*ast.Definition, error) {
+			return mergeScalars(previous, new, report)
+		}
+	case ast.Union:
+		hook, builtIn = m.Unions, mergeUnions
+	default:
+		// there's nothing to merge for any other kind so we keep the first definition
+		return func(previous *ast.Definition, _ *ast.Definition) (*ast.Definition, error) {
+			return previous, nil
+		}
+	}
+
+	if hook != nil {
+		return hook
+	}
+	return builtIn
+}
+
+// directiveMerger returns the function that combines directive definitions
+func (m *DefaultMerger) directiveMerger(report mergeConflictReporter) DirectiveMerger {
+	if m.Directives != nil {
+		return m.Directives
+	}
+	return func(previous *ast.DirectiveDefinition, new *ast.DirectiveDefinition) (*ast.DirectiveDefinition, error) {
+		return mergeDirectives(previous, new, report)
+	}
+}
+
+// conflictReporter returns the reporter for the conflicts between the definitions of a type in the given sources
+func (m *DefaultMerger) conflictReporter(name string, sources ...int) mergeConflictReporter {
+	if m.ConflictReporter == nil {
+		return nil
+	}
+	return func(field string, resolution string) {
+		m.ConflictReporter(MergeConflict{Type: name, Field: field, Sources: sources, Resolution: resolution})
+	}
+}
+
+// MergeError is returned when the definitions of a type provided by two different schemas
+// could not be merged together.
+type MergeError struct {
+	// Type is the name of the type that could not be merged
+	Type string
+	// Field is the name of the conflicting field, if the conflict was in one
+	Field string
+	// Sources holds the index of the schemas that conflicted in the list passed to the merger
+	Sources []int
+	// URLs holds the locations of the services that conflicted, when they are known
+	URLs []string
+	// Err is the underlying reason the definitions could not be merged
+	Err error
+}
+
+func (e *MergeError) Error() string {
+	// the thing that we couldn't merge
+	name := e.Type
+	if e.Field != "" {
+		name = fmt.Sprintf("%s.%s", e.Type, e.Field)
+	}
+
+	// the places that conflicted
+	sources := e.URLs
+	if len(sources) == 0 {
+		for _, source := range e.Sources {
+			sources = append(sources, fmt.Sprintf("schema %d", source))
+		}
+	}
+
+	if len(sources) == 0 {
+		return fmt.Sprintf("encountered error merging %s: %s", name, e.Err)
+	}
+	return fmt.Sprintf("encountered error merging %s between %s: %s", name, strings.Join(sources, " and "), e.Err)
+}
+
+func (e *MergeError) Unwrap() error {
+	return e.Err
+}
+
+// mergeError wraps the error encountered while merging a type with the indices of the schemas that conflicted
+func mergeError(name string, err error, sources ...int) error {
+	var mergeErr *MergeError
+	if !errors.As(err, &mergeErr) {
+		mergeErr = &MergeError{Err: err}
+	}
+	mergeErr.Type = name
+	mergeErr.Sources = sources
+
+	return mergeErr
+}
+
+// mergeSchemas merges the schemas with the built-in behavior for every kind of definition
 func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
+	return (&DefaultMerger{}).Merge(sources)
+}
+
+// Merge takes in a bunch of schemas and merges them into one. Following the strategies outlined here:
+// https://github.com/nautilus/gateway/blob/master/docs/mergingStrategies.md
+func (m *DefaultMerger) Merge(sources []*ast.Schema) (*ast.Schema, error) {
 	// a placeholder schema we will build up using the sources
 	result := &ast.Schema{
 		Types:         map[string]*ast.Definition{},
@@ -40,10 +232,19 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 	directives := map[string][]*ast.DirectiveDefinition{}
 	interfaces := map[string][]*ast.Definition{}
 
+	// we need to remember where each definition came from so we can report conflicts
+	definitionSources := map[*ast.Definition]int{}
+	typeSources := map[string]int{}
+	directiveSources := map[*ast.DirectiveDefinition]int{}
+
 	// we have to visit each source schema
-	for _, schema := range sources {
+	for ix, schema := range sources {
 		// add each type declared by the source schema to the one we are building up
 		for name, definition := range schema.Types {
+			if _, seen := definitionSources[definition]; !seen {
+				definitionSources[definition] = ix
+			}
+
 			// if the definition is an interface
 			if definition.Kind == ast.Interface {
 				// ad it to the list
@@ -55,10 +256,43 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 
 		// add each directive to the list
 		for name, definition := range schema.Directives {
+			if _, seen := directiveSources[definition]; !seen {
+				directiveSources[definition] = ix
+			}
 			directives[name] = append(directives[name], definition)
 		}
 	}
 
+	// a type can be extended by any service but one of them has to declare it. Services usually extend the
+	// root types without declaring them so those don't need a declaration
+	extensions := map[*ast.Definition]bool{}
+	sourceExtensions := map[*ast.Source]map[string]bool{}
+	for _, group := range []map[string][]*ast.Definition{types, interfaces} {
+		for name, definitions := range group {
+			declared := false
+			for _, definition := range definitions {
+				if isTypeExtension(sourceExtensions, definition) {
+					extensions[definition] = true
+				} else {
+					declared = true
+				}
+			}
+			if declared || name == typeNameQuery || name == typeNameMutation || name == typeNameSubscription {
+				// the declaration has to come first so the extensions have something to add to
+				sort.SliceStable(definitions, func(i, j int) bool {
+					return !extensions[definitions[i]] && extensions[definitions[j]]
+				})
+				continue
+			}
+
+			extendedIn := []int{}
+			for _, definition := range definitions {
+				extendedIn = append(extendedIn, definitionSources[definition])
+			}
+			return nil, mergeError(name, fmt.Errorf("%s is extended but no service declares it", name), extendedIn...)
+		}
+	}
+
 	// merge each interface into one
 	for name, definitions := range interfaces {
 		for _, definition := range definitions {
@@ -69,6 +303,7 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 			if !exists {
 				// use the declaration that we got from the new schema
 				result.Types[name] = definition
+				typeSources[name] = definitionSources[definition]
 
 				result.AddPossibleType(name, definition)
 
@@ -76,9 +311,10 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 				continue
 			}
 
-			previousDefinition, err := mergeInterfaces(previousDefinition, definition)
+			report := m.conflictReporter(name, typeSources[name], definitionSources[definition])
+			previousDefinition, err := m.definitionMerger(ast.Interface, report)(previousDefinition, definition)
 			if err != nil {
-				return nil, err
+				return nil, mergeError(name, err, typeSources[name], definitionSources[definition])
 			}
 			result.Types[name] = previousDefinition
 		}
@@ -86,6 +322,18 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 
 	possibleTypesSet := map[string]Set{}
 
+	// the members of a union are its possible types
+	addUnionMembers := func(union *ast.Definition) {
+		for _, possibleType := range union.Types {
+			for _, typedef := range types[possibleType] {
+				if !possibleTypesSet[union.Name].Has(typedef.Name) {
+					possibleTypesSet[union.Name].Add(typedef.Name)
+					result.AddPossibleType(union.Name, typedef)
+				}
+			}
+		}
+	}
+
 	// merge each definition of each type into one
 	for name, definitions := range types {
 		if _, exists := possibleTypesSet[name]; !exists {
@@ -99,16 +347,10 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 			if !exists {
 				// use the declaration that we got from the new schema
 				result.Types[name] = definition
+				typeSources[name] = definitionSources[definition]
 
 				if definition.Kind == ast.Union {
-					for _, possibleType := range definition.Types {
-						for _, typedef := range types[possibleType] {
-							if !possibleTypesSet[name].Has(typedef.Name) {
-								possibleTypesSet[name].Add(typedef.Name)
-								result.AddPossibleType(name, typedef)
-							}
-						}
-					}
+					addUnionMembers(definition)
 				} else {
 					// register the type as an implementer of itself
 					result.AddPossibleType(name, definition)
@@ -129,33 +371,42 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 				continue
 			}
 
-			// unify handling of errors for merging
-			var err error
-
-			switch definition.Kind {
-			case ast.Object:
-				previousDefinition, err = mergeObjectTypes(previousDefinition, definition)
-			case ast.Interface:
-				previousDefinition, err = mergeInterfaces(previousDefinition, definition)
-			case ast.InputObject:
-				previousDefinition, err = mergeInputObjects(previousDefinition, definition)
-			case ast.Enum:
-				previousDefinition, err = mergeEnums(previousDefinition, definition)
-			case ast.Scalar:
-				previousDefinition, err = mergeScalars(previousDefinition, definition)
-			case ast.Union:
-				previousDefinition, err = mergeUnions(previousDefinition, definition)
+			// an extension doesn't repeat the directives of the declaration but it can add its own
+			source := definitionSources[definition]
+			var extensionDirectives ast.DirectiveList
+			if extensions[definition] {
+				for _, directive := range definition.Directives {
+					if previousDefinition.Directives.ForName(directive.Name) == nil {
+						extensionDirectives = append(extensionDirectives, directive)
+					}
+				}
+				extension := *definition
+				extension.Directives = previousDefinition.Directives
+				definition = &extension
 			}
 
+			report := m.conflictReporter(name, typeSources[name], source)
+			previousDefinition, err := m.definitionMerger(definition.Kind, report)(previousDefinition, definition)
 			if err != nil {
-				return nil, err
+				return nil, mergeError(name, err, typeSources[name], source)
+			}
+			if len(extensionDirectives) > 0 {
+				extended := *previousDefinition
+				extended.Directives = append(append(ast.DirectiveList{}, previousDefinition.Directives...), extensionDirectives...)
+				previousDefinition = &extended
 			}
 			result.Types[name] = previousDefinition
+
+			// a custom merger could have added members to the union
+			if previousDefinition.Kind == ast.Union {
+				addUnionMembers(previousDefinition)
+			}
 		}
 	}
 
 	// merge each directive definition together
 	for name, definitions := range directives {
+		first := 0
 		for _, definition := range definitions {
 			// look up if the type is already registered in the aggregate
 			previousDefinition, exists := result.Directives[name]
@@ -164,13 +415,15 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 			if !exists {
 				// use the declaration that we got from the new schema
 				result.Directives[name] = definition
+				first = directiveSources[definition]
 
 				// we're done with this type
 				continue
 			}
 
 			// we have to merge the 2 directives
-			previousDefinition, err := mergeDirectives(previousDefinition, definition)
+			report := m.conflictReporter("@"+name, first, directiveSources[definition])
+			previousDefinition, err := m.directiveMerger(report)(previousDefinition, definition)
 			if err != nil {
 				return nil, err
 			}
@@ -183,6 +436,12 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 	mutationType := result.Types[typeNameMutation]
 	subscriptionType := result.Types[typeNameSubscription]
 
+	// services can contribute just about anything (only input types, only a mutation, ...) but
+	// there's nothing to query if none of them has a root type
+	if queryType == nil && mutationType == nil && subscriptionType == nil {
+		return nil, errors.New("none of the schemas define a Query, Mutation, or Subscription type")
+	}
+
 	result.Query = queryType
 	result.Mutation = mutationType
 	result.Subscription = subscriptionType
@@ -191,9 +450,37 @@ func mergeSchemas(sources []*ast.Schema) (*ast.Schema, error) {
 	return result, nil
 }
 
-func mergeInterfaces(previousDefinition *ast.Definition, newDefinition *ast.Definition) (*ast.Definition, error) {
+// isTypeExtension returns true if the definition only comes from an extension (extend type User { ... }) in its
+// schema. The schema doesn't keep track of that so we have to parse the source it was loaded from again. The
+// extended types of each source are cached in sourceExtensions. Schemas that weren't loaded from SDL, like
+// the ones built from an introspection query, don't have a source so their types are always declarations.
+func isTypeExtension(sourceExtensions map[*ast.Source]map[string]bool, definition *ast.Definition) bool {
+	if definition.Position == nil || definition.Position.Src == nil {
+		return false
+	}
+
+	extended, ok := sourceExtensions[definition.Position.Src]
+	if !ok {
+		extended = map[string]bool{}
+		if document, err := parser.ParseSchema(definition.Position.Src); err == nil {
+			for _, extension := range document.Extensions {
+				extended[extension.Name] = true
+			}
+			// a source that declares the type and extends it too is still a declaration
+			for _, declaration := range document.Definitions {
+				delete(extended, declaration.Name)
+			}
+		}
+		sourceExtensions[definition.Position.Src] = extended
+	}
+
+	return extended[definition.Name]
+}
+
+func mergeInterfaces(previousDefinition *ast.Definition, newDefinition *ast.Definition, nullability NullabilityMergeStrategy, report mergeConflictReporter) (*ast.Definition, error) {
 	prevCopy := *previousDefinition
 	// descriptions
+	report.reportDescriptions("", prevCopy.Description, newDefinition.Description)
 	if prevCopy.Description == "" {
 		prevCopy.Description = newDefinition.Description
 	}
@@ -208,24 +495,28 @@ func mergeInterfaces(previousDefinition *ast.Definition, newDefinition *ast.Defi
 		otherField := newDefinition.Fields.ForName(field.Name)
 
 		var err error
-		prevCopy.Fields[ix], err = mergeFields(field, otherField)
+		prevCopy.Fields[ix], err = mergeFields(field, otherField, nullability, report)
 		if err != nil {
-			return nil, fmt.Errorf("encountered error merging interface %v: %w", previousDefinition.Name, err)
+			return nil, &MergeError{Type: previousDefinition.Name, Field: field.Name, Err: err}
 		}
 	}
 
 	return &prevCopy, nil
 }
 
-func mergeObjectTypes(previousDefinition *ast.Definition, newDefinition *ast.Definition) (*ast.Definition, error) {
+func mergeObjectTypes(previousDefinition *ast.Definition, newDefinition *ast.Definition, nullability NullabilityMergeStrategy, report mergeConflictReporter) (*ast.Definition, error) {
 	prevCopy := *previousDefinition
 	// descriptions
+	report.reportDescriptions("", prevCopy.Description, newDefinition.Description)
 	if prevCopy.Description == "" {
 		prevCopy.Description = newDefinition.Description
 	}
 
 	// interfaces
 	prevCopy.Interfaces = mergeInterfaceNames(prevCopy.Interfaces, newDefinition.Interfaces)
+	if mergeStringSliceEquivalent(previousDefinition.Interfaces, newDefinition.Interfaces) != nil {
+		report.report("", "implemented the interfaces of both definitions")
+	}
 
 	// we have to add the fields in the source definition with the one in the aggregate
 	prevCopy.Fields = append(ast.FieldList{}, previousDefinition.Fields...)
@@ -236,10 +527,10 @@ func mergeObjectTypes(previousDefinition *ast.Definition, newDefinition *ast.Def
 		if prevField != nil {
 			// and they aren't equal
 			var err error
-			prevCopy.Fields[prevIndex], err = mergeFields(prevField, newField)
+			prevCopy.Fields[prevIndex], err = mergeFields(prevField, newField, nullability, report)
 			if err != nil {
 				//  we don't allow 2 fields that have different types
-				return nil, fmt.Errorf("encountered error merging object %v: %w", previousDefinition.Name, err)
+				return nil, &MergeError{Type: previousDefinition.Name, Field: newField.Name, Err: err}
 			}
 		} else {
 			// its safe to copy over the definition
@@ -280,22 +571,23 @@ func findField(fields ast.FieldList, fieldName string) (int, *ast.FieldDefinitio
 	return -1, nil
 }
 
-func mergeInputObjects(object1, object2 *ast.Definition) (*ast.Definition, error) {
+func mergeInputObjects(object1, object2 *ast.Definition, report mergeConflictReporter) (*ast.Definition, error) {
 	object1Copy := *object1
+	report.reportDescriptions("", object1.Description, object2.Description)
 
 	// if the field list isn't the same
 	var err error
-	object1Copy.Fields, err = mergeFieldList(object1.Fields, object2.Fields)
+	object1Copy.Fields, err = mergeFieldList(object1.Fields, object2.Fields, report)
 	if err != nil {
 		return nil, err
 	}
 
-	// check directives
+	// check directives (including @oneOf) so that every service agrees on how the object is used
 	if err := mergeDirectiveListsEqual(object1.Directives, object2.Directives); err != nil {
 		return nil, err
 	}
 
-	return object1, nil
+	return &object1Copy, nil
 }
 
 func mergeStringSliceEquivalent(slice1, slice2 []string) error {
@@ -321,7 +613,7 @@ func mergeStringSliceEquivalent(slice1, slice2 []string) error {
 	return nil
 }
 
-func mergeEnums(previousDefinition *ast.Definition, newDefinition *ast.Definition) (*ast.Definition, error) {
+func mergeEnums(previousDefinition *ast.Definition, newDefinition *ast.Definition, report mergeConflictReporter) (*ast.Definition, error) {
 	prevCopy := *previousDefinition
 
 	// if we are merging an internal enums
@@ -330,6 +622,7 @@ func mergeEnums(previousDefinition *ast.Definition, newDefinition *ast.Definitio
 		return &prevCopy, nil
 	}
 
+	report.reportDescriptions("", prevCopy.Description, newDefinition.Description)
 	if prevCopy.Description == "" {
 		prevCopy.Description = newDefinition.Description
 	}
@@ -344,7 +637,7 @@ func mergeEnums(previousDefinition *ast.Definition, newDefinition *ast.Definitio
 		newValue := newDefinition.EnumValues.ForName(value.Name)
 
 		var err error
-		prevCopy.EnumValues[ix], err = mergeEnumValues(value, newValue)
+		prevCopy.EnumValues[ix], err = mergeEnumValues(value, newValue, report)
 		if err != nil {
 			return nil, err
 		}
@@ -370,9 +663,10 @@ func mergeUnions(previousDefinition *ast.Definition, newDefinition *ast.Definiti
 	return previousDefinition, nil
 }
 
-func mergeDirectives(previousDefinition *ast.DirectiveDefinition, newDefinition *ast.DirectiveDefinition) (*ast.DirectiveDefinition, error) {
+func mergeDirectives(previousDefinition *ast.DirectiveDefinition, newDefinition *ast.DirectiveDefinition, report mergeConflictReporter) (*ast.DirectiveDefinition, error) {
 	result := *previousDefinition // shallow copy to mutate merge result
 	// keep the first description
+	report.reportDescriptions("", result.Description, newDefinition.Description)
 	if result.Description == "" {
 		result.Description = newDefinition.Description
 	}
@@ -383,9 +677,12 @@ func mergeDirectives(previousDefinition *ast.DirectiveDefinition, newDefinition
 	if err != nil {
 		return nil, fmt.Errorf("conflict in locations for directive %s: %w", previousDefinition.Name, err)
 	}
+	if len(result.Locations) != len(previousDefinition.Locations) || len(result.Locations) != len(newDefinition.Locations) {
+		report.report("", "allowed the directive on the locations of both definitions")
+	}
 
 	// make sure the 2 definitions take the same arguments
-	result.Arguments, err = mergeArgumentDefinitionList(result.Arguments, newDefinition.Arguments, result.Position.Src.BuiltIn)
+	result.Arguments, err = mergeArgumentDefinitionList(result.Arguments, newDefinition.Arguments, result.Position.Src.BuiltIn, "", report)
 	if err != nil {
 		return nil, fmt.Errorf("conflict in argument definitions for directive %s: %w", previousDefinition.Name, err)
 	}
@@ -394,8 +691,9 @@ func mergeDirectives(previousDefinition *ast.DirectiveDefinition, newDefinition
 	return &result, nil
 }
 
-func mergeEnumValues(value1, value2 *ast.EnumValueDefinition) (*ast.EnumValueDefinition, error) {
+func mergeEnumValues(value1, value2 *ast.EnumValueDefinition, report mergeConflictReporter) (*ast.EnumValueDefinition, error) {
 	value1Copy := *value1
+	report.reportDescriptions(value1.Name, value1.Description, value2.Description)
 	if value1Copy.Description == "" {
 		value1Copy.Description = value2.Description
 	}
@@ -408,21 +706,47 @@ func mergeEnumValues(value1, value2 *ast.EnumValueDefinition) (*ast.EnumValueDef
 	return &value1Copy, nil
 }
 
-func mergeScalars(value1, value2 *ast.Definition) (*ast.Definition, error) {
+func mergeScalars(value1, value2 *ast.Definition, report mergeConflictReporter) (*ast.Definition, error) {
 	value1Copy := *value1
+	report.reportDescriptions("", value1.Description, value2.Description)
 	if value1Copy.Description == "" {
 		value1Copy.Description = value2.Description
 	}
 
-	// if the 2 directives dont match
-	if err := mergeDirectiveListsEqual(value1.Directives, value2.Directives); err != nil {
-		return nil, fmt.Errorf("conflict in enum value directives: %w", err)
+	// @specifiedBy only points at a description of the scalar so, like the description, the first one wins
+	specifiedBy1, specifiedBy2 := value1.Directives.ForName(specifiedByDirective), value2.Directives.ForName(specifiedByDirective)
+	if specifiedBy1 == nil && specifiedBy2 != nil {
+		value1Copy.Directives = append(append(ast.DirectiveList{}, value1.Directives...), specifiedBy2)
+	}
+	if specifiedBy1 != nil && specifiedBy2 != nil && mergeDirectiveEqual(specifiedBy1, specifiedBy2) != nil {
+		report.report("", fmt.Sprintf("kept the first @%s", specifiedByDirective))
+	}
+
+	// the rest of the directives have to match
+	directives1 := mergeDirectivesWithout(value1.Directives, specifiedByDirective)
+	directives2 := mergeDirectivesWithout(value2.Directives, specifiedByDirective)
+	if err := mergeDirectiveListsEqual(directives1, directives2); err != nil {
+		return nil, fmt.Errorf("conflict in scalar directives: %w", err)
 	}
 
 	return &value1Copy, nil
 }
 
-func mergeFieldList(list1, list2 ast.FieldList) (ast.FieldList, error) {
+// the name of the directive that points at the specification of a scalar
+const specifiedByDirective = "specifiedBy"
+
+// mergeDirectivesWithout returns the directives in the list that don't have the given name
+func mergeDirectivesWithout(list ast.DirectiveList, name string) ast.DirectiveList {
+	result := ast.DirectiveList{}
+	for _, directive := range list {
+		if directive.Name != name {
+			result = append(result, directive)
+		}
+	}
+	return result
+}
+
+func mergeFieldList(list1, list2 ast.FieldList, report mergeConflictReporter) (ast.FieldList, error) {
 	if len(list1) != len(list2) {
 		return nil, fmt.Errorf("inconsistent number of fields")
 	}
@@ -435,7 +759,7 @@ func mergeFieldList(list1, list2 ast.FieldList) (ast.FieldList, error) {
 			return nil, fmt.Errorf("could not find field %s", field.Name)
 		}
 
-		newField, err := mergeFields(field, otherField)
+		newField, err := mergeFields(field, otherField, NullabilityStrict, report)
 		if err != nil {
 			return nil, err
 		}
@@ -445,21 +769,28 @@ func mergeFieldList(list1, list2 ast.FieldList) (ast.FieldList, error) {
 	return list1Copy, nil
 }
 
-func mergeFields(field1, field2 *ast.FieldDefinition) (*ast.FieldDefinition, error) {
+func mergeFields(field1, field2 *ast.FieldDefinition, nullability NullabilityMergeStrategy, report mergeConflictReporter) (*ast.FieldDefinition, error) {
 	field1Copy := *field1
 	// descriptions
+	if field2 != nil {
+		report.reportDescriptions(field1.Name, field1.Description, field2.Description)
+	}
 	if field1Copy.Description == "" {
 		field1Copy.Description = field2.Description
 	}
 
 	// fields
-	if err := mergeTypesEqual(field1.Type, field2.Type); err != nil {
+	var err error
+	field1Copy.Type, err = mergeTypes(field1.Type, field2.Type, nullability)
+	if err != nil {
 		return nil, fmt.Errorf("fields are not equal: %w", err)
 	}
+	if field1.Type != nil && field2.Type != nil && field1.Type.String() != field2.Type.String() {
+		report.report(field1.Name, fmt.Sprintf("merged the types %s and %s into %s", field1.Type, field2.Type, field1Copy.Type))
+	}
 
 	// arguments
-	var err error
-	field1Copy.Arguments, err = mergeArgumentDefinitionList(field1.Arguments, field2.Arguments, false)
+	field1Copy.Arguments, err = mergeArgumentDefinitionList(field1.Arguments, field2.Arguments, false, field1.Name, report)
 	if err != nil {
 		return nil, fmt.Errorf("fields are not equal: %w", err)
 	}
@@ -554,7 +885,7 @@ func mergeArgumentsEqual(arg1, arg2 *ast.Argument) error {
 	return nil
 }
 
-func mergeArgumentDefinitionList(list1, list2 ast.ArgumentDefinitionList, ignoreNewDefaultValue bool) (ast.ArgumentDefinitionList, error) {
+func mergeArgumentDefinitionList(list1, list2 ast.ArgumentDefinitionList, ignoreNewDefaultValue bool, field string, report mergeConflictReporter) (ast.ArgumentDefinitionList, error) {
 	list1Copy := append(ast.ArgumentDefinitionList{}, list1...)
 	// if the 2 lists are not the same length
 	if len(list1) != len(list2) {
@@ -571,7 +902,7 @@ func mergeArgumentDefinitionList(list1, list2 ast.ArgumentDefinitionList, ignore
 
 		// if the 2 arguments are not the same
 		var err error
-		list1Copy[ix], err = mergeArgumentDefinitions(arg1, arg2, ignoreNewDefaultValue)
+		list1Copy[ix], err = mergeArgumentDefinitions(arg1, arg2, ignoreNewDefaultValue, field, report)
 		if err != nil {
 			return nil, err
 		}
@@ -580,9 +911,12 @@ func mergeArgumentDefinitionList(list1, list2 ast.ArgumentDefinitionList, ignore
 	return list1Copy, nil
 }
 
-func mergeArgumentDefinitions(prevArg *ast.ArgumentDefinition, newArg *ast.ArgumentDefinition, ignoreNewDefaultValue bool) (*ast.ArgumentDefinition, error) {
+func mergeArgumentDefinitions(prevArg *ast.ArgumentDefinition, newArg *ast.ArgumentDefinition, ignoreNewDefaultValue bool, field string, report mergeConflictReporter) (*ast.ArgumentDefinition, error) {
 	result := *prevArg
 	// descriptions
+	if result.Description != "" && newArg.Description != "" && result.Description != newArg.Description {
+		report.report(field, fmt.Sprintf("kept the first description of the argument %s", prevArg.Name))
+	}
 	if result.Description == "" {
 		result.Description = newArg.Description
 	}
@@ -597,6 +931,8 @@ func mergeArgumentDefinitions(prevArg *ast.ArgumentDefinition, newArg *ast.Argum
 		if err := mergeValuesEqual(result.DefaultValue, newArg.DefaultValue); err != nil {
 			return nil, err
 		}
+	} else if mergeValuesEqual(result.DefaultValue, newArg.DefaultValue) != nil {
+		report.report(field, fmt.Sprintf("kept the first default value of the argument %s", prevArg.Name))
 	}
 
 	return &result, nil
@@ -622,9 +958,71 @@ func mergeValuesEqual(value1, value2 *ast.Value) error {
 		return errors.New("encountered different raw values")
 	}
 
+	switch value1.Kind {
+	case ast.ListValue:
+		// lists have to have the same values in the same order
+		if len(value1.Children) != len(value2.Children) {
+			return errors.New("lists do not have the same number of values")
+		}
+		for ix, child := range value1.Children {
+			if err := mergeValuesEqual(child.Value, value2.Children[ix].Value); err != nil {
+				return err
+			}
+		}
+	case ast.ObjectValue:
+		// objects have to have the same fields but they can be in any order
+		if len(value1.Children) != len(value2.Children) {
+			return errors.New("objects do not have the same number of fields")
+		}
+		for _, child := range value1.Children {
+			other := value2.Children.ForName(child.Name)
+			if other == nil {
+				return fmt.Errorf("only one object has a value for %s", child.Name)
+			}
+			if err := mergeValuesEqual(child.Value, other); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
+// mergeTypes returns the type of a field that is declared with both types, which can only differ in their
+// nullability if the strategy allows it
+func mergeTypes(type1, type2 *ast.Type, nullability NullabilityMergeStrategy) (*ast.Type, error) {
+	if nullability == NullabilityStrict || type1 == nil || type2 == nil {
+		return type1, mergeTypesEqual(type1, type2)
+	}
+
+	// name
+	if type1.NamedType != type2.NamedType {
+		return nil, errors.New("types do not have the same name")
+	}
+
+	merged := *type1
+	switch nullability {
+	case NullabilityMostNullable:
+		merged.NonNull = type1.NonNull && type2.NonNull
+	case NullabilityMostStrict:
+		merged.NonNull = type1.NonNull || type2.NonNull
+	}
+
+	// subtypes (ie, non-null string)
+	if (type1.Elem == nil) != (type2.Elem == nil) {
+		return nil, errors.New("one is a list the other isn't")
+	}
+	if type1.Elem != nil {
+		elem, err := mergeTypes(type1.Elem, type2.Elem, nullability)
+		if err != nil {
+			return nil, err
+		}
+		merged.Elem = elem
+	}
+
+	return &merged, nil
+}
+
 func mergeTypesEqual(type1, type2 *ast.Type) error {
 	// if one is null and the other isn't
 	if (type1 == nil && type2 != nil) || (type1 != nil && type2 == nil) {
@@ -769,3 +1167,133 @@ func executableDirectiveLocationDiff(set1, set2 map[ast.DirectiveLocation]struct
 	}
 	return fmt.Sprintf("these locations are not shared: %s", strings.Join(diff, ", "))
 }
+
+// normalizeRootTypes returns a version of the schema whose root operation types use the conventional
+// names (Query, Mutation, and Subscription) so that they line up with the other services. The schema
+// that was passed in is left untouched.
+func normalizeRootTypes(schema *ast.Schema) *ast.Schema {
+	// figure out which of the root types need a new name
+	renames := map[string]string{}
+	for name, root := range map[string]*ast.Definition{
+		typeNameQuery:        schema.Query,
+		typeNameMutation:     schema.Mutation,
+		typeNameSubscription: schema.Subscription,
+	} {
+		if root != nil && root.Name != name {
+			renames[root.Name] = name
+		}
+	}
+
+	// if everything is named the way we expect there's nothing to do
+	if len(renames) == 0 {
+		return schema
+	}
+
+	normalized := *schema
+	normalized.Types = map[string]*ast.Definition{}
+	for name, definition := range schema.Types {
+		definition = renameTypeReferences(definition, renames)
+
+		// if the definition is one of the roots we have to give it its new name
+		if newName, ok := renames[name]; ok {
+			renamed := *definition
+			renamed.Name = newName
+			definition = &renamed
+			name = newName
+		}
+
+		normalized.Types[name] = definition
+	}
+
+	// point the root types at their renamed definitions
+	if schema.Query != nil {
+		normalized.Query = normalized.Types[typeNameQuery]
+	}
+	if schema.Mutation != nil {
+		normalized.Mutation = normalized.Types[typeNameMutation]
+	}
+	if schema.Subscription != nil {
+		normalized.Subscription = normalized.Types[typeNameSubscription]
+	}
+
+	// the type relationships need to refer to the new definitions too
+	renameRelationships := func(relationships map[string][]*ast.Definition) map[string][]*ast.Definition {
+		result := map[string][]*ast.Definition{}
+		for name, definitions := range relationships {
+			if newName, ok := renames[name]; ok {
+				name = newName
+			}
+			for _, definition := range definitions {
+				typeName := definition.Name
+				if newName, ok := renames[typeName]; ok {
+					typeName = newName
+				}
+				if renamed, ok := normalized.Types[typeName]; ok {
+					definition = renamed
+				}
+				result[name] = append(result[name], definition)
+			}
+		}
+		return result
+	}
+	normalized.PossibleTypes = renameRelationships(schema.PossibleTypes)
+	normalized.Implements = renameRelationships(schema.Implements)
+
+	return &normalized
+}
+
+// renameTypeReferences returns a copy of the definition whose fields refer to the renamed types. If the
+// definition doesn't refer to any of them, it is returned as is.
+func renameTypeReferences(definition *ast.Definition, renames map[string]string) *ast.Definition {
+	var fields ast.FieldList
+	changed := false
+	for _, field := range definition.Fields {
+		newField := field
+
+		// the field's type might need to change
+		if fieldType := renameTypeReference(field.Type, renames); fieldType != field.Type {
+			fieldCopy := *field
+			fieldCopy.Type = fieldType
+			newField = &fieldCopy
+		}
+
+		fields = append(fields, newField)
+		if newField != field {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return definition
+	}
+
+	definitionCopy := *definition
+	definitionCopy.Fields = fields
+	return &definitionCopy
+}
+
+// renameTypeReference returns a copy of the type with any renamed types replaced
+func renameTypeReference(t *ast.Type, renames map[string]string) *ast.Type {
+	if t == nil {
+		return t
+	}
+
+	// lists need to look at their element
+	if t.Elem != nil {
+		elem := renameTypeReference(t.Elem, renames)
+		if elem == t.Elem {
+			return t
+		}
+		typeCopy := *t
+		typeCopy.Elem = elem
+		return &typeCopy
+	}
+
+	if newName, ok := renames[t.NamedType]; ok {
+		typeCopy := *t
+		typeCopy.NamedType = newName
+		return &typeCopy
+	}
+
+	return t
+}
@@ -2,6 +2,9 @@ package gateway
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
 	"testing"
 
@@ -119,6 +122,37 @@ func TestMergeSchema_inputTypes(t *testing.T) {
 		}
 	})
 
+	t.Run("OneOf", func(t *testing.T) {
+		t.Parallel()
+		oneOfSchema, err := graphql.LoadSchema(`
+			directive @oneOf on INPUT_OBJECT
+
+			input UserBy @oneOf {
+				id: ID
+			}
+		`)
+		require.NoError(t, err)
+
+		// both services agree that only one field can be given
+		schema, err := testMergeSchemas(t, oneOfSchema, `
+			directive @oneOf on INPUT_OBJECT
+
+			input UserBy @oneOf {
+				id: ID
+			}
+		`)
+		require.NoError(t, err)
+		assert.NotNil(t, schema.Types["UserBy"].Directives.ForName("oneOf"))
+
+		// but they can't disagree
+		_, err = testMergeSchemas(t, oneOfSchema, `
+			input UserBy {
+				id: ID
+			}
+		`)
+		assert.Error(t, err)
+	})
+
 	// the table we are testing
 	testMergeRunNegativeTable(t, []testMergeTableRow{
 		{
@@ -424,6 +458,72 @@ func TestMergeSchema_enums(t *testing.T) {
 	})
 }
 
+func TestMergeSchema_scalars(t *testing.T) {
+	t.Parallel()
+	for _, row := range []struct {
+		Message     string
+		Schema1     string
+		Schema2     string
+		SpecifiedBy string
+	}{
+		{
+			"Matching",
+			`scalar DateTime @specifiedBy(url: "https://example.com/datetime")`,
+			`scalar DateTime @specifiedBy(url: "https://example.com/datetime")`,
+			"https://example.com/datetime",
+		},
+		{
+			"Only First",
+			`scalar DateTime @specifiedBy(url: "https://example.com/datetime")`,
+			`scalar DateTime`,
+			"https://example.com/datetime",
+		},
+		{
+			"Only Second",
+			`scalar DateTime`,
+			`scalar DateTime @specifiedBy(url: "https://example.com/datetime")`,
+			"https://example.com/datetime",
+		},
+		{
+			"Different URLs",
+			`scalar DateTime @specifiedBy(url: "https://example.com/datetime")`,
+			`scalar DateTime @specifiedBy(url: "https://example.com/other")`,
+			"https://example.com/datetime",
+		},
+	} {
+		row := row // enable parallel sub-tests
+		t.Run(row.Message, func(t *testing.T) {
+			t.Parallel()
+			original, err := graphql.LoadSchema(row.Schema1)
+			require.NoError(t, err)
+
+			schema, err := testMergeSchemas(t, original, row.Schema2)
+			require.NoError(t, err)
+
+			directives := schema.Types["DateTime"].Directives
+			assert.Len(t, directives, 1)
+			if specifiedBy := directives.ForName("specifiedBy"); assert.NotNil(t, specifiedBy) {
+				assert.Equal(t, row.SpecifiedBy, specifiedBy.Arguments.ForName("url").Value.Raw)
+			}
+		})
+	}
+
+	// any other directive still has to match
+	testMergeRunNegativeTable(t, []testMergeTableRow{
+		{
+			"Conflicting Directives",
+			`
+				directive @foo on SCALAR
+				scalar DateTime @foo @specifiedBy(url: "https://example.com/datetime")
+			`,
+			`
+				directive @foo on SCALAR
+				scalar DateTime @specifiedBy(url: "https://example.com/datetime")
+			`,
+		},
+	})
+}
+
 func TestMergeSchema_directives(t *testing.T) {
 	t.Parallel()
 	t.Run("Matching", func(t *testing.T) {
@@ -805,6 +905,235 @@ func TestMergeSchema_interfaces(t *testing.T) {
 	})
 }
 
+func TestMergeSchema_typeExtensions(t *testing.T) {
+	t.Parallel()
+	declaration := `
+		directive @cached on OBJECT
+		directive @internal on OBJECT
+
+		"a user of the app"
+		type User @cached {
+			id: ID!
+			name: String!
+		}
+
+		extend type Query {
+			me: User
+		}
+	`
+	extension := `
+		directive @cached on OBJECT
+		directive @internal on OBJECT
+
+		extend type User @internal {
+			email: String!
+		}
+
+		extend type Query {
+			user(id: ID!): User
+		}
+	`
+
+	for _, row := range []struct {
+		Message string
+		Schema1 string
+		Schema2 string
+	}{
+		{"Declaration First", declaration, extension},
+		{"Extension First", extension, declaration},
+	} {
+		row := row // enable parallel sub-tests
+		t.Run(row.Message, func(t *testing.T) {
+			t.Parallel()
+			original, err := graphql.LoadSchema(row.Schema1)
+			require.NoError(t, err)
+
+			schema, err := testMergeSchemas(t, original, row.Schema2)
+			require.NoError(t, err)
+
+			// the extension adds to the declaration
+			user := schema.Types["User"]
+			assert.Equal(t, "a user of the app", user.Description)
+			var fields []string
+			for _, field := range user.Fields {
+				fields = append(fields, field.Name)
+			}
+			assert.Equal(t, []string{"id", "name", "email"}, fields)
+			var directives []string
+			for _, directive := range user.Directives {
+				directives = append(directives, directive.Name)
+			}
+			assert.Equal(t, []string{"cached", "internal"}, directives)
+
+			// every service can extend the root types
+			assert.NotNil(t, schema.Query.Fields.ForName("me"))
+			assert.NotNil(t, schema.Query.Fields.ForName("user"))
+		})
+	}
+
+	t.Run("Multi-byte Text", func(t *testing.T) {
+		t.Parallel()
+		// the positions in the schema count runes, not bytes
+		original, err := graphql.LoadSchema(`
+			# l'utilisateur connecté — ça marche ✓✓✓
+			extend type Query {
+				me: User
+			}
+
+			"un utilisateur de l'app ✓"
+			type User {
+				id: ID!
+			}
+		`)
+		require.NoError(t, err)
+
+		schema, err := testMergeSchemas(t, original, `
+			type Query {
+				user(id: ID!): User
+			}
+
+			# ✓✓✓
+			extend type User {
+				email: String!
+			}
+		`)
+		require.NoError(t, err)
+
+		var fields []string
+		for _, field := range schema.Types["User"].Fields {
+			fields = append(fields, field.Name)
+		}
+		assert.Equal(t, []string{"id", "email"}, fields)
+
+		// an extension is still an extension after some multi-byte text
+		original, err = graphql.LoadSchema(`
+			# ✓✓✓
+			extend type User {
+				id: ID!
+			}
+
+			type Query {
+				me: User
+			}
+		`)
+		require.NoError(t, err)
+
+		_, err = testMergeSchemas(t, original, `
+			# ✓✓✓
+			extend type User {
+				email: String!
+			}
+
+			type Query {
+				user(id: ID!): User
+			}
+		`)
+		var mergeErr *MergeError
+		if assert.True(t, errors.As(err, &mergeErr)) {
+			assert.Equal(t, "User", mergeErr.Type)
+		}
+	})
+
+	t.Run("No Declaration", func(t *testing.T) {
+		t.Parallel()
+		original, err := graphql.LoadSchema(`
+			extend type User {
+				id: ID!
+			}
+
+			type Query {
+				me: User
+			}
+		`)
+		require.NoError(t, err)
+
+		_, err = testMergeSchemas(t, original, `
+			extend type User {
+				email: String!
+			}
+
+			type Query {
+				user(id: ID!): User
+			}
+		`)
+		var mergeErr *MergeError
+		if assert.True(t, errors.As(err, &mergeErr)) {
+			assert.Equal(t, "User", mergeErr.Type)
+			assert.Contains(t, mergeErr.Error(), "User is extended but no service declares it")
+		}
+	})
+}
+
+func TestMergeSchema_defaultValues(t *testing.T) {
+	t.Parallel()
+	input := `
+		input Input {
+			a: Int
+			b: Int
+			list: [Input!]
+		}
+	`
+
+	// default values that say the same thing
+	for _, row := range []testMergeTableRow{
+		{
+			"Reordered object fields",
+			`type Query { field(arg: Input = {a: 1, b: 2}): String }`,
+			`type Query { field(arg: Input = {b: 2, a: 1}): String }`,
+		},
+		{
+			"Reordered fields of objects in lists",
+			`type Query { field(arg: [Input!] = [{a: 1, b: 2}, {a: 3}]): String }`,
+			`type Query { field(arg: [Input!] = [{b: 2, a: 1}, {a: 3}]): String }`,
+		},
+		{
+			"Reordered fields of nested objects",
+			`type Query { field(arg: Input = {list: [{a: 1, b: 2}], a: 3}): String }`,
+			`type Query { field(arg: Input = {a: 3, list: [{b: 2, a: 1}]}): String }`,
+		},
+	} {
+		row := row // enable parallel sub-tests
+		t.Run(row.Message, func(t *testing.T) {
+			t.Parallel()
+			original, err := graphql.LoadSchema(input + row.Schema1)
+			require.NoError(t, err)
+
+			schema, err := testMergeSchemas(t, original, input+row.Schema2)
+			require.NoError(t, err)
+			assert.NotNil(t, schema.Query.Fields.ForName("field").Arguments.ForName("arg").DefaultValue)
+		})
+	}
+
+	// and ones that don't
+	testMergeRunNegativeTable(t, []testMergeTableRow{
+		{
+			"Different object field values",
+			input + `type Query { field(arg: Input = {a: 1, b: 2}): String }`,
+			input + `type Query { field(arg: Input = {a: 1, b: 3}): String }`,
+		},
+		{
+			"Different object fields",
+			input + `type Query { field(arg: Input = {a: 1}): String }`,
+			input + `type Query { field(arg: Input = {b: 1}): String }`,
+		},
+		{
+			"Missing object field",
+			input + `type Query { field(arg: Input = {a: 1, b: 2}): String }`,
+			input + `type Query { field(arg: Input = {a: 1}): String }`,
+		},
+		{
+			"Reordered list",
+			input + `type Query { field(arg: [Int!] = [1, 2]): String }`,
+			input + `type Query { field(arg: [Int!] = [2, 1]): String }`,
+		},
+		{
+			"Different list lengths",
+			input + `type Query { field(arg: [Int!] = [1, 2]): String }`,
+			input + `type Query { field(arg: [Int!] = [1]): String }`,
+		},
+	})
+}
+
 type testMergeTableRow struct {
 	Message string
 	Schema1 string
@@ -833,6 +1162,76 @@ func testMergeRunNegativeTable(t *testing.T, table []testMergeTableRow) {
 	}
 }
 
+func TestMergeSchema_partialServices(t *testing.T) {
+	t.Parallel()
+	original, err := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	require.NoError(t, err)
+
+	// services don't have to have a Query type
+	for _, row := range []struct {
+		Message string
+		Schema  string
+		Check   func(t *testing.T, schema *ast.Schema)
+	}{
+		{"Only Input Objects", `
+			input Filter {
+				name: String
+			}
+		`, func(t *testing.T, schema *ast.Schema) {
+			assert.Equal(t, ast.InputObject, schema.Types["Filter"].Kind)
+		}},
+		{"Only Enums", `
+			enum Color {
+				RED
+				BLUE
+			}
+		`, func(t *testing.T, schema *ast.Schema) {
+			assert.Len(t, schema.Types["Color"].EnumValues, 2)
+		}},
+		{"Only a Mutation", `
+			type Mutation {
+				setValue(value: String!): String!
+			}
+		`, func(t *testing.T, schema *ast.Schema) {
+			assert.NotNil(t, schema.Mutation.Fields.ForName("setValue"))
+		}},
+	} {
+		row := row // enable parallel sub-tests
+		t.Run(row.Message, func(t *testing.T) {
+			t.Parallel()
+			schema, err := testMergeSchemas(t, original, row.Schema)
+			require.NoError(t, err)
+
+			// the query type of the other service is still there
+			assert.NotNil(t, schema.Query.Fields.ForName("value"))
+			row.Check(t, schema)
+		})
+	}
+
+	t.Run("No Root Types", func(t *testing.T) {
+		t.Parallel()
+		inputs, err := graphql.LoadSchema(`
+			input Filter {
+				name: String
+			}
+		`)
+		require.NoError(t, err)
+		enums, err := graphql.LoadSchema(`
+			enum Color {
+				RED
+			}
+		`)
+		require.NoError(t, err)
+
+		_, err = mergeSchemas([]*ast.Schema{inputs, enums})
+		assert.EqualError(t, err, "none of the schemas define a Query, Mutation, or Subscription type")
+	})
+}
+
 func testMergeSchemas(t *testing.T, schema1 *ast.Schema, schema2Str string) (*ast.Schema, error) {
 	t.Helper()
 	// create a schema with the provided content
@@ -930,6 +1329,8 @@ func TestMergeSchema_flexibleDeterministicMerges(t *testing.T) {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -960,6 +1361,8 @@ type User {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -991,6 +1394,8 @@ type User {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -1021,6 +1426,8 @@ type User {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -1052,6 +1459,8 @@ type User {
 other-description
 """
 directive @foo on FIELD_DEFINITION
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -1076,6 +1485,8 @@ type Query {
 description
 """
 directive @foo on FIELD_DEFINITION
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -1101,6 +1512,8 @@ type Query {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 enum Foo {
 	"""
 	description
@@ -1131,6 +1544,8 @@ type Query {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 enum Foo {
 	"""
 	description
@@ -1162,6 +1577,8 @@ type Query {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 """
 description
 """
@@ -1192,6 +1609,8 @@ type Query {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 """
 description
 """
@@ -1223,6 +1642,8 @@ type Query {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 """
 description
 """
@@ -1253,6 +1674,8 @@ type Query {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 """
 description
 """
@@ -1288,6 +1711,8 @@ type Query {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 type Foo {
 	name(
 		"""
@@ -1324,6 +1749,8 @@ type Query {
 			`,
 			},
 			expectSchema: `
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 type Foo {
 	name(
 		"""
@@ -1407,6 +1834,8 @@ func TestMergeSchema_multipleInterfaces(t *testing.T) {
 	formatter.NewFormatter(&currentSchemaBuf).FormatSchema(currentSchema)
 	currentSchemaStr := strings.TrimSpace(currentSchemaBuf.String())
 	assert.Equal(t, strings.TrimSpace(`
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 type Bar implements Baz & Foo & Node {
 	id: ID!
 	foo: String
@@ -1441,6 +1870,8 @@ func TestMergeSchema_directiveLocations(t *testing.T) {
 			schema2:     `directive @foo on INPUT_OBJECT | INPUT_FIELD_DEFINITION`,
 			expectMergedSchema: `
 directive @foo on INPUT_FIELD_DEFINITION | INPUT_OBJECT
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -1455,6 +1886,8 @@ type Query {
 			schema2:     `directive @foo on SCALAR | OBJECT`,
 			expectMergedSchema: `
 directive @foo on OBJECT | SCALAR | SCHEMA
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -1475,6 +1908,8 @@ type Query {
 			schema2:     `directive @foo on FIELD | SCALAR`,
 			expectMergedSchema: `
 directive @foo on FIELD | OBJECT | SCALAR | SCHEMA
+directive @stream(initialCount: Int = 0, label: String) on FIELD
+directive @timeout(ms: Int!) on QUERY | MUTATION
 interface Node {
 	id: ID!
 }
@@ -1505,3 +1940,363 @@ type Query {
 		})
 	}
 }
+
+func TestMergeSchema_nonStandardRootTypeNames(t *testing.T) {
+	t.Parallel()
+	schema1, err := graphql.LoadSchema(`
+		schema {
+			query: RootQuery
+			mutation: RootMutation
+		}
+
+		type RootQuery {
+			allUsers: [String!]!
+		}
+
+		type Payload {
+			query: RootQuery
+		}
+
+		type RootMutation {
+			addUser(name: String!): Payload
+		}
+	`)
+	require.NoError(t, err)
+
+	schema2, err := graphql.LoadSchema(`
+		type Query {
+			allPhotos: [String!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema1, URL: "url1"},
+		{Schema: schema2, URL: "url2"},
+	})
+	require.NoError(t, err)
+
+	// the roots should have been merged under their conventional names
+	require.NotNil(t, gateway.schema.Query)
+	assert.Equal(t, typeNameQuery, gateway.schema.Query.Name)
+	assert.NotNil(t, gateway.schema.Query.Fields.ForName("allUsers"))
+	assert.NotNil(t, gateway.schema.Query.Fields.ForName("allPhotos"))
+	require.NotNil(t, gateway.schema.Mutation)
+	assert.Equal(t, typeNameMutation, gateway.schema.Mutation.Name)
+	assert.NotContains(t, gateway.schema.Types, "RootQuery")
+	assert.NotContains(t, gateway.schema.Types, "RootMutation")
+
+	// references to the root types should follow the rename
+	assert.Equal(t, typeNameQuery, gateway.schema.Types["Payload"].Fields.ForName("query").Type.Name())
+
+	// and we should know where to find the fields
+	urls, err := gateway.fieldURLs.URLFor(typeNameQuery, "allUsers")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"url1"}, urls)
+
+	// the source schema should not have been modified
+	assert.Equal(t, "RootQuery", schema1.Query.Name)
+	assert.Contains(t, schema1.Types, "RootQuery")
+}
+
+func TestMergeSchema_defaultMergerHooks(t *testing.T) {
+	t.Parallel()
+	schema1, err := graphql.LoadSchema(`
+		type Photo {
+			url: String!
+		}
+
+		union Media = Photo
+
+		type Query {
+			media: [Media!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	schema2, err := graphql.LoadSchema(`
+		type Video {
+			url: String!
+		}
+
+		union Media = Video
+
+		type Query {
+			videos: [Video!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	sources := []*graphql.RemoteSchema{
+		{Schema: schema1, URL: "url1"},
+		{Schema: schema2, URL: "url2"},
+	}
+
+	// the built-in behavior won't merge unions with different members
+	_, err = New(sources)
+	require.Error(t, err)
+
+	// combine the members of the unions and keep merging everything else as usual
+	gateway, err := New(sources, WithMerger(&DefaultMerger{
+		Unions: func(previous *ast.Definition, new *ast.Definition) (*ast.Definition, error) {
+			merged := *previous
+			merged.Types = mergeInterfaceNames(previous.Types, new.Types)
+			return &merged, nil
+		},
+	}))
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"Photo", "Video"}, gateway.schema.Types["Media"].Types)
+	possibleTypes := []string{}
+	for _, definition := range gateway.schema.GetPossibleTypes(gateway.schema.Types["Media"]) {
+		possibleTypes = append(possibleTypes, definition.Name)
+	}
+	assert.ElementsMatch(t, []string{"Photo", "Video"}, possibleTypes)
+	assert.NotNil(t, gateway.schema.Query.Fields.ForName("media"))
+	assert.NotNil(t, gateway.schema.Query.Fields.ForName("videos"))
+}
+
+func TestMergeSchema_nullabilityStrategies(t *testing.T) {
+	t.Parallel()
+	schema1, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String
+			nicknames: [String!]!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			users: [User!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	schema2, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+			nicknames: [String]
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		strategy  NullabilityMergeStrategy
+		options   []Option
+		err       string
+		userName  string
+		nicknames string
+	}{
+		{
+			name:     "strict",
+			strategy: NullabilityStrict,
+			err:      "types do not have the same nullability constraints",
+		},
+		{
+			name:      "most nullable",
+			strategy:  NullabilityMostNullable,
+			userName:  "String",
+			nicknames: "[String]",
+		},
+		{
+			name:      "most strict",
+			strategy:  NullabilityMostStrict,
+			options:   []Option{WithNullBubbling()},
+			userName:  "String!",
+			nicknames: "[String!]!",
+		},
+		{
+			name:     "most strict without null bubbling",
+			strategy: NullabilityMostStrict,
+			err:      "the NullabilityMostStrict merge strategy needs WithNullBubbling",
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			gateway, err := New([]*graphql.RemoteSchema{
+				{Schema: schema1, URL: "url1"},
+				{Schema: schema2, URL: "url2"},
+			}, append(tc.options, WithNullabilityMergeStrategy(tc.strategy))...)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			user := gateway.schema.Types["User"]
+			assert.Equal(t, tc.userName, user.Fields.ForName("name").Type.String())
+			assert.Equal(t, tc.nicknames, user.Fields.ForName("nicknames").Type.String())
+
+			// the field should still be a valid implementation of the interface
+			assert.Equal(t, "ID!", gateway.schema.Types["Node"].Fields.ForName("id").Type.String())
+
+			// the source schemas should not have been modified
+			assert.Equal(t, "String", schema1.Types["User"].Fields.ForName("name").Type.String())
+			assert.Equal(t, "String!", schema2.Types["User"].Fields.ForName("name").Type.String())
+		})
+	}
+}
+
+func TestMergeSchema_nullabilityStrategiesInputs(t *testing.T) {
+	t.Parallel()
+	schema1, err := graphql.LoadSchema(`
+		input UserInput {
+			name: String
+		}
+
+		type Query {
+			users(filter: UserInput, first: Int): [String!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	schema2, err := graphql.LoadSchema(`
+		input UserInput {
+			name: String!
+		}
+
+		type Query {
+			users(filter: UserInput, first: Int): [String!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	// a service could reject a value that another one allows so inputs always have to agree
+	for _, strategy := range []NullabilityMergeStrategy{NullabilityMostNullable, NullabilityMostStrict} {
+		_, err := New([]*graphql.RemoteSchema{
+			{Schema: schema1, URL: "url1"},
+			{Schema: schema2, URL: "url2"},
+		}, WithNullabilityMergeStrategy(strategy), WithNullBubbling())
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "types do not have the same nullability constraints")
+		}
+	}
+}
+
+func TestMergeSchema_conflictReporter(t *testing.T) {
+	t.Parallel()
+	schema1, err := graphql.LoadSchema(`
+		directive @cached on FIELD_DEFINITION
+
+		scalar DateTime @specifiedBy(url: "https://example.com/date-time")
+
+		interface Node {
+			id: ID!
+		}
+
+		"A person"
+		type User implements Node {
+			id: ID!
+			"Their full name"
+			name(
+				"How to format it"
+				format: String
+			): String
+			joined: DateTime
+		}
+
+		enum Role {
+			"Can do anything"
+			ADMIN
+			MEMBER
+		}
+
+		type Query {
+			node(id: ID!): Node
+			users: [User!]!
+			role: Role
+		}
+	`)
+	require.NoError(t, err)
+
+	schema2, err := graphql.LoadSchema(`
+		directive @cached on FIELD_DEFINITION | OBJECT
+
+		scalar DateTime @specifiedBy(url: "https://example.com/timestamp")
+
+		interface Node {
+			id: ID!
+		}
+
+		interface Named {
+			name(format: String): String!
+		}
+
+		"Someone who uses the app"
+		type User implements Node & Named {
+			id: ID!
+			"The name they go by"
+			name(
+				"The format of the name"
+				format: String
+			): String!
+		}
+
+		enum Role {
+			"Can do everything"
+			ADMIN
+			MEMBER
+		}
+
+		type Query {
+			node(id: ID!): Node
+			roles: [Role!]!
+		}
+	`)
+	require.NoError(t, err)
+
+	conflicts := []MergeConflict{}
+	_, err = New([]*graphql.RemoteSchema{
+		{Schema: schema1, URL: "url1"},
+		{Schema: schema2, URL: "url2"},
+	},
+		WithNullabilityMergeStrategy(NullabilityMostNullable),
+		WithMergeConflictReporter(func(conflict MergeConflict) {
+			conflicts = append(conflicts, conflict)
+		}),
+	)
+	require.NoError(t, err)
+
+	// the types are merged in no particular order
+	sort.Slice(conflicts, func(i, j int) bool {
+		return fmt.Sprint(conflicts[i]) < fmt.Sprint(conflicts[j])
+	})
+	urls := []string{"url1", "url2"}
+	assert.Equal(t, []MergeConflict{
+		{Type: "@cached", Sources: []int{0, 1}, URLs: urls, Resolution: "allowed the directive on the locations of both definitions"},
+		{Type: "DateTime", Sources: []int{0, 1}, URLs: urls, Resolution: "kept the first @specifiedBy"},
+		{Type: "Role", Field: "ADMIN", Sources: []int{0, 1}, URLs: urls, Resolution: "kept the first description"},
+		{Type: "User", Sources: []int{0, 1}, URLs: urls, Resolution: "implemented the interfaces of both definitions"},
+		{Type: "User", Sources: []int{0, 1}, URLs: urls, Resolution: "kept the first description"},
+		{Type: "User", Field: "name", Sources: []int{0, 1}, URLs: urls, Resolution: "kept the first description of the argument format"},
+		{Type: "User", Field: "name", Sources: []int{0, 1}, URLs: urls, Resolution: "kept the first description"},
+		{Type: "User", Field: "name", Sources: []int{0, 1}, URLs: urls, Resolution: "merged the types String and String! into String"},
+	}, conflicts)
+
+	// schemas that agree don't have anything to report
+	conflicts = []MergeConflict{}
+	_, err = New([]*graphql.RemoteSchema{
+		{Schema: schema1, URL: "url1"},
+		{Schema: schema1, URL: "url2"},
+	}, WithMergeConflictReporter(func(conflict MergeConflict) {
+		conflicts = append(conflicts, conflict)
+	}))
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+}
@@ -1,10 +1,13 @@
 package gateway
 
 import (
+	"encoding/json"
 	"errors"
+	"strconv"
 	"sync"
 
 	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
 )
 
 // Middleware are things that can modify a gateway normal execution
@@ -26,6 +29,43 @@ type RequestMiddleware graphql.NetworkMiddleware
 // Middleware marks RequestMiddleware as a valid middleware
 func (p RequestMiddleware) Middleware() {}
 
+// MiddlewareAbort can be returned by a RequestMiddleware to reject an operation before it reaches a service, for
+// example because the client's token expired. The GraphQLHandler responds to the operation with the status code
+// (unless it is zero) and a single error with the code and message, in place of anything else the operation did.
+type MiddlewareAbort struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *MiddlewareAbort) Error() string {
+	return e.Message
+}
+
+// MarshalJSON serializes the abort like any other GraphQL error
+func (e *MiddlewareAbort) MarshalJSON() ([]byte, error) {
+	return json.Marshal(graphql.NewError(e.Code, e.Message))
+}
+
+// findMiddlewareAbort returns the first MiddlewareAbort in the error, which could be a list of errors
+func findMiddlewareAbort(err error) *MiddlewareAbort {
+	var errList graphql.ErrorList
+	if errors.As(err, &errList) {
+		for _, listErr := range errList {
+			if abort := findMiddlewareAbort(listErr); abort != nil {
+				return abort
+			}
+		}
+		return nil
+	}
+
+	var abort *MiddlewareAbort
+	if errors.As(err, &abort) {
+		return abort
+	}
+	return nil
+}
+
 // ResponseMiddleware is a middleware that can modify the
 // response before it is serialized and sent to the user
 type ResponseMiddleware func(ctx *ExecutionContext, response map[string]interface{}) error
@@ -41,11 +81,30 @@ func (p ResponseMiddleware) ExecutionMiddleware() {}
 func scrubInsertionIDs(ctx *ExecutionContext, response map[string]interface{}) error {
 	lock := sync.Mutex{}
 
+	// we find the objects to clean up with their id (or __typename if they don't have one)
+	// so those have to be the last fields we remove
+	fields := []string{}
+	for field := range ctx.Plan.FieldsToScrub {
+		if field != "id" && field != typenameField {
+			fields = append(fields, field)
+		}
+	}
+	for _, field := range []string{"id", typenameField} {
+		if _, ok := ctx.Plan.FieldsToScrub[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+
+	hasTypename := func(entry map[string]interface{}) bool {
+		_, ok := entry[typenameField]
+		return ok
+	}
+
 	// there are many fields to scrub
-	for field, locations := range ctx.Plan.FieldsToScrub {
-		for _, location := range locations {
+	for _, field := range fields {
+		for _, location := range ctx.Plan.FieldsToScrub[field] {
 			// look for the insertion points in the response for the field
-			insertionPoints, err := executorFindInsertionPoints(ctx, &lock, location, ctx.Plan.Operation.SelectionSet, response, [][]string{{}}, ctx.Plan.FragmentDefinitions)
+			insertionPoints, err := executorFindInsertionPoints(ctx, &lock, location, ctx.Plan.Operation.SelectionSet, response, [][]string{{}}, ctx.Plan.FragmentDefinitions, hasTypename)
 			if err != nil {
 				return err
 			}
@@ -54,6 +113,9 @@ func scrubInsertionIDs(ctx *ExecutionContext, response map[string]interface{}) e
 			for _, point := range insertionPoints {
 				// extract the obj at that point
 				value, err := executorExtractValue(ctx, response, &lock, point)
+				if err == errNullInsertionPoint {
+					continue
+				}
 				if err != nil {
 					return err
 				}
@@ -73,3 +135,95 @@ func scrubInsertionIDs(ctx *ExecutionContext, response map[string]interface{}) e
 	// the first thing we have to do is flatten all of the fragments into a single
 	return nil
 }
+
+// ResponseVisitor is called with the value of each field in the response along with its path and the name of its
+// type, once the response has been put together. Entries of lists are visited on their own with their index in the
+// path. If the visitor returns true, the value is replaced with the one it returned and the fields under it are not
+// visited. It isn't called for null values.
+type ResponseVisitor func(path []string, typeName string, value interface{}) (interface{}, bool)
+
+// WithResponseVisitor returns an Option that walks every response with the visitor, for example to mask the values
+// of a scalar that holds personal information. The visitor runs after the response middlewares.
+func WithResponseVisitor(visitor ResponseVisitor) Option {
+	return func(g *Gateway) {
+		g.responseVisitor = visitor
+	}
+}
+
+// visitResponse is the response middleware that walks the response with the gateway's visitor
+func (g *Gateway) visitResponse(ctx *ExecutionContext, response map[string]interface{}) error {
+	if ctx.Plan == nil || ctx.Plan.Operation == nil {
+		return nil
+	}
+
+	visitResponseObject(g.responseVisitor, ctx.Plan.FragmentDefinitions, []string{}, ctx.Plan.Operation.SelectionSet, response)
+	return nil
+}
+
+// visitResponseObject visits the fields of the object that were asked for in the selection set
+func visitResponseObject(visitor ResponseVisitor, fragments ast.FragmentDefinitionList, path []string, selectionSet ast.SelectionSet, object map[string]interface{}) {
+	// the same field could be selected more than once (in different fragments) so we have to merge their selections
+	keys := []string{}
+	definitions := map[string]*ast.FieldDefinition{}
+	selections := map[string]ast.SelectionSet{}
+
+	var collect func(selectionSet ast.SelectionSet)
+	collect = func(selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				key := selection.Alias
+				if key == "" {
+					key = selection.Name
+				}
+				if _, seen := selections[key]; !seen {
+					keys = append(keys, key)
+					definitions[key] = selection.Definition
+				}
+				selections[key] = append(selections[key], selection.SelectionSet...)
+			case *ast.InlineFragment:
+				collect(selection.SelectionSet)
+			case *ast.FragmentSpread:
+				if fragment := fragments.ForName(selection.Name); fragment != nil {
+					collect(fragment.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(selectionSet)
+
+	for _, key := range keys {
+		value, ok := object[key]
+		if !ok || definitions[key] == nil {
+			continue
+		}
+
+		fieldPath := append(append(make([]string, 0, len(path)+1), path...), key)
+		object[key] = visitResponseValue(visitor, fragments, fieldPath, definitions[key].Type, selections[key], value)
+	}
+}
+
+// visitResponseValue visits a value of the given type and returns the value that should take its place
+func visitResponseValue(visitor ResponseVisitor, fragments ast.FragmentDefinitionList, path []string, typ *ast.Type, selectionSet ast.SelectionSet, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	// each entry of a list is visited on its own
+	if list, ok := value.([]interface{}); ok && typ.Elem != nil {
+		for i, entry := range list {
+			entryPath := append(append(make([]string, 0, len(path)+1), path...), strconv.Itoa(i))
+			list[i] = visitResponseValue(visitor, fragments, entryPath, typ.Elem, selectionSet, entry)
+		}
+		return list
+	}
+
+	if replacement, replace := visitor(path, typ.Name(), value); replace {
+		return replacement
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		visitResponseObject(visitor, fragments, path, selectionSet, object)
+	}
+	return value
+}
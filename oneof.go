@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// oneOfDirective marks an input object that must be given exactly one of its fields
+const oneOfDirective = "oneOf"
+
+// validateOneOf makes sure that every @oneOf input object in the operation, whether it comes from the
+// query itself or from the variables, has exactly one non-null field
+func validateOneOf(schema *ast.Schema, operation *ast.OperationDefinition, fragments ast.FragmentDefinitionList, variables map[string]interface{}) error {
+	// look at the variables first
+	for _, definition := range operation.VariableDefinitions {
+		value, ok := variables[definition.Variable]
+		if !ok {
+			continue
+		}
+		if err := validateOneOfVariable(schema, definition.Type, value); err != nil {
+			return err
+		}
+	}
+
+	// and then any values written in the query
+	visited := Set{}
+	var walk func(selectionSet ast.SelectionSet) error
+	walk = func(selectionSet ast.SelectionSet) error {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				for _, argument := range selection.Arguments {
+					if err := validateOneOfValue(argument.Value, variables); err != nil {
+						return err
+					}
+				}
+				if err := walk(selection.SelectionSet); err != nil {
+					return err
+				}
+			case *ast.InlineFragment:
+				if err := walk(selection.SelectionSet); err != nil {
+					return err
+				}
+			case *ast.FragmentSpread:
+				definition := fragments.ForName(selection.Name)
+				if definition == nil || visited.Has(selection.Name) {
+					continue
+				}
+				visited.Add(selection.Name)
+				if err := walk(definition.SelectionSet); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return walk(operation.SelectionSet)
+}
+
+// validateOneOfValue checks a value that was written in the query
+func validateOneOfValue(value *ast.Value, variables map[string]interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	switch value.Kind {
+	case ast.ListValue:
+		for _, child := range value.Children {
+			if err := validateOneOfValue(child.Value, variables); err != nil {
+				return err
+			}
+		}
+	case ast.ObjectValue:
+		if value.Definition != nil && value.Definition.Directives.ForName(oneOfDirective) != nil {
+			if len(value.Children) != 1 {
+				return oneOfCountError(value.Definition.Name)
+			}
+
+			// the value could be null directly or through a variable
+			field := value.Children[0]
+			isNull := field.Value.Kind == ast.NullValue
+			if field.Value.Kind == ast.Variable {
+				isNull = variables[field.Value.Raw] == nil
+			}
+			if isNull {
+				return oneOfNullError(value.Definition.Name, field.Name)
+			}
+		}
+
+		for _, child := range value.Children {
+			if err := validateOneOfValue(child.Value, variables); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateOneOfVariable checks the value of a variable with the given type
+func validateOneOfVariable(schema *ast.Schema, typ *ast.Type, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	// lists have to check each of their entries
+	if typ.Elem != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			return validateOneOfVariable(schema, typ.Elem, value)
+		}
+		for _, entry := range list {
+			if err := validateOneOfVariable(schema, typ.Elem, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	definition := schema.Types[typ.NamedType]
+	object, ok := value.(map[string]interface{})
+	if definition == nil || definition.Kind != ast.InputObject || !ok {
+		return nil
+	}
+
+	if definition.Directives.ForName(oneOfDirective) != nil {
+		if len(object) != 1 {
+			return oneOfCountError(definition.Name)
+		}
+		for name, fieldValue := range object {
+			if fieldValue == nil {
+				return oneOfNullError(definition.Name, name)
+			}
+		}
+	}
+
+	for name, fieldValue := range object {
+		field := definition.Fields.ForName(name)
+		if field == nil {
+			continue
+		}
+		if err := validateOneOfVariable(schema, field.Type, fieldValue); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func oneOfCountError(typeName string) error {
+	return graphql.NewError("BAD_USER_INPUT", fmt.Sprintf("OneOf input object %s must specify exactly one field", typeName))
+}
+
+func oneOfNullError(typeName string, field string) error {
+	return graphql.NewError("BAD_USER_INPUT", fmt.Sprintf("field %s.%s must be non-null", typeName, field))
+}
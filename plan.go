@@ -3,10 +3,15 @@ package gateway
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+	"github.com/vektah/gqlparser/v2/validator"
 
 	"github.com/nautilus/graphql"
 )
@@ -22,12 +27,30 @@ type QueryPlanStep struct {
 	ParentType   string
 	ParentID     string
 	SelectionSet ast.SelectionSet
+	// the url of the service the step is sent to (empty for the root step)
+	Location string
 
 	// pre-generated query stuff
 	QueryDocument       *ast.QueryDocument
 	QueryString         string
 	FragmentDefinitions ast.FragmentDefinitionList
 	Variables           Set
+
+	// the variables that hold fields of the object the step is inserted into. The name of each
+	// variable is the name of the field with a prefix.
+	Requires ast.VariableDefinitionList
+
+	// if the step is inserted into an interface or union but only applies to some of its types,
+	// the names of those types. The executor skips objects whose __typename isn't in the set.
+	PossibleTypes Set
+
+	// Extra holds whatever a custom planner wants to pass along to a custom executor, like a routing key
+	// computed at plan time. The built-in planner and executor never set or read it, and the copies of the
+	// step given to the BeforeStepHook and AfterStepHook share it with the original.
+	Extra map[string]interface{}
+
+	// the paths to the interfaces and unions that the planner asked the __typename of for the client
+	addedTypenames [][]string
 }
 
 // QueryPlan is the full plan to resolve a particular query
@@ -38,6 +61,21 @@ type QueryPlan struct {
 	FieldsToScrub       map[string][][]string
 }
 
+// singleStep returns the only step of a plan that can be resolved by sending one query to one service, or nil
+// if the plan has to stitch together the results of more than one query
+func (p *QueryPlan) singleStep() *QueryPlanStep {
+	if p.RootStep == nil || len(p.RootStep.Then) != 1 {
+		return nil
+	}
+
+	step := p.RootStep.Then[0]
+	if len(step.Then) > 0 || len(step.InsertionPoint) > 0 {
+		return nil
+	}
+
+	return step
+}
+
 type newQueryPlanStepPayload struct {
 	Plan           *QueryPlan
 	Location       string
@@ -47,6 +85,8 @@ type newQueryPlanStepPayload struct {
 	InsertionPoint []string
 	Fragments      ast.FragmentDefinitionList
 	Wrapper        ast.SelectionSet
+	PossibleTypes  Set
+	Requires       ast.VariableDefinitionList
 }
 
 // QueryPlanner is responsible for taking a string with a graphql query and returns
@@ -65,18 +105,51 @@ type PlannerWithLocationPriorities interface {
 	WithLocationPriorities(priorities []string) QueryPlanner
 }
 
+// PlannerWithUpstreamQueryers is an interface for planners with queryers registered for specific urls
+type PlannerWithUpstreamQueryers interface {
+	WithUpstreamQueryers(queryers map[string]graphql.Queryer) QueryPlanner
+}
+
+// PlannerWithUnknownDirectivePolicy is an interface for planners that can be told what to do with directives
+// that the schema does not declare
+type PlannerWithUnknownDirectivePolicy interface {
+	WithUnknownDirectivePolicy(policy UnknownDirectivePolicy) QueryPlanner
+}
+
+// PlannerWithParsedQueryCacheSize is an interface for planners that can hold onto the documents
+// of the queries they have parsed
+type PlannerWithParsedQueryCacheSize interface {
+	WithParsedQueryCacheSize(size int) QueryPlanner
+}
+
+// UnknownDirectivePolicy decides what happens to queries that use directives the schema does not declare
+type UnknownDirectivePolicy int
+
+const (
+	// UnknownDirectivesError rejects the query. This is the default.
+	UnknownDirectivesError UnknownDirectivePolicy = iota
+	// UnknownDirectivesIgnore logs a warning and removes the directives before planning
+	UnknownDirectivesIgnore
+)
+
 // QueryerFactory is a function that returns the queryer to use depending on the context
 type QueryerFactory func(ctx *PlanningContext, url string) graphql.Queryer
 
 // Planner is meant to be embedded in other QueryPlanners to share configuration
 type Planner struct {
 	QueryerFactory *QueryerFactory
+	// UpstreamQueryers are used for their url instead of the factory
+	UpstreamQueryers map[string]graphql.Queryer
 }
 
 // MinQueriesPlanner does the most basic level of query planning
 type MinQueriesPlanner struct {
 	Planner
-	LocationPriorities []string
+	LocationPriorities     []string
+	UnknownDirectivePolicy UnknownDirectivePolicy
+
+	// the most recently parsed query documents. A nil cache parses every query.
+	parsedQueries *parsedQueryCache
 }
 
 // WithQueryerFactory returns a version of the planner with the factory set
@@ -85,23 +158,47 @@ func (p *MinQueriesPlanner) WithQueryerFactory(factory *QueryerFactory) QueryPla
 	return p
 }
 
+// WithUpstreamQueryers returns a version of the planner that uses the given queryers for their urls
+func (p *MinQueriesPlanner) WithUpstreamQueryers(queryers map[string]graphql.Queryer) QueryPlanner {
+	p.Planner.UpstreamQueryers = queryers
+	return p
+}
+
 func (p *MinQueriesPlanner) WithLocationPriorities(priorities []string) QueryPlanner {
 	p.LocationPriorities = priorities
 	return p
 }
 
+// WithUnknownDirectivePolicy returns a version of the planner that handles unknown directives with the given policy
+func (p *MinQueriesPlanner) WithUnknownDirectivePolicy(policy UnknownDirectivePolicy) QueryPlanner {
+	p.UnknownDirectivePolicy = policy
+	return p
+}
+
+// WithParsedQueryCacheSize returns a version of the planner that holds onto the documents of the
+// given number of queries so they don't have to be parsed and validated again. A size of 0 disables the cache.
+func (p *MinQueriesPlanner) WithParsedQueryCacheSize(size int) QueryPlanner {
+	p.parsedQueries = nil
+	if size > 0 {
+		p.parsedQueries = newParsedQueryCache(size)
+	}
+	return p
+}
+
 // PlanningContext is the input struct to the Plan method
 type PlanningContext struct {
 	Query     string
 	Schema    *ast.Schema
 	Locations FieldURLMap
 	Gateway   *Gateway
+	// LocationPriorities are the locations to prefer for this query, ahead of the planner's own priorities
+	LocationPriorities []string
 }
 
 // Plan computes the nested selections that will need to be performed
 func (p *MinQueriesPlanner) Plan(ctx *PlanningContext) (QueryPlanList, error) {
 	// the first thing to do is to parse the query
-	parsedQuery, e := gqlparser.LoadQuery(ctx.Schema, ctx.Query)
+	parsedQuery, e := p.loadQuery(ctx)
 	if e != nil {
 		return nil, e
 	}
@@ -127,6 +224,237 @@ func (p *MinQueriesPlanner) Plan(ctx *PlanningContext) (QueryPlanList, error) {
 	return plans, nil
 }
 
+// loadQuery returns the parsed and validated query, using a previously parsed document if there is one
+func (p *MinQueriesPlanner) loadQuery(ctx *PlanningContext) (*ast.QueryDocument, gqlerror.List) {
+	var document *ast.QueryDocument
+	var ignored []string
+	cached := false
+	if p.parsedQueries != nil {
+		document, ignored, cached = p.parsedQueries.get(ctx.Schema, ctx.Query)
+	}
+
+	if !cached {
+		var errs gqlerror.List
+		document, ignored, errs = p.parseQuery(ctx)
+		if errs != nil {
+			return nil, errs
+		}
+		if p.parsedQueries != nil {
+			p.parsedQueries.set(ctx.Schema, ctx.Query, document, ignored)
+		}
+	}
+
+	// whoever sent the query should hear about the parts that were left out every time, not just the first
+	for _, warning := range ignored {
+		ctx.Gateway.logger.Warn(warning)
+	}
+
+	return document, nil
+}
+
+// parseQuery parses and validates the query, applying the planner's policy for unknown directives. Along with
+// the document, it returns a warning for each part of the query that was left out.
+func (p *MinQueriesPlanner) parseQuery(ctx *PlanningContext) (*ast.QueryDocument, []string, gqlerror.List) {
+	lenientFragments := ctx.Gateway != nil && ctx.Gateway.lenientFragments
+	if p.UnknownDirectivePolicy != UnknownDirectivesIgnore && !lenientFragments {
+		query, errs := gqlparser.LoadQuery(ctx.Schema, ctx.Query)
+		return query, nil, errs
+	}
+
+	query, err := parser.ParseQuery(&ast.Source{Input: ctx.Query})
+	if err != nil {
+		return nil, nil, gqlerror.List{gqlerror.WrapIfUnwrapped(err)}
+	}
+
+	// remove the directives the schema doesn't know about before we validate the rest of the query
+	ignored := []string{}
+	if p.UnknownDirectivePolicy == UnknownDirectivesIgnore {
+		stripper := &directiveStripper{schema: ctx.Schema, removed: Set{}}
+		stripper.stripDocument(query)
+		for name := range stripper.removed {
+			ignored = append(ignored, fmt.Sprintf("ignoring unknown directive @%s", name))
+		}
+	}
+
+	// the same goes for fragments that can never apply where they are used
+	if lenientFragments {
+		stripper := &fragmentStripper{schema: ctx.Schema, query: query, removed: Set{}, dropped: Set{}}
+		stripper.stripDocument()
+		for name := range stripper.removed {
+			ignored = append(ignored, fmt.Sprintf("ignoring fragment on %s", name))
+		}
+	}
+
+	if errs := validator.Validate(ctx.Schema, query); len(errs) > 0 {
+		return nil, nil, errs
+	}
+	return query, ignored, nil
+}
+
+// directiveStripper removes the directives that are not declared by a schema from a query
+type directiveStripper struct {
+	schema  *ast.Schema
+	removed Set
+}
+
+func (s *directiveStripper) stripDocument(query *ast.QueryDocument) {
+	for _, operation := range query.Operations {
+		operation.Directives = s.strip(operation.Directives)
+		for _, variable := range operation.VariableDefinitions {
+			variable.Directives = s.strip(variable.Directives)
+		}
+		s.stripSelectionSet(operation.SelectionSet)
+	}
+	for _, fragment := range query.Fragments {
+		fragment.Directives = s.strip(fragment.Directives)
+		s.stripSelectionSet(fragment.SelectionSet)
+	}
+}
+
+func (s *directiveStripper) stripSelectionSet(selectionSet ast.SelectionSet) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			selection.Directives = s.strip(selection.Directives)
+			s.stripSelectionSet(selection.SelectionSet)
+		case *ast.FragmentSpread:
+			selection.Directives = s.strip(selection.Directives)
+		case *ast.InlineFragment:
+			selection.Directives = s.strip(selection.Directives)
+			s.stripSelectionSet(selection.SelectionSet)
+		}
+	}
+}
+
+func (s *directiveStripper) strip(directives ast.DirectiveList) ast.DirectiveList {
+	if len(directives) == 0 {
+		return directives
+	}
+
+	known := ast.DirectiveList{}
+	for _, directive := range directives {
+		if _, ok := s.schema.Directives[directive.Name]; ok {
+			known = append(known, directive)
+		} else {
+			s.removed.Add(directive.Name)
+		}
+	}
+	return known
+}
+
+// fragmentStripper removes the fragments of a query whose type condition is unknown or can never
+// match the type they are used in
+type fragmentStripper struct {
+	schema  *ast.Schema
+	query   *ast.QueryDocument
+	removed Set
+	// dropped holds the names of the fragments that we removed a spread of
+	dropped Set
+}
+
+func (s *fragmentStripper) stripDocument() {
+	for _, operation := range s.query.Operations {
+		var parent *ast.Definition
+		switch operation.Operation {
+		case ast.Mutation:
+			parent = s.schema.Mutation
+		case ast.Subscription:
+			parent = s.schema.Subscription
+		default:
+			parent = s.schema.Query
+		}
+		operation.SelectionSet = s.stripSelectionSet(parent, operation.SelectionSet)
+	}
+	for _, fragment := range s.query.Fragments {
+		fragment.SelectionSet = s.stripSelectionSet(s.schema.Types[fragment.TypeCondition], fragment.SelectionSet)
+	}
+
+	// fragments that we removed every spread of would now fail validation for not being used
+	if len(s.dropped) == 0 {
+		return
+	}
+	used := Set{}
+	for _, operation := range s.query.Operations {
+		s.collectSpreads(operation.SelectionSet, used)
+	}
+	for _, fragment := range s.query.Fragments {
+		s.collectSpreads(fragment.SelectionSet, used)
+	}
+	fragments := ast.FragmentDefinitionList{}
+	for _, fragment := range s.query.Fragments {
+		if used.Has(fragment.Name) || !s.dropped.Has(fragment.Name) {
+			fragments = append(fragments, fragment)
+		}
+	}
+	s.query.Fragments = fragments
+}
+
+func (s *fragmentStripper) stripSelectionSet(parent *ast.Definition, selectionSet ast.SelectionSet) ast.SelectionSet {
+	// if we don't know the parent type then the validator will complain about it for us
+	if parent == nil {
+		return selectionSet
+	}
+
+	result := ast.SelectionSet{}
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if definition := parent.Fields.ForName(selection.Name); definition != nil {
+				selection.SelectionSet = s.stripSelectionSet(s.schema.Types[definition.Type.Name()], selection.SelectionSet)
+			}
+		case *ast.InlineFragment:
+			if selection.TypeCondition == "" {
+				selection.SelectionSet = s.stripSelectionSet(parent, selection.SelectionSet)
+				break
+			}
+			if !s.possible(parent, selection.TypeCondition) {
+				continue
+			}
+			selection.SelectionSet = s.stripSelectionSet(s.schema.Types[selection.TypeCondition], selection.SelectionSet)
+		case *ast.FragmentSpread:
+			if fragment := s.query.Fragments.ForName(selection.Name); fragment != nil && !s.possible(parent, fragment.TypeCondition) {
+				s.dropped.Add(selection.Name)
+				continue
+			}
+		}
+		result = append(result, selection)
+	}
+
+	return result
+}
+
+// possible returns whether a fragment on the named type could ever apply to a value of the parent type
+func (s *fragmentStripper) possible(parent *ast.Definition, typeCondition string) bool {
+	condition, ok := s.schema.Types[typeCondition]
+	if ok {
+		conditionTypes := Set{}
+		for _, possible := range s.schema.GetPossibleTypes(condition) {
+			conditionTypes.Add(possible.Name)
+		}
+		for _, possible := range s.schema.GetPossibleTypes(parent) {
+			if conditionTypes.Has(possible.Name) {
+				return true
+			}
+		}
+	}
+
+	s.removed.Add(typeCondition)
+	return false
+}
+
+func (s *fragmentStripper) collectSpreads(selectionSet ast.SelectionSet, used Set) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			s.collectSpreads(selection.SelectionSet, used)
+		case *ast.InlineFragment:
+			s.collectSpreads(selection.SelectionSet, used)
+		case *ast.FragmentSpread:
+			used.Add(selection.Name)
+		}
+	}
+}
+
 func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.QueryDocument) (QueryPlanList, error) {
 	// an accumulator
 	plans := QueryPlanList{}
@@ -187,11 +515,14 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 			for payload := range newSteps {
 				step := &QueryPlanStep{
 					Queryer:             p.GetQueryer(ctx, payload.Location),
+					Location:            payload.Location,
 					ParentType:          payload.ParentType,
 					SelectionSet:        ast.SelectionSet{},
 					InsertionPoint:      payload.InsertionPoint,
 					Variables:           Set{},
 					FragmentDefinitions: payload.Fragments,
+					PossibleTypes:       payload.PossibleTypes,
+					Requires:            payload.Requires,
 				}
 
 				// if there is a parent to this query
@@ -249,12 +580,44 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 				variableDefs := ast.VariableDefinitionList{}
 				// we need to grab the variable definitions and values for each variable in the step
 				for variable := range step.Variables {
+					// the fields of the parent are defined below
+					if step.Requires.ForName(variable) != nil {
+						continue
+					}
 					// add the definition
 					variableDefs = append(variableDefs, plan.Operation.VariableDefinitions.ForName(variable))
 				}
+				// along with the fields of the parent that the step needs
+				variableDefs = append(variableDefs, step.Requires...)
+
+				// the services see the name of the client's operation unless the gateway was told otherwise
+				operationName := plan.Operation.Name
+				if ctx.Gateway.upstreamOperationNamer != nil {
+					if name := ctx.Gateway.upstreamOperationNamer(plan.Operation.Name, step); name != "" {
+						operationName = name
+					}
+				}
 
 				// build up the query document
-				step.QueryDocument = plannerBuildQuery(ctx, plan.Operation.Name, step.ParentType, variableDefs, step.SelectionSet, step.FragmentDefinitions)
+				step.QueryDocument = plannerBuildQuery(ctx, operationName, step.ParentType, variableDefs, step.SelectionSet, step.FragmentDefinitions)
+
+				// a step that only applies to some of the types of an interface or union puts each of its selections
+				// behind a type condition already so the service doesn't need to know about the abstract type
+				if step.PossibleTypes != nil {
+					if node, ok := step.QueryDocument.Operations[0].SelectionSet[0].(*ast.Field); ok {
+						node.SelectionSet = step.SelectionSet
+					}
+				}
+
+				// give the gateway a chance to adapt the document to the service
+				if ctx.Gateway.upstreamQueryRewriter != nil {
+					if document := ctx.Gateway.upstreamQueryRewriter(step, step.QueryDocument); document != nil {
+						step.QueryDocument = document
+					}
+				}
+
+				// the query should only define the variables the gateway sends along with it
+				step.QueryDocument = trimVariableDefinitions(step, step.QueryDocument)
 
 				// we also need to turn the query into a string
 				queryString, err := graphql.PrintQuery(step.QueryDocument)
@@ -297,12 +660,43 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 			close(stepCh)
 		}
 
+		// subscriptions can't be stitched together from more than one service
+		if operation.Operation == ast.Subscription {
+			if err := plannerValidateSubscription(plan); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// return the final plan
 	return plans, nil
 }
 
+// plannerValidateSubscription makes sure that every root field of the subscription can be resolved
+// by a single service without joining data from any other
+func plannerValidateSubscription(plan *QueryPlan) error {
+	if plan.RootStep == nil {
+		return nil
+	}
+
+	for _, step := range plan.RootStep.Then {
+		// if the step doesn't depend on any others then its fine
+		if len(step.Then) == 0 {
+			continue
+		}
+
+		// use the root fields to point the user at the problem
+		fields := []string{}
+		for _, field := range graphql.SelectedFields(step.SelectionSet) {
+			fields = append(fields, field.Name)
+		}
+
+		return fmt.Errorf("subscription field %s requires data from more than one service", strings.Join(fields, ", "))
+	}
+
+	return nil
+}
+
 type extractSelectionConfig struct {
 	stepCh chan *newQueryPlanStepPayload
 	stepWg *sync.WaitGroup
@@ -333,6 +727,13 @@ func (p *MinQueriesPlanner) extractSelection(ctx *PlanningContext, config *extra
 	// we only need to add an ID field if there are steps coming off of this insertion point
 	checkForID := false
 
+	// if we are looking at an interface or union, the types that the new steps apply to
+	stepTypes := Set{}
+	allTypes := false
+
+	// the fields of the parent that the new steps need
+	requiredFields := ast.SelectionSet{}
+
 	// we have to make sure we spawn any more goroutines before this one terminates. This means that
 	// we first have to look at any locations that are not the current one
 	for location, selectionSet := range locationFields {
@@ -352,6 +753,19 @@ func (p *MinQueriesPlanner) extractSelection(ctx *PlanningContext, config *extra
 		// id to the selection set
 		checkForID = true
 
+		// some of the fields might need other fields of the parent to be resolved
+		stepRequiredFields, requires := ctx.Gateway.requirements.addRequirements(config.parentType, selectionSet)
+		requiredFields = append(requiredFields, stepRequiredFields...)
+
+		// the step might only apply to some of the objects we will find here
+		possibleTypes := p.possibleTypes(ctx, config, selectionSet, locationFragments[location])
+		if possibleTypes == nil {
+			allTypes = true
+		}
+		for typeName := range possibleTypes {
+			stepTypes.Add(typeName)
+		}
+
 		// if we have a wrapper to add
 		if config.wrapper != nil && len(config.wrapper) > 0 {
 			ctx.Gateway.logger.Debug("wrapping selection", config.wrapper)
@@ -373,16 +787,47 @@ func (p *MinQueriesPlanner) extractSelection(ctx *PlanningContext, config *extra
 			Wrapper:        config.wrapper,
 			ParentType:     config.parentType,
 
-			Location:     location,
-			SelectionSet: selectionSet,
-			Fragments:    locationFragments[location],
+			Location:      location,
+			SelectionSet:  selectionSet,
+			Fragments:     locationFragments[location],
+			PossibleTypes: possibleTypes,
+			Requires:      requires,
 		}
 	}
 
 	// if we have to have an id field on this selection set
 	if checkForID {
-		// add the id field since duplicates are ignored
-		locationFields[config.parentLocation] = append(locationFields[config.parentLocation], &ast.Field{Name: "id"})
+		var parentDefinition *ast.Definition
+		if ctx.Schema != nil {
+			parentDefinition = ctx.Schema.Types[config.parentType]
+		}
+
+		// unions don't have fields of their own so we have to ask for the id of each type that needs one
+		if parentDefinition != nil && parentDefinition.Kind == ast.Union && !allTypes {
+			typeNames := []string{}
+			for typeName := range stepTypes {
+				typeNames = append(typeNames, typeName)
+			}
+			sort.Strings(typeNames)
+
+			for _, typeName := range typeNames {
+				locationFields[config.parentLocation] = append(locationFields[config.parentLocation], &ast.InlineFragment{
+					TypeCondition: typeName,
+					SelectionSet:  ast.SelectionSet{ctx.Gateway.keys.field(typeName)},
+				})
+			}
+		} else {
+			// add the id field since duplicates are ignored
+			locationFields[config.parentLocation] = append(locationFields[config.parentLocation], ctx.Gateway.keys.field(config.parentType))
+		}
+
+		// the executor needs to know the type of each object to decide which steps apply to it
+		if len(stepTypes) > 0 {
+			locationFields[config.parentLocation] = append(locationFields[config.parentLocation], &ast.Field{Name: typenameField})
+		}
+
+		// and the fields that the new steps will need
+		locationFields[config.parentLocation] = append(locationFields[config.parentLocation], requiredFields...)
 	}
 
 	// now we have to generate a selection set for fields that are coming from the same location as the parent
@@ -418,6 +863,14 @@ func (p *MinQueriesPlanner) extractSelection(ctx *PlanningContext, config *extra
 					}
 				}
 
+				// clients need the __typename of an interface or union to tell its types apart
+				fieldSelection := selection.SelectionSet
+				if !ctx.Gateway.withoutAbstractTypenames && plannerIsAbstract(ctx, coreFieldType(selection).Name()) &&
+					!plannerSelectsTypename(fieldSelection, config.plan.FragmentDefinitions) {
+					fieldSelection = append(ast.SelectionSet{&ast.Field{Name: typenameField, Alias: typenameField}}, fieldSelection...)
+					config.step.addedTypenames = append(config.step.addedTypenames, insertionPoint)
+				}
+
 				ctx.Gateway.logger.Debug("found a thing with a selection. extracting to ", insertionPoint, ". Parent insertion", config.insertionPoint)
 				// add any possible selections provided by this fields selections
 				subSelection, err := p.extractSelection(ctx, &extractSelectionConfig{
@@ -429,7 +882,7 @@ func (p *MinQueriesPlanner) extractSelection(ctx *PlanningContext, config *extra
 					plan:           config.plan,
 
 					parentType:     coreFieldType(selection).Name(),
-					selection:      selection.SelectionSet,
+					selection:      fieldSelection,
 					insertionPoint: insertionPoint,
 					wrapper:        wrapper,
 				})
@@ -606,18 +1059,62 @@ func (p *MinQueriesPlanner) wrapSelectionSet(ctx *PlanningContext, config *extra
 	return ast.SelectionSet{selection}, nil
 }
 
+// unknownFieldFallback sends a field that the gateway couldn't find the location of to the fallback service,
+// if the gateway has one
+func (p *MinQueriesPlanner) unknownFieldFallback(ctx *PlanningContext, typeName string, field string, locations []string, err error) ([]string, error) {
+	if err == nil || ctx.Gateway == nil || ctx.Gateway.unknownFieldFallback == "" {
+		return locations, err
+	}
+
+	ctx.Gateway.logger.Warn(fmt.Sprintf("sending %s.%s to %s because the gateway doesn't know where to find it", typeName, field, ctx.Gateway.unknownFieldFallback))
+	return []string{ctx.Gateway.unknownFieldFallback}, nil
+}
+
+// selectFieldLocation picks the location for a field of the given type, sending it wherever it is pinned to
+// if the gateway pinned it to a service
+func (p *MinQueriesPlanner) selectFieldLocation(ctx *PlanningContext, typeName string, field *ast.Field, possibleLocations []string, config *extractSelectionConfig, siblingLocations *plannerSiblings) (string, error) {
+	if ctx.Gateway != nil {
+		if pinned, ok := ctx.Gateway.fieldPins[ctx.Gateway.fieldPins.keyFor(typeName, field.Name)]; ok && len(pinned) > 0 {
+			for _, location := range possibleLocations {
+				if location == pinned[0] {
+					return location, nil
+				}
+			}
+			// a pinned field never falls back to another service
+			return "", plannerFieldError(typeName, field, fmt.Errorf("it is pinned to %s which is not one of its locations", pinned[0]))
+		}
+	}
+
+	location := p.selectLocation(ctx, field.Name, possibleLocations, config, siblingLocations)
+	if len(possibleLocations) > 1 && ctx.Gateway != nil {
+		ctx.Gateway.logger.Debug(fmt.Sprintf("sending %s.%s to %s out of %v", typeName, field.Name, location, possibleLocations))
+	}
+	return location, nil
+}
+
 // selects one location out of possibleLocations, prioritizing the parent's location and the internal schema
-func (p *MinQueriesPlanner) selectLocation(possibleLocations []string, config *extractSelectionConfig) string {
+func (p *MinQueriesPlanner) selectLocation(ctx *PlanningContext, field string, possibleLocations []string, config *extractSelectionConfig, siblingLocations *plannerSiblings) string {
 	// if this field can only be found in one location
 	if len(possibleLocations) == 1 {
 		return possibleLocations[0]
 	}
 	// the field can be found in many locations
 
+	// any service that gave us the object can tell us its type so we never need another step for __typename
+	if field == typenameField {
+		for _, location := range possibleLocations {
+			if location == config.parentLocation {
+				return location
+			}
+		}
+	}
+
 	// locations to prioritize first
+	// the priorities of the request come before the ones of the planner
 	initialLocationPriorities := []string{config.parentLocation, internalSchemaLocation}
-	priorities := make([]string, len(p.LocationPriorities), len(p.LocationPriorities)+len(initialLocationPriorities))
-	copy(priorities, p.LocationPriorities)
+	priorities := make([]string, 0, len(ctx.LocationPriorities)+len(p.LocationPriorities)+len(initialLocationPriorities))
+	priorities = append(priorities, ctx.LocationPriorities...)
+	priorities = append(priorities, p.LocationPriorities...)
 	priorities = append(priorities, initialLocationPriorities...)
 
 	for _, priority := range priorities {
@@ -631,15 +1128,192 @@ func (p *MinQueriesPlanner) selectLocation(possibleLocations []string, config *e
 		}
 	}
 
+	// if one of the locations already has to be visited for a sibling, send the field along with it
+	for _, location := range possibleLocations {
+		if siblingLocations.has(location) {
+			return location
+		}
+	}
+
+	// otherwise, the location that can resolve the most of the siblings saves the most round trips
+	if location, ok := siblingLocations.mostCovering(possibleLocations); ok {
+		return location
+	}
+
 	// if we got here then this field can be found in multiple services and none of the top priority locations.
 	// for now, just use the first one
 	return possibleLocations[0]
 }
 
+// possibleTypes returns the names of the types that a step with the given selection set applies to if
+// the parent type is abstract and the step is only concerned with some of its types. Otherwise, it returns nil.
+func (p *MinQueriesPlanner) possibleTypes(ctx *PlanningContext, config *extractSelectionConfig, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) Set {
+	if ctx.Schema == nil {
+		return nil
+	}
+	parentDefinition := ctx.Schema.Types[config.parentType]
+	if parentDefinition == nil || !parentDefinition.IsAbstractType() {
+		return nil
+	}
+
+	types := Set{}
+
+	// collect adds the types that the selections apply to and returns false if one of them applies to every type
+	var collect func(selectionSet ast.SelectionSet) bool
+	collect = func(selectionSet ast.SelectionSet) bool {
+		for _, selection := range selectionSet {
+			// the step only applies to some types if every one of its selections is behind a type condition
+			typeCondition := ""
+			switch selection := selection.(type) {
+			case *ast.InlineFragment:
+				// a fragment on the parent type (like the ones that wrap the branches of an interface
+				// grouped into one step) only applies to the types of its selections
+				if selection.TypeCondition == "" || selection.TypeCondition == config.parentType {
+					if !collect(selection.SelectionSet) {
+						return false
+					}
+					continue
+				}
+				typeCondition = selection.TypeCondition
+			case *ast.FragmentSpread:
+				defn := fragments.ForName(selection.Name)
+				if defn == nil {
+					defn = config.plan.FragmentDefinitions.ForName(selection.Name)
+				}
+				if defn != nil {
+					typeCondition = defn.TypeCondition
+				}
+			}
+			if typeCondition == "" || typeCondition == config.parentType {
+				return false
+			}
+
+			conditionDefinition := ctx.Schema.Types[typeCondition]
+			if conditionDefinition == nil {
+				return false
+			}
+			for _, possibleType := range ctx.Schema.GetPossibleTypes(conditionDefinition) {
+				types.Add(possibleType.Name)
+			}
+		}
+		return true
+	}
+	if !collect(selectionSet) {
+		return nil
+	}
+
+	// if the step applies to every type then there's nothing to filter
+	for _, possibleType := range ctx.Schema.GetPossibleTypes(parentDefinition) {
+		if !types.Has(possibleType.Name) {
+			return types
+		}
+	}
+	return nil
+}
+
+// plannerFieldError points the user at the field in their query that we couldn't plan
+func plannerFieldError(parentType string, field *ast.Field, err error) error {
+	planErr := &gqlerror.Error{
+		Message: fmt.Sprintf("could not plan field %s on type %s: %v", field.Name, parentType, err),
+		Extensions: map[string]interface{}{
+			"type":  parentType,
+			"field": field.Name,
+		},
+	}
+
+	if field.Position != nil {
+		planErr.Locations = []gqlerror.Location{{Line: field.Position.Line, Column: field.Position.Column}}
+	}
+
+	return planErr
+}
+
+// plannerSiblings describes where the fields of a selection set can be found so that fields with more
+// than one location can be sent along with the others
+type plannerSiblings struct {
+	// required are the locations that have to be visited because some of the fields can only be found there
+	required Set
+	// coverage counts the fields that each location can resolve
+	coverage map[string]int
+}
+
+// has returns whether the location has to be visited for another field
+func (s *plannerSiblings) has(location string) bool {
+	return s != nil && s.required.Has(location)
+}
+
+// mostCovering returns the location that can resolve the most fields of the selection set, as long as it can
+// resolve more than one. Ties go to the location that comes first.
+func (s *plannerSiblings) mostCovering(possibleLocations []string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+
+	best, bestCoverage := "", 1
+	for _, location := range possibleLocations {
+		if s.coverage[location] > bestCoverage {
+			best, bestCoverage = location, s.coverage[location]
+		}
+	}
+	return best, best != ""
+}
+
+// siblingLocations returns the locations that the selection set has to visit because some of its fields
+// (directly or through a fragment) can only be found in one place, along with the number of fields each
+// location could resolve
+func (p *MinQueriesPlanner) siblingLocations(config *extractSelectionConfig) *plannerSiblings {
+	siblings := &plannerSiblings{required: Set{}, coverage: map[string]int{}}
+
+	addFields := func(parentType string, selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			field, ok := selection.(*ast.Field)
+			if !ok {
+				continue
+			}
+			possibleLocations, err := config.locations.URLFor(parentType, field.Name)
+			if err != nil {
+				continue
+			}
+			if len(possibleLocations) == 1 {
+				siblings.required.Add(possibleLocations[0])
+			}
+			for _, location := range possibleLocations {
+				siblings.coverage[location]++
+			}
+		}
+	}
+
+	for _, selection := range config.selection {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			addFields(config.parentType, ast.SelectionSet{selection})
+		case *ast.FragmentSpread:
+			defn := config.step.FragmentDefinitions.ForName(selection.Name)
+			if defn == nil {
+				defn = config.plan.FragmentDefinitions.ForName(selection.Name)
+			}
+			if defn != nil {
+				addFields(defn.TypeCondition, defn.SelectionSet)
+			}
+		case *ast.InlineFragment:
+			typeCondition := selection.TypeCondition
+			if typeCondition == "" {
+				typeCondition = config.parentType
+			}
+			addFields(typeCondition, selection.SelectionSet)
+		}
+	}
+
+	return siblings
+}
+
 func (p *MinQueriesPlanner) groupSelectionSet(ctx *PlanningContext, config *extractSelectionConfig) (map[string]ast.SelectionSet, map[string]ast.FragmentDefinitionList, error) {
 	locationFields := map[string]ast.SelectionSet{}
 	locationFragments := map[string]ast.FragmentDefinitionList{}
 
+	// fields that can be found in many places should be sent along with their siblings when possible
+	siblingLocations := p.siblingLocations(config)
+
 	// split each selection into groups of selection sets to be sent to a single service
 	for _, selection := range config.selection {
 		// each kind of selection contributes differently to the final selection set
@@ -650,7 +1324,7 @@ func (p *MinQueriesPlanner) groupSelectionSet(ctx *PlanningContext, config *extr
 			field := &ast.Field{
 				Name:             selection.Name,
 				Alias:            selection.Alias,
-				Directives:       selection.Directives,
+				Directives:       plannerFieldDirectives(selection.Directives),
 				Arguments:        selection.Arguments,
 				Definition:       selection.Definition,
 				ObjectDefinition: selection.ObjectDefinition,
@@ -659,11 +1333,19 @@ func (p *MinQueriesPlanner) groupSelectionSet(ctx *PlanningContext, config *extr
 
 			// look up the location for this field
 			possibleLocations, err := config.locations.URLFor(config.parentType, selection.Name)
+			if err != nil && selection.Name == typenameField && config.parentLocation != "" {
+				// any service that gave us the object can tell us its type
+				possibleLocations, err = []string{config.parentLocation}, nil
+			}
+			possibleLocations, err = p.unknownFieldFallback(ctx, config.parentType, selection.Name, possibleLocations, err)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, plannerFieldError(config.parentType, selection, err)
 			}
 
-			location := p.selectLocation(possibleLocations, config)
+			location, err := p.selectFieldLocation(ctx, config.parentType, selection, possibleLocations, config, siblingLocations)
+			if err != nil {
+				return nil, nil, err
+			}
 			locationFields[location] = append(locationFields[location], field)
 		case *ast.FragmentSpread:
 			ctx.Gateway.logger.Debug("Encountered fragment spread ", selection.Name)
@@ -691,7 +1373,7 @@ func (p *MinQueriesPlanner) groupSelectionSet(ctx *PlanningContext, config *extr
 					field := &ast.Field{
 						Name:             fragmentSelection.Name,
 						Alias:            fragmentSelection.Alias,
-						Directives:       fragmentSelection.Directives,
+						Directives:       plannerFieldDirectives(fragmentSelection.Directives),
 						Arguments:        fragmentSelection.Arguments,
 						Definition:       fragmentSelection.Definition,
 						ObjectDefinition: fragmentSelection.ObjectDefinition,
@@ -700,11 +1382,15 @@ func (p *MinQueriesPlanner) groupSelectionSet(ctx *PlanningContext, config *extr
 
 					// look up the location of the field
 					fieldLocations, err := config.locations.URLFor(defn.TypeCondition, field.Name)
+					fieldLocations, err = p.unknownFieldFallback(ctx, defn.TypeCondition, field.Name, fieldLocations, err)
 					if err != nil {
-						return nil, nil, err
+						return nil, nil, plannerFieldError(defn.TypeCondition, fragmentSelection, err)
 					}
 
-					fieldLocation := p.selectLocation(fieldLocations, config)
+					fieldLocation, err := p.selectFieldLocation(ctx, defn.TypeCondition, fragmentSelection, fieldLocations, config, siblingLocations)
+					if err != nil {
+						return nil, nil, err
+					}
 					fragmentLocations[fieldLocation] = append(fragmentLocations[fieldLocation], field)
 
 				case *ast.FragmentSpread, *ast.InlineFragment:
@@ -736,27 +1422,9 @@ func (p *MinQueriesPlanner) groupSelectionSet(ctx *PlanningContext, config *extr
 
 			// we need to split the inline fragment into an inline fragment for each location that this cover
 			// and then add those inline fragments to the final selection
-
-			fragmentLocations := map[string]ast.SelectionSet{}
-
-			// each field in the fragment should be bundled with whats around it (still wrapped in fragment)
-			for _, fragmentSelection := range selection.SelectionSet {
-				switch fragmentSelection := fragmentSelection.(type) {
-				case *ast.Field:
-					// look up the location of the field
-					fieldLocations, err := config.locations.URLFor(selection.TypeCondition, fragmentSelection.Name)
-					if err != nil {
-						return nil, nil, err
-					}
-
-					// add the field to the location
-					fragmentLocations[fieldLocations[0]] = append(fragmentLocations[fieldLocations[0]], fragmentSelection)
-
-				case *ast.FragmentSpread, *ast.InlineFragment:
-					// non-field selections will be handled in the next tick
-					// add it to the current location so we don't create a new step if its not needed
-					fragmentLocations[config.parentLocation] = append(fragmentLocations[config.parentLocation], fragmentSelection)
-				}
+			fragmentLocations, err := p.groupInlineFragment(ctx, config, siblingLocations, config.parentType, selection)
+			if err != nil {
+				return nil, nil, err
 			}
 
 			// for each bundle under a fragment
@@ -774,6 +1442,69 @@ func (p *MinQueriesPlanner) groupSelectionSet(ctx *PlanningContext, config *extr
 	return locationFields, locationFragments, nil
 }
 
+// groupInlineFragment splits the selections of an inline fragment into a selection set for each location they
+// have to be sent to. Inline fragments inside of it are split the same way (instead of waiting for the next
+// tick) so the branches of an interface that are resolved by the same service end up in the same step.
+func (p *MinQueriesPlanner) groupInlineFragment(ctx *PlanningContext, config *extractSelectionConfig, siblingLocations *plannerSiblings, parentType string, fragment *ast.InlineFragment) (map[string]ast.SelectionSet, error) {
+	typeName := fragment.TypeCondition
+	if typeName == "" {
+		typeName = parentType
+	}
+
+	fragmentLocations := map[string]ast.SelectionSet{}
+
+	// each field in the fragment should be bundled with whats around it (still wrapped in fragment)
+	for _, fragmentSelection := range fragment.SelectionSet {
+		switch fragmentSelection := fragmentSelection.(type) {
+		case *ast.Field:
+			// look up the location of the field
+			fieldLocations, err := config.locations.URLFor(typeName, fragmentSelection.Name)
+			fieldLocations, err = p.unknownFieldFallback(ctx, typeName, fragmentSelection.Name, fieldLocations, err)
+			if err != nil {
+				return nil, plannerFieldError(typeName, fragmentSelection, err)
+			}
+
+			field := &ast.Field{
+				Name:             fragmentSelection.Name,
+				Alias:            fragmentSelection.Alias,
+				Directives:       plannerFieldDirectives(fragmentSelection.Directives),
+				Arguments:        fragmentSelection.Arguments,
+				Definition:       fragmentSelection.Definition,
+				ObjectDefinition: fragmentSelection.ObjectDefinition,
+				SelectionSet:     fragmentSelection.SelectionSet,
+			}
+
+			// add the field to the location
+			fieldLocation, err := p.selectFieldLocation(ctx, typeName, fragmentSelection, fieldLocations, config, siblingLocations)
+			if err != nil {
+				return nil, err
+			}
+			fragmentLocations[fieldLocation] = append(fragmentLocations[fieldLocation], field)
+
+		case *ast.InlineFragment:
+			// the fields of a nested fragment join the ones around it, still wrapped in the fragment
+			nestedLocations, err := p.groupInlineFragment(ctx, config, siblingLocations, typeName, fragmentSelection)
+			if err != nil {
+				return nil, err
+			}
+			for location, selectionSet := range nestedLocations {
+				fragmentLocations[location] = append(fragmentLocations[location], &ast.InlineFragment{
+					TypeCondition: fragmentSelection.TypeCondition,
+					Directives:    fragmentSelection.Directives,
+					SelectionSet:  selectionSet,
+				})
+			}
+
+		case *ast.FragmentSpread:
+			// fragment spreads will be handled in the next tick
+			// add it to the current location so we don't create a new step if its not needed
+			fragmentLocations[config.parentLocation] = append(fragmentLocations[config.parentLocation], fragmentSelection)
+		}
+	}
+
+	return fragmentLocations, nil
+}
+
 // This plan results in a query that has fields that were not explicitly asked for.
 // In order for the executor to know what to filter out of the final reply,
 // we have to leave behind paths to objects that need to be scrubbed.
@@ -791,7 +1522,9 @@ func (p *MinQueriesPlanner) generateScrubFields(plans QueryPlanList, requestSele
 			}
 
 			for field, values := range childScrubs {
-				fieldsToScrub[field] = append(fieldsToScrub[field], values...)
+				for _, value := range values {
+					fieldsToScrub[field] = plannerAppendPath(fieldsToScrub[field], value)
+				}
 			}
 		}
 
@@ -845,6 +1578,33 @@ func (p *MinQueriesPlanner) generateScrubFieldsWalk(step *QueryPlanStep, selecti
 		acc["id"] = append(acc["id"], insertionPoint)
 	}
 
+	// the user might not have asked for the fields we had to send along to the step
+	for _, requirement := range step.Requires {
+		field := strings.TrimPrefix(requirement.Variable, requiresVariablePrefix)
+		if len(insertionPoint) > 0 && !plannerSelectsField(targetSelection, field) {
+			acc[field] = append(acc[field], insertionPoint)
+		}
+	}
+
+	// if we asked for the __typename to decide if the step applies, the user might not want it
+	if step.PossibleTypes != nil && len(insertionPoint) > 0 {
+		naturalTypename := false
+		for _, field := range graphql.SelectedFields(targetSelection) {
+			if field.Alias == typenameField {
+				naturalTypename = true
+			}
+		}
+
+		if !naturalTypename {
+			acc[typenameField] = append(acc[typenameField], insertionPoint)
+		}
+	}
+
+	// the client didn't ask for the __typename of the interfaces and unions that we added it to
+	for _, point := range step.addedTypenames {
+		acc[typenameField] = plannerAppendPath(acc[typenameField], point)
+	}
+
 	// add all of the plans for the next step along with those from this step
 	for _, nextStep := range step.Then {
 		// compute the fields that our children have to add
@@ -854,13 +1614,66 @@ func (p *MinQueriesPlanner) generateScrubFieldsWalk(step *QueryPlanStep, selecti
 		}
 
 		for id, values := range childScrubs {
-			acc[id] = append(acc[id], values...)
+			for _, value := range values {
+				acc[id] = plannerAppendPath(acc[id], value)
+			}
 		}
 	}
 
 	return acc, nil
 }
 
+// plannerSelectsField returns true if the selection set asks for the field under its own name
+func plannerSelectsField(selectionSet ast.SelectionSet, name string) bool {
+	for _, field := range graphql.SelectedFields(selectionSet) {
+		if field.Alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// plannerAppendPath adds the path to the list if it isn't there already. A field can only be scrubbed from
+// an object once.
+func plannerAppendPath(paths [][]string, path []string) [][]string {
+	for _, existing := range paths {
+		if strings.Join(existing, ".") == strings.Join(path, ".") {
+			return paths
+		}
+	}
+	return append(paths, path)
+}
+
+// plannerIsAbstract returns true if the type is an interface or a union
+func plannerIsAbstract(ctx *PlanningContext, typeName string) bool {
+	if ctx.Schema == nil {
+		return false
+	}
+	definition, ok := ctx.Schema.Types[typeName]
+	return ok && (definition.Kind == ast.Interface || definition.Kind == ast.Union)
+}
+
+// plannerSelectsTypename returns true if the selection set asks for the __typename, in any of its fragments
+func plannerSelectsTypename(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) bool {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Alias == typenameField {
+				return true
+			}
+		case *ast.InlineFragment:
+			if plannerSelectsTypename(selection.SelectionSet, fragments) {
+				return true
+			}
+		case *ast.FragmentSpread:
+			if definition := fragments.ForName(selection.Name); definition != nil && plannerSelectsTypename(definition.SelectionSet, fragments) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func coreFieldType(source *ast.Field) *ast.Type {
 	// if we are looking at a
 	return source.Definition.Type
@@ -890,9 +1703,17 @@ func (set Set) Has(k string) bool {
 func (p *Planner) GetQueryer(ctx *PlanningContext, url string) graphql.Queryer {
 	// if we are looking to query the local schema
 	if url == internalSchemaLocation {
+		if ctx.Gateway.internalQueryer != nil {
+			return ctx.Gateway.internalQueryer
+		}
 		return ctx.Gateway
 	}
 
+	// if there is a queryer registered for the url
+	if queryer, ok := p.UpstreamQueryers[url]; ok {
+		return queryer
+	}
+
 	// if there is a queryer factory defined
 	if p.QueryerFactory != nil {
 		// use the factory
@@ -900,7 +1721,7 @@ func (p *Planner) GetQueryer(ctx *PlanningContext, url string) graphql.Queryer {
 	}
 
 	// return the queryer for the url
-	return graphql.NewSingleRequestQueryer(url)
+	return graphql.NewSingleRequestQueryer(url).WithHTTPClient(ctx.Gateway.httpClient())
 }
 
 func plannerBuildQuery(ctx *PlanningContext, operationName, parentType string, variables ast.VariableDefinitionList, selectionSet ast.SelectionSet, fragmentDefinitions ast.FragmentDefinitionList) *ast.QueryDocument {
@@ -944,7 +1765,7 @@ func plannerBuildQuery(ctx *PlanningContext, operationName, parentType string, v
 						Name: "id",
 						Value: &ast.Value{
 							Kind: ast.Variable,
-							Raw:  "id",
+							Raw:  nodeIDVariable,
 						},
 					},
 				},
@@ -957,10 +1778,12 @@ func plannerBuildQuery(ctx *PlanningContext, operationName, parentType string, v
 			},
 		}
 
-		// if the original query didn't have an id arg we need to add one
-		if variables.ForName("id") == nil {
+		// if the original query didn't have an id arg we need to add one. The value of a type's key is sent
+		// in it too, whatever the type of the key field, since every service has to declare node(id: ID!)
+		// for the schemas to merge
+		if variables.ForName(nodeIDVariable) == nil {
 			operation.VariableDefinitions = append(operation.VariableDefinitions, &ast.VariableDefinition{
-				Variable: "id",
+				Variable: nodeIDVariable,
 				Type:     ast.NonNullNamedType("ID", &ast.Position{}),
 			})
 		}
@@ -973,6 +1796,39 @@ func plannerBuildQuery(ctx *PlanningContext, operationName, parentType string, v
 	}
 }
 
+// trimVariableDefinitions returns the document without the variable definitions the gateway won't send
+// a value for when it executes the step. Some strict services reject a query that defines variables it doesn't
+// use. The document is copied before it is trimmed since the query rewriter could have handed back a shared one.
+func trimVariableDefinitions(step *QueryPlanStep, document *ast.QueryDocument) *ast.QueryDocument {
+	if document == nil || len(document.Operations) == 0 {
+		return document
+	}
+	operation := document.Operations[0]
+
+	// steps below the root look up their object with the node query
+	lookup := step.ParentType != typeNameQuery && step.ParentType != typeNameMutation && step.ParentType != typeNameSubscription
+
+	definitions := ast.VariableDefinitionList{}
+	for _, definition := range operation.VariableDefinitions {
+		if step.Variables.Has(definition.Variable) || step.Requires.ForName(definition.Variable) != nil || (lookup && definition.Variable == nodeIDVariable) {
+			definitions = append(definitions, definition)
+		}
+	}
+
+	// if we didn't remove anything there's nothing to do
+	if len(definitions) == len(operation.VariableDefinitions) {
+		return document
+	}
+
+	trimmedOperation := *operation
+	trimmedOperation.VariableDefinitions = definitions
+
+	trimmed := *document
+	trimmed.Operations = append(ast.OperationList{&trimmedOperation}, document.Operations[1:]...)
+
+	return &trimmed
+}
+
 // MockErrPlanner always returns the provided error. Useful in testing.
 type MockErrPlanner struct {
 	Err error
@@ -1,12 +1,15 @@
 package gateway
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 func TestPlanQuery_singleRootField(t *testing.T) {
@@ -638,6 +641,63 @@ func TestPlanQuery_singleRootObject(t *testing.T) {
 
 }
 
+func TestPlanStats(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			firstName: String!
+			catPhotos: [CatPhoto!]!
+		}
+
+		type CatPhoto {
+			URL: String!
+			owner: User!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", "user-location")
+	locations.RegisterURL("User", "firstName", "user-location")
+	locations.RegisterURL("User", "catPhotos", "cat-location")
+	locations.RegisterURL("CatPhoto", "URL", "cat-location")
+	locations.RegisterURL("CatPhoto", "owner", "user-location")
+
+	plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+		Query: `
+			{
+				allUsers {
+					firstName
+					catPhotos {
+						URL
+						owner {
+							firstName
+						}
+					}
+				}
+			}
+		`,
+		Schema:    schema,
+		Locations: locations,
+		Gateway:   &Gateway{logger: &DefaultLogger{}},
+	})
+	require.NoError(t, err)
+
+	// the users, then their cat photos, then the owners of the photos
+	assert.Equal(t, PlanStatistics{
+		Steps:       3,
+		URLs:        []string{"cat-location", "user-location"},
+		MaxDepth:    3,
+		StepsPerURL: map[string]int{"user-location": 2, "cat-location": 1},
+	}, PlanStats(plans[0]))
+
+	// an empty plan doesn't have anything to count
+	assert.Equal(t, PlanStatistics{URLs: []string{}, StepsPerURL: map[string]int{}}, PlanStats(&QueryPlan{}))
+}
+
 func TestPlanQuery_subGraphs(t *testing.T) {
 	t.Parallel()
 	schema, _ := graphql.LoadSchema(`
@@ -805,6 +865,81 @@ func TestPlanQuery_subGraphs(t *testing.T) {
 	assert.Equal(t, "firstName", thirdSubSelectionField.Name)
 }
 
+func TestPlanQuery_subscriptionSingleService(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			firstName: String!
+			catPhotos: [CatPhoto!]!
+		}
+
+		type CatPhoto {
+			URL: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+
+		type Subscription {
+			userAdded: User!
+		}
+	`)
+
+	// the location of the user service
+	userLocation := "user-location"
+	// the location of the cat service
+	catLocation := "cat-location"
+
+	// the location map for fields for this query
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", userLocation)
+	locations.RegisterURL(typeNameSubscription, "userAdded", userLocation)
+	locations.RegisterURL("User", "firstName", userLocation)
+	locations.RegisterURL("User", "catPhotos", catLocation)
+	locations.RegisterURL("CatPhoto", "URL", catLocation)
+
+	t.Run("single service", func(t *testing.T) {
+		t.Parallel()
+		plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+			Query: `
+				subscription {
+					userAdded {
+						firstName
+					}
+				}
+			`,
+			Schema:    schema,
+			Locations: locations,
+			Gateway:   &Gateway{logger: &DefaultLogger{}},
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Len(t, plans[0].RootStep.Then, 1)
+	})
+
+	t.Run("joins across services", func(t *testing.T) {
+		t.Parallel()
+		_, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+			Query: `
+				subscription {
+					userAdded {
+						firstName
+						catPhotos {
+							URL
+						}
+					}
+				}
+			`,
+			Schema:    schema,
+			Locations: locations,
+			Gateway:   &Gateway{logger: &DefaultLogger{}},
+		})
+		assert.EqualError(t, err, "subscription field userAdded requires data from more than one service")
+	})
+}
+
 func TestPlanQuery_preferParentLocation(t *testing.T) {
 	t.Parallel()
 
@@ -1058,6 +1193,297 @@ func TestPlanQuery_groupSiblings(t *testing.T) {
 	}
 }
 
+func TestPlanQuery_groupFragmentSiblings(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			favoriteCatSpecies: String!
+			catPhotoCount: Int!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	// the location of the user service
+	userLocation := "user-location"
+	// the location of the cat service
+	catLocation := "cat-location"
+	// a service that also knows about the favorite species
+	speciesLocation := "species-location"
+
+	// the location map for fields for this query
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", userLocation)
+	locations.RegisterURL("User", "favoriteCatSpecies", speciesLocation, catLocation)
+	locations.RegisterURL("User", "catPhotoCount", catLocation)
+
+	for _, tc := range []struct {
+		description string
+		query       string
+	}{
+		{
+			description: "fragment spreads",
+			query: `
+				{
+					allUsers {
+						...Species
+						...Count
+					}
+				}
+
+				fragment Species on User {
+					favoriteCatSpecies
+				}
+
+				fragment Count on User {
+					catPhotoCount
+				}
+			`,
+		},
+		{
+			description: "inline fragments",
+			query: `
+				{
+					allUsers {
+						... on User {
+							favoriteCatSpecies
+						}
+						... on User {
+							catPhotoCount
+						}
+					}
+				}
+			`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+			plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+				Query:     tc.query,
+				Schema:    schema,
+				Locations: locations,
+				Gateway:   &Gateway{logger: &DefaultLogger{}},
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// the first step should have all users
+			firstStep := plans[0].RootStep.Then[0]
+			assert.Equal(t, typeNameQuery, firstStep.ParentType)
+
+			// both fragments should be sent to the cat service in a single step
+			if !assert.Len(t, firstStep.Then, 1) {
+				return
+			}
+			assert.Equal(t, catLocation, firstStep.Then[0].Queryer.(*graphql.SingleRequestQueryer).URL())
+		})
+	}
+}
+
+func TestPlanQuery_groupInterfaceBranches(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		interface Animal {
+			id: ID!
+			sound: String!
+		}
+
+		type Cat implements Animal {
+			id: ID!
+			sound: String!
+			lives: Int!
+		}
+
+		type Dog implements Animal {
+			id: ID!
+			sound: String!
+			breed: String!
+		}
+
+		type Bird implements Animal {
+			id: ID!
+			sound: String!
+		}
+
+		type Query {
+			animals: [Animal!]!
+		}
+	`)
+
+	// the animals come from one service and everything we know about them from another
+	animalLocation := "animal-location"
+	detailsLocation := "details-location"
+
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "animals", animalLocation)
+	for _, typeName := range []string{"Animal", "Cat", "Dog", "Bird"} {
+		locations.RegisterURL(typeName, "id", animalLocation, detailsLocation)
+		locations.RegisterURL(typeName, "sound", detailsLocation)
+	}
+	locations.RegisterURL("Cat", "lives", detailsLocation)
+	locations.RegisterURL("Dog", "breed", detailsLocation)
+
+	for _, tc := range []struct {
+		description   string
+		query         string
+		possibleTypes Set
+	}{
+		{
+			description: "interface field next to the fragments",
+			query: `
+				{
+					animals {
+						... on Animal {
+							sound
+							... on Cat {
+								lives
+							}
+							... on Dog {
+								breed
+							}
+						}
+					}
+				}
+			`,
+		},
+		{
+			description: "fragments wrapped in fragments on the interface",
+			query: `
+				{
+					animals {
+						... on Animal {
+							... on Cat {
+								lives
+							}
+						}
+						... on Animal {
+							... on Dog {
+								breed
+							}
+						}
+					}
+				}
+			`,
+			possibleTypes: Set{"Cat": true, "Dog": true},
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+			plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+				Query:     tc.query,
+				Schema:    schema,
+				Locations: locations,
+				Gateway:   &Gateway{logger: &DefaultLogger{}},
+			})
+			require.NoError(t, err)
+
+			// the first step should have all of the animals
+			firstStep := plans[0].RootStep.Then[0]
+			assert.Equal(t, animalLocation, firstStep.Queryer.(*graphql.SingleRequestQueryer).URL())
+
+			// every branch should be sent to the details service in a single step
+			require.Len(t, firstStep.Then, 1)
+			detailsStep := firstStep.Then[0]
+			assert.Equal(t, detailsLocation, detailsStep.Queryer.(*graphql.SingleRequestQueryer).URL())
+			assert.Equal(t, []string{"animals"}, detailsStep.InsertionPoint)
+			assert.Equal(t, tc.possibleTypes, detailsStep.PossibleTypes)
+		})
+	}
+}
+
+func TestPlanQuery_groupMostCoveringLocation(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			bio: String!
+			avatar: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	// every field of the user other than the ones in the user service can be found in two places but only
+	// one service has both of them
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", "user-location")
+	locations.RegisterURL("User", "bio", "profile-location", "media-location")
+	locations.RegisterURL("User", "avatar", "media-location", "cdn-location")
+
+	plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+		Query:     "{ allUsers { bio avatar } }",
+		Schema:    schema,
+		Locations: locations,
+		Gateway:   &Gateway{logger: &DefaultLogger{}},
+	})
+	require.NoError(t, err)
+
+	// picking the first location for each field would need two steps after the users are found
+	firstStep := plans[0].RootStep.Then[0]
+	require.Len(t, firstStep.Then, 1)
+	assert.Equal(t, "media-location", firstStep.Then[0].Queryer.(*graphql.SingleRequestQueryer).URL())
+
+	fields := []string{}
+	for _, field := range graphql.SelectedFields(firstStep.Then[0].SelectionSet) {
+		fields = append(fields, field.Name)
+	}
+	assert.ElementsMatch(t, []string{"bio", "avatar"}, fields)
+}
+
+func TestPlanQuery_unknownFieldFallback(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			name: String!
+			nickname: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	// the gateway hasn't heard about the nickname yet
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", "user-location")
+	locations.RegisterURL("User", "name", "user-location")
+
+	for _, query := range []string{
+		"{ allUsers { name nickname } }",
+		"{ allUsers { name ... on User { nickname } } }",
+	} {
+		// without a fallback the query can't be planned
+		_, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+			Query:     query,
+			Schema:    schema,
+			Locations: locations,
+			Gateway:   &Gateway{logger: &DefaultLogger{}},
+		})
+		assert.ErrorContains(t, err, "could not plan field nickname on type User", query)
+
+		// but with one, the field is sent there
+		plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+			Query:     query,
+			Schema:    schema,
+			Locations: locations,
+			Gateway:   &Gateway{logger: &DefaultLogger{}, unknownFieldFallback: "fallback-location"},
+		})
+		require.NoError(t, err, query)
+
+		firstStep := plans[0].RootStep.Then[0]
+		assert.Equal(t, "user-location", firstStep.Location, query)
+		require.Len(t, firstStep.Then, 1, query)
+		assert.Equal(t, "fallback-location", firstStep.Then[0].Location, query)
+		assert.Contains(t, firstStep.Then[0].QueryString, "nickname", query)
+	}
+}
+
 func TestPlanQuery_nodeField(t *testing.T) {
 	t.Parallel()
 	// the query to test
@@ -1279,6 +1705,85 @@ func TestPlanQuery_stepVariables(t *testing.T) {
 	}
 }
 
+func TestPlanQuery_trimsUnusedVariables(t *testing.T) {
+	t.Parallel()
+	// the location map for fields for this query
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "user", "url1")
+	locations.RegisterURL("User", "favoriteCatPhoto", "url2")
+	locations.RegisterURL("CatPhoto", "URL", "url2")
+
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			favoriteCatPhoto(category: String!): CatPhoto!
+		}
+
+		type CatPhoto {
+			URL: String!
+		}
+
+		type Query {
+			user(name: String!): User
+		}
+	`)
+
+	// a rewriter that defines variables the gateway never sends
+	gateway := &Gateway{
+		logger: &DefaultLogger{},
+		upstreamQueryRewriter: func(step *QueryPlanStep, document *ast.QueryDocument) *ast.QueryDocument {
+			operation := document.Operations[0]
+			if operation.VariableDefinitions.ForName("id") == nil {
+				operation.VariableDefinitions = append(operation.VariableDefinitions, &ast.VariableDefinition{
+					Variable: "id",
+					Type:     ast.NonNullNamedType("ID", &ast.Position{}),
+				})
+			}
+			operation.VariableDefinitions = append(operation.VariableDefinitions, &ast.VariableDefinition{
+				Variable: "extra",
+				Type:     ast.NamedType("String", &ast.Position{}),
+			})
+			return document
+		},
+	}
+
+	plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+		Query: `
+			query($name: String!, $category: String!) {
+				user(name: $name) {
+					favoriteCatPhoto(category: $category) {
+						URL
+					}
+				}
+			}
+		`,
+		Schema:    schema,
+		Locations: locations,
+		Gateway:   gateway,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	definitionNames := func(step *QueryPlanStep) []string {
+		names := []string{}
+		for _, definition := range step.QueryDocument.Operations[0].VariableDefinitions {
+			names = append(names, definition.Variable)
+		}
+		return names
+	}
+
+	// the root step doesn't look anything up so it has no use for an id
+	firstStep := plans[0].RootStep.Then[0]
+	assert.Equal(t, []string{"name"}, definitionNames(firstStep))
+	assert.NotContains(t, firstStep.QueryString, "$extra")
+	assert.NotContains(t, firstStep.QueryString, "$id")
+
+	// the next step gets the id of the user it looks up
+	nextStep := firstStep.Then[0]
+	assert.Equal(t, []string{"category", "id"}, definitionNames(nextStep))
+	assert.NotContains(t, nextStep.QueryString, "$extra")
+}
+
 func TestPlanQuery_singleFragmentMultipleLocations(t *testing.T) {
 	t.Parallel()
 	// the locations for the schema
@@ -1792,3 +2297,310 @@ func TestPlanQuery_scrubWithAlias(t *testing.T) {
 	assert.Equal(t, "allUsers", firstField.Name)
 	assert.Equal(t, "users", firstField.Alias)
 }
+
+func TestPlanQuery_typenameAtBoundary(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			firstName: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	// the location of the user service
+	userLocation := "user-location"
+	// the location of the service that extends the user
+	profileLocation := "profile-location"
+
+	// both services know about the user type so both of them can tell us its __typename
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", userLocation)
+	locations.RegisterURL("User", "id", userLocation, profileLocation)
+	locations.RegisterURL("User", "firstName", profileLocation)
+	locations.RegisterURL("User", "__typename", profileLocation, userLocation)
+
+	// even if the other service is preferred, __typename should come from the service that gave us the user
+	planner := (&MinQueriesPlanner{}).WithLocationPriorities([]string{profileLocation})
+
+	plans, err := planner.Plan(&PlanningContext{
+		Query: `
+			{
+				allUsers {
+					__typename
+				}
+			}
+		`,
+		Schema:    schema,
+		Locations: locations,
+		Gateway:   &Gateway{logger: &DefaultLogger{}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// there should only be one step, the one that gets the users
+	if !assert.Len(t, plans[0].RootStep.Then, 1) {
+		return
+	}
+	firstStep := plans[0].RootStep.Then[0]
+	assert.Equal(t, userLocation, firstStep.Queryer.(*graphql.SingleRequestQueryer).URL())
+	assert.Len(t, firstStep.Then, 0)
+	assert.Equal(t, "query {\n\tallUsers {\n\t\t__typename\n\t}\n}\n", firstStep.QueryString)
+}
+
+func TestPlanQuery_unknownDirectivePolicy(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			firstName: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", "url1")
+	locations.RegisterURL("User", "firstName", "url1")
+
+	query := `
+		query @trace {
+			allUsers @cached(ttl: 10) {
+				firstName @include(if: true)
+			}
+		}
+	`
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		_, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+			Query:     query,
+			Schema:    schema,
+			Locations: locations,
+			Gateway:   &Gateway{logger: &DefaultLogger{}},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("ignore", func(t *testing.T) {
+		t.Parallel()
+		planner := (&MinQueriesPlanner{}).WithUnknownDirectivePolicy(UnknownDirectivesIgnore)
+		plans, err := planner.Plan(&PlanningContext{
+			Query:     query,
+			Schema:    schema,
+			Locations: locations,
+			Gateway:   &Gateway{logger: &DefaultLogger{}},
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		// the directives the schema knows about are left alone
+		assert.Equal(t, "query {\n\tallUsers {\n\t\tfirstName @include(if: true)\n\t}\n}\n", plans[0].RootStep.Then[0].QueryString)
+	})
+}
+
+func TestPlanQuery_missingLocationError(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			firstName: String!
+			lastName: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	// nothing knows where to find the last name of a user
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", "url1")
+	locations.RegisterURL("User", "firstName", "url1")
+
+	for _, tc := range []struct {
+		name  string
+		query string
+	}{
+		{
+			name: "field",
+			query: `{
+				allUsers {
+					lastName
+				}
+			}`,
+		},
+		{
+			name: "inline fragment",
+			query: `{
+				allUsers {
+					... on User {
+						lastName
+					}
+				}
+			}`,
+		},
+		{
+			name: "fragment spread",
+			query: `{
+				allUsers {
+					...UserFields
+				}
+			}
+
+			fragment UserFields on User {
+				lastName
+			}`,
+		},
+	} {
+		tc := tc // enable parallel sub-tests
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+				Query:     tc.query,
+				Schema:    schema,
+				Locations: locations,
+				Gateway:   &Gateway{logger: &DefaultLogger{}},
+			})
+
+			var planErr *gqlerror.Error
+			if !assert.True(t, errors.As(err, &planErr)) {
+				return
+			}
+			assert.Equal(t, "could not plan field lastName on type User: Could not find location for User.lastName", planErr.Message)
+			assert.Equal(t, map[string]interface{}{"type": "User", "field": "lastName"}, planErr.Extensions)
+			assert.Len(t, planErr.Locations, 1)
+		})
+	}
+}
+
+func TestPlanQuery_parsedQueryCache(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			firstName: String!
+			friends: [User!]!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", "url1")
+	locations.RegisterURL("User", "firstName", "url1")
+	locations.RegisterURL("User", "friends", "url2")
+
+	planner := (&MinQueriesPlanner{}).WithParsedQueryCacheSize(10)
+	planningContext := &PlanningContext{
+		Query:     "{ allUsers { firstName friends { firstName } } }",
+		Schema:    schema,
+		Locations: locations,
+		Gateway:   &Gateway{logger: &DefaultLogger{}},
+	}
+
+	first, err := planner.Plan(planningContext)
+	if !assert.NoError(t, err) {
+		return
+	}
+	second, err := planner.Plan(planningContext)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the second plan was built from the same document without changing it
+	assert.Same(t, first[0].Operation, second[0].Operation)
+	assert.Equal(t, first[0].RootStep.Then[0].QueryString, second[0].RootStep.Then[0].QueryString)
+	assert.Equal(t, first[0].RootStep.Then[0].Then[0].QueryString, second[0].RootStep.Then[0].Then[0].QueryString)
+	assert.Equal(t, first[0].FieldsToScrub, second[0].FieldsToScrub)
+}
+
+func TestPlanQuery_parsedQueryCacheWarnings(t *testing.T) {
+	t.Parallel()
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			firstName: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", "url1")
+	locations.RegisterURL("User", "firstName", "url1")
+
+	logger := newRecordingLogger()
+	planner := (&MinQueriesPlanner{UnknownDirectivePolicy: UnknownDirectivesIgnore}).WithParsedQueryCacheSize(10)
+	planningContext := &PlanningContext{
+		Query:     "{ allUsers { firstName @unknown } }",
+		Schema:    schema,
+		Locations: locations,
+		Gateway:   &Gateway{logger: logger},
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := planner.Plan(planningContext)
+		require.NoError(t, err)
+	}
+
+	// the second plan comes from the cached document but the directive was still ignored
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	warnings := 0
+	for _, message := range *logger.messages {
+		if message == "ignoring unknown directive @unknown" {
+			warnings++
+		}
+	}
+	assert.Equal(t, 2, warnings)
+}
+
+func TestPlanQuery_requestPriorityResolution(t *testing.T) {
+	t.Parallel()
+	// lastName is available in both services but the planner prefers the first one
+	locations := FieldURLMap{}
+	locations.RegisterURL(typeNameQuery, "allUsers", "url1")
+	locations.RegisterURL("User", "firstName", "url1")
+	locations.RegisterURL("User", "lastName", "url1")
+	locations.RegisterURL("User", "lastName", "url2")
+
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			firstName: String!
+			lastName: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+
+	planner := (&MinQueriesPlanner{}).WithLocationPriorities([]string{"url1"})
+
+	// the priorities of the request come first
+	plans, err := planner.Plan(&PlanningContext{
+		Query:              "{ allUsers { firstName lastName } }",
+		Schema:             schema,
+		Locations:          locations,
+		Gateway:            &Gateway{logger: &DefaultLogger{}},
+		LocationPriorities: []string{"url2"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	allUsersStep := plans[0].RootStep.Then[0]
+	if !assert.Len(t, allUsersStep.Then, 1) {
+		return
+	}
+	assert.Equal(t, "url2", allUsersStep.Then[0].Queryer.(*graphql.SingleRequestQueryer).URL())
+	assert.Equal(t, "lastName", graphql.SelectedFields(allUsersStep.Then[0].SelectionSet)[0].Name)
+}
@@ -0,0 +1,49 @@
+package gateway
+
+import "sort"
+
+// PlanStatistics describes the shape of a query plan
+type PlanStatistics struct {
+	// Steps is the number of queries the plan sends, not counting the empty root step
+	Steps int
+	// URLs are the distinct locations the plan sends queries to, sorted
+	URLs []string
+	// MaxDepth is the length of the longest chain of steps that wait on each other
+	MaxDepth int
+	// StepsPerURL is the number of queries sent to each location
+	StepsPerURL map[string]int
+}
+
+// PlanStats walks the steps of a plan and returns its statistics. Nothing is computed unless it is called.
+func PlanStats(plan *QueryPlan) PlanStatistics {
+	stats := PlanStatistics{
+		URLs:        []string{},
+		StepsPerURL: map[string]int{},
+	}
+	if plan == nil || plan.RootStep == nil {
+		return stats
+	}
+
+	for _, step := range plan.RootStep.Then {
+		planStatsWalk(&stats, step, 1)
+	}
+
+	for url := range stats.StepsPerURL {
+		stats.URLs = append(stats.URLs, url)
+	}
+	sort.Strings(stats.URLs)
+
+	return stats
+}
+
+func planStatsWalk(stats *PlanStatistics, step *QueryPlanStep, depth int) {
+	stats.Steps++
+	stats.StepsPerURL[step.Location]++
+	if depth > stats.MaxDepth {
+		stats.MaxDepth = depth
+	}
+
+	for _, next := range step.Then {
+		planStatsWalk(stats, next, depth+1)
+	}
+}
@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RateLimiter decides if an operation is allowed to run. It is called by the GraphQLHandler once
+// the operation to execute has been selected. Implementations are responsible for identifying the
+// client, usually from a value that a middleware put in the context.
+type RateLimiter interface {
+	// Allow returns true if the operation can run. If it can't, the duration is how long the
+	// client should wait before trying again (zero if unknown).
+	Allow(ctx context.Context, operationName string) (bool, time.Duration)
+}
+
+// RateLimiterFunc wraps a function to be used as a RateLimiter
+type RateLimiterFunc func(ctx context.Context, operationName string) (bool, time.Duration)
+
+// Allow invokes and returns the wrapped function
+func (r RateLimiterFunc) Allow(ctx context.Context, operationName string) (bool, time.Duration) {
+	return r(ctx, operationName)
+}
+
+// WithRateLimiter returns an Option that checks every operation sent to the GraphQLHandler with the
+// given limiter. Operations that are not allowed get a 429 response with a RATE_LIMITED error.
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(g *Gateway) {
+		g.rateLimiter = limiter
+	}
+}
+
+// rateLimitOperation returns an error if the operation the request wants to execute is not allowed to run
+func (g *Gateway) rateLimitOperation(w http.ResponseWriter, ctx *RequestContext, plans QueryPlanList) error {
+	if g.rateLimiter == nil {
+		return nil
+	}
+
+	// use the name of the operation we are going to execute if we can find it
+	operationName := ctx.OperationName
+	if plan, err := planForOperation(ctx, plans); err == nil && plan.Operation != nil {
+		operationName = plan.Operation.Name
+	}
+
+	allowed, retryAfter := g.rateLimiter.Allow(ctx.Context, operationName)
+	if allowed {
+		return nil
+	}
+
+	// let the client know when they can try again
+	setRetryAfter(w, retryAfter)
+
+	if operationName == "" {
+		return errors.New("too many requests")
+	}
+	return fmt.Errorf("too many requests for operation %s", operationName)
+}
@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// DefaultRequestIDHeader is the header that carries the id of a request when WithRequestID isn't given one
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// WithRequestID returns an Option that gives every request an id. The id is read from the header of the
+// request sent to the GraphQLHandler (or made with the generator if there isn't one), sent back in the same
+// header of the response, and forwarded in that header to every service. It is also added to the logs of
+// the request and to the extensions of its errors as requestId. An empty header falls back to X-Request-ID
+// and a nil generator makes random ids.
+func WithRequestID(header string, generator func() string) Option {
+	return func(g *Gateway) {
+		if header == "" {
+			header = DefaultRequestIDHeader
+		}
+		if generator == nil {
+			generator = randomRequestID
+		}
+		g.requestIDHeader = header
+		g.requestIDGenerator = generator
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the id of the request being handled under the context, if there is one
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID returns a context that holds the id of the request
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFor returns the id of the request sent over HTTP. Clients (or proxies in front of the
+// gateway) can pick the id themselves.
+func (g *Gateway) requestIDFor(r *http.Request) string {
+	if g.requestIDHeader == "" {
+		return ""
+	}
+	if id := r.Header.Get(g.requestIDHeader); id != "" {
+		return id
+	}
+	return g.requestIDGenerator()
+}
+
+// forwardRequestID is the request middleware that passes the id of the request along to the services
+func (g *Gateway) forwardRequestID(r *http.Request) error {
+	if id := RequestID(r.Context()); id != "" {
+		r.Header.Set(g.requestIDHeader, id)
+	}
+	return nil
+}
+
+// requestLogger returns the logger for the work done under the context
+func (g *Gateway) requestLogger(ctx context.Context) Logger {
+	if id := RequestID(ctx); id != "" {
+		return g.logger.WithFields(LoggerFields{"requestId": id})
+	}
+	return g.logger
+}
+
+// addRequestIDToErrors adds the id of the request to the extensions of the errors in a response (or a
+// list of them in batch mode)
+func addRequestIDToErrors(response interface{}, id string) {
+	switch response := response.(type) {
+	case []map[string]interface{}:
+		for _, payload := range response {
+			addRequestIDToErrors(payload, id)
+		}
+	case map[string]interface{}:
+		errList, _ := response["errors"].(graphql.ErrorList)
+		for _, err := range errList {
+			switch err := err.(type) {
+			case *graphql.Error:
+				if err.Extensions == nil {
+					err.Extensions = map[string]interface{}{}
+				}
+				err.Extensions["requestId"] = id
+			case *gqlerror.Error:
+				if err.Extensions == nil {
+					err.Extensions = map[string]interface{}{}
+				}
+				err.Extensions["requestId"] = id
+			}
+		}
+	}
+}
+
+// randomRequestID is the default generator of request ids
+func randomRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
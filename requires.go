@@ -0,0 +1,206 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/vektah/gqlparser/v2/ast"
+
+	"github.com/nautilus/graphql"
+)
+
+// requiresDirective is the name of the directive services put on a field to ask for other fields of the
+// object when resolving it, ie:
+//
+//	shippingEstimate(weight: Float): Int @requires(fields: "weight")
+//
+// The fields are fetched from the service that gave us the object and passed to the arguments with the same name.
+const requiresDirective = "requires"
+
+// requiresVariablePrefix is added to the name of a required field to get the variable it is passed as
+const requiresVariablePrefix = "_requires_"
+
+// fieldRequirements holds the arguments of each field that are filled in with other fields of the
+// same object, keyed by Type.field
+type fieldRequirements map[string]ast.ArgumentDefinitionList
+
+// collectFieldRequirements looks for fields marked with @requires in each of the sources
+func collectFieldRequirements(sources []*graphql.RemoteSchema) (fieldRequirements, error) {
+	requirements := fieldRequirements{}
+
+	for _, source := range sources {
+		for _, definition := range source.Schema.Types {
+			for _, field := range definition.Fields {
+				directive := field.Directives.ForName(requiresDirective)
+				if directive == nil {
+					continue
+				}
+
+				fieldsArg := directive.Arguments.ForName("fields")
+				if fieldsArg == nil || fieldsArg.Value == nil {
+					return nil, fmt.Errorf("@%s on %s.%s is missing its fields", requiresDirective, definition.Name, field.Name)
+				}
+
+				// each required field is passed to the argument with the same name
+				arguments := ast.ArgumentDefinitionList{}
+				for _, name := range strings.Fields(fieldsArg.Value.Raw) {
+					argument := field.Arguments.ForName(name)
+					if argument == nil {
+						return nil, fmt.Errorf("%s.%s requires %s but does not have an argument to receive it", definition.Name, field.Name, name)
+					}
+					arguments = append(arguments, argument)
+				}
+
+				requirements[fmt.Sprintf("%s.%s", definition.Name, field.Name)] = arguments
+			}
+		}
+	}
+
+	return requirements, nil
+}
+
+// checkCycles returns an error if a field ends up requiring itself. The planner asks for the required fields
+// before the field that needs them so a cycle can never be satisfied, no matter which services the fields live in.
+func (r fieldRequirements) checkCycles() error {
+	// visit the fields in a stable order so the same cycle is always reported
+	keys := make([]string, 0, len(r))
+	for key := range r {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// fields are done once we know that nothing they lead to is part of a cycle
+	done := Set{}
+	var visit func(path []string) error
+	visit = func(path []string) error {
+		key := path[len(path)-1]
+		if done.Has(key) {
+			return nil
+		}
+		for i, previous := range path[:len(path)-1] {
+			if previous == key {
+				return fmt.Errorf("the @%s directives form a cycle: %s", requiresDirective, strings.Join(path[i:], " -> "))
+			}
+		}
+
+		typeName := strings.SplitN(key, ".", 2)[0]
+		for _, requirement := range r[key] {
+			if err := visit(append(path, fmt.Sprintf("%s.%s", typeName, requirement.Name))); err != nil {
+				return err
+			}
+		}
+
+		done.Add(key)
+		return nil
+	}
+
+	for _, key := range keys {
+		if err := visit([]string{key}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hideArguments removes the arguments that the gateway fills in from the schema so clients can't provide them
+func (r fieldRequirements) hideArguments(schema *ast.Schema) {
+	for key, requirements := range r {
+		names := strings.SplitN(key, ".", 2)
+		definition := schema.Types[names[0]]
+		if definition == nil {
+			continue
+		}
+
+		for i, field := range definition.Fields {
+			if field.Name != names[1] {
+				continue
+			}
+
+			arguments := ast.ArgumentDefinitionList{}
+			for _, argument := range field.Arguments {
+				if requirements.ForName(argument.Name) == nil {
+					arguments = append(arguments, argument)
+				}
+			}
+
+			// the definition could be shared with a source so we have to leave a copy behind
+			copied := *field
+			copied.Arguments = arguments
+			definition.Fields[i] = &copied
+		}
+	}
+}
+
+// addRequirements fills in the arguments of the fields in a selection set that require other fields of
+// their parent and returns the fields that the parent has to provide along with the variables for the step
+func (r fieldRequirements) addRequirements(parentType string, selectionSet ast.SelectionSet) (ast.SelectionSet, ast.VariableDefinitionList) {
+	parentFields := ast.SelectionSet{}
+	variables := ast.VariableDefinitionList{}
+
+	var walk func(typeName string, selectionSet ast.SelectionSet)
+	walk = func(typeName string, selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				for _, requirement := range r[fmt.Sprintf("%s.%s", typeName, selection.Name)] {
+					variable := requiresVariablePrefix + requirement.Name
+					selection.Arguments = append(append(ast.ArgumentList{}, selection.Arguments...), &ast.Argument{
+						Name:  requirement.Name,
+						Value: &ast.Value{Kind: ast.Variable, Raw: variable},
+					})
+
+					if variables.ForName(variable) != nil {
+						continue
+					}
+					variables = append(variables, &ast.VariableDefinition{
+						Variable: variable,
+						Type:     requirement.Type,
+					})
+
+					// the parent has to ask for the field we need, under the right type if we're in a fragment
+					var field ast.Selection = &ast.Field{Name: requirement.Name, Alias: requirement.Name}
+					if typeName != parentType {
+						field = &ast.InlineFragment{TypeCondition: typeName, SelectionSet: ast.SelectionSet{field}}
+					}
+					parentFields = append(parentFields, field)
+				}
+			case *ast.InlineFragment:
+				fragmentType := selection.TypeCondition
+				if fragmentType == "" {
+					fragmentType = typeName
+				}
+				walk(fragmentType, selection.SelectionSet)
+			}
+		}
+	}
+	walk(parentType, selectionSet)
+
+	return parentFields, variables
+}
+
+// executorRequiredVariables returns the variables for a step that needs fields of the object it is inserted into.
+// The insertion point is relative to the result of the step that was executed at a point of the given depth.
+func executorRequiredVariables(ctx *ExecutionContext, resultLock *sync.Mutex, result map[string]interface{}, depth int, point []string, step *QueryPlanStep, queryVariables map[string]interface{}) (map[string]interface{}, error) {
+	value, err := executorExtractValue(ctx, result, resultLock, point[depth:])
+	if err != nil && err != errNullInsertionPoint {
+		return nil, err
+	}
+	object, _ := value.(map[string]interface{})
+
+	// the step sees the variables of the operation along with the ones it requires
+	variables := map[string]interface{}{}
+	for key, value := range queryVariables {
+		variables[key] = value
+	}
+
+	resultLock.Lock()
+	defer resultLock.Unlock()
+	for _, definition := range step.Requires {
+		variables[definition.Variable] = object[strings.TrimPrefix(definition.Variable, requiresVariablePrefix)]
+	}
+
+	return variables, nil
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// ScalarValidator checks the value of a custom scalar that the client sent as (part of) a variable
+type ScalarValidator func(value interface{}) error
+
+// WithScalarValidator returns an Option that runs the given function over every value of the named scalar
+// that shows up in the variables of a request, whether it's the variable itself, an entry in a list, or a
+// field of an input object. Values that fail are rejected with a BAD_USER_INPUT error before anything is
+// sent to the services, which is handy for scalars like DateTime that every service would otherwise have
+// to validate on their own.
+func WithScalarValidator(typeName string, validate ScalarValidator) Option {
+	return func(g *Gateway) {
+		if g.scalarValidators == nil {
+			g.scalarValidators = map[string]ScalarValidator{}
+		}
+		g.scalarValidators[typeName] = validate
+	}
+}
+
+// validateScalars runs the scalar validators over the (already coerced) variables of the operation
+func (g *Gateway) validateScalars(operation *ast.OperationDefinition, variables map[string]interface{}) error {
+	if len(g.scalarValidators) == 0 {
+		return nil
+	}
+
+	for _, definition := range operation.VariableDefinitions {
+		value, ok := variables[definition.Variable]
+		if !ok {
+			continue
+		}
+		if err := g.validateScalarVariable(definition.Variable, definition.Type, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateScalarVariable checks the value of a variable with the given type
+func (g *Gateway) validateScalarVariable(variable string, typ *ast.Type, value interface{}) error {
+	// null is always up to the type system
+	if value == nil {
+		return nil
+	}
+
+	// lists have to check each of their entries
+	if typ.Elem != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			return g.validateScalarVariable(variable, typ.Elem, value)
+		}
+		for _, entry := range list {
+			if err := g.validateScalarVariable(variable, typ.Elem, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	definition := g.schema.Types[typ.NamedType]
+	if definition == nil {
+		return nil
+	}
+
+	switch definition.Kind {
+	case ast.Scalar:
+		validate, ok := g.scalarValidators[definition.Name]
+		if !ok {
+			return nil
+		}
+		if err := validate(value); err != nil {
+			return graphql.NewError("BAD_USER_INPUT", fmt.Sprintf("variable $%s has an invalid %s value: %s", variable, definition.Name, err.Error()))
+		}
+	case ast.InputObject:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for name, fieldValue := range object {
+			field := definition.Fields.ForName(name)
+			if field == nil {
+				continue
+			}
+			if err := g.validateScalarVariable(variable, field.Type, fieldValue); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,523 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// streamDirective is the name of the field directive clients use to ask for a list to be streamed
+const streamDirective = "stream"
+
+// streamBoundary separates the parts of an incremental response
+const streamBoundary = "-"
+
+// plannerFieldDirectives returns the directives of a field that should be sent to the services. The
+// services respond with the whole list at once so @stream is handled by the executor, which holds back
+// the entries past the initialCount until the steps under them are done.
+func plannerFieldDirectives(directives ast.DirectiveList) ast.DirectiveList {
+	if directives.ForName(streamDirective) == nil {
+		return directives
+	}
+
+	filtered := ast.DirectiveList{}
+	for _, directive := range directives {
+		if directive.Name != streamDirective {
+			filtered = append(filtered, directive)
+		}
+	}
+	return filtered
+}
+
+// acceptsIncrementalDelivery returns true if the client can handle a multipart response
+func acceptsIncrementalDelivery(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "multipart/mixed")
+}
+
+// StreamedItem is an entry of a list marked with @stream that was sent after the rest of the result
+type StreamedItem struct {
+	// Label is the label given to @stream, if there was one
+	Label string
+	// Path is the path to the entry in the result, ending with its index in the list
+	Path []interface{}
+	// Data holds the entry at the end of its path. The objects along the way only hold the field that
+	// leads to the entry and the fields the gateway uses to identify them so the response middlewares can
+	// treat it like any other response.
+	Data map[string]interface{}
+	// Errors are the errors of the steps that resolved the fields of the entry
+	Errors graphql.ErrorList
+	// Last is true for the last entry of the operation
+	Last bool
+}
+
+// Value returns the entry at the end of the item's path
+func (item *StreamedItem) Value() interface{} {
+	var value interface{} = item.Data
+	for _, key := range item.Path {
+		switch key := key.(type) {
+		case string:
+			object, _ := value.(map[string]interface{})
+			value = object[key]
+		case int:
+			list, _ := value.([]interface{})
+			if key >= len(list) {
+				return nil
+			}
+			value = list[key]
+		}
+	}
+	return value
+}
+
+// StreamingExecutor is an Executor that can send the entries of the lists marked with @stream past their
+// initialCount on their own
+type StreamingExecutor interface {
+	Executor
+	// ExecuteStream returns the result without the entries of the lists marked with @stream past their
+	// initialCount as soon as the rest of it is resolved. Each of those entries is sent on the channel, in
+	// order, once the steps under it are done and the channel is closed after the last one. The channel is
+	// nil if there is nothing to stream.
+	ExecuteStream(ctx *ExecutionContext) (map[string]interface{}, <-chan *StreamedItem, error)
+}
+
+// streamedList is a field marked with @stream
+type streamedList struct {
+	field        *ast.Field
+	initialCount int
+	label        string
+}
+
+// planStreamedLists returns the fields of the operation marked with @stream, keyed by the aliases on the
+// way to them joined with dots
+func planStreamedLists(plan *QueryPlan, variables map[string]interface{}) (map[string]*streamedList, error) {
+	lists := map[string]*streamedList{}
+	if plan.Operation == nil {
+		return lists, nil
+	}
+
+	err := collectStreamedLists(plan.Operation.SelectionSet, plan.FragmentDefinitions, variables, nil, lists)
+	if err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+func collectStreamedLists(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, variables map[string]interface{}, aliases []string, lists map[string]*streamedList) error {
+	selection, err := graphql.ApplyFragments(selectionSet, fragments)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range graphql.SelectedFields(selection) {
+		alias := field.Alias
+		if alias == "" {
+			alias = field.Name
+		}
+		fieldAliases := append(append([]string{}, aliases...), alias)
+
+		if directive := field.Directives.ForName(streamDirective); directive != nil {
+			list := &streamedList{field: field}
+			if list.initialCount, err = streamInitialCount(directive, variables); err != nil {
+				return err
+			}
+			if arg := directive.Arguments.ForName("label"); arg != nil {
+				value, err := arg.Value.Value(variables)
+				if err != nil {
+					return err
+				}
+				list.label, _ = value.(string)
+			}
+			lists[strings.Join(fieldAliases, ".")] = list
+		}
+
+		if err := collectStreamedLists(field.SelectionSet, fragments, variables, fieldAliases, lists); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// streamInitialCount returns the number of entries the client wants before the rest are streamed
+func streamInitialCount(directive *ast.Directive, variables map[string]interface{}) (int, error) {
+	arg := directive.Arguments.ForName("initialCount")
+	if arg == nil {
+		return 0, nil
+	}
+
+	value, err := arg.Value.Value(variables)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, nil
+	}
+
+	count, ok := numberValue(value)
+	if !ok || count < 0 {
+		return 0, fmt.Errorf("@%s initialCount must be a non-negative integer", streamDirective)
+	}
+
+	return int(count), nil
+}
+
+// streamEntry is an entry of a streamed list that was left out of the initial result
+type streamEntry struct {
+	key  string
+	path []interface{}
+	list *streamedList
+}
+
+// streamInitial is the result that is returned before the streamed entries are sent
+type streamInitial struct {
+	result map[string]interface{}
+	err    error
+}
+
+// streamTracker keeps track of the steps that still have to finish before the initial result and each of
+// the streamed entries can be sent. It is only used by the goroutine that collects the results of the steps.
+type streamTracker struct {
+	ctx   *ExecutionContext
+	lists map[string]*streamedList
+
+	// initialSteps is the number of steps that don't fall under a streamed entry and haven't finished
+	initialSteps int
+	initial      chan streamInitial
+	sentInitial  bool
+
+	// entrySteps is the number of steps under each of the streamed entries that haven't finished
+	entrySteps  map[string]int
+	entryErrors map[string]graphql.ErrorList
+	entries     []*streamEntry
+	sent        int
+	items       chan *StreamedItem
+	closed      bool
+}
+
+func newStreamTracker(ctx *ExecutionContext, lists map[string]*streamedList) *streamTracker {
+	return &streamTracker{
+		ctx:          ctx,
+		lists:        lists,
+		initialSteps: len(ctx.Plan.RootStep.Then),
+		initial:      make(chan streamInitial, 1),
+		entrySteps:   map[string]int{},
+		entryErrors:  map[string]graphql.ErrorList{},
+	}
+}
+
+// entryKey returns the key of the streamed entry that the insertion point falls under, or an empty string
+// if it is part of the initial result
+func (t *streamTracker) entryKey(insertionPoint []string) string {
+	aliases := make([]string, 0, len(insertionPoint))
+	path := make([]interface{}, 0, len(insertionPoint)*2)
+	for _, point := range insertionPoint {
+		pointData, err := executorGetPointData(point)
+		if err != nil {
+			return ""
+		}
+		aliases = append(aliases, pointData.Field)
+		path = append(path, pointData.Field)
+
+		if pointData.Index >= 0 {
+			path = append(path, pointData.Index)
+			if list, ok := t.lists[strings.Join(aliases, ".")]; ok && pointData.Index >= list.initialCount {
+				return fmt.Sprint(path)
+			}
+		}
+	}
+	return ""
+}
+
+// stepDone records that the step for the payload is done and sends whatever that made ready
+func (t *streamTracker) stepDone(payload *queryExecutionResult, err error, result map[string]interface{}, resultLock *sync.Mutex, errs *graphql.ErrorList, errMutex *sync.Mutex) {
+	key := t.entryKey(payload.InsertionPoint)
+
+	// the errors of a streamed entry are sent along with it
+	if err != nil {
+		if key != "" {
+			t.entryErrors[key] = append(t.entryErrors[key], executorErrorList(err)...)
+		} else {
+			errMutex.Lock()
+			*errs = append(*errs, executorErrorList(err)...)
+			errMutex.Unlock()
+		}
+	}
+
+	// the steps that come after this one have to finish too
+	for _, point := range payload.Dependents {
+		if dependentKey := t.entryKey(point); dependentKey != "" {
+			t.entrySteps[dependentKey]++
+		} else {
+			t.initialSteps++
+		}
+	}
+	if key != "" {
+		t.entrySteps[key]--
+	} else {
+		t.initialSteps--
+	}
+
+	if !t.sentInitial && t.initialSteps == 0 {
+		t.sendInitial(result, resultLock, *errs, errMutex)
+	}
+	if t.sentInitial {
+		t.sendReady(result, resultLock)
+	}
+}
+
+// sendInitial hands the result without the streamed entries back to the caller of the executor
+func (t *streamTracker) sendInitial(result map[string]interface{}, resultLock *sync.Mutex, errs graphql.ErrorList, errMutex *sync.Mutex) {
+	t.sentInitial = true
+
+	// the steps for the streamed entries keep writing to the result so the caller gets its own copy
+	resultLock.Lock()
+	initial, _ := t.copyValue(result, nil, nil, false).(map[string]interface{})
+	resultLock.Unlock()
+
+	errMutex.Lock()
+	errs = append(graphql.ErrorList{}, errs...)
+	errMutex.Unlock()
+
+	if len(t.entries) > 0 {
+		t.items = make(chan *StreamedItem, len(t.entries))
+	}
+
+	completed, err := executorCompleteResult(t.ctx, initial, errs)
+	t.initial <- streamInitial{result: completed, err: err}
+}
+
+// copyValue copies the value at the path in the result, leaving out the entries of the streamed lists
+// past their initialCount and remembering them for later
+func (t *streamTracker) copyValue(value interface{}, aliases []string, path []interface{}, isField bool) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		// the fields are visited in order so the entries are streamed in the same order every time
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		copied := make(map[string]interface{}, len(value))
+		for _, key := range keys {
+			copied[key] = t.copyValue(value[key], append(append([]string{}, aliases...), key), append(append([]interface{}{}, path...), key), true)
+		}
+		return copied
+	case []interface{}:
+		entries := value
+		if list, ok := t.lists[strings.Join(aliases, ".")]; ok && isField && list.initialCount < len(value) {
+			entries = value[:list.initialCount]
+			for i := list.initialCount; i < len(value); i++ {
+				entryPath := append(append([]interface{}{}, path...), i)
+				t.entries = append(t.entries, &streamEntry{key: fmt.Sprint(entryPath), path: entryPath, list: list})
+			}
+		}
+
+		copied := make([]interface{}, len(entries))
+		for i, entry := range entries {
+			copied[i] = t.copyValue(entry, aliases, append(append([]interface{}{}, path...), i), false)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// sendReady sends the streamed entries that are done, in order
+func (t *streamTracker) sendReady(result map[string]interface{}, resultLock *sync.Mutex) {
+	for t.sent < len(t.entries) && t.entrySteps[t.entries[t.sent].key] == 0 {
+		t.items <- t.buildItem(t.entries[t.sent], result, resultLock)
+		t.sent++
+	}
+
+	if t.sent == len(t.entries) {
+		t.close()
+	}
+}
+
+// finish sends whatever is left once every step is done
+func (t *streamTracker) finish(result map[string]interface{}, resultLock *sync.Mutex) {
+	for t.sent < len(t.entries) {
+		t.items <- t.buildItem(t.entries[t.sent], result, resultLock)
+		t.sent++
+	}
+	t.close()
+}
+
+func (t *streamTracker) close() {
+	if t.items != nil && !t.closed {
+		t.closed = true
+		close(t.items)
+	}
+}
+
+// buildItem copies a streamed entry out of the result along with the path that leads to it
+func (t *streamTracker) buildItem(entry *streamEntry, result map[string]interface{}, resultLock *sync.Mutex) *StreamedItem {
+	item := &StreamedItem{
+		Label:  entry.list.label,
+		Path:   entry.path,
+		Errors: t.entryErrors[entry.key],
+		Last:   t.sent == len(t.entries)-1,
+	}
+
+	resultLock.Lock()
+	defer resultLock.Unlock()
+
+	// copy the objects along the path with just enough of them to find the entry
+	item.Data = map[string]interface{}{}
+	var source interface{} = result
+	var target interface{} = item.Data
+	for i, key := range entry.path {
+		var next interface{}
+		switch key := key.(type) {
+		case string:
+			object, _ := source.(map[string]interface{})
+			next = object[key]
+		case int:
+			list, _ := source.([]interface{})
+			if key < len(list) {
+				next = list[key]
+			}
+		}
+
+		var copied interface{}
+		if i == len(entry.path)-1 {
+			// the last key is the entry itself
+			copied = copyResultValue(next)
+
+			// which has to live up to the non-null fields of the operation on its own
+			if t.ctx.BubbleNulls && entry.list.field.Definition != nil && entry.list.field.Definition.Type.Elem != nil {
+				copied = t.completeEntry(item, entry, copied)
+			}
+		} else if nextKey, ok := entry.path[i+1].(int); ok {
+			copied = make([]interface{}, nextKey+1)
+		} else {
+			copied = streamItemParent(next)
+		}
+
+		switch key := key.(type) {
+		case string:
+			target.(map[string]interface{})[key] = copied
+		case int:
+			target.([]interface{})[key] = copied
+		}
+		source, target = next, copied
+	}
+
+	return item
+}
+
+// completeEntry enforces the non-null fields under a streamed entry and adds an error to the item for
+// each null that wasn't already reported
+func (t *streamTracker) completeEntry(item *StreamedItem, entry *streamEntry, value interface{}) interface{} {
+	reported := map[string]bool{}
+	for _, err := range item.Errors {
+		var gqlErr *graphql.Error
+		if errors.As(err, &gqlErr) && len(gqlErr.Path) > 0 {
+			reported[fmt.Sprint(gqlErr.Path)] = true
+		}
+	}
+
+	walker := &nullBubbler{
+		fragments: t.ctx.Plan.FragmentDefinitions,
+		reported:  reported,
+	}
+	completed := walker.completeValue(entry.list.field, entry.list.field.Definition.Type.Elem, value, entry.path)
+	item.Errors = append(item.Errors, walker.errs...)
+
+	return completed
+}
+
+// copyResultValue returns a copy of a value in the result that doesn't share any objects or lists with it
+func copyResultValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, fieldValue := range value {
+			copied[key] = copyResultValue(fieldValue)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, entry := range value {
+			copied[i] = copyResultValue(entry)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// streamItemParent returns the part of an object on the way to a streamed entry that is sent along with it
+func streamItemParent(value interface{}) map[string]interface{} {
+	parent := map[string]interface{}{}
+	if object, ok := value.(map[string]interface{}); ok {
+		// the response middlewares find the objects by the same fields the executor did
+		for _, field := range []string{"id", typenameField} {
+			if fieldValue, ok := object[field]; ok {
+				parent[field] = fieldValue
+			}
+		}
+	}
+	return parent
+}
+
+// emitIncrementalResponse sends the initial payload followed by a part for each of the streamed entries
+// as they come in, serializing each part with the given function
+func emitIncrementalResponse(w http.ResponseWriter, marshal func(interface{}) ([]byte, error), payload map[string]interface{}, items <-chan *StreamedItem) {
+	w.Header().Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", streamBoundary))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	writePart := func(part map[string]interface{}) {
+		body, err := marshal(part)
+		if err != nil {
+			body, _ = marshal(map[string]interface{}{
+				"errors":  graphql.ErrorList{graphql.NewError("INTERNAL_SERVER_ERROR", err.Error())},
+				"hasNext": part["hasNext"],
+			})
+		}
+
+		fmt.Fprintf(w, "\r\n--%s\r\nContent-Type: application/json; charset=utf-8\r\n\r\n%s", streamBoundary, body)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	payload["hasNext"] = true
+	writePart(payload)
+
+	// the last entry tells the client that there's nothing left
+	hasNext := true
+	for item := range items {
+		patch := map[string]interface{}{
+			"items": []interface{}{item.Value()},
+			"path":  item.Path,
+		}
+		if item.Label != "" {
+			patch["label"] = item.Label
+		}
+		if len(item.Errors) > 0 {
+			patch["errors"] = item.Errors
+		}
+
+		hasNext = !item.Last
+		writePart(map[string]interface{}{
+			"incremental": []interface{}{patch},
+			"hasNext":     hasNext,
+		})
+	}
+
+	// if the stream ended early, the client still has to hear that it's over
+	if hasNext {
+		writePart(map[string]interface{}{"hasNext": false})
+	}
+
+	fmt.Fprintf(w, "\r\n--%s--\r\n", streamBoundary)
+}
@@ -0,0 +1,428 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upstreamTransport is the http.RoundTripper used by the queryers the gateway builds for its services.
+// It lets the gateway look at parts of the response that the queryers don't pass along.
+type upstreamTransport struct {
+	base http.RoundTripper
+	// userAgent is sent with every request that doesn't already have one
+	userAgent string
+}
+
+// upstreamHTTPClient is shared by every queryer the gateway builds
+var upstreamHTTPClient = &http.Client{
+	Transport: &upstreamTransport{base: http.DefaultTransport},
+}
+
+// WithUpstreamRequestTimeout returns an Option that limits how long each HTTP request to a service can take,
+// from connecting to reading the whole response. It only applies to the queryers the gateway builds: the ones
+// from WithQueryerFactory or WithUpstreamQueryer bring their own client. A value of 0 (the default) doesn't
+// limit the requests. See WithStepTimeout for a limit that applies to every queryer.
+func WithUpstreamRequestTimeout(timeout time.Duration) Option {
+	return func(g *Gateway) {
+		g.upstreamTimeout = timeout
+	}
+}
+
+// WithUpstreamTransport returns an Option that sets the transport shared by the queryers the gateway builds for
+// its services, for example to tune how many idle connections are kept around for each service. Every step of
+// every request goes through it so the connections opened for one node query can be reused by the next. By
+// default, the queryers share http.DefaultTransport. The ones from WithQueryerFactory or WithUpstreamQueryer
+// bring their own client.
+func WithUpstreamTransport(transport *http.Transport) Option {
+	return func(g *Gateway) {
+		g.upstreamBaseTransport = transport
+	}
+}
+
+// buildUpstreamClient returns the client for the queryers the gateway builds if the options call for a different
+// one than the shared client
+func (g *Gateway) buildUpstreamClient() *http.Client {
+	if g.upstreamTimeout <= 0 && g.upstreamBaseTransport == nil && g.userAgent == "" {
+		return nil
+	}
+
+	transport := &upstreamTransport{base: http.DefaultTransport, userAgent: g.userAgent}
+	if g.upstreamBaseTransport != nil {
+		transport.base = g.upstreamBaseTransport
+	}
+	client := &http.Client{Transport: transport}
+	if g.upstreamTimeout > 0 {
+		client.Timeout = g.upstreamTimeout
+	}
+	return client
+}
+
+// httpClient returns the client used by the queryers the gateway builds for its services
+func (g *Gateway) httpClient() *http.Client {
+	if g == nil || g.upstreamClient == nil {
+		return upstreamHTTPClient
+	}
+	return g.upstreamClient
+}
+
+// DefaultUpstreamUserAgent is the User-Agent of the requests the gateway sends to its services, with the
+// version of the gateway if the binary knows it
+var DefaultUpstreamUserAgent = userAgentWithVersion("nautilus-gateway")
+
+// WithUpstreamUserAgent returns an Option that sets the User-Agent header of the requests sent to the services
+// so they can tell the gateway's traffic apart from everyone else's. An empty string leaves the header to the
+// HTTP client. Request middlewares can still set their own. It only applies to the queryers the gateway builds:
+// the ones from WithQueryerFactory or WithUpstreamQueryer bring their own client.
+func WithUpstreamUserAgent(userAgent string) Option {
+	return func(g *Gateway) {
+		g.userAgent = userAgent
+	}
+}
+
+// userAgentWithVersion adds the version of the gateway module to the name, if the binary was built with it
+func userAgentWithVersion(name string) string {
+	if version := moduleVersion(); version != "" {
+		return name + "/" + version
+	}
+	return name
+}
+
+// moduleVersion returns the version of the gateway module that the binary was built with, if it knows it
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	modules := append([]*debug.Module{&info.Main}, info.Deps...)
+	for _, module := range modules {
+		if module != nil && module.Path == "github.com/nautilus/gateway" && module.Version != "" && module.Version != "(devel)" {
+			return module.Version
+		}
+	}
+	return ""
+}
+
+// RoundTrip sends the request and records anything the gateway needs from the response
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the user agent is set here instead of in a request middleware so the executor doesn't have to
+	// add middlewares to the queryers of every step
+	if t.userAgent != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	// pass along the extensions of the client's request
+	if extensions := ForwardedExtensions(req.Context()); len(extensions) > 0 {
+		withExtensions, err := upstreamRequestWithExtensions(req, extensions)
+		if err != nil {
+			return nil, err
+		}
+		req = withExtensions
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	// if no one is interested in the response there's nothing to do
+	collector := upstreamCollectorFromContext(req.Context())
+	if collector == nil {
+		return resp, nil
+	}
+
+	// hold onto the headers of the response
+	collector.addHeader(resp.Header.Clone())
+
+	// the body only has to be read if we care about the extensions
+	if !collector.captureExtensions {
+		return resp, nil
+	}
+
+	// read the body so we can look at it and hand a fresh copy back to the queryer
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// pull out the extensions of the response if there are any
+	payload := struct {
+		Extensions map[string]interface{} `json:"extensions"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Extensions != nil {
+		collector.addExtensions(payload.Extensions)
+	}
+
+	return resp, nil
+}
+
+// upstreamCollector accumulates the parts of the upstream responses for a single operation
+type upstreamCollector struct {
+	captureExtensions bool
+
+	mu         sync.Mutex
+	extensions []map[string]interface{}
+	headers    []http.Header
+}
+
+func (c *upstreamCollector) addExtensions(extensions map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.extensions = append(c.extensions, extensions)
+}
+
+func (c *upstreamCollector) addHeader(header http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers = append(c.headers, header)
+}
+
+// Headers returns the headers of every upstream response seen so far
+func (c *upstreamCollector) Headers() []http.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]http.Header{}, c.headers...)
+}
+
+// Extensions returns the extensions of every upstream response seen so far
+func (c *upstreamCollector) Extensions() []map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]map[string]interface{}{}, c.extensions...)
+}
+
+type upstreamCollectorKey struct{}
+
+// withUpstreamCollector returns a context that records the upstream responses in the collector
+func withUpstreamCollector(ctx context.Context, collector *upstreamCollector) context.Context {
+	return context.WithValue(ctx, upstreamCollectorKey{}, collector)
+}
+
+func upstreamCollectorFromContext(ctx context.Context) *upstreamCollector {
+	collector, _ := ctx.Value(upstreamCollectorKey{}).(*upstreamCollector)
+	return collector
+}
+
+// upstreamRequestWithExtensions returns a copy of the request with the extensions added to the JSON body
+// (to every operation of a batch). Extensions set by the queryer are left alone. Requests that don't
+// have a JSON body, like file uploads, are returned as they are.
+func upstreamRequestWithExtensions(req *http.Request, extensions map[string]interface{}) (*http.Request, error) {
+	if req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return req, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// only the extensions are decoded so the rest of the operation, numbers included, is sent as it was
+	body, err = upstreamBodyWithExtensions(body, extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	// a round tripper isn't allowed to modify the request it was given
+	withExtensions := req.Clone(req.Context())
+	withExtensions.Body = io.NopCloser(bytes.NewReader(body))
+	withExtensions.ContentLength = int64(len(body))
+	withExtensions.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return withExtensions, nil
+}
+
+// upstreamBodyWithExtensions returns the JSON body of a request (a single operation or a batch of them) with
+// the extensions added to every operation. Only the extensions of the operations are decoded, every other value
+// is copied as it is.
+func upstreamBodyWithExtensions(body []byte, extensions map[string]interface{}) ([]byte, error) {
+	trimmed := bytes.TrimSpace(body)
+
+	// a batch is a list of operations
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var operations []json.RawMessage
+		if err := json.Unmarshal(body, &operations); err != nil {
+			return nil, err
+		}
+		for i, operation := range operations {
+			withExtensions, err := upstreamBodyWithExtensions(operation, extensions)
+			if err != nil {
+				return nil, err
+			}
+			operations[i] = withExtensions
+		}
+		return json.Marshal(operations)
+	}
+
+	// an operation that isn't an object is left for the service to complain about
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return body, nil
+	}
+	var operation map[string]json.RawMessage
+	if err := json.Unmarshal(body, &operation); err != nil {
+		return nil, err
+	}
+
+	operationExtensions := map[string]json.RawMessage{}
+	if raw, ok := operation["extensions"]; ok && !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		if err := json.Unmarshal(raw, &operationExtensions); err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range extensions {
+		if _, exists := operationExtensions[key]; exists {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		operationExtensions[key] = encoded
+	}
+
+	encodedExtensions, err := json.Marshal(operationExtensions)
+	if err != nil {
+		return nil, err
+	}
+	operation["extensions"] = encodedExtensions
+
+	return json.Marshal(operation)
+}
+
+type forwardedExtensionsKey struct{}
+
+// withForwardedExtensions returns a context that sends the extensions along with the queries to the services
+func withForwardedExtensions(ctx context.Context, extensions map[string]interface{}) context.Context {
+	return context.WithValue(ctx, forwardedExtensionsKey{}, extensions)
+}
+
+// ForwardedExtensions returns the extensions of the client's request that should be sent along with
+// the queries made under the context. Queryers that aren't built by the gateway can use it to pass
+// the extensions along themselves.
+func ForwardedExtensions(ctx context.Context) map[string]interface{} {
+	extensions, _ := ctx.Value(forwardedExtensionsKey{}).(map[string]interface{})
+	return extensions
+}
+
+// WithForwardRequestExtensions returns an Option that sends the given extensions of the client's request
+// along with every query sent to the services. By default, no extensions are forwarded. Only the queryers
+// built by the gateway add the extensions to their requests (see ForwardedExtensions).
+func WithForwardRequestExtensions(keys ...string) Option {
+	return func(g *Gateway) {
+		g.forwardedExtensionKeys = append(g.forwardedExtensionKeys, keys...)
+	}
+}
+
+// forwardedExtensions returns the extensions of the client's request that should be sent to the services
+func (g *Gateway) forwardedExtensions(extensions map[string]interface{}) map[string]interface{} {
+	forwarded := map[string]interface{}{}
+	for _, key := range g.forwardedExtensionKeys {
+		if value, ok := extensions[key]; ok {
+			forwarded[key] = value
+		}
+	}
+	return forwarded
+}
+
+// ExtensionsMerger combines the extensions returned by each service that was queried
+// for an operation into the extensions of the final response
+type ExtensionsMerger func(perService []map[string]interface{}) map[string]interface{}
+
+// WithExtensionsMerger returns an Option that adds the extensions returned by the services
+// to the response, combined with the given function. By default, the extensions returned by
+// the services are ignored. Only the queryers built by the gateway capture extensions.
+func WithExtensionsMerger(merger ExtensionsMerger) Option {
+	return func(g *Gateway) {
+		g.extensionsMerger = merger
+	}
+}
+
+// UpstreamHeaderForwarder combines the headers of the responses from each service that was queried
+// for an operation into the headers that should be set on the gateway's response
+type UpstreamHeaderForwarder func(perService []http.Header) http.Header
+
+// WithUpstreamHeaderForwarding returns an Option that sets the headers returned by the given function
+// on the response sent over HTTP, for example to pass along a Cache-Control header when every service
+// agrees on it. Only the queryers built by the gateway capture headers.
+func WithUpstreamHeaderForwarding(forwarder UpstreamHeaderForwarder) Option {
+	return func(g *Gateway) {
+		g.headerForwarder = forwarder
+	}
+}
+
+// WithHeaderPropagationPredicate returns an Option that copies every header of the request sent to the
+// GraphQLHandler whose name matches the predicate to the requests sent to the services, for example to pass
+// along the traceparent and tracestate headers or everything that starts with x-b3-. The predicate is given
+// the name of the header in lower case. The headers are set by a request middleware so every queryer that
+// supports middlewares propagates them, and the request middlewares that run after it can still overwrite
+// them. The headers that describe the client's body (Content-Type and Content-Length) or its connection to
+// the gateway (the hop-by-hop headers and the ones listed in Connection) are never propagated.
+func WithHeaderPropagationPredicate(predicate func(name string) bool) Option {
+	return func(g *Gateway) {
+		g.headerPropagationPredicate = predicate
+	}
+}
+
+type propagatedHeadersKey struct{}
+
+// withPropagatedHeaders returns a context that sends the headers along with the queries to the services
+func withPropagatedHeaders(ctx context.Context, headers http.Header) context.Context {
+	return context.WithValue(ctx, propagatedHeadersKey{}, headers)
+}
+
+// unpropagatedHeaders are the headers (in lower case) that only make sense for the client's request
+var unpropagatedHeaders = map[string]bool{
+	"content-type":        true,
+	"content-length":      true,
+	"connection":          true,
+	"proxy-connection":    true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// propagatedHeaders returns the headers of the client's request that should be sent to the services
+func (g *Gateway) propagatedHeaders(r *http.Request) http.Header {
+	// the client can name more headers that only apply to its connection
+	connectionHeaders := map[string]bool{}
+	for _, value := range r.Header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			connectionHeaders[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
+	propagated := http.Header{}
+	for name, values := range r.Header {
+		lowerName := strings.ToLower(name)
+		if unpropagatedHeaders[lowerName] || connectionHeaders[lowerName] {
+			continue
+		}
+		if g.headerPropagationPredicate(lowerName) {
+			propagated[name] = append([]string{}, values...)
+		}
+	}
+	return propagated
+}
+
+// propagateHeaders is the request middleware that copies the client's headers to the requests sent to the services
+func (g *Gateway) propagateHeaders(r *http.Request) error {
+	headers, _ := r.Context().Value(propagatedHeadersKey{}).(http.Header)
+	for name, values := range headers {
+		r.Header[name] = append([]string{}, values...)
+	}
+	return nil
+}